@@ -0,0 +1,83 @@
+package background
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// Pool tracks fire-and-forget jobs such as emails so they can finish before
+// the process exits
+type Pool struct {
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	closed  bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	pending int
+}
+
+func NewPool() *Pool {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Pool{ctx: ctx, cancel: cancel}
+}
+
+// Go runs job in its own goroutine. The job's context keeps the values of
+// ctx, such as the request ID, but outlives it and is only cancelled when
+// Shutdown gives up waiting. Jobs submitted after Shutdown has started are
+// dropped.
+func (p *Pool) Go(ctx context.Context, name string, job func(ctx context.Context)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		slog.WarnContext(ctx, "Dropping background job after shutdown", "job", name)
+		return
+	}
+	jobCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(p.ctx, cancel)
+	p.wg.Add(1)
+	p.pending++
+	go func() {
+		defer func() {
+			stop()
+			cancel()
+			if r := recover(); r != nil {
+				slog.ErrorContext(jobCtx, "Background job panicked", "job", name, "panic", r)
+			}
+			p.mu.Lock()
+			p.pending--
+			p.mu.Unlock()
+			p.wg.Done()
+		}()
+		job(jobCtx)
+	}()
+}
+
+// Pending is the number of jobs still running
+func (p *Pool) Pending() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pending
+}
+
+// Shutdown stops accepting jobs and waits for the running ones until ctx is
+// done, then cancels whatever is left
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		p.cancel()
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+		return ctx.Err()
+	}
+}
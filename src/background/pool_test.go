@@ -0,0 +1,99 @@
+package background
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type ctxKey struct{}
+
+func TestShutdownDrains(t *testing.T) {
+	p := NewPool()
+	release := make(chan struct{})
+	var finished atomic.Int32
+	var value atomic.Value
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "request"))
+	for i := 0; i < 3; i++ {
+		p.Go(ctx, "job", func(ctx context.Context) {
+			<-release
+			if ctx.Err() == nil {
+				finished.Add(1)
+			}
+			value.Store(fmt.Sprint(ctx.Value(ctxKey{})))
+		})
+	}
+	// The request ending does not cancel its jobs
+	cancel()
+	if n := p.Pending(); n != 3 {
+		t.Errorf("Pending = %d, want 3", n)
+	}
+
+	done := make(chan error)
+	go func() { done <- p.Shutdown(context.Background()) }()
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned %v before the jobs finished", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("Shutdown = %v, want nil once drained", err)
+	}
+	if finished.Load() != 3 || p.Pending() != 0 {
+		t.Errorf("%d jobs finished uncancelled, %d pending, want 3 and 0", finished.Load(), p.Pending())
+	}
+	if value.Load() != "request" {
+		t.Errorf("job context value = %v, want the request's", value.Load())
+	}
+}
+
+func TestShutdownTimeoutCancels(t *testing.T) {
+	p := NewPool()
+	cancelled := make(chan struct{})
+	p.Go(context.Background(), "stuck", func(ctx context.Context) {
+		<-ctx.Done()
+		close(cancelled)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown = %v, want the deadline error", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("job still running after Shutdown gave up")
+	}
+}
+
+func TestDropAfterShutdown(t *testing.T) {
+	p := NewPool()
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown = %v", err)
+	}
+	ran := make(chan struct{}, 1)
+	p.Go(context.Background(), "late", func(ctx context.Context) { ran <- struct{}{} })
+	if n := p.Pending(); n != 0 {
+		t.Errorf("Pending = %d after a dropped job, want 0", n)
+	}
+	select {
+	case <-ran:
+		t.Error("job submitted after Shutdown ran")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestPanicDoesNotBlockShutdown(t *testing.T) {
+	p := NewPool()
+	p.Go(context.Background(), "panics", func(ctx context.Context) { panic("boom") })
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown = %v after a panicking job, want nil", err)
+	}
+}
@@ -0,0 +1,95 @@
+// Package codes generates participant codes such as META25-7KX9Q: an event
+// prefix, random characters and a check character that catches a mistyped
+// character at the registration desk, and two swapped neighbours unless they
+// are 0 and Z.
+package codes
+
+import (
+	"crypto/rand"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+// Crockford's base32 leaves out I, L, O and U so codes read back unambiguously
+const alphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// bodyLength is the number of random characters, about a million per prefix
+const bodyLength = 4
+
+var prefixPattern = regexp.MustCompile(`^[A-Z0-9]{1,10}$`)
+
+// ValidPrefix reports whether prefix can start a code
+func ValidPrefix(prefix string) bool {
+	return prefixPattern.MatchString(prefix)
+}
+
+// New returns a random code for the prefix
+func New(prefix string) string {
+	body := make([]byte, bodyLength)
+	for i := range body {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			panic(err)
+		}
+		body[i] = alphabet[n.Int64()]
+	}
+	return prefix + "-" + string(body) + string(checkChar(string(body)))
+}
+
+// Reroll returns a new code with the same prefix, for when code is taken
+func Reroll(code string) string {
+	prefix, _, _ := cut(code)
+	return New(prefix)
+}
+
+// Normalize uppercases a code typed by hand and maps the characters that
+// Crockford's base32 leaves out to the ones they are mistaken for
+func Normalize(code string) string {
+	code = strings.ToUpper(strings.Join(strings.Fields(code), ""))
+	prefix, body, ok := cut(code)
+	if !ok {
+		return code
+	}
+	body = strings.NewReplacer("O", "0", "I", "1", "L", "1").Replace(body)
+	return prefix + "-" + body
+}
+
+// Valid reports whether a normalized code is well formed with a matching
+// check character
+func Valid(code string) bool {
+	prefix, body, ok := cut(code)
+	if !ok || !ValidPrefix(prefix) || len(body) != bodyLength+1 {
+		return false
+	}
+	for i := 0; i < len(body); i++ {
+		if strings.IndexByte(alphabet, body[i]) < 0 {
+			return false
+		}
+	}
+	return checkChar(body[:bodyLength]) == body[bodyLength]
+}
+
+func cut(code string) (prefix, body string, ok bool) {
+	i := strings.LastIndexByte(code, '-')
+	if i < 0 {
+		return "", "", false
+	}
+	return code[:i], code[i+1:], true
+}
+
+// checkChar is the Luhn mod N check character over the base32 alphabet.
+// Like Luhn over digits, which misses 09 typed as 90, it cannot tell the
+// first and last characters of the alphabet apart when they are swapped.
+// Codes are printed on tickets, so the scheme stays as it is.
+func checkChar(body string) byte {
+	n := len(alphabet)
+	factor, sum := 2, 0
+	for i := len(body) - 1; i >= 0; i-- {
+		addend := factor * strings.IndexByte(alphabet, body[i])
+		addend = addend/n + addend%n
+		sum += addend
+		factor = 3 - factor
+	}
+	return alphabet[(n-sum%n)%n]
+}
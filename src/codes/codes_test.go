@@ -0,0 +1,102 @@
+package codes
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewIsValid(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		code := New("META25")
+		if !strings.HasPrefix(code, "META25-") || !Valid(code) {
+			t.Fatalf("New = %q, which is not valid", code)
+		}
+	}
+}
+
+func TestValid(t *testing.T) {
+	body := "7KX9"
+	good := "META25-" + body + string(checkChar(body))
+	for _, tt := range []struct {
+		code string
+		want bool
+	}{
+		{good, true},
+		{"meta25-" + good[7:], false}, // not normalized
+		{"META25" + good[7:], false},
+		{"-" + good[7:], false},
+		{"TOOLONGPREFIX-" + good[7:], false},
+		{"META25-" + body, false},
+		{good + "0", false},
+		{"META25-7KU9" + good[11:], false}, // U is not in the alphabet
+		{"", false},
+	} {
+		if got := Valid(tt.code); got != tt.want {
+			t.Errorf("Valid(%q) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{"META25-7KX9Q", "META25-7KX9Q"},
+		{" meta25-7kx9q ", "META25-7KX9Q"},
+		{"META25 - 7KX9 Q", "META25-7KX9Q"},
+		// Only the body maps look-alikes, the prefix is kept as typed
+		{"OIL-O1IL0", "OIL-01110"},
+		{"no dash", "NODASH"},
+	} {
+		if got := Normalize(tt.in); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// The check character catches any single mistyped character and any swap
+// of two different neighbours but 0 and Z, whose values 0 and N-1 Luhn mod
+// N cannot tell apart. Every character is tried at every position, so the
+// test does not depend on random codes.
+func TestCheckCharCatchesTypos(t *testing.T) {
+	blindSpot := func(a, b byte) bool {
+		return (a == alphabet[0] && b == alphabet[len(alphabet)-1]) || (b == alphabet[0] && a == alphabet[len(alphabet)-1])
+	}
+	const filler = "7KX9"
+	for pos := 0; pos < bodyLength; pos++ {
+		for _, a := range []byte(alphabet) {
+			for _, b := range []byte(alphabet) {
+				data := []byte(filler)
+				data[pos] = a
+				if pos+1 < bodyLength {
+					data[pos+1] = b
+				}
+				body := string(data) + string(checkChar(string(data)))
+				// Replacing the character at pos with b
+				typo := []byte(body)
+				typo[pos] = b
+				if a != b && Valid("EV-"+string(typo)) {
+					t.Errorf("EV-%s is valid with %q at %d", body, b, pos)
+				}
+				// Swapping pos with its neighbour, the check character for the last
+				swapped := []byte(body)
+				swapped[pos], swapped[pos+1] = swapped[pos+1], swapped[pos]
+				if swapped[pos] != swapped[pos+1] && !blindSpot(swapped[pos], swapped[pos+1]) && Valid("EV-"+string(swapped)) {
+					t.Errorf("EV-%s is valid with %d and %d swapped", body, pos, pos+1)
+				}
+			}
+		}
+	}
+	// The check character itself mistyped
+	for _, c := range []byte(alphabet) {
+		if c != checkChar(filler) && Valid("EV-"+filler+string(c)) {
+			t.Errorf("EV-%s%c is valid", filler, c)
+		}
+	}
+}
+
+func TestReroll(t *testing.T) {
+	if code := Reroll("META25-7KX9Q"); !strings.HasPrefix(code, "META25-") || !Valid(code) {
+		t.Errorf("Reroll = %q, want a valid code with the same prefix", code)
+	}
+}
@@ -0,0 +1,386 @@
+package config
+
+import (
+	"backend/src/pii"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Secret is a string that is redacted whenever it is printed or dumped
+type Secret string
+
+const redacted = "[redacted]"
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return strconv.AppendQuote(nil, s.String()), nil
+}
+
+// Value returns the secret itself
+func (s Secret) Value() string {
+	return string(s)
+}
+
+// Config is the whole backend configuration. Values come from the defaults
+// below, then the optional YAML file, then the environment variable named in
+// each field's env tag.
+type Config struct {
+	Port         string       `yaml:"port" env:"BACKEND_PORT"`
+	AdminToken   Secret       `yaml:"adminToken" env:"BACKEND_ADMIN_TOKEN"`
+	TokenSecret  Secret       `yaml:"tokenSecret" env:"BACKEND_TOKEN_SECRET"`
+	ManageURL    string       `yaml:"manageUrl" env:"BACKEND_MANAGE_URL"`
+	PrivacyURL   string       `yaml:"privacyUrl" env:"BACKEND_PRIVACY_URL"`
+	CountryCode  string       `yaml:"defaultCountryCode" env:"BACKEND_DEFAULT_COUNTRY_CODE"`
+	LogLevel     string       `yaml:"logLevel" env:"BACKEND_LOG_LEVEL"` // debug, info, warn or error
+	Server       Server       `yaml:"server"`
+	Mongo        Mongo        `yaml:"mongo"`
+	Storage      Storage      `yaml:"storage"`
+	Mail         Mail         `yaml:"mail"`
+	Cloudinary   Cloudinary   `yaml:"cloudinary"`
+	DefaultEvent DefaultEvent `yaml:"defaultEvent"`
+	Protection   Protection   `yaml:"protection"`
+	Tracing      Tracing      `yaml:"tracing"`
+	Retention    Retention    `yaml:"retention"`
+	Encryption   Encryption   `yaml:"encryption"`
+}
+
+// Server timeouts. On shutdown readiness fails first and requests are still
+// served for DrainGrace, so load balancers stop routing here before the
+// listener closes. ShutdownTimeout then bounds the rest of the ordered
+// shutdown: draining requests, waiting for background jobs and closing the
+// database.
+type Server struct {
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout" env:"BACKEND_READ_HEADER_TIMEOUT"`
+	ReadTimeout       time.Duration `yaml:"readTimeout" env:"BACKEND_READ_TIMEOUT"`
+	WriteTimeout      time.Duration `yaml:"writeTimeout" env:"BACKEND_WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `yaml:"idleTimeout" env:"BACKEND_IDLE_TIMEOUT"`
+	ShutdownTimeout   time.Duration `yaml:"shutdownTimeout" env:"BACKEND_SHUTDOWN_TIMEOUT"`
+	DrainGrace        time.Duration `yaml:"drainGrace" env:"BACKEND_DRAIN_GRACE"`
+	// MetricsAddr serves /metrics on a separate listener, such as
+	// 127.0.0.1:9090, kept off the public port. Without it the metrics are
+	// served at /admin/metrics to admins with the system permission.
+	MetricsAddr string `yaml:"metricsAddr" env:"BACKEND_METRICS_ADDR"`
+}
+
+// Mongo connection, used when storage.backend is mongo. MigrateOnStart
+// applies pending migrations when the server starts; with it off, run the
+// migrate command before deploying a new version instead. PIDLease is how
+// many PIDs each instance reserves at a time, leaving gaps after restarts,
+// and 0 reserves them per registration.
+type Mongo struct {
+	URI            Secret `yaml:"uri" env:"BACKEND_MONGO_URI"`
+	Database       string `yaml:"database" env:"BACKEND_MONGO_DB"`
+	MigrateOnStart bool   `yaml:"migrateOnStart" env:"BACKEND_MIGRATE_ON_START"`
+	PIDLease       int    `yaml:"pidLease" env:"BACKEND_PID_LEASE"`
+}
+
+// Storage selects where every record is kept. The default sqlite backend
+// needs no server and uses a metamorphosis.db file in the working directory
+// unless DSN says otherwise.
+type Storage struct {
+	Backend string `yaml:"backend" env:"BACKEND_STORAGE"` // sqlite, postgres or mongo
+	DSN     Secret `yaml:"dsn" env:"BACKEND_STORAGE_DSN"`
+}
+
+type Mail struct {
+	Host     string `yaml:"host" env:"BACKEND_MAIL_HOST"`
+	Port     int    `yaml:"port" env:"BACKEND_MAIL_PORT"`
+	User     string `yaml:"user" env:"BACKEND_MAIL_USER"`
+	Password Secret `yaml:"password" env:"BACKEND_MAIL_PASSWORD"`
+}
+
+type Cloudinary struct {
+	CloudName string `yaml:"cloudName" env:"CLOUDINARY_CLOUD_NAME"`
+	Key       string `yaml:"key" env:"CLOUDINARY_KEY"`
+	Secret    Secret `yaml:"secret" env:"CLOUDINARY_SECRET"`
+}
+
+// DefaultEvent is the event served by the legacy routes, which also adopts
+// the registrations stored before events existed. The name, window and
+// pricing are only used to create it on first start.
+type DefaultEvent struct {
+	Slug                 string    `yaml:"slug" env:"BACKEND_DEFAULT_EVENT"`
+	Name                 string    `yaml:"name" env:"BACKEND_DEFAULT_EVENT_NAME"`
+	RegistrationOpensAt  time.Time `yaml:"registrationOpensAt" env:"BACKEND_REGISTRATION_OPENS_AT"`
+	RegistrationClosesAt time.Time `yaml:"registrationClosesAt" env:"BACKEND_REGISTRATION_CLOSES_AT"`
+	EarlyBirdEndsAt      time.Time `yaml:"earlyBirdEndsAt" env:"BACKEND_EARLY_BIRD_ENDS_AT"`
+	Capacity             int       `yaml:"capacity" env:"BACKEND_CAPACITY"`
+	Fee                  int       `yaml:"fee" env:"BACKEND_FEE"`
+	EarlyBirdFee         int       `yaml:"earlyBirdFee" env:"BACKEND_EARLY_BIRD_FEE"`
+}
+
+type Protection struct {
+	Challenge      string `yaml:"challenge" env:"BACKEND_CHALLENGE"` // honeypot, pow or none
+	HoneypotField  string `yaml:"honeypotField" env:"BACKEND_HONEYPOT_FIELD"`
+	PowDifficulty  int    `yaml:"powDifficulty" env:"BACKEND_POW_DIFFICULTY"`
+	RateLimitStore string `yaml:"rateLimitStore" env:"BACKEND_RATE_LIMIT_STORE"` // memory or database (formerly mongo)
+	RateLimitIP    string `yaml:"rateLimitIp" env:"BACKEND_RATE_LIMIT_IP"`
+	RateLimitEmail string `yaml:"rateLimitEmail" env:"BACKEND_RATE_LIMIT_EMAIL"`
+	// RateLimitLogin limits admin sign-in attempts per username and client
+	// IP, RateLimitLoginIP per client IP whatever the username
+	RateLimitLogin   string `yaml:"rateLimitLogin" env:"BACKEND_RATE_LIMIT_LOGIN"`
+	RateLimitLoginIP string `yaml:"rateLimitLoginIp" env:"BACKEND_RATE_LIMIT_LOGIN_IP"`
+	TrustProxy       bool   `yaml:"trustProxy" env:"BACKEND_TRUST_PROXY"`
+	// TrustedProxies lists the addresses and CIDR ranges of the proxies in
+	// front of the server, comma-separated. Empty trusts only the direct peer.
+	TrustedProxies string `yaml:"trustedProxies" env:"BACKEND_TRUSTED_PROXIES"`
+}
+
+// Tracing exports OpenTelemetry spans. The OTLP exporter also honours the
+// standard OTEL_EXPORTER_OTLP_* variables when no endpoint is set here.
+type Tracing struct {
+	Exporter    string `yaml:"exporter" env:"BACKEND_TRACING_EXPORTER"` // none, otlp or stdout
+	Endpoint    string `yaml:"endpoint" env:"BACKEND_OTLP_ENDPOINT"`    // e.g. http://collector:4318
+	ServiceName string `yaml:"serviceName" env:"OTEL_SERVICE_NAME"`
+}
+
+// Retention anonymizes personal data once Period has passed since an event
+// ended or a registration was deleted, checking every Interval. Names,
+// emails, phones, team names and payment screenshots are removed while
+// colleges and years of study stay for reports. A zero period keeps
+// everything.
+type Retention struct {
+	Period   time.Duration `yaml:"period" env:"BACKEND_RETENTION_PERIOD"` // e.g. 2160h for 90 days
+	Interval time.Duration `yaml:"interval" env:"BACKEND_RETENTION_INTERVAL"`
+}
+
+// Encryption seals participants' emails and phones at rest when Keys is
+// set. Keys is a comma-separated list of id:base64 pairs of 32-byte keys;
+// the first encrypts and the others only decrypt. To rotate, put a new key
+// first, run migrate (or restart) to reseal every row, then drop the old
+// key. IndexKey derives the blind indexes used to look emails up.
+type Encryption struct {
+	Keys     Secret `yaml:"keys" env:"BACKEND_ENCRYPTION_KEYS"`
+	IndexKey Secret `yaml:"indexKey" env:"BACKEND_BLIND_INDEX_KEY"` // base64, at least 32 bytes
+}
+
+func defaults() Config {
+	return Config{
+		Port:        "5000",
+		CountryCode: "91",
+		LogLevel:    "info",
+		Server: Server{
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       time.Minute, // screenshots are uploaded from slow phones
+			WriteTimeout:      time.Minute,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   30 * time.Second,
+			DrainGrace:        5 * time.Second,
+		},
+		Mongo:        Mongo{Database: "metamorphosis", MigrateOnStart: true},
+		Storage:      Storage{Backend: "sqlite"},
+		Mail:         Mail{Port: 587},
+		DefaultEvent: DefaultEvent{Slug: "metamorphosis", Name: "Metamorphosis"},
+		Protection: Protection{
+			Challenge:        "honeypot",
+			HoneypotField:    "website",
+			PowDifficulty:    18,
+			RateLimitStore:   "memory",
+			RateLimitIP:      "60/h",
+			RateLimitEmail:   "5/h",
+			RateLimitLogin:   "10/h",
+			RateLimitLoginIP: "30/h",
+		},
+		Tracing:   Tracing{Exporter: "none", ServiceName: "metamorphosis-backend"},
+		Retention: Retention{Interval: 24 * time.Hour},
+	}
+}
+
+// Load reads the configuration from the YAML file at path, if any, and the
+// environment, then validates it
+func Load(path string) (Config, error) {
+	cfg := defaults()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("reading config file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+	}
+	envErr := applyEnv(reflect.ValueOf(&cfg).Elem())
+	return cfg, errors.Join(envErr, cfg.Validate())
+}
+
+// applyEnv overrides fields that have an env tag with the variable's value
+func applyEnv(v reflect.Value) error {
+	var errs []error
+	for i := 0; i < v.NumField(); i++ {
+		field, spec := v.Field(i), v.Type().Field(i)
+		key := spec.Tag.Get("env")
+		if key == "" {
+			if field.Kind() == reflect.Struct && field.Type() != reflect.TypeOf(time.Time{}) {
+				errs = append(errs, applyEnv(field))
+			}
+			continue
+		}
+		value, ok := os.LookupEnv(key)
+		if !ok || value == "" {
+			continue
+		}
+		if err := setField(field, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func setField(field reflect.Value, value string) error {
+	switch field.Interface().(type) {
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("must be a duration such as 30s")
+		}
+		field.Set(reflect.ValueOf(d))
+		return nil
+	case time.Time:
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("must be an RFC 3339 timestamp")
+		}
+		field.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("must be a whole number")
+		}
+		field.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("must be true or false")
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// Validate reports every missing or invalid setting at once
+func (c Config) Validate() error {
+	var errs []error
+	require := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	require(c.Port != "", "port is required (BACKEND_PORT)")
+	require(c.DefaultEvent.Slug != "", "defaultEvent.slug is required (BACKEND_DEFAULT_EVENT)")
+	if c.Storage.Backend == "mongo" {
+		require(c.Mongo.URI != "", "mongo.uri is required for the mongo backend (BACKEND_MONGO_URI)")
+		require(c.Mongo.Database != "", "mongo.database is required for the mongo backend (BACKEND_MONGO_DB)")
+	}
+	require(c.TokenSecret != "", "tokenSecret is required (BACKEND_TOKEN_SECRET)")
+	require(c.Mail.Host != "", "mail.host is required (BACKEND_MAIL_HOST)")
+	require(c.Mail.User != "", "mail.user is required (BACKEND_MAIL_USER)")
+	require(c.Mail.Password != "", "mail.password is required (BACKEND_MAIL_PASSWORD)")
+	s := c.Server
+	require(s.ReadHeaderTimeout > 0 && s.ReadTimeout > 0 && s.WriteTimeout > 0 && s.IdleTimeout > 0,
+		"server timeouts must be positive")
+	require(s.ShutdownTimeout > 0, "server.shutdownTimeout must be positive (BACKEND_SHUTDOWN_TIMEOUT)")
+	require(s.DrainGrace >= 0, "server.drainGrace cannot be negative (BACKEND_DRAIN_GRACE)")
+	require(c.Mail.Port > 0, "mail.port must be positive (BACKEND_MAIL_PORT)")
+	require(c.Mongo.PIDLease >= 0, "mongo.pidLease cannot be negative (BACKEND_PID_LEASE)")
+	require(c.Cloudinary.CloudName != "", "cloudinary.cloudName is required (CLOUDINARY_CLOUD_NAME)")
+	require(c.Cloudinary.Key != "", "cloudinary.key is required (CLOUDINARY_KEY)")
+	require(c.Cloudinary.Secret != "", "cloudinary.secret is required (CLOUDINARY_SECRET)")
+
+	if c.Mongo.URI != "" {
+		_, err := url.Parse(c.Mongo.URI.Value())
+		require(err == nil && strings.HasPrefix(c.Mongo.URI.Value(), "mongodb"), "mongo.uri must be a mongodb:// or mongodb+srv:// URI")
+	}
+	if c.ManageURL != "" {
+		u, err := url.Parse(c.ManageURL)
+		require(err == nil && u.IsAbs(), "manageUrl must be an absolute URL (BACKEND_MANAGE_URL)")
+	}
+	if c.PrivacyURL != "" {
+		u, err := url.Parse(c.PrivacyURL)
+		require(err == nil && u.IsAbs(), "privacyUrl must be an absolute URL (BACKEND_PRIVACY_URL)")
+	}
+	_, err := strconv.Atoi(c.CountryCode)
+	require(err == nil, "defaultCountryCode must be digits only (BACKEND_DEFAULT_COUNTRY_CODE)")
+	var level slog.Level
+	require(level.UnmarshalText([]byte(c.LogLevel)) == nil, "logLevel must be debug, info, warn or error (BACKEND_LOG_LEVEL)")
+
+	p := c.Protection
+	require(p.Challenge == "honeypot" || p.Challenge == "pow" || p.Challenge == "none",
+		"protection.challenge must be honeypot, pow or none (BACKEND_CHALLENGE)")
+	require(p.Challenge != "honeypot" || p.HoneypotField != "", "protection.honeypotField is required (BACKEND_HONEYPOT_FIELD)")
+	require(p.Challenge != "pow" || (p.PowDifficulty > 0 && p.PowDifficulty <= 32),
+		"protection.powDifficulty must be between 1 and 32 (BACKEND_POW_DIFFICULTY)")
+	require(p.RateLimitStore == "memory" || p.RateLimitStore == "database" || p.RateLimitStore == "mongo",
+		"protection.rateLimitStore must be memory or database (BACKEND_RATE_LIMIT_STORE)")
+	for _, entry := range strings.Split(p.TrustedProxies, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		_, prefixErr := netip.ParsePrefix(entry)
+		_, addrErr := netip.ParseAddr(entry)
+		require(prefixErr == nil || addrErr == nil,
+			"protection.trustedProxies: %q is not an address or CIDR range (BACKEND_TRUSTED_PROXIES)", entry)
+	}
+
+	st := c.Storage
+	require(st.Backend == "sqlite" || st.Backend == "postgres" || st.Backend == "mongo",
+		"storage.backend must be sqlite, postgres or mongo (BACKEND_STORAGE)")
+	require(st.Backend != "postgres" || st.DSN != "", "storage.dsn is required for postgres (BACKEND_STORAGE_DSN)")
+
+	t := c.Tracing
+	require(t.Exporter == "none" || t.Exporter == "otlp" || t.Exporter == "stdout",
+		"tracing.exporter must be none, otlp or stdout (BACKEND_TRACING_EXPORTER)")
+	if t.Endpoint != "" {
+		u, err := url.Parse(t.Endpoint)
+		require(err == nil && u.IsAbs(), "tracing.endpoint must be an absolute URL (BACKEND_OTLP_ENDPOINT)")
+	}
+
+	require(c.Retention.Period >= 0, "retention.period cannot be negative (BACKEND_RETENTION_PERIOD)")
+	require(c.Retention.Interval > 0, "retention.interval must be positive (BACKEND_RETENTION_INTERVAL)")
+
+	if c.Encryption.Keys != "" {
+		_, err := pii.ParseKeys(c.Encryption.Keys.Value())
+		require(err == nil, "encryption.keys: %v (BACKEND_ENCRYPTION_KEYS)", err)
+		indexKey, err := base64.StdEncoding.DecodeString(c.Encryption.IndexKey.Value())
+		require(err == nil && len(indexKey) >= 32, "encryption.indexKey must be at least 32 bytes of base64 (BACKEND_BLIND_INDEX_KEY)")
+	}
+
+	return errors.Join(errs...)
+}
+
+// Dump renders the configuration as YAML with secrets redacted
+func (c Config) Dump() string {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
@@ -0,0 +1,376 @@
+package controllers
+
+import (
+	"backend/src/models"
+	"backend/src/protection"
+	"backend/src/repository"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	adminSessionPurpose = "admin_session"
+	adminSessionTTL     = 12 * time.Hour
+	minPasswordLength   = 12
+	maxPasswordLength   = 72 // bcrypt rejects longer passwords
+)
+
+// Permissions checked by RequirePermission, one per group of admin routes
+const (
+	PermEvents       = "events"       // create, edit and pause events, view stats
+	PermParticipants = "participants" // look participants up at the desk
+	PermRecords      = "records"      // delete and restore registrations and participants
+	PermRefunds      = "refunds"      // review and pay out refunds
+	PermAudit        = "audit"        // read and export the audit log
+	PermSystem       = "system"       // runtime settings such as the log level
+	PermAdmins       = "admins"       // manage admin accounts
+)
+
+var rolePermissions = map[string][]string{
+	models.RoleOrganizer: {PermEvents, PermParticipants, PermRecords, PermAudit, PermSystem, PermAdmins},
+	models.RoleFinance:   {PermRefunds, PermAudit},
+	models.RoleVolunteer: {PermParticipants},
+}
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9._-]{3,32}$`)
+
+// dummyHash is compared against when the username does not exist so
+// logins take as long either way
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+// principal is who an admin request is made by. The static admin token is a
+// superuser with every permission.
+type principal struct {
+	Name      string // audit actor
+	Role      string
+	Superuser bool
+}
+
+func (p principal) can(permission string) bool {
+	return p.Superuser || slices.Contains(rolePermissions[p.Role], permission)
+}
+
+type principalKey struct{}
+
+func principalFrom(ctx context.Context) principal {
+	p, _ := ctx.Value(principalKey{}).(principal)
+	return p
+}
+
+// adminActor names the signed-in admin in audit entries
+func adminActor(ctx context.Context) string {
+	if name := principalFrom(ctx).Name; name != "" {
+		return name
+	}
+	return "admin"
+}
+
+// RequireAdmin accepts the configured admin token or a session token from
+// Login, and rejects everything else
+func (a AdminService) RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		var p principal
+		if a.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.token.Value())) == 1 {
+			p = principal{Name: "admin", Superuser: true}
+		} else if user, ok := a.sessionUser(r.Context(), token); ok {
+			p = principal{Name: "admin:" + user.Username, Role: user.Role}
+		} else {
+			writeError(w, http.StatusUnauthorized, models.Error{Message: "Unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+// sessionUser resolves a session token to an enabled account whose
+// sessions have not been ended since the token was issued
+func (a AdminService) sessionUser(ctx context.Context, token string) (models.AdminUser, bool) {
+	if a.Tokens == nil || token == "" {
+		return models.AdminUser{}, false
+	}
+	claims, err := a.Tokens.Verify(token, adminSessionPurpose)
+	if err != nil {
+		return models.AdminUser{}, false
+	}
+	username, version, _ := strings.Cut(claims.Subject, "/")
+	user, err := a.Store.GetAdminUser(ctx, username)
+	if err != nil {
+		if !errors.Is(err, repository.ErrNotFound) {
+			slog.ErrorContext(ctx, "Error reading admin user", "error", err)
+		}
+		return user, false
+	}
+	return user, !user.Disabled && version == strconv.Itoa(user.SessionVersion)
+}
+
+// RequirePermission rejects admins whose role lacks the permission. It must
+// run after RequireAdmin.
+func RequirePermission(permission string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !principalFrom(r.Context()).can(permission) {
+				writeError(w, http.StatusForbidden, models.Error{Message: "Your role does not allow this", Code: "forbidden"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Login exchanges a username and password for a session token
+func (a AdminService) Login(w http.ResponseWriter, r *http.Request) {
+	var req loginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Username == "" || req.Password == "" {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Username and password are required"})
+		return
+	}
+	username := strings.ToLower(strings.TrimSpace(req.Username))
+
+	// One address may not guess many usernames, nor many addresses one
+	// username as fast as one address could
+	ip, _ := r.Context().Value(clientIPKey{}).(string)
+	for _, limit := range []struct {
+		limiter *protection.Limiter
+		key     string
+	}{
+		{a.LoginIPLimiter, ip},
+		{a.LoginLimiter, username + "|" + ip},
+	} {
+		if limit.limiter == nil {
+			continue
+		}
+		allowed, retryAfter, err := limit.limiter.Allow(r.Context(), limit.key)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Rate limiter error", "error", err)
+		} else if !allowed {
+			protection.WriteTooManyRequests(w, retryAfter)
+			return
+		}
+	}
+
+	user, err := a.Store.GetAdminUser(r.Context(), username)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		slog.ErrorContext(r.Context(), "Error reading admin user", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not sign in"})
+		return
+	}
+	hash := []byte(user.PasswordHash)
+	if err != nil {
+		hash = dummyHash
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(req.Password)) != nil || user.Disabled || err != nil {
+		writeError(w, http.StatusUnauthorized, models.Error{Message: "Incorrect username or password", Code: "login_failed"})
+		return
+	}
+
+	token, err := a.Tokens.Sign(adminSessionPurpose, user.Username+"/"+strconv.Itoa(user.SessionVersion), "", adminSessionTTL)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error signing session token", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not sign in"})
+		return
+	}
+	now := time.Now()
+	if _, err := a.Store.UpdateAdminUser(r.Context(), user.Username, repository.AdminUserUpdate{LastLoginAt: &now}, false); err != nil {
+		slog.ErrorContext(r.Context(), "Error recording login", "error", err)
+	}
+	recordAudit(r.Context(), a.Store, models.AuditEntry{
+		Actor:      "admin:" + user.Username,
+		Action:     "admin.login",
+		TargetType: "admin",
+		TargetID:   user.Username,
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"token":     token,
+		"expiresAt": time.Now().Add(adminSessionTTL),
+		"username":  user.Username,
+		"role":      user.Role,
+	})
+}
+
+// Logout ends every session of the signed-in account
+func (a AdminService) Logout(w http.ResponseWriter, r *http.Request) {
+	p := principalFrom(r.Context())
+	username, ok := strings.CutPrefix(p.Name, "admin:")
+	if !ok {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "The admin token has no session to end"})
+		return
+	}
+	err := a.Store.WithTransaction(r.Context(), func(ctx context.Context) error {
+		if _, err := a.Store.UpdateAdminUser(ctx, username, repository.AdminUserUpdate{}, true); err != nil {
+			return err
+		}
+		return a.Store.CreateAuditEntry(ctx, stampAudit(ctx, models.AuditEntry{
+			Actor:      p.Name,
+			Action:     "admin.logout",
+			TargetType: "admin",
+			TargetID:   username,
+		}))
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error ending sessions", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not sign out"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a AdminService) ListAdminUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := a.Store.ListAdminUsers(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing admin users", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not list admin users"})
+		return
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+type adminUserRequest struct {
+	Username string  `json:"username"`
+	Password *string `json:"password"`
+	Role     *string `json:"role"`
+	Disabled *bool   `json:"disabled"`
+}
+
+// validate checks the fields that are set, writing the error response
+// itself and returning false on failure
+func (req adminUserRequest) validate(w http.ResponseWriter) bool {
+	if req.Password != nil && len(*req.Password) < minPasswordLength {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Password must be at least " + strconv.Itoa(minPasswordLength) + " characters"})
+		return false
+	}
+	if req.Password != nil && len(*req.Password) > maxPasswordLength {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Password must be at most " + strconv.Itoa(maxPasswordLength) + " bytes"})
+		return false
+	}
+	if req.Role != nil && rolePermissions[*req.Role] == nil {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Role must be organizer, finance or volunteer"})
+		return false
+	}
+	return true
+}
+
+func (a AdminService) CreateAdminUser(w http.ResponseWriter, r *http.Request) {
+	var req adminUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Invalid request body"})
+		return
+	}
+	req.Username = strings.ToLower(strings.TrimSpace(req.Username))
+	if !usernamePattern.MatchString(req.Username) || req.Password == nil || req.Role == nil {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "A username of 3 to 32 lowercase letters, digits, dots, dashes or underscores, a password and a role are required"})
+		return
+	}
+	if !req.validate(w) {
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(*req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error hashing password", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not create admin user"})
+		return
+	}
+
+	user := models.AdminUser{Username: req.Username, PasswordHash: string(hash), Role: *req.Role}
+	err = a.Store.WithTransaction(r.Context(), func(ctx context.Context) error {
+		if _, err := a.Store.CreateAdminUser(ctx, user); err != nil {
+			return err
+		}
+		return a.Store.CreateAuditEntry(ctx, stampAudit(ctx, models.AuditEntry{
+			Actor:      adminActor(ctx),
+			Action:     "admin.create",
+			TargetType: "admin",
+			TargetID:   user.Username,
+			Changes:    map[string]models.Change{"role": {After: user.Role}},
+		}))
+	})
+	if errors.Is(err, repository.ErrDuplicate) {
+		writeError(w, http.StatusConflict, models.Error{Message: "Username is already taken"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error creating admin user", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not create admin user"})
+		return
+	}
+	writeJSON(w, http.StatusCreated, user)
+}
+
+// UpdateAdminUser changes the role, password or disabled flag of an
+// account. Any of them signs the account out.
+func (a AdminService) UpdateAdminUser(w http.ResponseWriter, r *http.Request) {
+	var req adminUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Invalid request body"})
+		return
+	}
+	if !req.validate(w) {
+		return
+	}
+	update := repository.AdminUserUpdate{Role: req.Role, Disabled: req.Disabled}
+	changes := map[string]models.Change{}
+	if req.Password != nil {
+		hash, err := bcrypt.GenerateFromPassword([]byte(*req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error hashing password", "error", err)
+			writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not update admin user"})
+			return
+		}
+		passwordHash := string(hash)
+		update.PasswordHash = &passwordHash
+		changes["password"] = models.Change{After: "[changed]"}
+	}
+	if update == (repository.AdminUserUpdate{}) {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Nothing to update"})
+		return
+	}
+
+	username := mux.Vars(r)["username"]
+	var user models.AdminUser
+	err := a.Store.WithTransaction(r.Context(), func(ctx context.Context) error {
+		before, err := a.Store.GetAdminUser(ctx, username)
+		if err != nil {
+			return err
+		}
+		if user, err = a.Store.UpdateAdminUser(ctx, username, update, true); err != nil {
+			return err
+		}
+		for key, change := range diffFields(before, user, "passwordHash", "sessionVersion", "updatedAt") {
+			changes[key] = change
+		}
+		return a.Store.CreateAuditEntry(ctx, stampAudit(ctx, models.AuditEntry{
+			Actor:      adminActor(ctx),
+			Action:     "admin.update",
+			TargetType: "admin",
+			TargetID:   username,
+			Changes:    changes,
+		}))
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		writeError(w, http.StatusNotFound, models.Error{Message: "Admin user not found"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error updating admin user", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not update admin user"})
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
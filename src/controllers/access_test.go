@@ -0,0 +1,257 @@
+package controllers
+
+import (
+	"backend/src/models"
+	"backend/src/protection"
+	"backend/src/repository"
+	"backend/src/tokens"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newAccessService has an organizer, a finance and a volunteer account with
+// the password "correct horse battery", and a disabled finance account
+func newAccessService(t *testing.T) AdminService {
+	t.Helper()
+	ctx := context.Background()
+	store := repository.NewMemory()
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse battery"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword: %v", err)
+	}
+	for _, user := range []models.AdminUser{
+		{Username: "olga", Role: models.RoleOrganizer},
+		{Username: "finn", Role: models.RoleFinance},
+		{Username: "vera", Role: models.RoleVolunteer},
+		{Username: "dora", Role: models.RoleFinance, Disabled: true},
+	} {
+		user.PasswordHash = string(hash)
+		if _, err := store.CreateAdminUser(ctx, user); err != nil {
+			t.Fatalf("CreateAdminUser: %v", err)
+		}
+	}
+	return AdminService{Store: store, Tokens: tokens.NewSigner("secret"), token: "static-admin-token"}
+}
+
+// login signs in from ip and returns the response
+func login(a AdminService, ip, username, password string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(loginRequest{Username: username, Password: password})
+	r := httptest.NewRequest("POST", "/admin/login", strings.NewReader(string(body)))
+	r = r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip))
+	w := httptest.NewRecorder()
+	a.Login(w, r)
+	return w
+}
+
+// sessionToken signs in and returns the session token
+func sessionToken(t *testing.T, a AdminService, username string) string {
+	t.Helper()
+	w := login(a, "192.0.2.1", username, "correct horse battery")
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil || resp.Token == "" {
+		t.Fatalf("Login(%s) = %d %s", username, w.Code, w.Body)
+	}
+	return resp.Token
+}
+
+func TestRolePermissions(t *testing.T) {
+	all := []string{PermEvents, PermParticipants, PermRecords, PermRefunds, PermAudit, PermSystem, PermAdmins}
+	for _, tt := range []struct {
+		name      string
+		principal principal
+		want      []string
+	}{
+		{"organizer", principal{Role: models.RoleOrganizer}, []string{PermEvents, PermParticipants, PermRecords, PermAudit, PermSystem, PermAdmins}},
+		{"finance", principal{Role: models.RoleFinance}, []string{PermRefunds, PermAudit}},
+		{"volunteer", principal{Role: models.RoleVolunteer}, []string{PermParticipants}},
+		{"unknown role", principal{Role: "owner"}, nil},
+		{"no role", principal{}, nil},
+		{"superuser", principal{Superuser: true}, all},
+	} {
+		for _, permission := range all {
+			if got, want := tt.principal.can(permission), slices.Contains(tt.want, permission); got != want {
+				t.Errorf("%s can %s = %v, want %v", tt.name, permission, got, want)
+			}
+		}
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	a := newAccessService(t)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	for _, tt := range []struct {
+		name       string
+		token      string
+		permission string
+		want       int
+	}{
+		{"no token", "", PermRefunds, http.StatusUnauthorized},
+		{"bad token", "not-a-token", PermRefunds, http.StatusUnauthorized},
+		{"static admin token", "static-admin-token", PermAdmins, http.StatusOK},
+		{"organizer", sessionToken(t, a, "olga"), PermAdmins, http.StatusOK},
+		{"organizer without refunds", sessionToken(t, a, "olga"), PermRefunds, http.StatusForbidden},
+		{"finance", sessionToken(t, a, "finn"), PermRefunds, http.StatusOK},
+		{"finance without records", sessionToken(t, a, "finn"), PermRecords, http.StatusForbidden},
+		{"volunteer", sessionToken(t, a, "vera"), PermParticipants, http.StatusOK},
+		{"volunteer without audit", sessionToken(t, a, "vera"), PermAudit, http.StatusForbidden},
+	} {
+		r := httptest.NewRequest("GET", "/admin/anything", nil)
+		r.Header.Set("Authorization", "Bearer "+tt.token)
+		w := httptest.NewRecorder()
+		a.RequireAdmin(RequirePermission(tt.permission)(ok)).ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s asking for %s = %d, want %d", tt.name, tt.permission, w.Code, tt.want)
+		}
+	}
+}
+
+func TestSessionRevocation(t *testing.T) {
+	ctx := context.Background()
+	disabled := true
+	for _, tt := range []struct {
+		name   string
+		revoke func(a AdminService, token string) error
+	}{
+		{"logout", func(a AdminService, token string) error {
+			r := httptest.NewRequest("POST", "/admin/logout", nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			a.RequireAdmin(http.HandlerFunc(a.Logout)).ServeHTTP(w, r)
+			if w.Code != http.StatusNoContent {
+				t.Errorf("Logout = %d %s", w.Code, w.Body)
+			}
+			return nil
+		}},
+		{"sessions ended", func(a AdminService, token string) error {
+			_, err := a.Store.UpdateAdminUser(ctx, "finn", repository.AdminUserUpdate{}, true)
+			return err
+		}},
+		{"account disabled", func(a AdminService, token string) error {
+			_, err := a.Store.UpdateAdminUser(ctx, "finn", repository.AdminUserUpdate{Disabled: &disabled}, false)
+			return err
+		}},
+	} {
+		a := newAccessService(t)
+		token := sessionToken(t, a, "finn")
+		if _, ok := a.sessionUser(ctx, token); !ok {
+			t.Fatalf("%s: fresh session rejected", tt.name)
+		}
+		if err := tt.revoke(a, token); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if _, ok := a.sessionUser(ctx, token); ok {
+			t.Errorf("%s: revoked session still accepted", tt.name)
+		}
+	}
+
+	// Ending the sessions leaves the account able to sign in again
+	a := newAccessService(t)
+	old := sessionToken(t, a, "finn")
+	if _, err := a.Store.UpdateAdminUser(ctx, "finn", repository.AdminUserUpdate{}, true); err != nil {
+		t.Fatalf("UpdateAdminUser: %v", err)
+	}
+	if _, ok := a.sessionUser(ctx, sessionToken(t, a, "finn")); !ok {
+		t.Error("session from a new sign-in rejected")
+	}
+	if _, ok := a.sessionUser(ctx, old); ok {
+		t.Error("session from before the sign-out accepted after a new sign-in")
+	}
+	entries, _ := a.Store.ListAuditEntries(ctx, repository.AuditFilter{Action: "admin.login"}, 0)
+	if len(entries) != 2 || entries[0].Actor != "admin:finn" {
+		t.Errorf("login audit entries = %+v, want two by admin:finn", entries)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	a := newAccessService(t)
+	for _, tt := range []struct {
+		name     string
+		username string
+		password string
+		want     int
+		code     string
+	}{
+		{"correct", "finn", "correct horse battery", http.StatusOK, ""},
+		{"username case and spaces", " FINN ", "correct horse battery", http.StatusOK, ""},
+		{"wrong password", "finn", "wrong horse battery", http.StatusUnauthorized, "login_failed"},
+		{"unknown username", "nobody", "correct horse battery", http.StatusUnauthorized, "login_failed"},
+		{"disabled", "dora", "correct horse battery", http.StatusUnauthorized, "login_failed"},
+		{"password over bcrypt's limit", "finn", strings.Repeat("x", 100), http.StatusUnauthorized, "login_failed"},
+		{"missing password", "finn", "", http.StatusBadRequest, ""},
+		{"missing username", "", "correct horse battery", http.StatusBadRequest, ""},
+	} {
+		w := login(a, "192.0.2.1", tt.username, tt.password)
+		var resp struct {
+			Token string `json:"token"`
+			Role  string `json:"role"`
+			Code  string `json:"code"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != tt.want || resp.Code != tt.code {
+			t.Errorf("Login(%s) = %d %s, want %d %q", tt.name, w.Code, w.Body, tt.want, tt.code)
+		}
+		if tt.want == http.StatusOK && (resp.Token == "" || resp.Role != models.RoleFinance) {
+			t.Errorf("Login(%s) = %s, want a finance session", tt.name, w.Body)
+		}
+	}
+}
+
+func TestLoginRateLimits(t *testing.T) {
+	a := newAccessService(t)
+	store := protection.NewMemoryStore()
+	a.LoginLimiter = &protection.Limiter{Store: store, Rate: protection.Rate{Burst: 2, Per: time.Hour}, Prefix: "login"}
+	a.LoginIPLimiter = &protection.Limiter{Store: store, Rate: protection.Rate{Burst: 3, Per: time.Hour}, Prefix: "login-ip"}
+	// Steps run in order, each taking from the buckets the earlier ones used
+	for i, tt := range []struct {
+		ip       string
+		username string
+		want     int
+	}{
+		{"192.0.2.1", "finn", http.StatusUnauthorized},
+		{"192.0.2.1", "finn", http.StatusUnauthorized},
+		{"192.0.2.1", "finn", http.StatusTooManyRequests}, // username from this address
+		{"192.0.2.1", "olga", http.StatusTooManyRequests}, // any username from this address
+		{"192.0.2.2", "finn", http.StatusUnauthorized},    // the username from elsewhere
+		{"192.0.2.2", "olga", http.StatusUnauthorized},
+	} {
+		if w := login(a, tt.ip, tt.username, "wrong horse battery"); w.Code != tt.want {
+			t.Errorf("step %d: Login(%s from %s) = %d, want %d", i+1, tt.username, tt.ip, w.Code, tt.want)
+		}
+	}
+}
+
+func TestAdminUserRequestValidate(t *testing.T) {
+	password := func(n int) *string {
+		p := strings.Repeat("x", n)
+		return &p
+	}
+	role := func(r string) *string { return &r }
+	for _, tt := range []struct {
+		name string
+		req  adminUserRequest
+		want bool
+	}{
+		{"nothing set", adminUserRequest{}, true},
+		{"shortest password", adminUserRequest{Password: password(minPasswordLength)}, true},
+		{"too short", adminUserRequest{Password: password(minPasswordLength - 1)}, false},
+		{"longest password", adminUserRequest{Password: password(maxPasswordLength)}, true},
+		{"too long for bcrypt", adminUserRequest{Password: password(maxPasswordLength + 1)}, false},
+		{"role", adminUserRequest{Role: role(models.RoleVolunteer)}, true},
+		{"unknown role", adminUserRequest{Role: role("owner")}, false},
+	} {
+		w := httptest.NewRecorder()
+		if got := tt.req.validate(w); got != tt.want || !got != (w.Code == http.StatusBadRequest) {
+			t.Errorf("validate(%s) = %v with %d, want %v", tt.name, got, w.Code, tt.want)
+		}
+	}
+}
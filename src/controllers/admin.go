@@ -0,0 +1,288 @@
+package controllers
+
+import (
+	"backend/src/codes"
+	"backend/src/config"
+	"backend/src/logging"
+	"backend/src/models"
+	"backend/src/protection"
+	"backend/src/repository"
+	"backend/src/tokens"
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type AdminService struct {
+	Store          repository.Store
+	Tokens         *tokens.Signer      // issues session tokens; nil allows only the admin token
+	LoginLimiter   *protection.Limiter // sign-ins per username and client IP; nil disables it
+	LoginIPLimiter *protection.Limiter // sign-ins per client IP; nil disables it
+	token          config.Secret
+}
+
+func NewAdminService(store repository.Store, token config.Secret) *AdminService {
+	return &AdminService{Store: store, token: token}
+}
+
+func (a AdminService) ListEvents(w http.ResponseWriter, r *http.Request) {
+	events, err := a.Store.ListEvents(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing events", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not list events"})
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+func (a AdminService) CreateEvent(w http.ResponseWriter, r *http.Request) {
+	var event models.Event
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Invalid request body"})
+		return
+	}
+	if !slugPattern.MatchString(event.Slug) || event.Name == "" {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "A lowercase slug and a name are required"})
+		return
+	}
+	if !validCodePrefix(&event) {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "The code prefix must be up to 10 letters and digits"})
+		return
+	}
+	if !validTemplates(w, event) {
+		return
+	}
+
+	event.ID = models.ID{}
+	err := a.Store.WithTransaction(r.Context(), func(ctx context.Context) error {
+		var err error
+		if event.ID, err = a.Store.CreateEvent(ctx, event); err != nil {
+			return err
+		}
+		return a.Store.CreateAuditEntry(ctx, stampAudit(ctx, models.AuditEntry{
+			Actor:      adminActor(ctx),
+			Action:     "event.create",
+			TargetType: "event",
+			TargetID:   event.Slug,
+			EventID:    event.ID,
+			Changes:    diffFields(models.Event{}, event, "id"),
+		}))
+	})
+	if errors.Is(err, repository.ErrDuplicate) {
+		writeError(w, http.StatusConflict, models.Error{Message: "Event already exists"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error creating event", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not create event"})
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"id": event.ID.Hex()})
+}
+
+func (a AdminService) UpdateEvent(w http.ResponseWriter, r *http.Request) {
+	existing, err := a.Store.GetEventBySlug(r.Context(), mux.Vars(r)["slug"])
+	if errors.Is(err, repository.ErrNotFound) {
+		writeError(w, http.StatusNotFound, models.Error{Message: "Event not found"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading event", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not read event"})
+		return
+	}
+
+	var event models.Event
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Invalid request body"})
+		return
+	}
+	if event.Name == "" {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Event name is required"})
+		return
+	}
+	// The slug is referenced by routes and the pause switch has its own
+	// endpoint. The default code prefix comes from the slug.
+	event.Slug = existing.Slug
+	if !validCodePrefix(&event) {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "The code prefix must be up to 10 letters and digits"})
+		return
+	}
+	if !validTemplates(w, event) {
+		return
+	}
+	event.ID = existing.ID
+	event.Paused = existing.Paused
+	event.ResumesAt = existing.ResumesAt
+	event.CreatedAt = existing.CreatedAt
+	err = a.Store.WithTransaction(r.Context(), func(ctx context.Context) error {
+		if err := a.Store.UpdateEvent(ctx, event); err != nil {
+			return err
+		}
+		return a.Store.CreateAuditEntry(ctx, stampAudit(ctx, models.AuditEntry{
+			Actor:      adminActor(ctx),
+			Action:     "event.update",
+			TargetType: "event",
+			TargetID:   event.Slug,
+			EventID:    event.ID,
+			Changes:    diffFields(existing, event, "updatedAt"),
+		}))
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error updating event", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not update event"})
+		return
+	}
+	writeJSON(w, http.StatusOK, event)
+}
+
+// validCodePrefix uppercases the event's code prefix and checks it, or the
+// one derived from the slug when it is empty
+func validCodePrefix(event *models.Event) bool {
+	event.CodePrefix = strings.ToUpper(strings.TrimSpace(event.CodePrefix))
+	return codes.ValidPrefix(event.ParticipantCodePrefix())
+}
+
+// validTemplates parses the event's confirmation template, so that a broken
+// one is rejected when saved rather than failing every confirmation email.
+// It writes the error response itself and returns false on failure.
+func validTemplates(w http.ResponseWriter, event models.Event) bool {
+	if event.Templates.Confirmation == "" {
+		return true
+	}
+	if _, err := template.New("confirmation").Parse(event.Templates.Confirmation); err != nil {
+		writeError(w, http.StatusBadRequest, models.Error{
+			Message: "The confirmation template is invalid",
+			Code:    "template_invalid",
+			Fields:  []models.FieldError{{Index: -1, Field: "templates.confirmation", Message: err.Error()}},
+		})
+		return false
+	}
+	return true
+}
+
+// eventFromRequest loads the event named by the slug route variable. It
+// writes the error response itself and returns false on failure.
+func (a AdminService) eventFromRequest(w http.ResponseWriter, r *http.Request) (models.Event, bool) {
+	event, err := a.Store.GetEventBySlug(r.Context(), mux.Vars(r)["slug"])
+	if errors.Is(err, repository.ErrNotFound) {
+		writeError(w, http.StatusNotFound, models.Error{Message: "Event not found"})
+		return event, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading event", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not read event"})
+		return event, false
+	}
+	return event, true
+}
+
+// GetParticipantByCode looks up a participant at the registration desk. A
+// code with a wrong check character is reported as a typo.
+func (a AdminService) GetParticipantByCode(w http.ResponseWriter, r *http.Request) {
+	event, ok := a.eventFromRequest(w, r)
+	if !ok {
+		return
+	}
+	code := codes.Normalize(mux.Vars(r)["code"])
+	if !codes.Valid(code) {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Code is mistyped", Code: "code_invalid"})
+		return
+	}
+	participant, err := a.Store.GetParticipantByCode(r.Context(), event.ID, code)
+	if errors.Is(err, repository.ErrNotFound) {
+		writeError(w, http.StatusNotFound, models.Error{Message: "Participant not found"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading participant", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not read participant"})
+		return
+	}
+	writeJSON(w, http.StatusOK, participant)
+}
+
+type pauseRequest struct {
+	Paused    bool       `json:"paused"`
+	ResumesAt *time.Time `json:"resumesAt"`
+}
+
+// PauseRegistrations toggles the maintenance switch for an event's registrations
+func (a AdminService) PauseRegistrations(w http.ResponseWriter, r *http.Request) {
+	var req pauseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Invalid request body"})
+		return
+	}
+	if !req.Paused {
+		req.ResumesAt = nil
+	}
+	var event models.Event
+	err := a.Store.WithTransaction(r.Context(), func(ctx context.Context) error {
+		before, err := a.Store.GetEventBySlug(ctx, mux.Vars(r)["slug"])
+		if err != nil {
+			return err
+		}
+		if event, err = a.Store.SetEventPaused(ctx, before.Slug, req.Paused, req.ResumesAt); err != nil {
+			return err
+		}
+		return a.Store.CreateAuditEntry(ctx, stampAudit(ctx, models.AuditEntry{
+			Actor:      adminActor(ctx),
+			Action:     "event.pause",
+			TargetType: "event",
+			TargetID:   event.Slug,
+			EventID:    event.ID,
+			Changes:    diffFields(before, event, "updatedAt"),
+		}))
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		writeError(w, http.StatusNotFound, models.Error{Message: "Event not found"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error updating event", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not update event"})
+		return
+	}
+	writeJSON(w, http.StatusOK, event)
+}
+
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+func (a AdminService) GetLogLevel(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, logLevelRequest{Level: logging.Level.Level().String()})
+}
+
+// SetLogLevel changes the log level until the next restart
+func (a AdminService) SetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req logLevelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Invalid request body"})
+		return
+	}
+	level, err := logging.ParseLevel(req.Level)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Level must be debug, info, warn or error"})
+		return
+	}
+	old := logging.Level.Level()
+	logging.Level.Set(level)
+	slog.InfoContext(r.Context(), "Log level changed", "from", old.String(), "to", level.String())
+	recordAudit(r.Context(), a.Store, models.AuditEntry{
+		Actor:      adminActor(r.Context()),
+		Action:     "config.logLevel",
+		TargetType: "config",
+		TargetID:   "logLevel",
+		Changes:    map[string]models.Change{"level": {Before: old.String(), After: level.String()}},
+	})
+	writeJSON(w, http.StatusOK, logLevelRequest{Level: level.String()})
+}
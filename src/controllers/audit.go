@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"backend/src/models"
+	"backend/src/protection"
+	"backend/src/repository"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	auditPageSize    = 100
+	auditMaxPageSize = 1000
+)
+
+// redactedValue replaces personal data in audit entries
+const redactedValue = "[redacted]"
+
+// Actors for changes no admin or participant made: background jobs, and
+// requests made before anyone is identified
+const (
+	systemActor    = "system"
+	anonymousActor = "anonymous"
+)
+
+type clientIPKey struct{}
+
+// ClientIPMiddleware records the client address in the request context so
+// audit entries can carry it
+func ClientIPMiddleware(proxies protection.TrustedProxies) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), clientIPKey{}, protection.ClientIP(r, proxies))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// participantActor names a participant acting through their email in audit
+// entries and refunds without storing the address
+func (u UserService) participantActor(email string) string {
+	return "participant:" + u.hashEmail(email)
+}
+
+// stampAudit sets the client address of the request in ctx on the entry
+func stampAudit(ctx context.Context, entry models.AuditEntry) models.AuditEntry {
+	entry.IP, _ = ctx.Value(clientIPKey{}).(string)
+	return entry
+}
+
+// recordAudit appends an entry for a change that has already been made,
+// logging failures rather than failing the request. Changes made in a
+// single store transaction write their entry in it instead.
+func recordAudit(ctx context.Context, audit repository.AuditRepository, entry models.AuditEntry) {
+	if err := audit.CreateAuditEntry(ctx, stampAudit(ctx, entry)); err != nil {
+		slog.ErrorContext(ctx, "Error writing audit entry", "error", err)
+	}
+}
+
+// diffFields compares two documents by their JSON fields, skipping the
+// given ones. Comparing with a zero value lists every field that is set.
+func diffFields(before, after interface{}, skip ...string) map[string]models.Change {
+	a, b := toDoc(before), toDoc(after)
+	changes := map[string]models.Change{}
+	for _, doc := range []map[string]interface{}{a, b} {
+		for key := range doc {
+			if slices.Contains(skip, key) || reflect.DeepEqual(a[key], b[key]) {
+				continue
+			}
+			changes[key] = models.Change{Before: a[key], After: b[key]}
+		}
+	}
+	return changes
+}
+
+func toDoc(value interface{}) map[string]interface{} {
+	doc := map[string]interface{}{}
+	if data, err := json.Marshal(value); err == nil {
+		json.Unmarshal(data, &doc)
+	}
+	return doc
+}
+
+// auditFilter builds the query from ?actor=, ?action=, ?targetType=,
+// ?targetId=, ?event= and the ?since= and ?until= RFC 3339 times. It writes
+// the error response itself and returns false on failure.
+func (a AdminService) auditFilter(w http.ResponseWriter, r *http.Request) (repository.AuditFilter, bool) {
+	query := r.URL.Query()
+	filter := repository.AuditFilter{
+		Actor:      query.Get("actor"),
+		Action:     query.Get("action"),
+		TargetType: query.Get("targetType"),
+	}
+	if targetID := query.Get("targetId"); targetID != "" {
+		filter.TargetIDs = []string{targetID}
+	}
+	if slug := query.Get("event"); slug != "" {
+		event, err := a.Store.GetEventBySlug(r.Context(), slug)
+		if err != nil {
+			writeError(w, http.StatusNotFound, models.Error{Message: "Event not found"})
+			return filter, false
+		}
+		filter.EventID = event.ID
+	}
+	for param, bound := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, models.Error{Message: param + " must be an RFC 3339 time"})
+				return filter, false
+			}
+			*bound = t
+		}
+	}
+	return filter, true
+}
+
+// ListAuditEntries returns a page of the audit log, newest first. Pass the
+// last entry's ID as ?before= for the next page.
+func (a AdminService) ListAuditEntries(w http.ResponseWriter, r *http.Request) {
+	filter, ok := a.auditFilter(w, r)
+	if !ok {
+		return
+	}
+	limit := auditPageSize
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > auditMaxPageSize {
+			writeError(w, http.StatusBadRequest, models.Error{Message: "limit must be between 1 and " + strconv.Itoa(auditMaxPageSize)})
+			return
+		}
+		limit = n
+	}
+	if before := r.URL.Query().Get("before"); before != "" {
+		id, err := models.ParseID(before)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, models.Error{Message: "Invalid before ID"})
+			return
+		}
+		filter.Before = id
+	}
+
+	entries, err := a.Store.ListAuditEntries(r.Context(), filter, limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing audit entries", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not list audit entries"})
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// ExportAuditEntries downloads every matching entry as CSV, with the
+// changes as a JSON column. Entries are written as they are read, so an
+// error after the first row can only cut the download short.
+func (a AdminService) ExportAuditEntries(w http.ResponseWriter, r *http.Request) {
+	filter, ok := a.auditFilter(w, r)
+	if !ok {
+		return
+	}
+
+	out := csv.NewWriter(w)
+	started := false
+	err := a.Store.EachAuditEntry(r.Context(), filter, func(entry models.AuditEntry) error {
+		if !started {
+			started = true
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+			out.Write(auditCSVHeader)
+		}
+		changes := ""
+		if len(entry.Changes) > 0 {
+			data, _ := json.Marshal(entry.Changes)
+			changes = string(data)
+		}
+		eventID := ""
+		if !entry.EventID.IsZero() {
+			eventID = entry.EventID.Hex()
+		}
+		return out.Write([]string{entry.ID.Hex(), entry.CreatedAt.UTC().Format(time.RFC3339), entry.Actor, entry.Action,
+			entry.TargetType, entry.TargetID, eventID, entry.IP, changes})
+	})
+	if err != nil && !started {
+		slog.ErrorContext(r.Context(), "Error listing audit entries", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not export audit entries"})
+		return
+	}
+	if !started {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+		out.Write(auditCSVHeader)
+	}
+	out.Flush()
+	if err == nil {
+		err = out.Error()
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error writing audit export", "error", err)
+	}
+}
+
+var auditCSVHeader = []string{"id", "createdAt", "actor", "action", "targetType", "targetId", "eventId", "ip", "changes"}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"backend/src/models"
+	"backend/src/repository"
+	"context"
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// brokenAudit cannot read the audit log
+type brokenAudit struct {
+	repository.Store
+}
+
+func (brokenAudit) EachAuditEntry(ctx context.Context, filter repository.AuditFilter, fn func(models.AuditEntry) error) error {
+	return errors.New("audit log unavailable")
+}
+
+func TestExportAuditEntries(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemory()
+	for _, target := range []string{"a", "b", "c"} {
+		store.CreateAuditEntry(ctx, models.AuditEntry{Actor: "admin:root", Action: "event.update", TargetType: "event", TargetID: target,
+			Changes: map[string]models.Change{"name": {Before: "A", After: "B"}}})
+		time.Sleep(2 * time.Millisecond)
+	}
+	store.CreateAuditEntry(ctx, models.AuditEntry{Actor: "admin:root", Action: "event.create", TargetType: "event", TargetID: "d"})
+
+	for _, tt := range []struct {
+		name    string
+		store   repository.Store
+		query   string
+		status  int
+		targets []string
+	}{
+		{"all", store, "", http.StatusOK, []string{"d", "c", "b", "a"}},
+		{"filtered", store, "?action=event.update", http.StatusOK, []string{"c", "b", "a"}},
+		{"empty", store, "?action=event.delete", http.StatusOK, nil},
+		{"store error", brokenAudit{store}, "", http.StatusInternalServerError, nil},
+	} {
+		w := httptest.NewRecorder()
+		AdminService{Store: tt.store}.ExportAuditEntries(w, httptest.NewRequest("GET", "/admin/audit/export"+tt.query, nil))
+		if w.Code != tt.status {
+			t.Errorf("ExportAuditEntries(%s) = %d, want %d", tt.name, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		rows, err := csv.NewReader(w.Body).ReadAll()
+		if err != nil || len(rows) != len(tt.targets)+1 || rows[0][0] != "id" {
+			t.Errorf("ExportAuditEntries(%s) = %v, %v, want a header and %d rows", tt.name, rows, err, len(tt.targets))
+			continue
+		}
+		for i, target := range tt.targets {
+			if rows[i+1][5] != target {
+				t.Errorf("ExportAuditEntries(%s) row %d targets %s, want %s", tt.name, i+1, rows[i+1][5], target)
+			}
+		}
+		if tt.name == "filtered" && rows[1][8] != `{"name":{"before":"A","after":"B"}}` {
+			t.Errorf("ExportAuditEntries(%s) changes = %s", tt.name, rows[1][8])
+		}
+	}
+}
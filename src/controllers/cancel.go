@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"backend/src/models"
+	"backend/src/repository"
+	"backend/src/tokens"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const ticketTokenPurpose = "ticket"
+
+// ticketTokenTTL keeps ticket tokens valid for a while after the event so
+// late refund requests can still be authenticated.
+func ticketTokenTTL(event models.Event) time.Duration {
+	if event.EndsAt.IsZero() {
+		return 365 * 24 * time.Hour
+	}
+	// A token issued long after the event must not be born expired
+	return max(time.Until(event.EndsAt)+30*24*time.Hour, 24*time.Hour)
+}
+
+// registrationAccess is a verified caller acting on one registration
+type registrationAccess struct {
+	Actor        string
+	Event        models.Event
+	Registration models.Registration
+	Owner        bool  // ticket holder or team leader
+	Own          []int // PIDs of the caller's own participant records
+}
+
+// registrationAccessFromRequest resolves the {id} registration for the bearer
+// of either a magic link or the ticket token returned at registration. It
+// writes the error response itself and returns false on failure.
+func (u UserService) registrationAccessFromRequest(w http.ResponseWriter, r *http.Request) (registrationAccess, bool) {
+	id := mux.Vars(r)["id"]
+	token := tokenFromRequest(r)
+
+	if claims, err := u.Tokens.Verify(token, ticketTokenPurpose); err == nil {
+		if claims.Subject != id {
+			writeError(w, http.StatusForbidden, models.Error{Message: "Token does not match this registration"})
+			return registrationAccess{}, false
+		}
+		event, err := u.Store.GetEventBySlug(r.Context(), claims.Event)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error reading event", "error", err)
+			writeTokenError(w, tokens.ErrInvalid)
+			return registrationAccess{}, false
+		}
+		objID, err := models.ParseID(id)
+		if err != nil {
+			writeError(w, http.StatusNotFound, models.Error{Message: "Registration not found"})
+			return registrationAccess{}, false
+		}
+		reg, err := u.Store.GetRegistration(r.Context(), objID)
+		if err != nil {
+			writeError(w, http.StatusNotFound, models.Error{Message: "Registration not found"})
+			return registrationAccess{}, false
+		}
+		return registrationAccess{Actor: "ticket:" + id, Event: event, Registration: reg, Owner: true}, true
+	} else if !errors.Is(err, tokens.ErrInvalid) {
+		writeTokenError(w, err)
+		return registrationAccess{}, false
+	}
+
+	session, ok := u.manageSessionFromRequest(w, r)
+	if !ok {
+		return registrationAccess{}, false
+	}
+	views, err := u.registrationsFor(r, session)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading registrations", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not read registration"})
+		return registrationAccess{}, false
+	}
+	for _, view := range views {
+		if view.ID.Hex() != id {
+			continue
+		}
+		access := registrationAccess{
+			Actor:        u.participantActor(session.Email),
+			Event:        session.Event,
+			Registration: view.Registration,
+			// Registrations from before team leaders existed have no leader
+			Owner: view.LeaderPID == 0,
+		}
+		for _, participant := range view.ParticipantDetails {
+			if !strings.EqualFold(participant.Email, session.Email) {
+				continue
+			}
+			access.Own = append(access.Own, participant.PID)
+			if participant.PID == view.LeaderPID {
+				access.Owner = true
+			}
+		}
+		return access, true
+	}
+	writeError(w, http.StatusNotFound, models.Error{Message: "Registration not found"})
+	return registrationAccess{}, false
+}
+
+type cancelRequest struct {
+	Participants []int  `json:"participants"` // empty cancels the whole group
+	Reason       string `json:"reason"`
+}
+
+// CancelRegistration cancels a whole registration or some of its
+// participants and records a refund request for the cancelled seats. Only the
+// team leader or ticket holder may cancel others; members can withdraw
+// themselves as long as the team stays within the event's size limits.
+func (u UserService) CancelRegistration(w http.ResponseWriter, r *http.Request) {
+	access, ok := u.registrationAccessFromRequest(w, r)
+	if !ok {
+		return
+	}
+	actor, event, reg := access.Actor, access.Event, access.Registration
+
+	var req cancelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Invalid request body"})
+		return
+	}
+	if reg.Status == models.RegistrationCancelled || len(reg.Participants) == 0 {
+		writeError(w, http.StatusConflict, models.Error{Message: "Registration is already cancelled"})
+		return
+	}
+	now := time.Now()
+	if !event.StartsAt.IsZero() && !now.Before(event.StartsAt) {
+		writeError(w, http.StatusForbidden, models.Error{Message: "Cancellations are closed", Code: "cancellations_closed"})
+		return
+	}
+
+	pids := req.Participants
+	if len(pids) == 0 {
+		pids = reg.Participants
+		if !access.Owner {
+			pids = access.Own
+		}
+	}
+	// The registration's own slice is also the audit's Before value
+	pids = slices.Clone(pids)
+	slices.Sort(pids)
+	pids = slices.Compact(pids)
+	if len(pids) == 0 {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "No participants to cancel"})
+		return
+	}
+	for _, pid := range pids {
+		if !slices.Contains(reg.Participants, pid) {
+			writeError(w, http.StatusBadRequest, models.Error{Message: "Participant is not part of this registration", Details: pid})
+			return
+		}
+		if !access.Owner && !slices.Contains(access.Own, pid) {
+			writeError(w, http.StatusForbidden, models.Error{Message: "Only the team leader can remove other participants", Details: pid})
+			return
+		}
+	}
+	if remaining := len(reg.Participants) - len(pids); remaining > 0 {
+		if slices.Contains(pids, reg.LeaderPID) {
+			writeError(w, http.StatusBadRequest, models.Error{Message: "The team leader can only leave by cancelling the whole registration"})
+			return
+		}
+		if minSize, _ := event.TeamSizeLimits(); remaining < minSize {
+			writeError(w, http.StatusBadRequest, models.Error{Message: fmt.Sprintf("A team needs at least %d participants", minSize)})
+			return
+		}
+	}
+
+	// The fee is split evenly across everyone originally in the group
+	paidPerParticipant := 0
+	if seats := len(reg.Participants) + len(reg.Cancelled); seats > 0 {
+		paidPerParticipant = reg.TotalAmount / seats
+	}
+	amount := paidPerParticipant * len(pids) * event.RefundPercent(now) / 100
+
+	// The seats are only released together with the refund request for them
+	refund := models.Refund{
+		EventID:        event.ID,
+		RegistrationID: reg.ID,
+		Participants:   pids,
+		Amount:         amount,
+		Status:         models.RefundRequested,
+		RequestedBy:    actor,
+		Reason:         req.Reason,
+		TransactionID:  reg.TransactionID,
+	}
+	var updated models.Registration
+	var refundID models.ID
+	err := u.Store.WithTransaction(r.Context(), func(ctx context.Context) error {
+		var err error
+		if updated, err = u.Store.CancelParticipants(ctx, reg, pids); err != nil {
+			return err
+		}
+		if refundID, err = u.Store.CreateRefund(ctx, refund); err != nil {
+			return err
+		}
+		return u.Store.CreateAuditEntry(ctx, stampAudit(ctx, models.AuditEntry{
+			Actor:      actor,
+			Action:     "registration.cancel",
+			TargetType: "registration",
+			TargetID:   reg.ID.Hex(),
+			EventID:    event.ID,
+			Changes: map[string]models.Change{
+				"participants": {Before: reg.Participants, After: updated.Participants},
+				"status":       {Before: reg.Status, After: updated.Status},
+			},
+		}))
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		writeError(w, http.StatusConflict, models.Error{Message: "Registration changed, please retry"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error cancelling participants", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not cancel registration"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"registration": updated,
+		"refundId":     refundID,
+		"refundAmount": amount,
+	})
+}
@@ -0,0 +1,25 @@
+package controllers
+
+import (
+	"backend/src/models"
+	"testing"
+	"time"
+)
+
+func TestTicketTokenTTL(t *testing.T) {
+	day := 24 * time.Hour
+	for _, tt := range []struct {
+		name     string
+		endsAt   time.Time
+		min, max time.Duration
+	}{
+		{"no end", time.Time{}, 365 * day, 365 * day},
+		{"upcoming", time.Now().Add(10 * day), 39 * day, 40 * day},
+		{"ended recently", time.Now().Add(-10 * day), 19 * day, 20 * day},
+		{"ended long ago", time.Now().Add(-100 * day), day, day},
+	} {
+		if got := ticketTokenTTL(models.Event{EndsAt: tt.endsAt}); got < tt.min || got > tt.max {
+			t.Errorf("ticketTokenTTL(%s) = %v, want between %v and %v", tt.name, got, tt.min, tt.max)
+		}
+	}
+}
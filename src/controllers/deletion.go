@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"backend/src/models"
+	"backend/src/repository"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// DeleteRegistration soft-deletes a registration and its participants
+func (a AdminService) DeleteRegistration(w http.ResponseWriter, r *http.Request) {
+	a.setRegistrationDeleted(w, r, true)
+}
+
+// RestoreRegistration undoes DeleteRegistration
+func (a AdminService) RestoreRegistration(w http.ResponseWriter, r *http.Request) {
+	a.setRegistrationDeleted(w, r, false)
+}
+
+func (a AdminService) setRegistrationDeleted(w http.ResponseWriter, r *http.Request, deleted bool) {
+	id := mux.Vars(r)["id"]
+	objID, err := models.ParseID(id)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Invalid registration ID"})
+		return
+	}
+	action := "registration.restore"
+	if deleted {
+		action = "registration.delete"
+	}
+	// The registration is read while it is not deleted, before deleting or
+	// after restoring it, for the event of the audit entry
+	err = a.Store.WithTransaction(r.Context(), func(ctx context.Context) error {
+		var reg models.Registration
+		var err error
+		if deleted {
+			if reg, err = a.Store.GetRegistration(ctx, objID); err != nil {
+				return err
+			}
+			err = a.Store.DeleteRegistration(ctx, objID)
+		} else if err = a.Store.RestoreRegistration(ctx, objID); err == nil {
+			reg, err = a.Store.GetRegistration(ctx, objID)
+		}
+		if err != nil {
+			return err
+		}
+		return a.Store.CreateAuditEntry(ctx, deletionAudit(ctx, action, "registration", objID.Hex(), reg.EventID, deleted))
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		writeError(w, http.StatusNotFound, models.Error{Message: "Registration not found"})
+		return
+	}
+	if errors.Is(err, repository.ErrDuplicate) {
+		writeError(w, http.StatusConflict, models.Error{Message: "Another registration has taken the team name", Code: "team_name_taken"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error updating registration", "error", err, "deleted", deleted)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not update registration"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteParticipant soft-deletes a single participant
+func (a AdminService) DeleteParticipant(w http.ResponseWriter, r *http.Request) {
+	a.setParticipantDeleted(w, r, true)
+}
+
+// RestoreParticipant undoes DeleteParticipant
+func (a AdminService) RestoreParticipant(w http.ResponseWriter, r *http.Request) {
+	a.setParticipantDeleted(w, r, false)
+}
+
+func (a AdminService) setParticipantDeleted(w http.ResponseWriter, r *http.Request, deleted bool) {
+	pid, err := strconv.Atoi(mux.Vars(r)["pid"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Invalid participant ID"})
+		return
+	}
+	action := "participant.restore"
+	if deleted {
+		action = "participant.delete"
+	}
+	err = a.Store.WithTransaction(r.Context(), func(ctx context.Context) error {
+		var participant models.Participant
+		var err error
+		if deleted {
+			if participant, err = a.Store.GetParticipant(ctx, pid); err != nil {
+				return err
+			}
+			err = a.Store.DeleteParticipant(ctx, pid)
+		} else if err = a.Store.RestoreParticipant(ctx, pid); err == nil {
+			participant, err = a.Store.GetParticipant(ctx, pid)
+		}
+		if err != nil {
+			return err
+		}
+		return a.Store.CreateAuditEntry(ctx, deletionAudit(ctx, action, "participant", strconv.Itoa(pid), participant.EventID, deleted))
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		writeError(w, http.StatusNotFound, models.Error{Message: "Participant not found"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error updating participant", "error", err, "deleted", deleted)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not update participant"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deletionAudit is the entry for deleting or restoring a record
+func deletionAudit(ctx context.Context, action, targetType, targetID string, eventID models.ID, deleted bool) models.AuditEntry {
+	return stampAudit(ctx, models.AuditEntry{
+		Actor:      adminActor(ctx),
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		EventID:    eventID,
+		Changes:    map[string]models.Change{"deleted": {Before: !deleted, After: deleted}},
+	})
+}
+
+// ParticipantStats reports how many participants came from each college and
+// year, which remains available after personal data is anonymized
+func (a AdminService) ParticipantStats(w http.ResponseWriter, r *http.Request) {
+	event, ok := a.eventFromRequest(w, r)
+	if !ok {
+		return
+	}
+	stats, err := a.Store.ParticipantStats(r.Context(), event.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading participant stats", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not read statistics"})
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
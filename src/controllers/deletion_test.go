@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"backend/src/models"
+	"backend/src/repository"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestDeleteAndRestoreAudit(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemory()
+	event := models.NewID()
+	pid, _ := store.CreateParticipant(ctx, models.Participant{EventID: event, Name: "Ada"})
+	alone, _ := store.CreateParticipant(ctx, models.Participant{EventID: event, Name: "Rao"})
+	reg, _ := store.CreateRegistration(ctx, models.Registration{EventID: event, Participants: []int{pid}, Status: models.RegistrationActive})
+
+	a := AdminService{Store: store}
+	for _, tt := range []struct {
+		action  string
+		handler http.HandlerFunc
+		vars    map[string]string
+		target  string
+	}{
+		{"registration.delete", a.DeleteRegistration, map[string]string{"id": reg.Hex()}, reg.Hex()},
+		{"registration.restore", a.RestoreRegistration, map[string]string{"id": reg.Hex()}, reg.Hex()},
+		{"participant.delete", a.DeleteParticipant, map[string]string{"pid": strconv.Itoa(alone)}, strconv.Itoa(alone)},
+		{"participant.restore", a.RestoreParticipant, map[string]string{"pid": strconv.Itoa(alone)}, strconv.Itoa(alone)},
+	} {
+		w := httptest.NewRecorder()
+		tt.handler(w, mux.SetURLVars(httptest.NewRequest("POST", "/", nil), tt.vars))
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("%s = %d %s", tt.action, w.Code, w.Body)
+		}
+		// The event filter finds the entry
+		entries, _ := store.ListAuditEntries(ctx, repository.AuditFilter{Action: tt.action, EventID: event}, 0)
+		if len(entries) != 1 || entries[0].TargetID != tt.target {
+			t.Errorf("%s entries for the event = %+v", tt.action, entries)
+		}
+	}
+
+	// Without its audit entry the deletion is rolled back
+	w := httptest.NewRecorder()
+	AdminService{Store: failingAudit{store}}.DeleteParticipant(w, mux.SetURLVars(httptest.NewRequest("POST", "/", nil),
+		map[string]string{"pid": strconv.Itoa(alone)}))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("delete without an audit entry = %d, want 500", w.Code)
+	}
+	if _, err := store.GetParticipant(ctx, alone); err != nil {
+		t.Errorf("participant deleted without an audit entry: %v", err)
+	}
+}
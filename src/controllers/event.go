@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"backend/src/codes"
+	"backend/src/models"
+	"backend/src/repository"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// EnsureDefaultEvent creates the event served by the legacy routes if it does
+// not exist yet, taking its registration window from the configuration, and
+// assigns it the registrations stored before events existed.
+func (u UserService) EnsureDefaultEvent(ctx context.Context) error {
+	seed := u.Config.DefaultEvent
+	event, err := u.Store.GetEventBySlug(ctx, seed.Slug)
+	if errors.Is(err, repository.ErrNotFound) {
+		event, err = u.createDefaultEvent(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	adopted, err := u.Store.AdoptOrphans(ctx, event.ID)
+	if adopted > 0 {
+		slog.InfoContext(ctx, "Assigned registrations without an event to the default event", "event", event.Slug, "registrations", adopted)
+		recordAudit(ctx, u.Store, models.AuditEntry{
+			Actor:      systemActor,
+			Action:     "event.adopt",
+			TargetType: "event",
+			TargetID:   event.ID.Hex(),
+			EventID:    event.ID,
+			Changes:    map[string]models.Change{"registrations": {After: adopted}},
+		})
+	}
+	return err
+}
+
+func (u UserService) createDefaultEvent(ctx context.Context) (models.Event, error) {
+	seed := u.Config.DefaultEvent
+	name := seed.Name
+	if name == "" {
+		name = seed.Slug
+	}
+	event := models.Event{
+		Slug:                 seed.Slug,
+		Name:                 name,
+		RegistrationOpensAt:  seed.RegistrationOpensAt,
+		RegistrationClosesAt: seed.RegistrationClosesAt,
+		EarlyBirdEndsAt:      seed.EarlyBirdEndsAt,
+		Capacity:             seed.Capacity,
+		Fee:                  seed.Fee,
+		EarlyBirdFee:         seed.EarlyBirdFee,
+	}
+	slog.InfoContext(ctx, "Creating default event", "event", event.Slug)
+	err := u.Store.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if event.ID, err = u.Store.CreateEvent(ctx, event); err != nil {
+			return err
+		}
+		return u.Store.CreateAuditEntry(ctx, models.AuditEntry{
+			Actor:      systemActor,
+			Action:     "event.create",
+			TargetType: "event",
+			TargetID:   event.ID.Hex(),
+			EventID:    event.ID,
+			Changes:    diffFields(models.Event{}, event, "id"),
+		})
+	})
+	return event, err
+}
+
+// codeBackfillBatch is how many participants are read per query
+const codeBackfillBatch = 200
+
+// BackfillParticipantCodes gives the participants stored before codes
+// existed a code of their event, returning how many it assigned. Running it
+// again after a failure picks up where it stopped.
+func (u UserService) BackfillParticipantCodes(ctx context.Context) (assigned int, err error) {
+	defer func() {
+		if assigned > 0 {
+			recordAudit(ctx, u.Store, models.AuditEntry{
+				Actor:      systemActor,
+				Action:     "participant.backfill_codes",
+				TargetType: "participant",
+				Changes:    map[string]models.Change{"codes": {After: assigned}},
+			})
+		}
+	}()
+	events, err := u.Store.ListEvents(ctx)
+	if err != nil {
+		return 0, err
+	}
+	prefixes := map[models.ID]string{}
+	for _, event := range events {
+		prefixes[event.ID] = event.ParticipantCodePrefix()
+	}
+	after := 0
+	for {
+		participants, err := u.Store.ScanParticipants(ctx, after, codeBackfillBatch)
+		if err != nil {
+			return assigned, err
+		}
+		for _, participant := range participants {
+			after = participant.PID
+			prefix, ok := prefixes[participant.EventID]
+			if participant.Code != "" || !ok {
+				continue
+			}
+			for attempt := 1; ; attempt++ {
+				err = u.Store.SetParticipantCode(ctx, participant.PID, codes.New(prefix))
+				if !errors.Is(err, repository.ErrDuplicate) || attempt == maxCodeAttempts {
+					break
+				}
+			}
+			// ErrNotFound means another instance got there first
+			if err != nil && !errors.Is(err, repository.ErrNotFound) {
+				return assigned, err
+			}
+			if err == nil {
+				assigned++
+			}
+		}
+		if len(participants) < codeBackfillBatch {
+			return assigned, nil
+		}
+	}
+}
+
+// maxCodeAttempts bounds the rerolls when a participant code is taken
+const maxCodeAttempts = 5
+
+// eventFromRequest resolves the event named by the {slug} route variable,
+// falling back to the default event for the legacy routes.
+// It writes the error response itself and returns false on failure.
+func (u UserService) eventFromRequest(w http.ResponseWriter, r *http.Request) (models.Event, bool) {
+	slug := mux.Vars(r)["slug"]
+	if slug == "" {
+		slug = u.Config.DefaultEvent.Slug
+	}
+	event, err := u.Store.GetEventBySlug(r.Context(), slug)
+	if errors.Is(err, repository.ErrNotFound) {
+		writeError(w, http.StatusNotFound, models.Error{Message: "Event not found"})
+		return event, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading event", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not read event"})
+		return event, false
+	}
+	return event, true
+}
+
+// EventStatus returns the current registration phase and remaining seats
+func (u UserService) EventStatus(ctx context.Context, event models.Event) (models.EventStatus, error) {
+	registered := 0
+	if event.Capacity > 0 {
+		var err error
+		if registered, err = u.Store.CountParticipants(ctx, event.ID); err != nil {
+			return models.EventStatus{}, err
+		}
+	}
+	return event.Status(time.Now(), registered), nil
+}
+
+func (u UserService) GetEventStatus(w http.ResponseWriter, r *http.Request) {
+	event, ok := u.eventFromRequest(w, r)
+	if !ok {
+		return
+	}
+	status, err := u.EventStatus(r.Context(), event)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading event status", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not read event status"})
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"backend/src/codes"
+	"backend/src/models"
+	"backend/src/repository"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestBackfillParticipantCodes(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemory()
+	eventID, err := store.CreateEvent(ctx, models.Event{Slug: "meta", CodePrefix: "META25"})
+	if err != nil {
+		t.Fatalf("CreateEvent: %v", err)
+	}
+	existing := codes.New("META25")
+	pids, err := store.CreateParticipants(ctx, []models.Participant{
+		{EventID: eventID, Name: "Legacy"},
+		{EventID: eventID, Name: "Coded", Code: existing},
+		{EventID: models.NewID(), Name: "Unknown event"},
+	})
+	if err != nil {
+		t.Fatalf("CreateParticipants: %v", err)
+	}
+
+	u := UserService{Store: store}
+	if n, err := u.BackfillParticipantCodes(ctx); err != nil || n != 1 {
+		t.Fatalf("BackfillParticipantCodes = %d, %v, want 1", n, err)
+	}
+	participants, _ := store.GetParticipants(ctx, pids)
+	if code := participants[0].Code; !codes.Valid(code) || !strings.HasPrefix(code, "META25-") {
+		t.Errorf("legacy participant got code %q", code)
+	}
+	if participants[1].Code != existing {
+		t.Errorf("existing code changed to %q", participants[1].Code)
+	}
+	if participants[2].Code != "" {
+		t.Errorf("participant of an unknown event got code %q", participants[2].Code)
+	}
+	if n, err := u.BackfillParticipantCodes(ctx); err != nil || n != 0 {
+		t.Errorf("second BackfillParticipantCodes = %d, %v, want 0", n, err)
+	}
+}
+
+func TestSaveEvent(t *testing.T) {
+	a := AdminService{Store: repository.NewMemory()}
+	for _, tt := range []struct {
+		name    string
+		handler http.HandlerFunc
+		slug    string
+		body    string
+		want    int
+	}{
+		{"create", a.CreateEvent, "", `{"slug":"meta","name":"Meta","templates":{"confirmation":"Hi {{.Name}}"}}`, http.StatusCreated},
+		{"create with a taken slug", a.CreateEvent, "", `{"slug":"meta","name":"Meta again"}`, http.StatusConflict},
+		{"create with a broken template", a.CreateEvent, "", `{"slug":"other","name":"Other","templates":{"confirmation":"Hi {{.Name"}}`, http.StatusBadRequest},
+		{"update", a.UpdateEvent, "meta", `{"name":"Meta","templates":{"confirmation":"Hello {{.Name}}"}}`, http.StatusOK},
+		{"update with a broken template", a.UpdateEvent, "meta", `{"name":"Meta","templates":{"confirmation":"{{if .Name}}"}}`, http.StatusBadRequest},
+	} {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+		w := httptest.NewRecorder()
+		tt.handler(w, mux.SetURLVars(r, map[string]string{"slug": tt.slug}))
+		if w.Code != tt.want {
+			t.Errorf("%s = %d %s, want %d", tt.name, w.Code, w.Body, tt.want)
+		}
+	}
+	event, _ := a.Store.GetEventBySlug(context.Background(), "meta")
+	if event.Templates.Confirmation != "Hello {{.Name}}" {
+		t.Errorf("stored template = %q, want the last valid one", event.Templates.Confirmation)
+	}
+}
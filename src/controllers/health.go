@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"backend/src/version"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Cloudinary rate limits its admin API, so storage pings are cached
+	storagePingInterval = time.Minute
+	readinessTimeout    = 3 * time.Second
+	maxPendingJobs      = 100
+)
+
+// HealthService answers the liveness, readiness and version probes
+type HealthService struct {
+	users    *UserService
+	draining atomic.Bool
+
+	mu          sync.Mutex
+	storageErr  error
+	storageTime time.Time
+}
+
+func NewHealthService(users *UserService) *HealthService {
+	return &HealthService{users: users}
+}
+
+// Drain makes readiness fail so the orchestrator stops routing traffic here
+// while the server shuts down
+func (h *HealthService) Drain() {
+	h.draining.Store(true)
+}
+
+type readiness struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Healthz reports that the process is up and serving requests
+func (h *HealthService) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readyz checks the dependencies needed to serve registrations
+func (h *HealthService) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	result := readiness{Ready: true, Checks: map[string]string{}}
+	check := func(name string, err error) {
+		if err != nil {
+			result.Ready = false
+			result.Checks[name] = err.Error()
+			return
+		}
+		result.Checks[name] = "ok"
+	}
+
+	if h.draining.Load() {
+		result.Ready = false
+		result.Checks["server"] = "shutting down"
+	}
+	check("database", h.users.Store.Ping(ctx))
+	check("storage", h.pingStorage(ctx))
+	var queueErr error
+	if pending := h.users.Jobs.Pending(); pending > maxPendingJobs {
+		queueErr = fmt.Errorf("%d emails queued", pending)
+	}
+	check("mailer", queueErr)
+
+	status := http.StatusOK
+	if !result.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, result)
+}
+
+// Version reports the build the server is running
+func (h *HealthService) Version(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, version.Get())
+}
+
+func (h *HealthService) pingStorage(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if time.Since(h.storageTime) < storagePingInterval {
+		return h.storageErr
+	}
+	h.storageErr = h.users.PingStorage(ctx)
+	h.storageTime = time.Now()
+	return h.storageErr
+}
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"backend/src/background"
+	"backend/src/repository"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// unreachableStore fails its health check
+type unreachableStore struct {
+	repository.Store
+}
+
+func (unreachableStore) Ping(ctx context.Context) error {
+	return errors.New("connection refused")
+}
+
+// newHealthService checks store, with the storage ping cached as healthy
+func newHealthService(store repository.Store) *HealthService {
+	h := NewHealthService(&UserService{Store: store, Jobs: background.NewPool()})
+	h.storageTime = time.Now()
+	return h
+}
+
+func TestReadyz(t *testing.T) {
+	draining := newHealthService(repository.NewMemory())
+	draining.Drain()
+	queued := newHealthService(repository.NewMemory())
+	release := make(chan struct{})
+	defer close(release)
+	for i := 0; i <= maxPendingJobs; i++ {
+		queued.users.Jobs.Go(context.Background(), "email", func(ctx context.Context) { <-release })
+	}
+	storageDown := newHealthService(repository.NewMemory())
+	storageDown.storageErr = errors.New("cloudinary unavailable")
+
+	for _, tt := range []struct {
+		name   string
+		health *HealthService
+		want   int
+		failed map[string]string
+	}{
+		{"healthy", newHealthService(repository.NewMemory()), http.StatusOK, nil},
+		{"database down", newHealthService(unreachableStore{repository.NewMemory()}), http.StatusServiceUnavailable,
+			map[string]string{"database": "connection refused"}},
+		{"storage down", storageDown, http.StatusServiceUnavailable, map[string]string{"storage": "cloudinary unavailable"}},
+		{"mail backlog", queued, http.StatusServiceUnavailable, map[string]string{"mailer": fmt.Sprintf("%d emails queued", maxPendingJobs+1)}},
+		{"draining", draining, http.StatusServiceUnavailable, map[string]string{"server": "shutting down"}},
+	} {
+		w := httptest.NewRecorder()
+		tt.health.Readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+		var resp readiness
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != tt.want || resp.Ready != (tt.want == http.StatusOK) {
+			t.Errorf("Readyz(%s) = %d %s, want %d", tt.name, w.Code, w.Body, tt.want)
+			continue
+		}
+		// Every check is reported, the failed ones with their error
+		for _, name := range []string{"database", "storage", "mailer"} {
+			want, failed := tt.failed[name]
+			if !failed {
+				want = "ok"
+			}
+			if resp.Checks[name] != want {
+				t.Errorf("Readyz(%s) check %s = %q, want %q", tt.name, name, resp.Checks[name], want)
+			}
+		}
+		if want := tt.failed["server"]; resp.Checks["server"] != want {
+			t.Errorf("Readyz(%s) check server = %q, want %q", tt.name, resp.Checks["server"], want)
+		}
+	}
+}
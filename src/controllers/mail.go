@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"backend/src/config"
+	"backend/src/metrics"
+	"backend/src/tracing"
+	"context"
+	"log/slog"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	mailAttempts = 3
+	mailBackoff  = 2 * time.Second
+)
+
+// Mailer delivers HTML emails
+type Mailer interface {
+	// Send delivers one email. Kind labels the delivery metrics and logs.
+	Send(ctx context.Context, kind, to, subject, html string) error
+}
+
+// SMTPMailer delivers emails through the configured SMTP relay
+type SMTPMailer struct {
+	cfg config.Mail
+}
+
+func NewMailer(cfg config.Mail) *SMTPMailer {
+	return &SMTPMailer{cfg: cfg}
+}
+
+// Send delivers one email, retrying with a growing delay until it succeeds,
+// runs out of attempts or ctx is cancelled. Kind labels the delivery metrics
+// and logs. Failures are logged here so callers only need the result.
+func (m SMTPMailer) Send(ctx context.Context, kind, to, subject, html string) error {
+	err := m.send(ctx, kind, to, subject, html)
+	for attempt := 1; err != nil && attempt < mailAttempts; attempt++ {
+		slog.WarnContext(ctx, "Error sending email, retrying", "kind", kind, "to", to, "attempt", attempt, "error", err)
+		metrics.Email(kind, metrics.EmailRetry)
+		select {
+		case <-time.After(time.Duration(attempt) * mailBackoff):
+			err = m.send(ctx, kind, to, subject, html)
+		case <-ctx.Done():
+			err = ctx.Err()
+			attempt = mailAttempts
+		}
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error sending email", "kind", kind, "to", to, "error", err)
+		metrics.Email(kind, metrics.EmailFailed)
+		return err
+	}
+	slog.DebugContext(ctx, "Email sent", "kind", kind, "to", to)
+	metrics.Email(kind, metrics.EmailSent)
+	return nil
+}
+
+func (m SMTPMailer) send(ctx context.Context, kind, to, subject, html string) (err error) {
+	_, span := tracer.Start(ctx, "smtp.send", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("email.kind", kind),
+		attribute.String("server.address", m.cfg.Host),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	from := m.cfg.User
+	auth := smtp.PlainAuth("", from, m.cfg.Password.Value(), m.cfg.Host)
+
+	msg := []byte("From: " + from + "\r\n" + "To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/html; charset=UTF-8\r\n\r\n" +
+		html)
+
+	return smtp.SendMail(m.cfg.Host+":"+strconv.Itoa(m.cfg.Port), auth, from, []string{to}, msg)
+}
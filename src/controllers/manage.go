@@ -0,0 +1,365 @@
+package controllers
+
+import (
+	"backend/src/models"
+	"backend/src/protection"
+	"backend/src/repository"
+	"backend/src/tokens"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	manageTokenPurpose = "manage"
+	manageTokenTTL     = 30 * time.Minute
+)
+
+type manageLinkRequest struct {
+	Email string `json:"email"`
+}
+
+// RequestManageLink emails a signed, time-limited link to manage the
+// registrations of an address. It answers the same way whether or not the
+// address is registered so it cannot be used to probe for participants.
+func (u UserService) RequestManageLink(w http.ResponseWriter, r *http.Request) {
+	event, ok := u.eventFromRequest(w, r)
+	if !ok {
+		return
+	}
+	var req manageLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Email is required"})
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+
+	// Checked before the lookup, so registered and unknown addresses are
+	// limited alike
+	if u.EmailLimiter != nil {
+		allowed, retryAfter, err := u.EmailLimiter.Allow(r.Context(), "manage:"+u.hashEmail(email))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Rate limiter error", "error", err)
+		} else if !allowed {
+			protection.WriteTooManyRequests(w, retryAfter)
+			return
+		}
+	}
+
+	participants, err := u.Store.GetParticipantsByEmail(r.Context(), event.ID, email)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error looking up participants", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not process request"})
+		return
+	}
+	if len(participants) > 0 {
+		token, err := u.Tokens.Sign(manageTokenPurpose, email, event.Slug, manageTokenTTL)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error signing token", "error", err)
+			writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not process request"})
+			return
+		}
+		u.Jobs.Go(r.Context(), "manage link email", func(ctx context.Context) {
+			u.sendManageLink(ctx, event, email, token)
+		})
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]string{
+		"message": "If this email is registered, a link to manage the registration has been sent",
+	})
+}
+
+func (u UserService) sendManageLink(ctx context.Context, event models.Event, email, token string) {
+	base := u.Config.ManageURL
+	if base == "" {
+		base = strings.TrimSuffix(event.Website, "/") + "/manage"
+	}
+	link := base + "?token=" + url.QueryEscape(token)
+
+	var buf bytes.Buffer
+	err := magicLinkTemplate.Execute(&buf, magicLinkEmail{Event: event, Link: link, ExpiresIn: "30 minutes"})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error rendering email", "error", err)
+		return
+	}
+	u.Mailer.Send(ctx, "manage_link", email, "Manage your "+event.Name+" registration", buf.String())
+}
+
+// manageSession is the verified owner of a magic link
+type manageSession struct {
+	Email string
+	Event models.Event
+}
+
+// manageSessionFromRequest verifies the magic link token sent as a bearer
+// token or `token` query parameter. It writes the error response itself and
+// returns false on failure.
+func (u UserService) manageSessionFromRequest(w http.ResponseWriter, r *http.Request) (manageSession, bool) {
+	claims, err := u.Tokens.Verify(tokenFromRequest(r), manageTokenPurpose)
+	if err != nil {
+		writeTokenError(w, err)
+		return manageSession{}, false
+	}
+
+	event, err := u.Store.GetEventBySlug(r.Context(), claims.Event)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading event", "error", err)
+		writeError(w, http.StatusUnauthorized, models.Error{Message: "Invalid link", Code: "token_invalid"})
+		return manageSession{}, false
+	}
+	return manageSession{Email: claims.Subject, Event: event}, true
+}
+
+func tokenFromRequest(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	return r.URL.Query().Get("token")
+}
+
+func writeTokenError(w http.ResponseWriter, err error) {
+	if errors.Is(err, tokens.ErrExpired) {
+		writeError(w, http.StatusUnauthorized, models.Error{Message: "Link has expired", Code: "token_expired"})
+		return
+	}
+	writeError(w, http.StatusUnauthorized, models.Error{Message: "Invalid link", Code: "token_invalid"})
+}
+
+// RegistrationView is a registration together with its participants
+type RegistrationView struct {
+	models.Registration
+	ParticipantDetails []models.Participant `json:"participantDetails"`
+}
+
+// ManageView is everything the owner of a magic link can see
+type ManageView struct {
+	Event         models.EventStatus `json:"event"`
+	EditableUntil time.Time          `json:"editableUntil"`
+	CanEdit       bool               `json:"canEdit"`
+	Registrations []RegistrationView `json:"registrations"`
+}
+
+// registrationsFor loads every registration of the event that includes the
+// session's email, with all of their participants.
+func (u UserService) registrationsFor(r *http.Request, session manageSession) ([]RegistrationView, error) {
+	owned, err := u.Store.GetParticipantsByEmail(r.Context(), session.Event.ID, session.Email)
+	if err != nil {
+		return nil, err
+	}
+	pids := make([]int, 0, len(owned))
+	for _, participant := range owned {
+		pids = append(pids, participant.PID)
+	}
+	registrations, err := u.Store.GetRegistrationsByParticipants(r.Context(), pids)
+	if err != nil {
+		return nil, err
+	}
+
+	views := make([]RegistrationView, 0, len(registrations))
+	for _, registration := range registrations {
+		if registration.EventID != session.Event.ID {
+			continue
+		}
+		participants, err := u.Store.GetParticipants(r.Context(), registration.Participants)
+		if err != nil {
+			return nil, err
+		}
+		views = append(views, RegistrationView{Registration: registration, ParticipantDetails: participants})
+	}
+	return views, nil
+}
+
+func (u UserService) GetManagedRegistration(w http.ResponseWriter, r *http.Request) {
+	session, ok := u.manageSessionFromRequest(w, r)
+	if !ok {
+		return
+	}
+	status, err := u.EventStatus(r.Context(), session.Event)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading event status", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not read registration"})
+		return
+	}
+	views, err := u.registrationsFor(r, session)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading registrations", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not read registration"})
+		return
+	}
+
+	deadline := session.Event.EditDeadline()
+	writeJSON(w, http.StatusOK, ManageView{
+		Event:         status,
+		EditableUntil: deadline,
+		CanEdit:       deadline.IsZero() || time.Now().Before(deadline),
+		Registrations: views,
+	})
+}
+
+// participantEdit lists the fields a participant may change themselves
+type participantEdit struct {
+	Name        *string `json:"name"`
+	Phone       *string `json:"phone"`
+	CollegeName *string `json:"collegeName"`
+	YearOfStudy *int    `json:"yearOfStudy"`
+	DualBoot    *bool   `json:"dualBoot"`
+}
+
+// changes diffs the edit against the stored participant, returning the
+// update and the audit changes for the fields that differ.
+func (e participantEdit) changes(p models.Participant) (repository.ParticipantUpdate, map[string]models.Change) {
+	changes := map[string]models.Change{}
+	update := repository.ParticipantUpdate{
+		Name:        changed(changes, "name", p.Name, e.Name),
+		Phone:       changed(changes, "phone", p.Phone, e.Phone),
+		CollegeName: changed(changes, "collegeName", p.CollegeName, e.CollegeName),
+		YearOfStudy: changed(changes, "yearOfStudy", p.YearOfStudy, e.YearOfStudy),
+		DualBoot:    changed(changes, "dualBoot", p.DualBoot, e.DualBoot),
+	}
+	// The audit trail outlives the participant's data, so it only records
+	// that personal fields changed
+	for _, field := range []string{"name", "phone"} {
+		if _, ok := changes[field]; ok {
+			changes[field] = models.Change{Before: redactedValue, After: redactedValue}
+		}
+	}
+	return update, changes
+}
+
+// changed returns after when it is set and differs from before, recording
+// the change under key
+func changed[T comparable](changes map[string]models.Change, key string, before T, after *T) *T {
+	if after == nil || *after == before {
+		return nil
+	}
+	changes[key] = models.Change{Before: before, After: *after}
+	return after
+}
+
+// normalize validates the provided fields with the same rules as
+// registration, canonicalizing them in place.
+func (e *participantEdit) normalize(countryCode string) []models.FieldError {
+	var errs []models.FieldError
+	check := func(field, msg string) {
+		if msg != "" {
+			errs = append(errs, models.FieldError{Index: -1, Field: field, Message: msg})
+		}
+	}
+	var msg string
+	if e.Name != nil {
+		*e.Name, msg = validateName(*e.Name)
+		check("name", msg)
+	}
+	if e.Phone != nil {
+		*e.Phone, msg = validatePhone(*e.Phone, countryCode)
+		check("phone", msg)
+	}
+	if e.CollegeName != nil {
+		*e.CollegeName, msg = validateCollege(*e.CollegeName)
+		check("collegeName", msg)
+	}
+	if e.YearOfStudy != nil {
+		check("yearOfStudy", validateYear(*e.YearOfStudy))
+	}
+	return errs
+}
+
+// EditManagedParticipant updates the allowed fields of a participant in one
+// of the session's registrations and records the change in the audit trail.
+func (u UserService) EditManagedParticipant(w http.ResponseWriter, r *http.Request) {
+	session, ok := u.manageSessionFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if deadline := session.Event.EditDeadline(); !deadline.IsZero() && !time.Now().Before(deadline) {
+		writeError(w, http.StatusForbidden, models.Error{Message: "Edits are closed", Code: "edits_closed"})
+		return
+	}
+	pid, err := strconv.Atoi(mux.Vars(r)["pid"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Invalid participant ID"})
+		return
+	}
+
+	var edit participantEdit
+	if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Invalid request body"})
+		return
+	}
+	if fieldErrs := edit.normalize(u.Config.CountryCode); len(fieldErrs) > 0 {
+		writeError(w, http.StatusBadRequest, models.Error{
+			Message: "Invalid participant",
+			Code:    "validation_failed",
+			Fields:  fieldErrs,
+		})
+		return
+	}
+
+	views, err := u.registrationsFor(r, session)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading registrations", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not update participant"})
+		return
+	}
+	participant, found := findParticipant(views, pid)
+	if !found {
+		writeError(w, http.StatusNotFound, models.Error{Message: "Participant not found"})
+		return
+	}
+
+	update, changes := edit.changes(participant)
+	if len(changes) == 0 {
+		writeJSON(w, http.StatusOK, participant)
+		return
+	}
+	err = u.Store.WithTransaction(r.Context(), func(ctx context.Context) error {
+		if err := u.Store.UpdateParticipant(ctx, pid, update); err != nil {
+			return err
+		}
+		return u.Store.CreateAuditEntry(ctx, stampAudit(ctx, models.AuditEntry{
+			Actor:      u.participantActor(session.Email),
+			Action:     "participant.update",
+			TargetType: "participant",
+			TargetID:   strconv.Itoa(pid),
+			EventID:    session.Event.ID,
+			Changes:    changes,
+		}))
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		writeError(w, http.StatusNotFound, models.Error{Message: "Participant not found"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error updating participant", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not update participant"})
+		return
+	}
+
+	updated, err := u.Store.GetParticipant(r.Context(), pid)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading participant", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not read participant"})
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func findParticipant(views []RegistrationView, pid int) (models.Participant, bool) {
+	for _, view := range views {
+		for _, participant := range view.ParticipantDetails {
+			if participant.PID == pid {
+				return participant, true
+			}
+		}
+	}
+	return models.Participant{}, false
+}
@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"backend/src/background"
+	"backend/src/models"
+	"backend/src/protection"
+	"backend/src/repository"
+	"backend/src/tokens"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// newManageService has ada@example.com registered for the event "event"
+func newManageService(t *testing.T) UserService {
+	t.Helper()
+	ctx := context.Background()
+	store := repository.NewMemory()
+	event, err := store.CreateEvent(ctx, models.Event{Slug: "event", Name: "Event", Website: "https://example.com/"})
+	if err != nil {
+		t.Fatalf("CreateEvent: %v", err)
+	}
+	pid, _ := store.CreateParticipant(ctx, models.Participant{EventID: event, Name: "Ada", Email: "ada@example.com"})
+	if _, err := store.CreateRegistration(ctx, models.Registration{EventID: event, Participants: []int{pid}, Status: models.RegistrationActive}); err != nil {
+		t.Fatalf("CreateRegistration: %v", err)
+	}
+	return UserService{Store: store, Tokens: tokens.NewSigner("secret"), Mailer: &sentMail{}, Jobs: background.NewPool()}
+}
+
+// requestManageLink asks for a link to manage the registrations of email
+func requestManageLink(u UserService, email string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(manageLinkRequest{Email: email})
+	r := mux.SetURLVars(httptest.NewRequest("POST", "/", strings.NewReader(string(body))), map[string]string{"slug": "event"})
+	w := httptest.NewRecorder()
+	u.RequestManageLink(w, r)
+	return w
+}
+
+func TestRequestManageLink(t *testing.T) {
+	u := newManageService(t)
+	mailer := u.Mailer.(*sentMail)
+
+	registered := requestManageLink(u, " Ada@Example.com ")
+	unknown := requestManageLink(u, "eve@example.com")
+	if registered.Code != http.StatusAccepted || unknown.Code != http.StatusAccepted || registered.Body.String() != unknown.Body.String() {
+		t.Errorf("RequestManageLink = %d %s for a registered address and %d %s for an unknown one, want the same 202",
+			registered.Code, registered.Body, unknown.Code, unknown.Body)
+	}
+	if w := requestManageLink(u, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("RequestManageLink without an email = %d, want 400", w.Code)
+	}
+	u.Jobs.Shutdown(context.Background())
+	if len(mailer.sent) != 1 || len(mailer.sent["ada@example.com"]) != 1 {
+		t.Fatalf("emails sent = %v, want one to ada@example.com", mailer.sent)
+	}
+
+	// The emailed link opens the registration
+	link := regexp.MustCompile(`href="([^"]+)"`).FindStringSubmatch(mailer.sent["ada@example.com"][0])
+	if link == nil || !strings.HasPrefix(link[1], "https://example.com/manage?token=") {
+		t.Fatalf("email links to %v, want the event's manage page", link)
+	}
+	parsed, _ := url.Parse(strings.ReplaceAll(link[1], "&amp;", "&"))
+	if w := getManagedRegistration(u, parsed.Query().Get("token"), false); w.Code != http.StatusOK {
+		t.Errorf("emailed link = %d %s", w.Code, w.Body)
+	}
+}
+
+func TestRequestManageLinkEmailLimit(t *testing.T) {
+	u := newManageService(t)
+	u.Jobs.Shutdown(context.Background())
+	u.EmailLimiter = &protection.Limiter{Store: protection.NewMemoryStore(), Rate: protection.Rate{Burst: 1, Per: time.Hour}, Prefix: "email"}
+	// Unknown addresses are limited too, so the answers do not tell them apart
+	for i, tt := range []struct {
+		email string
+		want  int
+	}{
+		{"ada@example.com", http.StatusAccepted},
+		{"ADA@example.com", http.StatusTooManyRequests},
+		{"eve@example.com", http.StatusAccepted},
+		{"eve@example.com", http.StatusTooManyRequests},
+	} {
+		w := requestManageLink(u, tt.email)
+		if w.Code != tt.want || (tt.want == http.StatusTooManyRequests && (errorCode(w) != "rate_limited" || w.Header().Get("Retry-After") == "")) {
+			t.Errorf("step %d: RequestManageLink(%s) = %d %s, want %d", i+1, tt.email, w.Code, w.Body, tt.want)
+		}
+	}
+}
+
+// getManagedRegistration opens the registrations with token, sent as a
+// bearer token or a query parameter
+func getManagedRegistration(u UserService, token string, bearer bool) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/manage?token="+url.QueryEscape(token), nil)
+	if bearer {
+		r = httptest.NewRequest("GET", "/manage", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	u.GetManagedRegistration(w, r)
+	return w
+}
+
+func TestManageToken(t *testing.T) {
+	u := newManageService(t)
+	sign := func(purpose, subject, event string, ttl time.Duration) string {
+		token, _ := u.Tokens.Sign(purpose, subject, event, ttl)
+		return token
+	}
+	valid := sign(manageTokenPurpose, "ada@example.com", "event", manageTokenTTL)
+	for _, tt := range []struct {
+		name   string
+		token  string
+		bearer bool
+		want   int
+		code   string
+	}{
+		{"query parameter", valid, false, http.StatusOK, ""},
+		{"bearer", valid, true, http.StatusOK, ""},
+		{"missing", "", false, http.StatusUnauthorized, "token_invalid"},
+		{"expired", sign(manageTokenPurpose, "ada@example.com", "event", -time.Minute), false, http.StatusUnauthorized, "token_expired"},
+		{"other purpose", sign(verificationPurpose, "ada@example.com", "event", manageTokenTTL), false, http.StatusUnauthorized, "token_invalid"},
+		{"other secret", func() string {
+			token, _ := tokens.NewSigner("other").Sign(manageTokenPurpose, "ada@example.com", "event", manageTokenTTL)
+			return token
+		}(), false, http.StatusUnauthorized, "token_invalid"},
+		{"tampered", valid[:len(valid)-2] + "xx", true, http.StatusUnauthorized, "token_invalid"},
+		{"missing event", sign(manageTokenPurpose, "ada@example.com", "gone", manageTokenTTL), false, http.StatusUnauthorized, "token_invalid"},
+	} {
+		w := getManagedRegistration(u, tt.token, tt.bearer)
+		if w.Code != tt.want || errorCode(w) != tt.code {
+			t.Errorf("GetManagedRegistration(%s) = %d %s, want %d %q", tt.name, w.Code, w.Body, tt.want, tt.code)
+			continue
+		}
+		if tt.want != http.StatusOK {
+			continue
+		}
+		var view ManageView
+		if err := json.Unmarshal(w.Body.Bytes(), &view); err != nil || len(view.Registrations) != 1 ||
+			len(view.Registrations[0].ParticipantDetails) != 1 || view.Registrations[0].ParticipantDetails[0].Name != "Ada" {
+			t.Errorf("GetManagedRegistration(%s) = %s, want Ada's registration", tt.name, w.Body)
+		}
+	}
+
+	// A token only opens the registrations of its own address
+	w := getManagedRegistration(u, sign(manageTokenPurpose, "eve@example.com", "event", manageTokenTTL), true)
+	var view ManageView
+	if json.Unmarshal(w.Body.Bytes(), &view); w.Code != http.StatusOK || len(view.Registrations) != 0 {
+		t.Errorf("GetManagedRegistration(unregistered address) = %d %s, want no registrations", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"backend/src/models"
+	"backend/src/protection"
+	"backend/src/repository"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	otpTTL              = 10 * time.Minute
+	otpResendCooldown   = time.Minute
+	otpMaxAttempts      = 5
+	verificationPurpose = "email_verification"
+	verificationTTL     = 2 * time.Hour
+)
+
+type otpRequest struct {
+	Email string `json:"email"`
+	Code  string `json:"code"`
+}
+
+// hashOTP keys the hash with the token secret, as six digits are quickly
+// brute forced from a plain one
+func (u UserService) hashOTP(email, code string) string {
+	return u.Tokens.MAC("otp", email+":"+code)
+}
+
+// hashEmail stands in for an address wherever one is only needed as a key,
+// such as pending codes, rate limits and audit actors, so those records
+// hold no plaintext address
+func (u UserService) hashEmail(email string) string {
+	return u.Tokens.MAC("email", strings.ToLower(strings.TrimSpace(email)))
+}
+
+// decodeOTPRequest reads the body and normalizes the email, writing the
+// error response itself and returning false on failure.
+func decodeOTPRequest(w http.ResponseWriter, r *http.Request) (otpRequest, bool) {
+	var req otpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Invalid request body"})
+		return req, false
+	}
+	email, msg := validateEmail(req.Email)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, models.Error{
+			Message: "Invalid email",
+			Code:    "validation_failed",
+			Fields:  []models.FieldError{{Index: -1, Field: "email", Message: msg}},
+		})
+		return req, false
+	}
+	req.Email = email
+	return req, true
+}
+
+// RequestOTP emails a 6-digit code that proves ownership of an address
+func (u UserService) RequestOTP(w http.ResponseWriter, r *http.Request) {
+	event, ok := u.eventFromRequest(w, r)
+	if !ok {
+		return
+	}
+	req, ok := decodeOTPRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if u.EmailLimiter != nil {
+		allowed, retryAfter, err := u.EmailLimiter.Allow(r.Context(), "otp:"+u.hashEmail(req.Email))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Rate limiter error", "error", err)
+		} else if !allowed {
+			protection.WriteTooManyRequests(w, retryAfter)
+			return
+		}
+	}
+
+	existing, err := u.Store.GetOTP(r.Context(), event.ID, u.hashEmail(req.Email))
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		slog.ErrorContext(r.Context(), "Error reading OTP", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not send code"})
+		return
+	}
+	if err == nil {
+		if wait := time.Until(existing.CreatedAt.Add(otpResendCooldown)); wait > 0 {
+			w.Header().Set("Retry-After", protection.RetryAfterSeconds(wait))
+			writeError(w, http.StatusTooManyRequests, models.Error{Message: "Please wait before requesting another code", Code: "otp_cooldown"})
+			return
+		}
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error generating OTP", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not send code"})
+		return
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+	otp := models.OTP{
+		EventID:   event.ID,
+		EmailHash: u.hashEmail(req.Email),
+		CodeHash:  u.hashOTP(req.Email, code),
+		ExpiresAt: time.Now().Add(otpTTL),
+	}
+	if err := u.Store.SaveOTP(r.Context(), otp); err != nil {
+		slog.ErrorContext(r.Context(), "Error saving OTP", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not send code"})
+		return
+	}
+	recordAudit(r.Context(), u.Store, models.AuditEntry{
+		Actor:      u.participantActor(req.Email),
+		Action:     "otp.send",
+		TargetType: "email",
+		TargetID:   otp.EmailHash,
+		EventID:    event.ID,
+	})
+	u.Jobs.Go(r.Context(), "otp email", func(ctx context.Context) {
+		u.sendOTP(ctx, event, req.Email, code)
+	})
+
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"message":   "Verification code sent",
+		"expiresAt": otp.ExpiresAt,
+	})
+}
+
+func (u UserService) sendOTP(ctx context.Context, event models.Event, email, code string) {
+	var buf bytes.Buffer
+	err := otpTemplate.Execute(&buf, otpEmail{Event: event, Code: code, ExpiresIn: "10 minutes"})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error rendering email", "error", err)
+		return
+	}
+	u.Mailer.Send(ctx, "otp", email, "Your "+event.Name+" verification code", buf.String())
+}
+
+// VerifyOTP exchanges a valid code for a verification token to send with
+// the participant in the registration request
+func (u UserService) VerifyOTP(w http.ResponseWriter, r *http.Request) {
+	event, ok := u.eventFromRequest(w, r)
+	if !ok {
+		return
+	}
+	req, ok := decodeOTPRequest(w, r)
+	if !ok {
+		return
+	}
+
+	otp, err := u.Store.ClaimOTPAttempt(r.Context(), event.ID, u.hashEmail(req.Email), otpMaxAttempts)
+	if errors.Is(err, repository.ErrNotFound) {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "No valid code, please request a new one", Code: "otp_invalid"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading OTP", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not verify code"})
+		return
+	}
+	if !time.Now().Before(otp.ExpiresAt) {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Code has expired, please request a new one", Code: "otp_expired"})
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(u.hashOTP(req.Email, req.Code)), []byte(otp.CodeHash)) != 1 {
+		writeError(w, http.StatusBadRequest, models.Error{
+			Message: "Incorrect code",
+			Code:    "otp_incorrect",
+			Details: map[string]int{"attemptsRemaining": otpMaxAttempts - otp.Attempts},
+		})
+		return
+	}
+
+	if err := u.Store.DeleteOTP(r.Context(), otp.ID); err != nil {
+		slog.ErrorContext(r.Context(), "Error deleting OTP", "error", err)
+	}
+	recordAudit(r.Context(), u.Store, models.AuditEntry{
+		Actor:      u.participantActor(req.Email),
+		Action:     "otp.verify",
+		TargetType: "email",
+		TargetID:   otp.EmailHash,
+		EventID:    event.ID,
+	})
+	token, err := u.Tokens.Sign(verificationPurpose, req.Email, event.Slug, verificationTTL)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error signing verification token", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not verify code"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"verificationToken": token,
+		"expiresAt":         time.Now().Add(verificationTTL),
+	})
+}
+
+// checkVerifications requires a verification token for every participant
+// email when the event verifies emails
+func (u UserService) checkVerifications(event models.Event, input registrationInput) error {
+	if !event.VerifyEmails {
+		return nil
+	}
+	var fieldErrs []models.FieldError
+	for i, participant := range input.Participants {
+		claims, err := u.Tokens.Verify(participant.VerificationToken, verificationPurpose)
+		if err != nil || claims.Event != event.Slug || claims.Subject != participant.Email {
+			fieldErrs = append(fieldErrs, models.FieldError{Index: i, Field: "verificationToken", Message: "email is not verified"})
+		}
+	}
+	if len(fieldErrs) > 0 {
+		return &requestError{Status: http.StatusBadRequest, Body: models.Error{
+			Message: "Participant emails must be verified",
+			Code:    "email_not_verified",
+			Fields:  fieldErrs,
+		}}
+	}
+	return nil
+}
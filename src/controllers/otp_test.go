@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"backend/src/background"
+	"backend/src/models"
+	"backend/src/protection"
+	"backend/src/repository"
+	"backend/src/tokens"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// sentMail records the emails sent through it
+type sentMail struct {
+	mu   sync.Mutex
+	sent map[string][]string
+}
+
+func (m *sentMail) Send(ctx context.Context, kind, to, subject, html string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sent == nil {
+		m.sent = map[string][]string{}
+	}
+	m.sent[to] = append(m.sent[to], html)
+	return nil
+}
+
+// newOTPService has the events "event" and "other"
+func newOTPService(t *testing.T) UserService {
+	t.Helper()
+	store := repository.NewMemory()
+	for _, slug := range []string{"event", "other"} {
+		if _, err := store.CreateEvent(context.Background(), models.Event{Slug: slug, Name: "Event"}); err != nil {
+			t.Fatalf("CreateEvent: %v", err)
+		}
+	}
+	return UserService{Store: store, Tokens: tokens.NewSigner("secret"), Mailer: &sentMail{}, Jobs: background.NewPool()}
+}
+
+// otpCall posts an email and code to an OTP handler for the event
+func otpCall(handler http.HandlerFunc, slug, email, code string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(otpRequest{Email: email, Code: code})
+	r := mux.SetURLVars(httptest.NewRequest("POST", "/", strings.NewReader(string(body))), map[string]string{"slug": slug})
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// errorCode reads the code of an error response
+func errorCode(w *httptest.ResponseRecorder) string {
+	var resp models.Error
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return resp.Code
+}
+
+// saveOTP stores the code 123456 for ada@example.com at the event
+func saveOTP(t *testing.T, u UserService, expiresAt time.Time) {
+	t.Helper()
+	ctx := context.Background()
+	event, _ := u.Store.GetEventBySlug(ctx, "event")
+	err := u.Store.SaveOTP(ctx, models.OTP{
+		EventID:   event.ID,
+		EmailHash: u.hashEmail("ada@example.com"),
+		CodeHash:  u.hashOTP("ada@example.com", "123456"),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		t.Fatalf("SaveOTP: %v", err)
+	}
+}
+
+func TestRequestOTP(t *testing.T) {
+	ctx := context.Background()
+	u := newOTPService(t)
+	mailer := u.Mailer.(*sentMail)
+
+	if w := otpCall(u.RequestOTP, "event", "Ada@Example.com", ""); w.Code != http.StatusAccepted {
+		t.Fatalf("RequestOTP = %d %s", w.Code, w.Body)
+	}
+	u.Jobs.Shutdown(ctx)
+	if len(mailer.sent["ada@example.com"]) != 1 {
+		t.Fatalf("emails sent = %v, want one to ada@example.com", mailer.sent)
+	}
+	code := regexp.MustCompile(`\b\d{6}\b`).FindString(mailer.sent["ada@example.com"][0])
+
+	// The store keeps neither the address nor the code
+	event, _ := u.Store.GetEventBySlug(ctx, "event")
+	otp, err := u.Store.GetOTP(ctx, event.ID, u.hashEmail("ada@example.com"))
+	if err != nil {
+		t.Fatalf("GetOTP: %v", err)
+	}
+	if otp.CodeHash != u.hashOTP("ada@example.com", code) || otp.CodeHash == tokens.NewSigner("other").MAC("otp", "ada@example.com:"+code) {
+		t.Errorf("stored code hash %s is not keyed by the token secret", otp.CodeHash)
+	}
+	if strings.Contains(otp.EmailHash, "ada") || strings.Contains(otp.CodeHash, code) {
+		t.Errorf("stored OTP %+v holds the address or the code", otp)
+	}
+	entries, _ := u.Store.ListAuditEntries(ctx, repository.AuditFilter{Action: "otp.send"}, 0)
+	if len(entries) != 1 || entries[0].TargetID != otp.EmailHash {
+		t.Errorf("otp.send entries = %+v", entries)
+	}
+
+	w := otpCall(u.RequestOTP, "event", "ada@example.com", "")
+	if w.Code != http.StatusTooManyRequests || errorCode(w) != "otp_cooldown" || w.Header().Get("Retry-After") == "" {
+		t.Errorf("resend within the cooldown = %d %s, want 429 otp_cooldown with Retry-After", w.Code, w.Body)
+	}
+	for _, tt := range []struct {
+		name  string
+		email string
+	}{
+		{"missing", ""},
+		{"malformed", "ada@"},
+		{"display name", "Ada <ada@example.com>"},
+	} {
+		if w := otpCall(u.RequestOTP, "event", tt.email, ""); w.Code != http.StatusBadRequest || errorCode(w) != "validation_failed" {
+			t.Errorf("RequestOTP(%s email) = %d %s, want 400", tt.name, w.Code, w.Body)
+		}
+	}
+	if w := otpCall(u.RequestOTP, "missing", "ada@example.com", ""); w.Code != http.StatusNotFound {
+		t.Errorf("RequestOTP for a missing event = %d, want 404", w.Code)
+	}
+}
+
+func TestRequestOTPEmailLimit(t *testing.T) {
+	u := newOTPService(t)
+	u.Jobs.Shutdown(context.Background())
+	u.EmailLimiter = &protection.Limiter{Store: protection.NewMemoryStore(), Rate: protection.Rate{Burst: 1, Per: time.Hour}, Prefix: "email"}
+	// The limit follows the address across events, where the cooldown does not
+	for i, tt := range []struct {
+		slug  string
+		email string
+		want  int
+	}{
+		{"event", "ada@example.com", http.StatusAccepted},
+		{"other", "ada@example.com", http.StatusTooManyRequests},
+		{"other", "ADA@example.com", http.StatusTooManyRequests},
+		{"other", "rao@example.com", http.StatusAccepted},
+	} {
+		w := otpCall(u.RequestOTP, tt.slug, tt.email, "")
+		if w.Code != tt.want || (tt.want == http.StatusTooManyRequests && errorCode(w) != "rate_limited") {
+			t.Errorf("step %d: RequestOTP(%s at %s) = %d %s, want %d", i+1, tt.email, tt.slug, w.Code, w.Body, tt.want)
+		}
+	}
+}
+
+func TestVerifyOTP(t *testing.T) {
+	ctx := context.Background()
+	u := newOTPService(t)
+	saveOTP(t, u, time.Now().Add(otpTTL))
+
+	w := otpCall(u.VerifyOTP, "event", "ada@example.com", "654321")
+	var wrong struct {
+		Code    string         `json:"code"`
+		Details map[string]int `json:"details"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &wrong)
+	if w.Code != http.StatusBadRequest || wrong.Code != "otp_incorrect" || wrong.Details["attemptsRemaining"] != otpMaxAttempts-1 {
+		t.Errorf("wrong code = %d %s, want otp_incorrect with %d attempts left", w.Code, w.Body, otpMaxAttempts-1)
+	}
+	if w := otpCall(u.VerifyOTP, "other", "ada@example.com", "123456"); w.Code != http.StatusBadRequest || errorCode(w) != "otp_invalid" {
+		t.Errorf("code from another event = %d %s, want otp_invalid", w.Code, w.Body)
+	}
+
+	w = otpCall(u.VerifyOTP, "event", " ADA@example.com", "123456")
+	var resp struct {
+		VerificationToken string `json:"verificationToken"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+		t.Fatalf("VerifyOTP = %d %s", w.Code, w.Body)
+	}
+	claims, err := u.Tokens.Verify(resp.VerificationToken, verificationPurpose)
+	if err != nil || claims.Subject != "ada@example.com" || claims.Event != "event" {
+		t.Errorf("verification token claims = %+v, %v", claims, err)
+	}
+	entries, _ := u.Store.ListAuditEntries(ctx, repository.AuditFilter{Action: "otp.verify"}, 0)
+	if len(entries) != 1 || entries[0].Actor != u.participantActor("ada@example.com") {
+		t.Errorf("otp.verify entries = %+v", entries)
+	}
+	// A code is used once
+	if w := otpCall(u.VerifyOTP, "event", "ada@example.com", "123456"); w.Code != http.StatusBadRequest || errorCode(w) != "otp_invalid" {
+		t.Errorf("reused code = %d %s, want otp_invalid", w.Code, w.Body)
+	}
+}
+
+func TestVerifyOTPAttempts(t *testing.T) {
+	u := newOTPService(t)
+	saveOTP(t, u, time.Now().Add(otpTTL))
+	for i := 1; i <= otpMaxAttempts; i++ {
+		if w := otpCall(u.VerifyOTP, "event", "ada@example.com", "000000"); errorCode(w) != "otp_incorrect" {
+			t.Fatalf("attempt %d = %d %s, want otp_incorrect", i, w.Code, w.Body)
+		}
+	}
+	// Every attempt is claimed, so the right code no longer works
+	if w := otpCall(u.VerifyOTP, "event", "ada@example.com", "123456"); w.Code != http.StatusBadRequest || errorCode(w) != "otp_invalid" {
+		t.Errorf("right code after %d attempts = %d %s, want otp_invalid", otpMaxAttempts, w.Code, w.Body)
+	}
+
+	// An expired code is refused even when right
+	saveOTP(t, u, time.Now().Add(-time.Second))
+	if w := otpCall(u.VerifyOTP, "event", "ada@example.com", "123456"); w.Code != http.StatusBadRequest || errorCode(w) != "otp_expired" {
+		t.Errorf("expired code = %d %s, want otp_expired", w.Code, w.Body)
+	}
+}
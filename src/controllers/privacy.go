@@ -0,0 +1,392 @@
+package controllers
+
+import (
+	"archive/zip"
+	"backend/src/models"
+	"backend/src/repository"
+	"backend/src/tracing"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	privacyExportPurpose  = "privacy_export"
+	privacyErasurePurpose = "privacy_erasure"
+	privacyTokenTTL       = 30 * time.Minute
+	erasedActor           = "participant:[erased]"
+)
+
+var screenshotClient = &http.Client{Timeout: 30 * time.Second}
+
+type privacyRequest struct {
+	Email string `json:"email"`
+	Kind  string `json:"kind"` // export or erasure
+}
+
+// PrivacyExport is everything stored for an email address
+type PrivacyExport struct {
+	Email         string                `json:"email"`
+	GeneratedAt   time.Time             `json:"generatedAt"`
+	Participants  []models.Participant  `json:"participants"`
+	Registrations []models.Registration `json:"registrations"`
+	Refunds       []models.Refund       `json:"refunds"`
+	AuditEntries  []models.AuditEntry   `json:"auditEntries"`
+}
+
+// RequestPrivacyLink emails a signed link that confirms an export or
+// erasure request. Like RequestManageLink it answers the same way whether
+// or not the address is registered.
+func (u UserService) RequestPrivacyLink(w http.ResponseWriter, r *http.Request) {
+	event, ok := u.eventFromRequest(w, r)
+	if !ok {
+		return
+	}
+	var req privacyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Email is required"})
+		return
+	}
+	purpose := map[string]string{"export": privacyExportPurpose, "erasure": privacyErasurePurpose}[req.Kind]
+	if purpose == "" {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Kind must be export or erasure"})
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+
+	// Deleted participants still have data to export or erase
+	participants, err := u.Store.FindParticipantsByEmail(r.Context(), email)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error looking up participants", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not process request"})
+		return
+	}
+	if slices.ContainsFunc(participants, func(p models.Participant) bool { return p.EventID == event.ID }) {
+		token, err := u.Tokens.Sign(purpose, email, event.Slug, privacyTokenTTL)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error signing token", "error", err)
+			writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not process request"})
+			return
+		}
+		u.Jobs.Go(r.Context(), "privacy link email", func(ctx context.Context) {
+			u.sendPrivacyLink(ctx, event, email, req.Kind, token)
+		})
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]string{
+		"message": "If this email is registered, a link to confirm the request has been sent",
+	})
+}
+
+func (u UserService) sendPrivacyLink(ctx context.Context, event models.Event, email, kind, token string) {
+	base := u.Config.PrivacyURL
+	if base == "" {
+		base = strings.TrimSuffix(event.Website, "/") + "/privacy"
+	}
+	link := base + "?kind=" + kind + "&token=" + url.QueryEscape(token)
+
+	action := "export"
+	if kind == "erasure" {
+		action = "erase"
+	}
+	var buf bytes.Buffer
+	err := privacyLinkTemplate.Execute(&buf, privacyLinkEmail{Event: event, Action: action, Link: link, ExpiresIn: "30 minutes"})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error rendering email", "error", err)
+		return
+	}
+	u.Mailer.Send(ctx, "privacy_link", email, "Confirm your "+event.Name+" data request", buf.String())
+}
+
+// privacyEmailFromRequest verifies the link token for purpose, writing the
+// error response itself and returning false on failure
+func (u UserService) privacyEmailFromRequest(w http.ResponseWriter, r *http.Request, purpose string) (string, bool) {
+	claims, err := u.Tokens.Verify(tokenFromRequest(r), purpose)
+	if err != nil {
+		writeTokenError(w, err)
+		return "", false
+	}
+	return claims.Subject, true
+}
+
+// collectPersonalData gathers the records of an email address across all
+// events, deleted ones included
+func (u UserService) collectPersonalData(ctx context.Context, email string) (PrivacyExport, error) {
+	export := PrivacyExport{
+		Email:         email,
+		GeneratedAt:   time.Now(),
+		Participants:  []models.Participant{},
+		Registrations: []models.Registration{},
+		Refunds:       []models.Refund{},
+		AuditEntries:  []models.AuditEntry{},
+	}
+	var err error
+	export.Participants, err = u.Store.FindParticipantsByEmail(ctx, email)
+	if err != nil || len(export.Participants) == 0 {
+		return export, err
+	}
+
+	pids := make([]int, len(export.Participants))
+	targets := make([]string, len(export.Participants))
+	for i, participant := range export.Participants {
+		pids[i] = participant.PID
+		targets[i] = strconv.Itoa(participant.PID)
+	}
+	export.Registrations, err = u.Store.FindRegistrationsByParticipants(ctx, pids)
+	if err != nil {
+		return export, err
+	}
+	regIDs := make([]models.ID, len(export.Registrations))
+	for i, reg := range export.Registrations {
+		regIDs[i] = reg.ID
+	}
+
+	received, err := u.Store.ListRefunds(ctx, repository.RefundFilter{RegistrationIDs: regIDs})
+	if err != nil {
+		return export, err
+	}
+	about, err := u.Store.ListAuditEntries(ctx, repository.AuditFilter{TargetType: "participant", TargetIDs: targets}, 0)
+	if err != nil {
+		return export, err
+	}
+	for _, actor := range u.participantActors(email) {
+		requested, err := u.Store.ListRefunds(ctx, repository.RefundFilter{RequestedBy: actor})
+		if err != nil {
+			return export, err
+		}
+		received = mergeByID(received, requested, func(r models.Refund) models.ID { return r.ID })
+		made, err := u.Store.ListAuditEntries(ctx, repository.AuditFilter{Actor: actor}, 0)
+		if err != nil {
+			return export, err
+		}
+		about = mergeByID(about, made, func(e models.AuditEntry) models.ID { return e.ID })
+	}
+	export.Refunds = received
+	slices.Reverse(export.Refunds)
+	export.AuditEntries = about
+	return export, nil
+}
+
+// participantActors are the actors an address may appear as, including
+// the plain one of records written before actors were hashed
+func (u UserService) participantActors(email string) []string {
+	return []string{u.participantActor(email), "participant:" + email}
+}
+
+// mergeByID merges two lists sorted by ID into one, newest first, without
+// duplicates
+func mergeByID[T any](a, b []T, id func(T) models.ID) []T {
+	merged := append(slices.Clone(a), b...)
+	slices.SortFunc(merged, func(x, y T) int { return strings.Compare(id(y).Hex(), id(x).Hex()) })
+	return slices.CompactFunc(merged, func(x, y T) bool { return id(x) == id(y) })
+}
+
+// ExportPersonalData returns everything stored for the verified email as
+// JSON, or with ?format=zip as a ZIP of data.json and the payment
+// screenshots
+func (u UserService) ExportPersonalData(w http.ResponseWriter, r *http.Request) {
+	email, ok := u.privacyEmailFromRequest(w, r, privacyExportPurpose)
+	if !ok {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "zip" {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Format must be json or zip"})
+		return
+	}
+
+	export, err := u.collectPersonalData(r.Context(), email)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error collecting personal data", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not export data"})
+		return
+	}
+	if format != "zip" {
+		w.Header().Set("Content-Disposition", `attachment; filename="personal-data.json"`)
+		writeJSON(w, http.StatusOK, export)
+		return
+	}
+
+	// Build the archive in memory so a failed download can still be
+	// reported as an error instead of a truncated file
+	var buf bytes.Buffer
+	if err := u.writeExportZip(r.Context(), &buf, export); err != nil {
+		slog.ErrorContext(r.Context(), "Error building export archive", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not export data"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="personal-data.zip"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+func (u UserService) writeExportZip(ctx context.Context, out io.Writer, export PrivacyExport) error {
+	archive := zip.NewWriter(out)
+	data, err := archive.Create("data.json")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(data)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(export); err != nil {
+		return err
+	}
+	for _, reg := range export.Registrations {
+		if reg.TransactionImage == "" {
+			continue
+		}
+		file, err := archive.Create("screenshots/" + reg.ID.Hex() + path.Ext(reg.TransactionImage))
+		if err != nil {
+			return err
+		}
+		if err := downloadFile(ctx, file, reg.TransactionImage); err != nil {
+			return fmt.Errorf("downloading screenshot of registration %s: %w", reg.ID.Hex(), err)
+		}
+	}
+	return archive.Close()
+}
+
+func downloadFile(ctx context.Context, out io.Writer, fileURL string) (err error) {
+	ctx, span := tracer.Start(ctx, "screenshot.download")
+	defer func() { tracing.End(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := screenshotClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
+
+// ErasePersonalData anonymizes and deletes the records of the verified
+// email. Registrations that only contain the requester are removed with
+// their screenshot; team registrations keep the payment proof for the
+// remaining members. The request itself is audited under a hash of the
+// address. Each link erases once. A step that fails is logged and skipped
+// so the rest still happens, and the response lists what failed.
+func (u UserService) ErasePersonalData(w http.ResponseWriter, r *http.Request) {
+	email, ok := u.privacyEmailFromRequest(w, r, privacyErasurePurpose)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+
+	export, err := u.collectPersonalData(ctx, email)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error collecting personal data", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not erase data"})
+		return
+	}
+	first, err := u.claimToken(ctx, tokenFromRequest(r), privacyTokenTTL)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error claiming erasure link", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not erase data"})
+		return
+	}
+	if !first {
+		writeError(w, http.StatusUnauthorized, models.Error{Message: "Link has already been used", Code: "token_used"})
+		return
+	}
+
+	var failed []string
+	fail := func(step string, err error) {
+		slog.ErrorContext(ctx, "Error erasing personal data", "step", step, "error", err)
+		failed = append(failed, step)
+	}
+	pids := make([]int, len(export.Participants))
+	for i, participant := range export.Participants {
+		pids[i] = participant.PID
+	}
+
+	removed := 0
+	for _, reg := range export.Registrations {
+		members := append(append([]int{}, reg.Participants...), reg.Cancelled...)
+		if slices.ContainsFunc(members, func(pid int) bool { return !slices.Contains(pids, pid) }) {
+			continue
+		}
+		// The screenshot is only reachable through the registration, so it
+		// stays whole until the file is gone
+		if reg.TransactionImage != "" {
+			if err := u.DeleteFile(ctx, reg.TransactionImage); err != nil {
+				fail("screenshot of registration "+reg.ID.Hex(), err)
+				continue
+			}
+		}
+		if err := u.Store.DeleteRegistration(ctx, reg.ID); err != nil && !errors.Is(err, repository.ErrNotFound) {
+			fail("registration "+reg.ID.Hex(), err)
+			continue
+		}
+		if err := u.Store.AnonymizeRegistration(ctx, reg.ID); err != nil {
+			fail("registration "+reg.ID.Hex(), err)
+			continue
+		}
+		removed++
+	}
+
+	if err := u.Store.AnonymizeParticipants(ctx, pids); err != nil {
+		fail("participants", err)
+	}
+	for _, pid := range pids {
+		if err := u.Store.DeleteParticipant(ctx, pid); err != nil && !errors.Is(err, repository.ErrNotFound) {
+			fail("participant "+strconv.Itoa(pid), err)
+		}
+	}
+	if err := u.Store.DeleteOTPsByEmailHash(ctx, u.hashEmail(email)); err != nil {
+		fail("verification codes", err)
+	}
+	for _, actor := range u.participantActors(email) {
+		if err := u.Store.RedactActor(ctx, actor, erasedActor, pids); err != nil {
+			fail("history", err)
+			break
+		}
+	}
+
+	counts := map[string]int{"participants": len(pids), "registrations": removed}
+	recordAudit(ctx, u.Store, models.AuditEntry{
+		Actor:      "privacy",
+		Action:     "privacy.erasure",
+		TargetType: "email",
+		TargetID:   u.hashEmail(email),
+		Changes: map[string]models.Change{
+			"participants":  {After: counts["participants"]},
+			"registrations": {After: counts["registrations"]},
+			"failed":        {After: len(failed)},
+		},
+	})
+	if len(failed) > 0 {
+		writeError(w, http.StatusInternalServerError, models.Error{
+			Message: "Some data could not be erased. Request a new link to try again.",
+			Code:    "erasure_incomplete",
+			Details: map[string]any{"erased": counts, "failed": failed},
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, counts)
+}
+
+// claimToken marks a link token as used for as long as it is valid,
+// returning false when it already was
+func (u UserService) claimToken(ctx context.Context, token string, ttl time.Duration) (bool, error) {
+	first, _, err := u.Store.TakeRateLimitToken(ctx, "token:"+u.Tokens.MAC("used token", token), 1, 1/ttl.Seconds())
+	return first, err
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"backend/src/models"
+	"backend/src/repository"
+	"backend/src/tokens"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErasePersonalData(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemory()
+	u := UserService{Store: store, Tokens: tokens.NewSigner("secret")}
+	event := models.NewID()
+
+	register := func(image string) (int, models.ID) {
+		t.Helper()
+		pid, err := store.CreateParticipant(ctx, models.Participant{EventID: event, Name: "Ada", Email: "ada@example.com"})
+		if err != nil {
+			t.Fatalf("CreateParticipant: %v", err)
+		}
+		id, err := store.CreateRegistration(ctx, models.Registration{EventID: event, Participants: []int{pid},
+			TransactionImage: image, Status: models.RegistrationActive})
+		if err != nil {
+			t.Fatalf("CreateRegistration: %v", err)
+		}
+		return pid, id
+	}
+	// An admin deleted this registration, which still holds personal data
+	deletedPID, deleted := register("")
+	if err := store.DeleteRegistration(ctx, deleted); err != nil {
+		t.Fatalf("DeleteRegistration: %v", err)
+	}
+	// This screenshot cannot be deleted, which must not stop the rest
+	stuckPID, stuck := register("https://example.com/not-cloudinary.png")
+
+	export, err := u.collectPersonalData(ctx, "ada@example.com")
+	if err != nil || len(export.Participants) != 2 || len(export.Registrations) != 2 {
+		t.Fatalf("collectPersonalData = %d participants, %d registrations, %v, want the deleted ones too",
+			len(export.Participants), len(export.Registrations), err)
+	}
+
+	token, _ := u.Tokens.Sign(privacyErasurePurpose, "ada@example.com", "event", privacyTokenTTL)
+	erase := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/privacy/erasure", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		u.ErasePersonalData(w, r)
+		return w
+	}
+	if w := erase(); w.Code != http.StatusInternalServerError {
+		t.Errorf("erasure with a failed step = %d %s, want 500", w.Code, w.Body)
+	}
+	participants, _ := store.ScanParticipants(ctx, 0, 10)
+	for _, participant := range participants {
+		if participant.AnonymizedAt == nil || participant.DeletedAt == nil {
+			t.Errorf("participant %d was not erased: %+v", participant.PID, participant)
+		}
+	}
+	registrations, _ := store.FindRegistrationsByParticipants(ctx, []int{deletedPID, stuckPID})
+	if len(registrations) != 2 {
+		t.Fatalf("%d registrations, want 2", len(registrations))
+	}
+	for _, reg := range registrations {
+		// The registration whose screenshot is left keeps pointing at it
+		if want := reg.ID != stuck; (reg.AnonymizedAt != nil) != want || (reg.TransactionImage == "") != want {
+			t.Errorf("registration %s anonymized = %v, want %v", reg.ID.Hex(), reg.AnonymizedAt != nil, want)
+		}
+	}
+
+	if w := erase(); w.Code != http.StatusUnauthorized {
+		t.Errorf("second use of the link = %d %s, want 401", w.Code, w.Body)
+	}
+}
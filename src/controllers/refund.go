@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"backend/src/models"
+	"backend/src/repository"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// ListRefunds returns the refund queue, filtered by ?status= and ?event=
+func (a AdminService) ListRefunds(w http.ResponseWriter, r *http.Request) {
+	var filter repository.RefundFilter
+	if status := r.URL.Query().Get("status"); status != "" {
+		filter.Status = status
+	}
+	if slug := r.URL.Query().Get("event"); slug != "" {
+		event, err := a.Store.GetEventBySlug(r.Context(), slug)
+		if err != nil {
+			writeError(w, http.StatusNotFound, models.Error{Message: "Event not found"})
+			return
+		}
+		filter.EventID = event.ID
+	}
+
+	refunds, err := a.Store.ListRefunds(r.Context(), filter)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing refunds", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not list refunds"})
+		return
+	}
+	writeJSON(w, http.StatusOK, refunds)
+}
+
+type refundReview struct {
+	Reference string `json:"reference"` // payout reference, required when marking paid
+}
+
+func (a AdminService) ApproveRefund(w http.ResponseWriter, r *http.Request) {
+	a.transitionRefund(w, r, models.RefundRequested, models.RefundApproved)
+}
+
+func (a AdminService) RejectRefund(w http.ResponseWriter, r *http.Request) {
+	a.transitionRefund(w, r, models.RefundRequested, models.RefundRejected)
+}
+
+func (a AdminService) MarkRefundPaid(w http.ResponseWriter, r *http.Request) {
+	a.transitionRefund(w, r, models.RefundApproved, models.RefundPaid)
+}
+
+func (a AdminService) transitionRefund(w http.ResponseWriter, r *http.Request, from, to string) {
+	var req refundReview
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Invalid request body"})
+		return
+	}
+	reviewer, reference := adminActor(r.Context()), ""
+	changes := map[string]models.Change{
+		"status":     {Before: from, After: to},
+		"reviewedBy": {After: reviewer},
+	}
+	if to == models.RefundPaid {
+		if req.Reference == "" {
+			writeError(w, http.StatusBadRequest, models.Error{Message: "Payment reference is required"})
+			return
+		}
+		reference = req.Reference
+		changes["reference"] = models.Change{After: reference}
+	}
+
+	id := mux.Vars(r)["id"]
+	objID, err := models.ParseID(id)
+	if err != nil {
+		writeError(w, http.StatusConflict, models.Error{Message: "Refund not found or not " + from})
+		return
+	}
+	var refund models.Refund
+	err = a.Store.WithTransaction(r.Context(), func(ctx context.Context) error {
+		var err error
+		if refund, err = a.Store.UpdateRefundStatus(ctx, objID, from, to, reviewer, reference); err != nil {
+			return err
+		}
+		return a.Store.CreateAuditEntry(ctx, stampAudit(ctx, models.AuditEntry{
+			Actor:      adminActor(ctx),
+			Action:     "refund." + to,
+			TargetType: "refund",
+			TargetID:   id,
+			EventID:    refund.EventID,
+			Changes:    changes,
+		}))
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		writeError(w, http.StatusConflict, models.Error{Message: "Refund not found or not " + from})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error updating refund", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Could not update refund"})
+		return
+	}
+	writeJSON(w, http.StatusOK, refund)
+}
@@ -0,0 +1,488 @@
+package controllers
+
+import (
+	"backend/src/codes"
+	"backend/src/metrics"
+	"backend/src/models"
+	"backend/src/protection"
+	"backend/src/repository"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// requestError is a registration failure with a client-facing response
+type requestError struct {
+	Status     int
+	Body       models.Error
+	RetryAfter time.Duration // sent as Retry-After when set
+}
+
+func (e *requestError) Error() string {
+	return e.Body.Message
+}
+
+func writeRequestError(w http.ResponseWriter, r *http.Request, err error) {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		if reqErr.RetryAfter > 0 {
+			w.Header().Set("Retry-After", protection.RetryAfterSeconds(reqErr.RetryAfter))
+		}
+		writeError(w, reqErr.Status, reqErr.Body)
+		return
+	}
+	slog.ErrorContext(r.Context(), "Registration failed", "error", err)
+	writeError(w, http.StatusInternalServerError, models.Error{Message: "Registration failed"})
+}
+
+// failRegistration counts a rejected or failed registration attempt before
+// writing the error response
+func failRegistration(w http.ResponseWriter, r *http.Request, err error) {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		reason := reqErr.Body.Code
+		if reason == "" {
+			reason = "invalid_request"
+		}
+		metrics.Registration(metrics.Rejected, reason)
+	} else {
+		metrics.Registration(metrics.Failed, "internal")
+	}
+	writeRequestError(w, r, err)
+}
+
+// registrationInput is a validated registration, however it was submitted
+type registrationInput struct {
+	Participants  []ParticipantInput
+	TransactionID string
+	ReferralCode  string
+	TeamName      string
+	Leader        int // index into Participants
+}
+
+// registrationResult is the success response of both registration APIs
+type registrationResult struct {
+	Success        bool                `json:"success"`
+	Message        string              `json:"message"`
+	RegistrationID string              `json:"registrationId"`
+	TicketToken    string              `json:"ticketToken"`
+	Participants   []participantTicket `json:"participants"`
+}
+
+// participantTicket is what a ticket shows for each participant
+type participantTicket struct {
+	Name string `json:"name"`
+	Code string `json:"code"`
+}
+
+// parseRegistration validates the raw participants JSON, payment fields and
+// team rules of the event. The leader is the participant flagged `leader`,
+// or the first one when nobody is.
+func (u UserService) parseRegistration(event models.Event, participants []byte, transactionID, referralCode, teamName string) (registrationInput, error) {
+	var fieldErrs []models.FieldError
+	var inputs []ParticipantInput
+	if len(participants) == 0 {
+		fieldErrs = append(fieldErrs, models.FieldError{Index: -1, Field: "participants", Message: "is required"})
+	} else {
+		inputs, fieldErrs = decodeParticipants(participants, u.Config.CountryCode)
+	}
+	if transactionID == "" {
+		fieldErrs = append(fieldErrs, models.FieldError{Index: -1, Field: "transactionId", Message: "is required"})
+	}
+	teamName, msg := validateTeamName(teamName)
+	if msg != "" {
+		fieldErrs = append(fieldErrs, models.FieldError{Index: -1, Field: "teamName", Message: msg})
+	}
+
+	leader := -1
+	for i, input := range inputs {
+		if !input.Leader {
+			continue
+		}
+		if leader >= 0 {
+			fieldErrs = append(fieldErrs, models.FieldError{Index: i, Field: "leader", Message: "only one participant can be the leader"})
+			continue
+		}
+		leader = i
+	}
+	leader = max(leader, 0)
+
+	minSize, maxSize := event.TeamSizeLimits()
+	if inputs != nil && len(inputs) < minSize {
+		fieldErrs = append(fieldErrs, models.FieldError{Index: -1, Field: "participants", Message: fmt.Sprintf("at least %d participants are required", minSize)})
+	}
+	if maxSize > 0 && len(inputs) > maxSize {
+		fieldErrs = append(fieldErrs, models.FieldError{Index: -1, Field: "participants", Message: fmt.Sprintf("at most %d participants are allowed", maxSize)})
+	}
+	if len(fieldErrs) > 0 {
+		sortFieldErrors(fieldErrs)
+		return registrationInput{}, &requestError{Status: http.StatusBadRequest, Body: models.Error{
+			Message: "Invalid registration",
+			Code:    "validation_failed",
+			Fields:  fieldErrs,
+		}}
+	}
+	return registrationInput{
+		Participants:  inputs,
+		TransactionID: transactionID,
+		ReferralCode:  referralCode,
+		TeamName:      teamName,
+		Leader:        leader,
+	}, nil
+}
+
+// limitEmails applies the per-email rate limit to every participant. Rate
+// limit store errors let the registration through.
+func (u UserService) limitEmails(ctx context.Context, input registrationInput) error {
+	if u.EmailLimiter == nil {
+		return nil
+	}
+	for i, participant := range input.Participants {
+		allowed, retryAfter, err := u.EmailLimiter.Allow(ctx, u.hashEmail(participant.Email))
+		if err != nil {
+			slog.ErrorContext(ctx, "Rate limiter error", "error", err)
+			return nil
+		}
+		if !allowed {
+			return &requestError{Status: http.StatusTooManyRequests, Body: models.Error{
+				Message: "Too many registrations for this email, please try again later",
+				Code:    "rate_limited",
+				Fields:  []models.FieldError{{Index: i, Field: "email", Message: "has too many recent registrations"}},
+			}, RetryAfter: retryAfter}
+		}
+	}
+	return nil
+}
+
+// admit rejects submissions outside the registration window
+func (u UserService) admit(ctx context.Context, event models.Event) (models.EventStatus, error) {
+	status, err := u.EventStatus(ctx, event)
+	if err != nil {
+		return status, err
+	}
+	if !status.Open {
+		return status, &requestError{Status: http.StatusForbidden, Body: models.Error{
+			Message: "Registrations are not open",
+			Code:    "registration_" + status.Phase,
+			Details: status,
+		}}
+	}
+	return status, nil
+}
+
+// checkSeats rejects groups larger than the seats left
+func checkSeats(status models.EventStatus, count int) error {
+	if status.RemainingSeats != nil && count > *status.RemainingSeats {
+		return &requestError{Status: http.StatusConflict, Body: models.Error{
+			Message: "Not enough seats left",
+			Code:    "registration_full",
+			Details: status,
+		}}
+	}
+	return nil
+}
+
+// errTeamNameTaken rejects a registration whose team name is in use
+var errTeamNameTaken = &requestError{Status: http.StatusConflict, Body: models.Error{
+	Message: "Team name is already taken",
+	Code:    "team_name_taken",
+	Fields:  []models.FieldError{{Index: -1, Field: "teamName", Message: "is already taken"}},
+}}
+
+// createRegistration persists the participants and their registration, then
+// sends the confirmation emails. uploadID names the consumed upload the
+// screenshot came from, if any.
+func (u UserService) createRegistration(ctx context.Context, event models.Event, status models.EventStatus, input registrationInput, imageURL string, uploadID models.ID) (registrationResult, error) {
+	// Checked up front to spare the writes, the store's unique index settles races
+	teamNameKey := strings.ToLower(input.TeamName)
+	if teamNameKey != "" {
+		taken, err := u.Store.TeamNameTaken(ctx, event.ID, teamNameKey)
+		if err != nil {
+			return registrationResult{}, err
+		}
+		if taken {
+			return registrationResult{}, errTeamNameTaken
+		}
+	}
+
+	// Insert participants into DB, allocating the group's PIDs at once
+	var participants []models.Participant
+	for _, p := range input.Participants {
+		participants = append(participants, models.Participant{
+			EventID:     event.ID,
+			Code:        codes.New(event.ParticipantCodePrefix()),
+			Name:        p.Name,
+			Email:       p.Email,
+			Phone:       p.Phone,
+			CollegeName: p.CollegeName,
+			YearOfStudy: p.YearOfStudy,
+			DualBoot:    p.DualBoot,
+			CreatedAt:   time.Now(),
+			UpdatedAt:   time.Now(),
+		})
+	}
+	// The participants are only kept together with their registration and
+	// its audit entries
+	var registration models.Registration
+	err := u.Store.WithTransaction(ctx, func(ctx context.Context) error {
+		// The seats were checked up front to spare the writes, registrations
+		// of a capped event then count them again one at a time
+		if event.Capacity > 0 {
+			if err := u.Store.LockInTransaction(ctx, "seats:"+event.ID.Hex()); err != nil {
+				return err
+			}
+			registered, err := u.Store.CountParticipants(ctx, event.ID)
+			if err != nil {
+				return err
+			}
+			if err := checkSeats(event.Status(time.Now(), registered), len(participants)); err != nil {
+				return err
+			}
+		}
+		participantIDs, err := u.Store.CreateParticipants(ctx, participants)
+		if err != nil {
+			return err
+		}
+		registration = models.Registration{
+			EventID:           event.ID,
+			TeamName:          input.TeamName,
+			TeamNameKey:       teamNameKey,
+			LeaderPID:         participantIDs[input.Leader],
+			NumOfParticipants: len(participantIDs),
+			Participants:      participantIDs,
+			TotalAmount:       status.Fee * len(participantIDs),
+			TransactionID:     input.TransactionID,
+			TransactionImage:  imageURL,
+			ReferralCode:      input.ReferralCode,
+			Status:            models.RegistrationActive,
+		}
+		registration.ID, err = u.Store.CreateRegistration(ctx, registration)
+		if errors.Is(err, repository.ErrDuplicate) {
+			return errTeamNameTaken
+		}
+		if err != nil {
+			return err
+		}
+		actor := u.participantActor(participants[input.Leader].Email)
+		err = u.Store.CreateAuditEntry(ctx, stampAudit(ctx, models.AuditEntry{
+			Actor:      actor,
+			Action:     "registration.create",
+			TargetType: "registration",
+			TargetID:   registration.ID.Hex(),
+			EventID:    event.ID,
+			Changes: map[string]models.Change{
+				"participants": {After: registration.Participants},
+				"totalAmount":  {After: registration.TotalAmount},
+				"status":       {After: registration.Status},
+			},
+		}))
+		if err != nil || uploadID.IsZero() {
+			return err
+		}
+		return u.Store.CreateAuditEntry(ctx, stampAudit(ctx, models.AuditEntry{
+			Actor:      actor,
+			Action:     "upload.attach",
+			TargetType: "upload",
+			TargetID:   uploadID.Hex(),
+			EventID:    event.ID,
+			Changes:    map[string]models.Change{"registration": {After: registration.ID.Hex()}},
+		}))
+	})
+	if err != nil {
+		return registrationResult{}, err
+	}
+
+	// The ticket token lets the group manage the registration without a magic link
+	ticketToken, err := u.Tokens.Sign(ticketTokenPurpose, registration.ID.Hex(), event.Slug, ticketTokenTTL(event))
+	if err != nil {
+		slog.ErrorContext(ctx, "Error signing ticket token", "error", err)
+	}
+
+	// Send confirmation emails, with the payment receipt going to the leader
+	for _, participant := range participants {
+		participant := participant
+		u.Jobs.Go(ctx, "confirmation email", func(ctx context.Context) {
+			u.SendEmail(ctx, event, registration, participant, participants)
+		})
+	}
+
+	metrics.Registration(metrics.Created, "")
+	metrics.Participants(len(participants))
+
+	tickets := make([]participantTicket, len(participants))
+	for i, participant := range participants {
+		tickets[i] = participantTicket{Name: participant.Name, Code: participant.Code}
+	}
+	return registrationResult{
+		Success:        true,
+		Message:        "Registration successful",
+		RegistrationID: registration.ID.Hex(),
+		TicketToken:    ticketToken,
+		Participants:   tickets,
+	}, nil
+}
+
+const (
+	uploadTokenPurpose = "upload"
+	uploadTokenTTL     = time.Hour
+)
+
+// UploadScreenshot is the first step of the JSON API. It stores the payment
+// screenshot and returns a token to reference it from the registration.
+func (u UserService) UploadScreenshot(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	event, ok := u.eventFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if _, err := u.admit(ctx, event); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Failed to parse form"})
+		return
+	}
+	file, _, err := r.FormFile("transactionImage")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, models.Error{Message: "Transaction screenshot is required"})
+		return
+	}
+	defer file.Close()
+
+	image, err := isImage(file)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Image upload failed"})
+		return
+	}
+	if !image {
+		writeError(w, http.StatusUnsupportedMediaType, models.Error{Message: "Transaction screenshot must be an image"})
+		return
+	}
+
+	imageURL, is := u.FileUpload(ctx, file, event.Folder())
+	if !is {
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Image upload failed"})
+		return
+	}
+	uploadID, err := u.Store.CreateUpload(ctx, models.Upload{EventID: event.ID, URL: imageURL})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error recording upload", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Image upload failed"})
+		return
+	}
+	// Nobody is identified until the upload is attached to a registration
+	recordAudit(ctx, u.Store, models.AuditEntry{
+		Actor:      anonymousActor,
+		Action:     "upload.create",
+		TargetType: "upload",
+		TargetID:   uploadID.Hex(),
+		EventID:    event.ID,
+	})
+	token, err := u.Tokens.Sign(uploadTokenPurpose, uploadID.Hex(), event.Slug, uploadTokenTTL)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error signing upload token", "error", err)
+		writeError(w, http.StatusInternalServerError, models.Error{Message: "Image upload failed"})
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"uploadToken": token,
+		"expiresAt":   time.Now().Add(uploadTokenTTL),
+	})
+}
+
+// registrationRequest is the body of the JSON registration API
+type registrationRequest struct {
+	Participants  json.RawMessage `json:"participants"`
+	TransactionID string          `json:"transactionId"`
+	ReferralCode  string          `json:"referralCode"`
+	TeamName      string          `json:"teamName"`
+	UploadToken   string          `json:"uploadToken"`
+}
+
+// RegisterParticipantsJSON is the second step of the JSON API. It takes the
+// registration as a JSON body referencing a screenshot upload token.
+func (u UserService) RegisterParticipantsJSON(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	event, ok := u.eventFromRequest(w, r)
+	if !ok {
+		return
+	}
+	status, err := u.admit(ctx, event)
+	if err != nil {
+		failRegistration(w, r, err)
+		return
+	}
+
+	var req registrationRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		failRegistration(w, r, &requestError{Status: http.StatusBadRequest, Body: models.Error{Message: "Invalid request body", Code: "invalid_body"}})
+		return
+	}
+	input, err := u.parseRegistration(event, req.Participants, req.TransactionID, req.ReferralCode, req.TeamName)
+	if err == nil {
+		err = u.checkVerifications(event, input)
+	}
+	if err == nil {
+		err = u.limitEmails(ctx, input)
+	}
+	if err == nil {
+		err = checkSeats(status, len(input.Participants))
+	}
+	if err != nil {
+		failRegistration(w, r, err)
+		return
+	}
+
+	var uploadID models.ID
+	claims, err := u.Tokens.Verify(req.UploadToken, uploadTokenPurpose)
+	if err == nil {
+		uploadID, err = models.ParseID(claims.Subject)
+	}
+	if err != nil || claims.Event != event.Slug {
+		failRegistration(w, r, &requestError{Status: http.StatusBadRequest, Body: models.Error{
+			Message: "Upload token is invalid or expired",
+			Code:    "upload_token_invalid",
+			Fields:  []models.FieldError{{Index: -1, Field: "uploadToken", Message: "is invalid or expired"}},
+		}})
+		return
+	}
+	upload, err := u.Store.ConsumeUpload(ctx, uploadID, event.ID)
+	if errors.Is(err, repository.ErrNotFound) {
+		failRegistration(w, r, &requestError{Status: http.StatusConflict, Body: models.Error{Message: "Upload has already been used", Code: "upload_token_used"}})
+		return
+	}
+	if err != nil {
+		failRegistration(w, r, err)
+		return
+	}
+
+	result, err := u.createRegistration(ctx, event, status, input, upload.URL, uploadID)
+	if err != nil {
+		if releaseErr := u.Store.ReleaseUpload(ctx, uploadID, event.ID); releaseErr != nil {
+			slog.ErrorContext(ctx, "Error releasing upload", "error", releaseErr)
+		}
+		failRegistration(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, result)
+}
+
+// isImage sniffs the file's content, whatever type the client claims, and
+// rewinds it for the upload
+func isImage(file io.ReadSeeker) (bool, error) {
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	return strings.HasPrefix(http.DetectContentType(head[:n]), "image/"), nil
+}
@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"backend/src/background"
+	"backend/src/models"
+	"backend/src/repository"
+	"backend/src/tokens"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestIsImage(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	for _, tt := range []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"png", png, true},
+		{"jpeg", []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"), true},
+		{"html", []byte("<html><body>hi</body></html>"), false},
+		{"pdf", []byte("%PDF-1.7\n"), false},
+		{"empty", nil, false},
+	} {
+		file := bytes.NewReader(tt.data)
+		got, err := isImage(file)
+		if err != nil || got != tt.want {
+			t.Errorf("isImage(%s) = %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+		// The upload must see the whole file
+		if rest, _ := io.ReadAll(file); !bytes.Equal(rest, tt.data) {
+			t.Errorf("isImage(%s) did not rewind the file", tt.name)
+		}
+	}
+}
+
+// lateTeamNames misses team names taken after the check, as a concurrent
+// registration would
+type lateTeamNames struct {
+	repository.Store
+}
+
+func (lateTeamNames) TeamNameTaken(ctx context.Context, eventID models.ID, teamNameKey string) (bool, error) {
+	return false, nil
+}
+
+func TestCreateRegistrationTeamNameRace(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemory()
+	event := models.Event{ID: models.NewID(), Slug: "event"}
+	_, err := store.CreateRegistration(ctx, models.Registration{
+		EventID: event.ID, TeamName: "Null Pointers", TeamNameKey: "null pointers", Status: models.RegistrationActive,
+	})
+	if err != nil {
+		t.Fatalf("CreateRegistration: %v", err)
+	}
+
+	u := UserService{Store: lateTeamNames{store}}
+	input := registrationInput{
+		Participants: []ParticipantInput{{Name: "Ada", Email: "ada@example.com", Phone: "+919876543210", CollegeName: "IIT", YearOfStudy: 2}},
+		TeamName:     "Null Pointers",
+	}
+	_, err = u.createRegistration(ctx, event, models.EventStatus{}, input, "https://example.com/txn.png", models.ID{})
+	if !errors.Is(err, errTeamNameTaken) {
+		t.Fatalf("createRegistration = %v, want errTeamNameTaken", err)
+	}
+	// The participants are rolled back with the registration
+	if n, _ := store.CountParticipants(ctx, event.ID); n != 0 {
+		t.Errorf("%d participants left behind", n)
+	}
+}
+
+// failingAudit cannot write audit entries
+type failingAudit struct {
+	repository.Store
+}
+
+func (failingAudit) CreateAuditEntry(ctx context.Context, entry models.AuditEntry) error {
+	return errors.New("audit log unavailable")
+}
+
+func TestCreateRegistrationAudit(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemory()
+	event := models.Event{ID: models.NewID(), Slug: "event"}
+	// A closed pool drops the confirmation emails
+	jobs := background.NewPool()
+	jobs.Shutdown(ctx)
+	input := registrationInput{
+		Participants: []ParticipantInput{{Name: "Ada", Email: "ada@example.com", Phone: "+919876543210", CollegeName: "IIT", YearOfStudy: 2}},
+	}
+
+	// The registration is not kept without its audit entry
+	u := UserService{Store: failingAudit{store}, Tokens: tokens.NewSigner("secret"), Jobs: jobs}
+	if _, err := u.createRegistration(ctx, event, models.EventStatus{}, input, "", models.ID{}); err == nil {
+		t.Fatal("createRegistration succeeded without its audit entry")
+	}
+	if n, _ := store.CountParticipants(ctx, event.ID); n != 0 {
+		t.Errorf("%d participants kept without an audit entry", n)
+	}
+
+	u.Store = store
+	upload := models.NewID()
+	result, err := u.createRegistration(ctx, event, models.EventStatus{}, input, "https://example.com/txn.png", upload)
+	if err != nil {
+		t.Fatalf("createRegistration: %v", err)
+	}
+	for _, tt := range []struct {
+		action, targetID string
+	}{
+		{"registration.create", result.RegistrationID},
+		{"upload.attach", upload.Hex()},
+	} {
+		entries, _ := store.ListAuditEntries(ctx, repository.AuditFilter{Action: tt.action}, 0)
+		if len(entries) != 1 || entries[0].TargetID != tt.targetID || entries[0].Actor != u.participantActor("ada@example.com") {
+			t.Errorf("%s entries = %+v, want one for %s", tt.action, entries, tt.targetID)
+		}
+	}
+}
+
+func TestCreateRegistrationRechecksSeats(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemory()
+	event := models.Event{ID: models.NewID(), Slug: "event", Capacity: 2}
+	jobs := background.NewPool()
+	jobs.Shutdown(ctx)
+	u := UserService{Store: store, Tokens: tokens.NewSigner("secret"), Jobs: jobs}
+	// Both registrations saw two seats left before either was stored
+	stale := event.Status(time.Now(), 0)
+	input := func(email string) registrationInput {
+		return registrationInput{Participants: []ParticipantInput{
+			{Name: "Ada", Email: email, Phone: "+919876543210", CollegeName: "IIT", YearOfStudy: 2},
+		}}
+	}
+	if _, err := u.createRegistration(ctx, event, stale, input("ada@example.com"), "", models.ID{}); err != nil {
+		t.Fatalf("first registration: %v", err)
+	}
+	two := input("rao@example.com")
+	two.Participants = append(two.Participants, input("eve@example.com").Participants...)
+	_, err := u.createRegistration(ctx, event, stale, two, "", models.ID{})
+	var reqErr *requestError
+	if !errors.As(err, &reqErr) || reqErr.Body.Code != "registration_full" {
+		t.Fatalf("second registration = %v, want registration_full", err)
+	}
+	if n, _ := store.CountParticipants(ctx, event.ID); n != 1 {
+		t.Errorf("%d participants registered, want the first one only", n)
+	}
+}
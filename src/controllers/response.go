@@ -0,0 +1,20 @@
+package controllers
+
+import (
+	"backend/src/models"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error writing response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, e models.Error) {
+	writeJSON(w, status, e)
+}
@@ -0,0 +1,210 @@
+package controllers
+
+import (
+	"backend/src/models"
+	"backend/src/tracing"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const (
+	// retentionBatch is how many records are anonymized per query
+	retentionBatch = 100
+	// retentionLock keeps other instances from applying the policy
+	// meanwhile. Its lease is extended after every batch.
+	retentionLock    = "retention"
+	retentionLockTTL = 10 * time.Minute
+	// anonymizedActor replaces the participants anonymized by retention in
+	// the audit log and refunds
+	anonymizedActor = "participant:[anonymized]"
+)
+
+// RunRetention applies the retention policy now and then every interval
+// until ctx is cancelled. It does nothing without a retention period.
+func (u UserService) RunRetention(ctx context.Context) {
+	retention := u.Config.Retention
+	if retention.Period == 0 {
+		return
+	}
+	ticker := time.NewTicker(retention.Interval)
+	defer ticker.Stop()
+	for {
+		if err := u.ApplyRetention(ctx); err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "Error applying retention policy", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ApplyRetention anonymizes the participants and registrations of events
+// that ended more than the retention period ago and of those deleted before
+// then, deleting their payment screenshots, their codes and their names in
+// the audit log and refunds, and removes expired uploads and codes. A file
+// that cannot be deleted is logged and skipped, keeping its registration or
+// upload for the next run. Only one instance applies the policy at a time.
+func (u UserService) ApplyRetention(ctx context.Context) (err error) {
+	ctx, span := tracer.Start(ctx, "retention")
+	defer func() { tracing.End(span, err) }()
+
+	owner := models.NewID().Hex()
+	if held, err := u.Store.AcquireLock(ctx, retentionLock, owner, retentionLockTTL); err != nil || !held {
+		return err
+	}
+	defer u.Store.ReleaseLock(context.WithoutCancel(ctx), retentionLock, owner)
+	// renew extends the lease between batches
+	renew := func() error {
+		held, err := u.Store.AcquireLock(ctx, retentionLock, owner, retentionLockTTL)
+		if err == nil && !held {
+			err = errors.New("lost the retention lock")
+		}
+		return err
+	}
+
+	cutoff := time.Now().Add(-u.Config.Retention.Period)
+	events, err := u.Store.ListEvents(ctx)
+	if err != nil {
+		return err
+	}
+	var expired []models.ID
+	for _, event := range events {
+		if end := event.EndTime(); !end.IsZero() && end.Before(cutoff) {
+			expired = append(expired, event.ID)
+		}
+	}
+
+	if err := u.hashPlaintextActors(ctx); err != nil {
+		return err
+	}
+
+	// Participants go first, while their emails still name them in the
+	// audit log, refunds and codes
+	participants := 0
+	for {
+		batch, err := u.Store.ParticipantsToAnonymize(ctx, expired, cutoff, retentionBatch)
+		if err != nil {
+			return err
+		}
+		pids := make([]int, len(batch))
+		for i, participant := range batch {
+			pids[i] = participant.PID
+			if err := u.Store.DeleteOTPsByEmailHash(ctx, u.hashEmail(participant.Email)); err != nil {
+				return err
+			}
+			for _, actor := range u.participantActors(participant.Email) {
+				if err := u.Store.RedactActor(ctx, actor, anonymizedActor, []int{participant.PID}); err != nil {
+					return err
+				}
+			}
+		}
+		if err := u.Store.AnonymizeParticipants(ctx, pids); err != nil {
+			return err
+		}
+		participants += len(batch)
+		if len(batch) < retentionBatch {
+			break
+		}
+		if err := renew(); err != nil {
+			return err
+		}
+	}
+
+	registrations, failed := 0, 0
+	var after models.ID
+	for {
+		batch, err := u.Store.RegistrationsToAnonymize(ctx, expired, cutoff, after, retentionBatch)
+		if err != nil {
+			return err
+		}
+		for _, registration := range batch {
+			after = registration.ID
+			if registration.TransactionImage != "" {
+				if err := u.DeleteFile(ctx, registration.TransactionImage); err != nil {
+					slog.ErrorContext(ctx, "Error deleting screenshot", "registration", registration.ID.Hex(), "error", err)
+					failed++
+					continue
+				}
+			}
+			if err := u.Store.AnonymizeRegistration(ctx, registration.ID); err != nil {
+				return err
+			}
+			registrations++
+		}
+		if len(batch) < retentionBatch {
+			break
+		}
+		if err := renew(); err != nil {
+			return err
+		}
+	}
+
+	uploads, err := u.Store.ExpiredUploads(ctx, expired, cutoff)
+	if err != nil {
+		return err
+	}
+	deleted := 0
+	for _, upload := range uploads {
+		if err := u.DeleteFile(ctx, upload.URL); err != nil {
+			slog.ErrorContext(ctx, "Error deleting upload", "upload", upload.ID.Hex(), "error", err)
+			failed++
+			continue
+		}
+		if err := u.Store.DeleteUpload(ctx, upload.ID); err != nil {
+			return err
+		}
+		deleted++
+	}
+
+	codes, err := u.Store.DeleteExpiredOTPs(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if participants > 0 || registrations > 0 || deleted > 0 || codes > 0 || failed > 0 {
+		slog.InfoContext(ctx, "Applied retention policy",
+			"participants", participants, "registrations", registrations, "uploads", deleted, "codes", codes)
+		recordAudit(ctx, u.Store, models.AuditEntry{
+			Actor:      systemActor,
+			Action:     "retention.apply",
+			TargetType: "retention",
+			Changes: map[string]models.Change{
+				"participants":  {After: participants},
+				"registrations": {After: registrations},
+				"uploads":       {After: deleted},
+				"codes":         {After: codes},
+				"failed":        {After: failed},
+			},
+		})
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d files could not be deleted and are left for the next run", failed)
+	}
+	return nil
+}
+
+// hashPlaintextActors replaces the email addresses that records written
+// before actors were hashed name their participants by
+func (u UserService) hashPlaintextActors(ctx context.Context) error {
+	for {
+		actors, err := u.Store.PlaintextActors(ctx, retentionBatch)
+		if err != nil {
+			return err
+		}
+		for _, actor := range actors {
+			email := strings.TrimPrefix(actor, "participant:")
+			if err := u.Store.RedactActor(ctx, actor, u.participantActor(email), nil); err != nil {
+				return err
+			}
+		}
+		if len(actors) < retentionBatch {
+			return nil
+		}
+	}
+}
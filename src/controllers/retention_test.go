@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"backend/src/config"
+	"backend/src/models"
+	"backend/src/repository"
+	"backend/src/tokens"
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestApplyRetention(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemory()
+	u := UserService{Store: store, Tokens: tokens.NewSigner("secret"), Config: config.Config{Retention: config.Retention{Period: time.Hour}}}
+	event := models.NewID()
+	if _, err := store.CreateEvent(ctx, models.Event{ID: event, Slug: "event"}); err != nil {
+		t.Fatalf("CreateEvent: %v", err)
+	}
+
+	register := func(email, image string) (int, models.ID) {
+		t.Helper()
+		pid, err := store.CreateParticipant(ctx, models.Participant{EventID: event, Name: "Ada", Email: email})
+		if err != nil {
+			t.Fatalf("CreateParticipant: %v", err)
+		}
+		id, err := store.CreateRegistration(ctx, models.Registration{EventID: event, Participants: []int{pid},
+			TeamName: email, TeamNameKey: email, TransactionImage: image, Status: models.RegistrationActive})
+		if err != nil {
+			t.Fatalf("CreateRegistration: %v", err)
+		}
+		if err := store.DeleteRegistration(ctx, id); err != nil {
+			t.Fatalf("DeleteRegistration: %v", err)
+		}
+		return pid, id
+	}
+	// The screenshot cannot be deleted, which must not hold up the rest
+	_, stuck := register("stuck@example.com", "https://example.com/not-cloudinary.png")
+	pid, done := register("ada@example.com", "")
+	alone, _ := store.CreateParticipant(ctx, models.Participant{EventID: event, Name: "Rao", Email: "rao@example.com"})
+	if err := store.DeleteParticipant(ctx, alone); err != nil {
+		t.Fatalf("DeleteParticipant: %v", err)
+	}
+	store.CreateAuditEntry(ctx, models.AuditEntry{Actor: u.participantActor("ada@example.com"), Action: "registration.create"})
+	store.CreateAuditEntry(ctx, models.AuditEntry{Actor: "participant:rao@example.com", Action: "participant.update",
+		TargetType: "participant", TargetID: strconv.Itoa(alone), Changes: map[string]models.Change{"collegeName": {Before: "A", After: "B"}}})
+	store.SaveOTP(ctx, models.OTP{EventID: event, EmailHash: u.hashEmail("ada@example.com"), ExpiresAt: time.Now().Add(time.Hour)})
+
+	// Deletions count once the retention period has passed
+	u.Config.Retention.Period = -time.Minute
+
+	// Another instance holding the lock keeps this one out
+	store.AcquireLock(ctx, retentionLock, "other", time.Hour)
+	if err := u.ApplyRetention(ctx); err != nil {
+		t.Fatalf("ApplyRetention while locked: %v", err)
+	}
+	if p, _ := store.GetParticipants(ctx, []int{pid}); len(p) > 0 && p[0].AnonymizedAt != nil {
+		t.Fatal("ApplyRetention ran while another instance held the lock")
+	}
+	store.ReleaseLock(ctx, retentionLock, "other")
+
+	if err := u.ApplyRetention(ctx); err == nil {
+		t.Error("ApplyRetention did not report the screenshot it could not delete")
+	}
+	for _, tt := range []struct {
+		id   models.ID
+		want bool
+	}{{stuck, false}, {done, true}} {
+		regs, _ := store.RegistrationsToAnonymize(ctx, nil, time.Now().Add(time.Hour), models.ID{}, 10)
+		pending := false
+		for _, reg := range regs {
+			pending = pending || reg.ID == tt.id
+		}
+		if pending == tt.want {
+			t.Errorf("registration %s anonymized = %v, want %v", tt.id.Hex(), !pending, tt.want)
+		}
+	}
+	left, _ := store.ParticipantsToAnonymize(ctx, []models.ID{event}, time.Now().Add(time.Hour), 10)
+	if len(left) != 0 {
+		t.Errorf("participants left with personal data: %+v", left)
+	}
+
+	entries, _ := store.ListAuditEntries(ctx, repository.AuditFilter{}, 0)
+	summaries := 0
+	for _, entry := range entries {
+		if entry.Action == "retention.apply" {
+			summaries++
+			if entry.Actor != systemActor || entry.Changes["participants"].After != 3 || entry.Changes["failed"].After != 1 {
+				t.Errorf("retention summary = %+v", entry)
+			}
+			continue
+		}
+		if entry.Actor != anonymizedActor || entry.Changes != nil {
+			t.Errorf("audit entry not redacted: %+v", entry)
+		}
+	}
+	if summaries != 1 {
+		t.Errorf("%d retention summaries recorded, want 1", summaries)
+	}
+	if _, err := store.GetOTP(ctx, event, u.hashEmail("ada@example.com")); err == nil {
+		t.Error("the code of an anonymized participant was kept")
+	}
+}
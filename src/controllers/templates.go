@@ -0,0 +1,435 @@
+package controllers
+
+import (
+	"backend/src/models"
+	"html/template"
+)
+
+// confirmationEmail is the data passed to registration confirmation templates
+type confirmationEmail struct {
+	Event        models.Event
+	Name         string
+	Code         string // the recipient's participant code
+	Participants []string
+	TeamName     string
+	LeaderName   string
+	Receipt      *paymentReceipt // only set for the team leader
+}
+
+// paymentReceipt is the payment summary sent to the team leader
+type paymentReceipt struct {
+	RegistrationID string
+	TransactionID  string
+	Amount         int
+	Participants   int
+}
+
+var confirmationTemplate = template.Must(template.New("confirmation").Parse(defaultConfirmationTemplate))
+
+const defaultConfirmationTemplate = `<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="UTF-8" />
+    <meta http-equiv="X-UA-Compatible" content="IE=edge" />
+    <meta name="viewport" content="width=device-width, initial-scale=1.0" />
+    <link rel="preconnect" href="https://fonts.googleapis.com" />
+    <link rel="preconnect" href="https://fonts.gstatic.com" crossorigin />
+    <link
+      href="https://fonts.googleapis.com/css2?family=Poppins:ital,wght@0,400;0,500;0,600;1,400;1,500&display=swap"
+      rel="stylesheet"
+    />
+    <title>{{.Event.Name}}</title>
+
+    <!-- <title>Responsive GIF Display</title>
+    <style>
+        body {
+            margin: 0;
+            padding: 0;
+            display: flex;
+            justify-content: center;
+            align-items: center;
+            min-height: 100vh;
+            background-color: #f0f0f0;
+            text-align: center;
+        }
+        .gif-container {
+            max-width: 600;
+            overflow: hidden;
+        }
+        img {
+            width: 600;
+            display: block;
+        }
+        
+    </style> -->
+  </head>
+
+  <body style="font-family: 'Poppins', sans-serif">
+    <div>
+      <u></u>
+
+      <div
+        style="
+          text-align: center;
+          margin: 0;
+          padding-top: 10px;
+          padding-bottom: 10px;
+          padding-left: 0;
+          padding-right: 0;
+          background-color: #f2f4f6;
+          color: #000000;
+        "
+        align="center"
+      >
+        <div style="text-align: center">
+          <table
+            align="center"
+            style="
+              text-align: center;
+              vertical-align: middle;
+              width: 600px;
+              max-width: 600px;
+            "
+            width="600"
+          >
+            <tbody>
+              <tr>
+                <td
+                  style="width: 596px; vertical-align: middle"
+                  width="596"
+                ></td>
+              </tr>
+            </tbody>
+          </table>
+          
+          <!-- <div class="gif-container"> -->
+          <img
+            style="text-align: center"
+            alt="{{.Event.Name}} Banner"
+            src="{{.Event.BannerURL}}"
+            width="600"
+            class="CToWUd a6T"
+            data-bit="iit"
+            tabindex="0"
+          />
+
+          <div
+            class="a6S"
+            dir="ltr"
+            style="opacity: 0.01; left: 552px; top: 501.5px"
+          >
+            <div
+              id=":155"
+              class="T-I J-J5-Ji aQv T-I-ax7 L3 a5q"
+              role="button"
+              tabindex="0"
+              aria-label="Download attachment "
+              jslog="91252; u014N:cOuCgd,Kr2w4b,xr6bB; 4:WyIjbXNnLWY6MTc2MjU0MTQxMTA0MjYyMTM2NyIsbnVsbCxbXV0."
+              data-tooltip-class="a1V"
+              data-tooltip="Download"
+            >
+              <div class="akn">
+                <div class="aSK J-J5-Ji aYr"></div>
+              </div>
+            </div>
+          </div>
+
+          <table
+            align="center"
+            style="
+              text-align: center;
+              vertical-align: top;
+              width: 600px;
+              max-width: 600px;
+              background-color: #ffffff;
+            "
+            width="600"
+          >
+            <tbody style="color: #343434">
+              <tr>
+                <td
+                  style="
+                    width: 596px;
+                    vertical-align: top;
+                    padding-left: 30px;
+                    padding-right: 30px;
+                    padding-top: 30px;
+                    padding-bottom: 40px;
+                  "
+                  width="596"
+                >
+                  <h1
+                    style="
+                      font-size: 22px;
+                      line-height: 34px;
+                      font-family: 'Helvetica', Arial, sans-serif;
+                      font-weight: 600;
+                      text-decoration: none;
+                      color: #000000;
+                    "
+                  >
+                    Hola Tech Enthusiasts! 🐧
+                  </h1>
+
+                  <p
+                    style="
+                      line-height: 24px;
+                      font-weight: 400;
+                      text-decoration: none;
+                    "
+                  >
+                    We are pleased to inform you that your registration for
+                    <strong>{{.Event.Name}}</strong> was successful! 🎉<br /><br />
+                    The event will be held on
+                    <strong><em>{{.Event.Dates}}</em></strong
+                    >{{with .Event.Description}}, {{.}}{{end}}.💜
+                  </p>
+                  <p>
+                    {{with .TeamName}}<strong>Team:</strong> {{.}}<br />{{end}}
+                    <strong>Participant Name(s):</strong><br />
+                    {{range .Participants}}{{.}}{{if eq . $.LeaderName}} (Team Leader){{end}}<br />{{end}}
+                  </p>
+                  {{with .Code}}
+                  <p>
+                    <strong>Your participant code:</strong> {{.}}<br />
+                    Please show this code at the registration desk.
+                  </p>
+                  {{end}}
+                  {{with .Receipt}}
+                  <p>
+                    <strong>Payment Receipt</strong><br />
+                    <strong>Registration ID:</strong> {{.RegistrationID}} <br />
+                    <strong>Transaction ID:</strong> {{.TransactionID}} <br />
+                    <strong>Participants:</strong> {{.Participants}} <br />
+                    <strong>Amount:</strong> &#8377;{{.Amount}}
+                  </p>
+                  As the team leader you will receive all further
+                  correspondence about this registration.
+                  <br />
+                  {{else}}{{with .LeaderName}}
+                  <p>
+                    Your team leader <strong>{{.}}</strong> will receive the
+                    payment receipt and further correspondence.
+                  </p>
+                  {{end}}{{end}}
+                  You will have access to all the sessions and activities we
+                  have scheduled for the event as a registered participant.
+                  <br />
+                  <p>
+                    Details of the event are as follows: <br />
+                    <strong>Date:</strong> {{.Event.Dates}} <br />
+                    <strong>Time:</strong> {{.Event.StartTime}} <br />
+                    <strong>Venue:</strong>
+                    {{.Event.Venue}}
+                  </p>
+                  Please do not hesitate to contact us if you have any queries
+                  about the event. We will be happy to assist you in any way we
+                  can.
+                  <p></p>
+                  <p>
+                    {{with .Event.Website}}<strong style="font-size: 17px">
+                      {{$.Event.Name}} Website:</strong
+                    >
+                    <a
+                      href="{{.}}"
+                      style="font-size: 17px"
+                      >{{.}}</a
+                    >
+                    <br />{{end}}
+                    Do share this with your friends and join us for an exciting
+                    journey!
+                  </p>
+
+                  <p>
+                    <strong>
+                      <i>We look forward to seeing you there!</i>
+                    </strong>
+                  </p>
+
+                  <p>
+                    Thanks and regards,<br />
+                    Walchand Linux Users' Group
+                  </p>
+                </td>
+              </tr>
+            </tbody>
+          </table>
+
+        <table
+          align="center"
+          style="
+            text-align: center;
+            vertical-align: top;
+            width: 600px;
+            max-width: 600px;
+            background-color: #ffffff;
+          "
+          width="600"
+        >
+          <tbody>
+            <tr>
+              <td
+                style="
+                  width: 600px;
+                  vertical-align: top;
+                  padding-left: 0;
+                  padding-right: 0;
+                "
+              >
+                <img
+                  style="
+                    text-align: center;
+                    border-top-left-radius: 30px;
+                    border-bottom-right-radius: 30px;
+                    margin-bottom: 5px;
+                  "
+                  alt="Logo"
+                  src="https://res.cloudinary.com/dduur8qoo/image/upload/v1689771850/wlug_white_logo_page-0001_u8efnh.jpg"
+                  align="center"
+                  width="200"
+                  height="120"
+                  class="CToWUd"
+                  data-bit="iit"
+                />
+              </td>
+            </tr>
+
+            <tr style="margin-bottom: 30px" align="center">
+              <td align="center">
+              
+                <a
+                  href="https://linkedin.com/company/wlug-club"
+                  target="_blank"
+                  data-saferedirecturl="https://www.google.com/url?q=https://linkedin.com/company/wlug-club&amp;source=gmail&amp;ust=1680976985984000&amp;usg=AOvVaw0TDo2Akq1O-un9s_gRi70t"
+                  style="margin: 0 10px"
+                  ><img
+                    src="https://res.cloudinary.com/dduur8qoo/image/upload/v1685247353/linkedin_mg2ujv.png"
+                    class="CToWUd"
+                    data-bit="iit"
+                    height="30"
+                    width="30"
+                    style="border-radius: 5px"
+                /></a>
+                <a
+                  href="http://discord.wcewlug.org/join"
+                  target="_blank"
+                  data-saferedirecturl="https://www.google.com/url?q=http://discord.wcewlug.org/join&amp;source=gmail&amp;ust=1680976985984000&amp;usg=AOvVaw3PNiAyDSeiO1V36KVKeLZl"
+                  style="margin: 0 1px"
+                  ><img
+                    src="https://res.cloudinary.com/dduur8qoo/image/upload/v1689771996/unnamed_m7lgs0.png"
+                    class="CToWUd"
+                    data-bit="iit"
+                    height="30"
+                    width="30"
+                    style="border-radius: 5px"
+                /></a>
+                <a
+                  href="https://www.instagram.com/wcewlug/"
+                  target="_blank"
+                  data-saferedirecturl="https://www.google.com/url?q=https://www.instagram.com/wcewlug/&amp;source=gmail&amp;ust=1680976985984000&amp;usg=AOvVaw16ObtJOZ1hpw9644RZ4oMM"
+                  style="margin: 0 12px"
+                  ><img
+                    src="https://res.cloudinary.com/dduur8qoo/image/upload/v1689773467/Instagram_vn7dni_kzulby.png"
+                    class="CToWUd"
+                    data-bit="iit"
+                    height="30"
+                    width="30"
+                /></a>
+                <a
+                  href="https://twitter.com/wcewlug"
+                  target="_blank"
+                  data-saferedirecturl="https://www.google.com/url?q=https://twitter.com/wcewlug&amp;source=gmail&amp;ust=1680976985984000&amp;usg=AOvVaw1ypHRKREADjq_cn0IRD2po"
+                  ><img
+                    src="https://res.cloudinary.com/dfuwno067/image/upload/v1738444243/twitter_wxkrwu.png"
+                    class="CToWUd"
+                    data-bit="iit"
+                    height="30"
+                    width="30"
+                    style="border-radius: 5px"
+                /></a>
+              </td>
+            </tr>
+          </tbody>
+        </table>
+          <div class="yj6qo"></div>
+          <div class="adL"></div>
+        </div>
+        <div class="adL"></div>
+      </div>
+      <div class="adL"></div>
+    </div>
+  </body>
+</html>`
+
+// magicLinkEmail is the data passed to the manage-registration email
+type magicLinkEmail struct {
+	Event     models.Event
+	Link      string
+	ExpiresIn string
+}
+
+var magicLinkTemplate = template.Must(template.New("magicLink").Parse(`<!DOCTYPE html>
+<html lang="en">
+  <body style="font-family: 'Poppins', sans-serif">
+    <p>Hello,</p>
+    <p>
+      Use the link below to view or update your registration for
+      <strong>{{.Event.Name}}</strong>. The link expires in {{.ExpiresIn}}.
+    </p>
+    <p><a href="{{.Link}}">Manage my registration</a></p>
+    <p>If you did not ask for this link you can ignore this email.</p>
+    <p>
+      Thanks and regards,<br />
+      Walchand Linux Users' Group
+    </p>
+  </body>
+</html>`))
+
+// otpEmail is the data passed to the email verification code email
+type otpEmail struct {
+	Event     models.Event
+	Code      string
+	ExpiresIn string
+}
+
+var otpTemplate = template.Must(template.New("otp").Parse(`<!DOCTYPE html>
+<html lang="en">
+  <body style="font-family: 'Poppins', sans-serif">
+    <p>Hello,</p>
+    <p>
+      Your verification code for <strong>{{.Event.Name}}</strong> registration is
+    </p>
+    <p style="font-size: 28px; letter-spacing: 6px"><strong>{{.Code}}</strong></p>
+    <p>The code expires in {{.ExpiresIn}}. Do not share it with anyone.</p>
+    <p>
+      Thanks and regards,<br />
+      Walchand Linux Users' Group
+    </p>
+  </body>
+</html>`))
+
+// privacyLinkEmail is the data passed to the personal data request email
+type privacyLinkEmail struct {
+	Event     models.Event
+	Action    string
+	Link      string
+	ExpiresIn string
+}
+
+var privacyLinkTemplate = template.Must(template.New("privacyLink").Parse(`<!DOCTYPE html>
+<html lang="en">
+  <body style="font-family: 'Poppins', sans-serif">
+    <p>Hello,</p>
+    <p>
+      We received a request to {{.Action}} the personal data stored for this
+      email address. Use the link below to confirm. The link expires in
+      {{.ExpiresIn}}.
+    </p>
+    <p><a href="{{.Link}}">Confirm my request</a></p>
+    <p>If you did not make this request you can ignore this email.</p>
+    <p>
+      Thanks and regards,<br />
+      Walchand Linux Users' Group
+    </p>
+  </body>
+</html>`))
@@ -0,0 +1,192 @@
+package controllers
+
+import (
+	"backend/src/models"
+	"encoding/json"
+	"net/mail"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// ParticipantInput is a participant as submitted in a registration request
+type ParticipantInput struct {
+	Name        string `json:"name"`
+	Email       string `json:"email"`
+	Phone       string `json:"phone"`
+	CollegeName string `json:"collegeName"`
+	YearOfStudy int    `json:"yearOfStudy"`
+	DualBoot    bool   `json:"dualBoot"`
+	Leader      bool   `json:"leader"`
+
+	VerificationToken string `json:"verificationToken"` // from VerifyOTP, when the event verifies emails
+}
+
+const (
+	maxNameLength     = 100
+	maxTeamNameLength = 50
+)
+
+var (
+	e164Pattern      = regexp.MustCompile(`^\+[1-9][0-9]{7,14}$`)
+	phoneStripper    = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "")
+	fieldTypeMessage = map[string]string{
+		"name":        "must be a string",
+		"email":       "must be a string",
+		"phone":       "must be a string",
+		"collegeName": "must be a string",
+		"yearOfStudy": "must be a whole number",
+		"dualBoot":    "must be true or false",
+		"leader":      "must be true or false",
+
+		"verificationToken": "must be a string",
+	}
+)
+
+// decodeParticipants decodes and validates the participants JSON array. Every
+// field is decoded on its own so that all type mismatches are reported, not
+// just the first one.
+func decodeParticipants(data []byte, countryCode string) ([]ParticipantInput, []models.FieldError) {
+	var raw []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, []models.FieldError{{Index: -1, Field: "participants", Message: "must be an array of objects"}}
+	}
+	if len(raw) == 0 {
+		return nil, []models.FieldError{{Index: -1, Field: "participants", Message: "at least one participant is required"}}
+	}
+
+	var errs []models.FieldError
+	inputs := make([]ParticipantInput, len(raw))
+	for i, fields := range raw {
+		input := &inputs[i]
+		targets := map[string]interface{}{
+			"name":        &input.Name,
+			"email":       &input.Email,
+			"phone":       &input.Phone,
+			"collegeName": &input.CollegeName,
+			"yearOfStudy": &input.YearOfStudy,
+			"dualBoot":    &input.DualBoot,
+			"leader":      &input.Leader,
+
+			"verificationToken": &input.VerificationToken,
+		}
+		typeErrors := map[string]bool{}
+		for field, target := range targets {
+			value, ok := fields[field]
+			if !ok || string(value) == "null" {
+				continue
+			}
+			if err := json.Unmarshal(value, target); err != nil {
+				typeErrors[field] = true
+				errs = append(errs, models.FieldError{Index: i, Field: field, Message: fieldTypeMessage[field]})
+			}
+		}
+		for _, fieldErr := range input.normalize(i, countryCode) {
+			if !typeErrors[fieldErr.Field] {
+				errs = append(errs, fieldErr)
+			}
+		}
+	}
+	sortFieldErrors(errs)
+	return inputs, errs
+}
+
+// normalize trims and canonicalizes the input in place and returns the
+// validation errors for participant index i.
+func (p *ParticipantInput) normalize(i int, countryCode string) []models.FieldError {
+	var errs []models.FieldError
+	check := func(field, msg string) {
+		if msg != "" {
+			errs = append(errs, models.FieldError{Index: i, Field: field, Message: msg})
+		}
+	}
+	var msg string
+	p.Name, msg = validateName(p.Name)
+	check("name", msg)
+	p.Email, msg = validateEmail(p.Email)
+	check("email", msg)
+	p.Phone, msg = validatePhone(p.Phone, countryCode)
+	check("phone", msg)
+	p.CollegeName, msg = validateCollege(p.CollegeName)
+	check("collegeName", msg)
+	check("yearOfStudy", validateYear(p.YearOfStudy))
+	return errs
+}
+
+func validateName(name string) (string, string) {
+	name = strings.Join(strings.Fields(name), " ")
+	switch {
+	case name == "":
+		return name, "is required"
+	case utf8.RuneCountInString(name) > maxNameLength:
+		return name, "is too long"
+	}
+	return name, ""
+}
+
+func validateEmail(email string) (string, string) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return email, "is required"
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || !strings.Contains(addr.Address[strings.LastIndex(addr.Address, "@"):], ".") {
+		return email, "is not a valid email address"
+	}
+	return strings.ToLower(addr.Address), ""
+}
+
+// validatePhone normalizes a phone number to E.164. Numbers without a
+// country code get the configured default country code.
+func validatePhone(phone, countryCode string) (string, string) {
+	phone = phoneStripper.Replace(strings.TrimSpace(phone))
+	if phone == "" {
+		return phone, "is required"
+	}
+	switch {
+	case strings.HasPrefix(phone, "+"):
+	case strings.HasPrefix(phone, "00"):
+		phone = "+" + phone[2:]
+	default:
+		phone = "+" + strings.TrimPrefix(countryCode, "+") + strings.TrimPrefix(phone, "0")
+	}
+	if !e164Pattern.MatchString(phone) {
+		return phone, "is not a valid phone number"
+	}
+	return phone, ""
+}
+
+func validateTeamName(name string) (string, string) {
+	name = strings.Join(strings.Fields(name), " ")
+	if utf8.RuneCountInString(name) > maxTeamNameLength {
+		return name, "is too long"
+	}
+	return name, ""
+}
+
+func validateCollege(college string) (string, string) {
+	college = strings.Join(strings.Fields(college), " ")
+	if college == "" {
+		return college, "is required"
+	}
+	return college, ""
+}
+
+func validateYear(year int) string {
+	if year < 1 || year > 5 {
+		return "must be between 1 and 5"
+	}
+	return ""
+}
+
+// sortFieldErrors orders errors by participant, then field, so responses are
+// stable regardless of map iteration order.
+func sortFieldErrors(errs []models.FieldError) {
+	slices.SortFunc(errs, func(a, b models.FieldError) int {
+		if a.Index != b.Index {
+			return a.Index - b.Index
+		}
+		return strings.Compare(a.Field, b.Field)
+	})
+}
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"backend/src/models"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateName(t *testing.T) {
+	for _, tt := range []struct {
+		in, want, msg string
+	}{
+		{"Ada Lovelace", "Ada Lovelace", ""},
+		{"  Ada \t  Lovelace\n", "Ada Lovelace", ""},
+		{"", "", "is required"},
+		{"   ", "", "is required"},
+		{strings.Repeat("a", maxNameLength), strings.Repeat("a", maxNameLength), ""},
+		{strings.Repeat("a", maxNameLength+1), strings.Repeat("a", maxNameLength+1), "is too long"},
+		// Length counts characters, not bytes
+		{strings.Repeat("é", maxNameLength), strings.Repeat("é", maxNameLength), ""},
+	} {
+		got, msg := validateName(tt.in)
+		if got != tt.want || msg != tt.msg {
+			t.Errorf("validateName(%q) = %q, %q, want %q, %q", tt.in, got, msg, tt.want, tt.msg)
+		}
+	}
+}
+
+func TestValidateEmail(t *testing.T) {
+	for _, tt := range []struct {
+		in, want, msg string
+	}{
+		{"ada@example.com", "ada@example.com", ""},
+		{" Ada@Example.COM ", "ada@example.com", ""},
+		{"", "", "is required"},
+		{"ada", "ada", "is not a valid email address"},
+		{"ada@localhost", "ada@localhost", "is not a valid email address"},
+		{"Ada <ada@example.com>", "Ada <ada@example.com>", "is not a valid email address"},
+	} {
+		got, msg := validateEmail(tt.in)
+		if got != tt.want || msg != tt.msg {
+			t.Errorf("validateEmail(%q) = %q, %q, want %q, %q", tt.in, got, msg, tt.want, tt.msg)
+		}
+	}
+}
+
+func TestValidatePhone(t *testing.T) {
+	for _, tt := range []struct {
+		in, countryCode, want, msg string
+	}{
+		{"9876543210", "91", "+919876543210", ""},
+		{"09876543210", "91", "+919876543210", ""},
+		{"98765 43210", "+91", "+919876543210", ""},
+		{"(987) 654-3210", "1", "+19876543210", ""},
+		{"+44 20 7946 0958", "91", "+442079460958", ""},
+		{"0044 20 7946 0958", "91", "+442079460958", ""},
+		{"", "91", "", "is required"},
+		{"12345", "91", "+9112345", "is not a valid phone number"},
+		{"98765abc10", "91", "+9198765abc10", "is not a valid phone number"},
+		{"+0123456789", "91", "+0123456789", "is not a valid phone number"},
+		{"+1234567890123456", "91", "+1234567890123456", "is not a valid phone number"},
+	} {
+		got, msg := validatePhone(tt.in, tt.countryCode)
+		if got != tt.want || msg != tt.msg {
+			t.Errorf("validatePhone(%q, %q) = %q, %q, want %q, %q", tt.in, tt.countryCode, got, msg, tt.want, tt.msg)
+		}
+	}
+}
+
+func TestValidateCollege(t *testing.T) {
+	for _, tt := range []struct {
+		in, want, msg string
+	}{
+		{"IIT Madras", "IIT Madras", ""},
+		{"  IIT   Madras ", "IIT Madras", ""},
+		{"", "", "is required"},
+		{" \t ", "", "is required"},
+	} {
+		got, msg := validateCollege(tt.in)
+		if got != tt.want || msg != tt.msg {
+			t.Errorf("validateCollege(%q) = %q, %q, want %q, %q", tt.in, got, msg, tt.want, tt.msg)
+		}
+	}
+}
+
+func TestValidateYear(t *testing.T) {
+	for _, tt := range []struct {
+		year int
+		msg  string
+	}{
+		{1, ""},
+		{5, ""},
+		{0, "must be between 1 and 5"},
+		{6, "must be between 1 and 5"},
+		{-1, "must be between 1 and 5"},
+	} {
+		if msg := validateYear(tt.year); msg != tt.msg {
+			t.Errorf("validateYear(%d) = %q, want %q", tt.year, msg, tt.msg)
+		}
+	}
+}
+
+func TestDecodeParticipants(t *testing.T) {
+	valid := `{"name": "Ada", "email": "ada@example.com", "phone": "9876543210", "collegeName": "IIT", "yearOfStudy": 2}`
+	fields := func(errs []models.FieldError) []string {
+		var out []string
+		for _, err := range errs {
+			out = append(out, err.Field)
+		}
+		return out
+	}
+	for _, tt := range []struct {
+		name string
+		data string
+		want []string
+	}{
+		{"valid", "[" + valid + "]", nil},
+		{"not an array", `{"name": "Ada"}`, []string{"participants"}},
+		{"empty", `[]`, []string{"participants"}},
+		{"missing fields", `[{}]`, []string{"collegeName", "email", "name", "phone", "yearOfStudy"}},
+		// A wrong type is reported once, not also as missing
+		{"wrong types", `[{"name": 1, "email": "ada@example.com", "phone": "9876543210", "collegeName": "IIT", "yearOfStudy": "2"}]`,
+			[]string{"name", "yearOfStudy"}},
+	} {
+		_, errs := decodeParticipants([]byte(tt.data), "91")
+		if got := fields(errs); !slices.Equal(got, tt.want) {
+			t.Errorf("decodeParticipants(%s) errors on %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
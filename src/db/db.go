@@ -1,92 +1,1189 @@
 package db
 
 import (
+	"backend/src/codes"
+	"backend/src/config"
 	"backend/src/models"
+	"backend/src/repository"
+	"backend/src/tracing"
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
-	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel"
 )
 
+var tracer = otel.Tracer("backend/src/db")
+
 type DbAdapter struct {
-	Db *mongo.Database
+	Db           *mongo.Database
+	pids         *pidLease // nil reserves PIDs per request
+	transactions bool      // replica sets and sharded clusters only
 }
 
-func NewDbAdapter(ctx context.Context) (*DbAdapter, error) {
-	uri := os.Getenv("BACKEND_MONGO_URI")
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+var _ repository.Store = (*DbAdapter)(nil)
+
+func NewDbAdapter(ctx context.Context, cfg config.Mongo) (*DbAdapter, error) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.URI.Value()).SetMonitor(commandMonitor()))
 	if err != nil {
-		slog.Error("Error connecting to mongo", err)
-		return nil, err
+		slog.Error("Error connecting to mongo", "error", err)
+		return nil, dbErr(err)
 	}
 	if err := client.Ping(ctx, nil); err != nil {
-		slog.Error("Error pinging mongo", err)
-		return nil, err
+		slog.Error("Error pinging mongo", "error", err)
+		return nil, dbErr(err)
+	}
+	adapter := &DbAdapter{Db: client.Database(cfg.Database)}
+	if cfg.PIDLease > 0 {
+		adapter.pids = &pidLease{size: cfg.PIDLease}
+	}
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := adapter.Db.RunCommand(ctx, bson.M{"hello": 1}).Decode(&hello); err != nil {
+		slog.Warn("Could not detect mongo topology, writing without transactions", "error", err)
+	}
+	adapter.transactions = hello.SetName != "" || hello.Msg == "isdbgrid"
+	return adapter, nil
+}
+
+// WithTransaction runs fn in a transaction when the deployment supports
+// them, and directly on a standalone server. fn may be retried, so it
+// should only write to Mongo through the context it is given.
+func (d DbAdapter) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	// Nested calls join the transaction already running
+	if !d.transactions || mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+	session, err := d.Db.Client().StartSession()
+	if err != nil {
+		return dbErr(err)
 	}
-	db := client.Database("metamorphosis")
-	return &DbAdapter{Db: db}, nil
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(ctx)
+	})
+	return dbErr(err)
 }
 
 func (d DbAdapter) Close(ctx context.Context) error {
 	return d.Db.Client().Disconnect(ctx)
 }
 
+func (d DbAdapter) Ping(ctx context.Context) (err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.Ping")
+	defer func() { tracing.End(span, err) }()
+	return d.Db.Client().Ping(ctx, nil)
+}
+
 // Participant Operations
-func (d DbAdapter) GetNextPID(ctx context.Context) (int, error) {
+func (d DbAdapter) GetNextPID(ctx context.Context) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.GetNextPID")
+	defer func() { tracing.End(span, err) }()
+	pids, err := d.allocatePIDs(ctx, 1)
+	if err != nil {
+		return 0, dbErr(err)
+	}
+	return pids[0], nil
+}
+
+// ReservePIDs allocates n consecutive PIDs with a single $inc and returns the first
+func (d DbAdapter) ReservePIDs(ctx context.Context, n int) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.ReservePIDs")
+	defer func() { tracing.End(span, err) }()
 	var counter models.Counter
 	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
-	err := d.Db.Collection("counters").FindOneAndUpdate(
+	err = d.Db.Collection("counters").FindOneAndUpdate(
 		ctx,
 		bson.M{"name": "participant_pid"},
-		bson.M{"$inc": bson.M{"seq": 1}},
+		bson.M{"$inc": bson.M{"seq": n}},
 		opts,
 	).Decode(&counter)
 	if err != nil {
-		return 0, err
+		return 0, dbErr(err)
 	}
-	return counter.Seq, nil
+	return counter.Seq - n + 1, nil
 }
 
-func (d DbAdapter) CreateParticipant(ctx context.Context, participant models.Participant) (int, error) {
-	pid, err := d.GetNextPID(ctx)
+// allocatePIDs takes n PIDs from the in-process lease when one is
+// configured, or reserves exactly n otherwise
+// allocatePIDs reserves PIDs outside any transaction in ctx. A rolled back
+// reservation would hand the PIDs the lease already holds to the next
+// caller, and every registration would contend for the counter document.
+// PIDs of an aborted registration are skipped.
+func (d DbAdapter) allocatePIDs(ctx context.Context, n int) ([]int, error) {
+	ctx = mongo.NewSessionContext(ctx, nil)
+	if d.pids != nil {
+		return d.pids.take(ctx, n, d.ReservePIDs)
+	}
+	first, err := d.ReservePIDs(ctx, n)
 	if err != nil {
-		return 0, err
+		return nil, dbErr(err)
+	}
+	pids := make([]int, n)
+	for i := range pids {
+		pids[i] = first + i
 	}
-	participant.PID = pid
-	participant.CreatedAt = time.Now()
-	participant.UpdatedAt = time.Now()
-	_, err = d.Db.Collection("participants").InsertOne(ctx, participant)
-	return pid, err
+	return pids, nil
 }
 
-func (d DbAdapter) GetParticipant(ctx context.Context, pid int) (models.Participant, error) {
+func (d DbAdapter) CreateParticipant(ctx context.Context, participant models.Participant) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.CreateParticipant")
+	defer func() { tracing.End(span, err) }()
+	pids, err := d.CreateParticipants(ctx, []models.Participant{participant})
+	if err != nil {
+		return 0, dbErr(err)
+	}
+	return pids[0], nil
+}
+
+// CreateParticipants allocates PIDs for the whole group at once and inserts
+// the participants in one round trip
+func (d DbAdapter) CreateParticipants(ctx context.Context, participants []models.Participant) (_ []int, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.CreateParticipants")
+	defer func() { tracing.End(span, err) }()
+	if len(participants) == 0 {
+		return []int{}, nil
+	}
+	pids, err := d.allocatePIDs(ctx, len(participants))
+	if err != nil {
+		return nil, dbErr(err)
+	}
+	pending := make([]int, len(participants))
+	for i := range participants {
+		participants[i].PID = pids[i]
+		participants[i].CreatedAt = time.Now()
+		participants[i].UpdatedAt = time.Now()
+		pending[i] = i
+	}
+	// A failed write aborts a transaction, so inside one the taken codes
+	// are rerolled before the only insert. A code taken concurrently then
+	// fails the transaction.
+	if mongo.SessionFromContext(ctx) != nil {
+		if err := d.rerollTakenCodes(ctx, participants); err != nil {
+			return nil, dbErr(err)
+		}
+		docs := make([]interface{}, len(participants))
+		for i := range participants {
+			docs[i] = participants[i]
+		}
+		_, err = d.Db.Collection("participants").InsertMany(ctx, docs)
+		return pids, dbErr(err)
+	}
+	// Codes are random, so the rare one already taken in the event is
+	// rerolled and only the participants that failed are inserted again
+	for attempt := 1; ; attempt++ {
+		docs := make([]interface{}, len(pending))
+		for i, index := range pending {
+			docs[i] = participants[index]
+		}
+		_, err = d.Db.Collection("participants").InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+		var bulkErr mongo.BulkWriteException
+		if err == nil || attempt == maxCodeAttempts || !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+			return pids, dbErr(err)
+		}
+		var retry []int
+		for _, writeErr := range bulkErr.WriteErrors {
+			if !mongo.IsDuplicateKeyError(writeErr) || !strings.Contains(writeErr.Message, participantCodeIndex) {
+				return pids, dbErr(err)
+			}
+			index := pending[writeErr.Index]
+			participants[index].Code = codes.Reroll(participants[index].Code)
+			retry = append(retry, index)
+		}
+		pending = retry
+	}
+}
+
+// maxCodeAttempts bounds the retries when participant codes collide
+const maxCodeAttempts = 5
+
+// rerollTakenCodes gives a new code to the participants whose code is taken
+// in their event or by another participant of the group
+func (d DbAdapter) rerollTakenCodes(ctx context.Context, participants []models.Participant) error {
+	seen := map[string]bool{}
+	for i := range participants {
+		p := &participants[i]
+		if p.Code == "" {
+			continue
+		}
+		for {
+			key := p.EventID.Hex() + "/" + p.Code
+			taken, err := d.Db.Collection("participants").CountDocuments(ctx, bson.M{"eventId": p.EventID, "code": p.Code},
+				options.Count().SetLimit(1))
+			if err != nil {
+				return err
+			}
+			if taken == 0 && !seen[key] {
+				seen[key] = true
+				break
+			}
+			p.Code = codes.Reroll(p.Code)
+		}
+	}
+	return nil
+}
+
+func (d DbAdapter) GetParticipantByCode(ctx context.Context, eventID models.ID, code string) (_ models.Participant, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.GetParticipantByCode")
+	defer func() { tracing.End(span, err) }()
 	var participant models.Participant
-	err := d.Db.Collection("participants").FindOne(ctx, bson.M{"pid": pid}).Decode(&participant)
-	return participant, err
+	err = d.Db.Collection("participants").FindOne(ctx, bson.M{"eventId": eventID, "code": code, "deletedAt": nil}).Decode(&participant)
+	return participant, dbErr(err)
+}
+
+func (d DbAdapter) GetParticipant(ctx context.Context, pid int) (_ models.Participant, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.GetParticipant")
+	defer func() { tracing.End(span, err) }()
+	var participant models.Participant
+	err = d.Db.Collection("participants").FindOne(ctx, bson.M{"pid": pid, "deletedAt": nil}).Decode(&participant)
+	return participant, dbErr(err)
+}
+
+func (d DbAdapter) GetParticipantsByEmail(ctx context.Context, eventID models.ID, email string) (_ []models.Participant, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.GetParticipantsByEmail")
+	defer func() { tracing.End(span, err) }()
+	participants := []models.Participant{}
+	filter := bson.M{"eventId": eventID, "email": email, "deletedAt": nil}
+	opts := options.Find().SetCollation(emailCollation).SetSort(bson.M{"pid": 1})
+	cursor, err := d.Db.Collection("participants").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, dbErr(err)
+	}
+	err = cursor.All(ctx, &participants)
+	return participants, dbErr(err)
+}
+
+func (d DbAdapter) GetParticipantsByEmailIndex(ctx context.Context, eventID models.ID, index string) (_ []models.Participant, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.GetParticipantsByEmailIndex")
+	defer func() { tracing.End(span, err) }()
+	participants := []models.Participant{}
+	filter := bson.M{"eventId": eventID, "emailIndex": index, "deletedAt": nil}
+	cursor, err := d.Db.Collection("participants").Find(ctx, filter, options.Find().SetSort(bson.M{"pid": 1}))
+	if err != nil {
+		return nil, dbErr(err)
+	}
+	err = cursor.All(ctx, &participants)
+	return participants, dbErr(err)
+}
+
+func (d DbAdapter) FindParticipantsByEmail(ctx context.Context, email string) (_ []models.Participant, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.FindParticipantsByEmail")
+	defer func() { tracing.End(span, err) }()
+	participants := []models.Participant{}
+	opts := options.Find().SetCollation(emailCollation).SetSort(bson.M{"pid": 1})
+	cursor, err := d.Db.Collection("participants").Find(ctx, bson.M{"email": email}, opts)
+	if err != nil {
+		return nil, dbErr(err)
+	}
+	err = cursor.All(ctx, &participants)
+	return participants, dbErr(err)
+}
+
+func (d DbAdapter) FindParticipantsByEmailIndex(ctx context.Context, index string) (_ []models.Participant, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.FindParticipantsByEmailIndex")
+	defer func() { tracing.End(span, err) }()
+	participants := []models.Participant{}
+	cursor, err := d.Db.Collection("participants").Find(ctx, bson.M{"emailIndex": index}, options.Find().SetSort(bson.M{"pid": 1}))
+	if err != nil {
+		return nil, dbErr(err)
+	}
+	err = cursor.All(ctx, &participants)
+	return participants, dbErr(err)
+}
+
+func (d DbAdapter) ScanParticipants(ctx context.Context, afterPID, limit int) (_ []models.Participant, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.ScanParticipants")
+	defer func() { tracing.End(span, err) }()
+	participants := []models.Participant{}
+	opts := options.Find().SetSort(bson.M{"pid": 1}).SetLimit(int64(limit))
+	cursor, err := d.Db.Collection("participants").Find(ctx, bson.M{"pid": bson.M{"$gt": afterPID}}, opts)
+	if err != nil {
+		return nil, dbErr(err)
+	}
+	err = cursor.All(ctx, &participants)
+	return participants, dbErr(err)
+}
+
+func (d DbAdapter) ResealParticipant(ctx context.Context, p models.Participant) (err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.ResealParticipant")
+	defer func() { tracing.End(span, err) }()
+	return d.updateOne(ctx, "participants", bson.M{"pid": p.PID}, bson.M{"$set": bson.M{
+		"email":      p.Email,
+		"phone":      p.Phone,
+		"emailIndex": p.EmailIndex,
+	}})
+}
+
+func (d DbAdapter) SetParticipantCode(ctx context.Context, pid int, code string) (err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.SetParticipantCode")
+	defer func() { tracing.End(span, err) }()
+	return d.updateOne(ctx, "participants", bson.M{"pid": pid, "code": bson.M{"$in": bson.A{nil, ""}}}, bson.M{"$set": bson.M{"code": code}})
+}
+
+func (d DbAdapter) GetParticipants(ctx context.Context, pids []int) (_ []models.Participant, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.GetParticipants")
+	defer func() { tracing.End(span, err) }()
+	participants := []models.Participant{}
+	opts := options.Find().SetSort(bson.M{"pid": 1})
+	cursor, err := d.Db.Collection("participants").Find(ctx, bson.M{"pid": bson.M{"$in": pids}, "deletedAt": nil}, opts)
+	if err != nil {
+		return nil, dbErr(err)
+	}
+	err = cursor.All(ctx, &participants)
+	return participants, dbErr(err)
+}
+
+func (d DbAdapter) UpdateParticipant(ctx context.Context, pid int, update repository.ParticipantUpdate) (err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.UpdateParticipant")
+	defer func() { tracing.End(span, err) }()
+	set := bson.M{"updatedAt": time.Now()}
+	setIf(set, "name", update.Name)
+	setIf(set, "email", update.Email)
+	setIf(set, "phone", update.Phone)
+	setIf(set, "emailIndex", update.EmailIndex)
+	setIf(set, "collegeName", update.CollegeName)
+	setIf(set, "yearOfStudy", update.YearOfStudy)
+	setIf(set, "dualBoot", update.DualBoot)
+	return d.updateOne(ctx, "participants", bson.M{"pid": pid, "deletedAt": nil}, bson.M{"$set": set})
+}
+
+// setIf adds a field of a typed update to a $set document unless it is nil
+func setIf[T any](set bson.M, key string, value *T) {
+	if value != nil {
+		set[key] = *value
+	}
+}
+
+func (d DbAdapter) CountParticipants(ctx context.Context, eventID models.ID) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.CountParticipants")
+	defer func() { tracing.End(span, err) }()
+	filter := bson.M{"eventId": eventID, "cancelled": bson.M{"$ne": true}, "deletedAt": nil}
+	count, err := d.Db.Collection("participants").CountDocuments(ctx, filter)
+	return int(count), dbErr(err)
+}
+
+func (d DbAdapter) DeleteParticipant(ctx context.Context, pid int) (err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.DeleteParticipant")
+	defer func() { tracing.End(span, err) }()
+	now := time.Now()
+	return d.updateOne(ctx, "participants",
+		bson.M{"pid": pid, "deletedAt": nil},
+		bson.M{"$set": bson.M{"deletedAt": now, "updatedAt": now}},
+	)
+}
+
+func (d DbAdapter) RestoreParticipant(ctx context.Context, pid int) (err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.RestoreParticipant")
+	defer func() { tracing.End(span, err) }()
+	return d.updateOne(ctx, "participants",
+		bson.M{"pid": pid, "deletedAt": bson.M{"$ne": nil}},
+		bson.M{"$unset": bson.M{"deletedAt": ""}, "$set": bson.M{"updatedAt": time.Now()}},
+	)
+}
+
+// updateOne returns repository.ErrNotFound when the filter matches nothing
+func (d DbAdapter) updateOne(ctx context.Context, collection string, filter bson.M, update interface{}) error {
+	result, err := d.Db.Collection(collection).UpdateOne(ctx, filter, update)
+	if err == nil && result.MatchedCount == 0 {
+		return repository.ErrNotFound
+	}
+	return dbErr(err)
+}
+
+func (d DbAdapter) AnonymizeParticipants(ctx context.Context, pids []int) (err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.AnonymizeParticipants")
+	defer func() { tracing.End(span, err) }()
+	return d.anonymizeParticipants(ctx, pids, time.Now())
+}
+
+func (d DbAdapter) anonymizeParticipants(ctx context.Context, pids []int, now time.Time) error {
+	_, err := d.Db.Collection("participants").UpdateMany(ctx,
+		bson.M{"pid": bson.M{"$in": append([]int{}, pids...)}},
+		bson.M{
+			"$set":   bson.M{"name": "", "email": "", "phone": "", "anonymizedAt": now, "updatedAt": now},
+			"$unset": bson.M{"emailIndex": ""},
+		},
+	)
+	return dbErr(err)
+}
+
+func (d DbAdapter) ParticipantsToAnonymize(ctx context.Context, eventIDs []models.ID, deletedBefore time.Time, limit int) (_ []models.Participant, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.ParticipantsToAnonymize")
+	defer func() { tracing.End(span, err) }()
+	participants := []models.Participant{}
+	opts := options.Find().SetSort(bson.M{"pid": 1}).SetLimit(int64(limit))
+	cursor, err := d.Db.Collection("participants").Find(ctx, expiredFilter(eventIDs, deletedBefore), opts)
+	if err != nil {
+		return nil, dbErr(err)
+	}
+	err = cursor.All(ctx, &participants)
+	return participants, dbErr(err)
+}
+
+func (d DbAdapter) ParticipantStats(ctx context.Context, eventID models.ID) (_ []models.ParticipantStat, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.ParticipantStats")
+	defer func() { tracing.End(span, err) }()
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"eventId": eventID, "deletedAt": nil}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"collegeName": "$collegeName", "yearOfStudy": "$yearOfStudy"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$project", Value: bson.M{"_id": 0, "collegeName": "$_id.collegeName", "yearOfStudy": "$_id.yearOfStudy", "count": 1}}},
+		{{Key: "$sort", Value: bson.D{{Key: "collegeName", Value: 1}, {Key: "yearOfStudy", Value: 1}}}},
+	}
+	cursor, err := d.Db.Collection("participants").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, dbErr(err)
+	}
+	stats := []models.ParticipantStat{}
+	err = cursor.All(ctx, &stats)
+	return stats, dbErr(err)
 }
 
 // Registration Operations
-func (d DbAdapter) CreateRegistration(ctx context.Context, reg models.Registration) (string, error) {
+
+// registrationDocument adds teamNameHeld, a copy of the team name key kept
+// only while the registration holds the name: active and not deleted. A
+// partial index cannot match a missing deletedAt, so the unique index on
+// team names covers this field instead.
+type registrationDocument struct {
+	models.Registration `bson:",inline"`
+	TeamNameHeld        string `bson:"teamNameHeld,omitempty"`
+}
+
+// holdsTeamName is the teamNameHeld of a registration, for update pipelines
+var holdsTeamName = bson.M{"$cond": bson.A{
+	bson.M{"$and": bson.A{bson.M{"$ne": bson.A{"$status", models.RegistrationCancelled}}, bson.M{"$gt": bson.A{"$teamNameKey", ""}}}},
+	"$teamNameKey",
+	"$$REMOVE",
+}}
+
+func (d DbAdapter) CreateRegistration(ctx context.Context, reg models.Registration) (_ models.ID, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.CreateRegistration")
+	defer func() { tracing.End(span, err) }()
 	reg.CreatedAt = time.Now()
 	reg.UpdatedAt = time.Now()
-	result, err := d.Db.Collection("registrations").InsertOne(ctx, reg)
+	reg.ID = models.NewID()
+	doc := registrationDocument{Registration: reg}
+	if reg.Status != models.RegistrationCancelled && reg.DeletedAt == nil {
+		doc.TeamNameHeld = reg.TeamNameKey
+	}
+	_, err = d.Db.Collection("registrations").InsertOne(ctx, doc)
+	if err != nil {
+		return models.ID{}, dbErr(err)
+	}
+	return reg.ID, nil
+}
+
+func (d DbAdapter) GetRegistration(ctx context.Context, id models.ID) (_ models.Registration, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.GetRegistration")
+	defer func() { tracing.End(span, err) }()
+	var reg models.Registration
+	err = d.Db.Collection("registrations").FindOne(ctx, bson.M{"_id": id, "deletedAt": nil}).Decode(&reg)
+	return reg, dbErr(err)
+}
+
+func (d DbAdapter) GetRegistrationsByParticipants(ctx context.Context, pids []int) (_ []models.Registration, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.GetRegistrationsByParticipants")
+	defer func() { tracing.End(span, err) }()
+	registrations := []models.Registration{}
+	opts := options.Find().SetSort(bson.M{"createdAt": 1})
+	cursor, err := d.Db.Collection("registrations").Find(ctx, bson.M{"participants": bson.M{"$in": pids}, "deletedAt": nil}, opts)
+	if err != nil {
+		return nil, dbErr(err)
+	}
+	err = cursor.All(ctx, &registrations)
+	return registrations, dbErr(err)
+}
+
+// FindRegistrationsByParticipants matches active and cancelled members, in
+// deleted registrations too
+func (d DbAdapter) FindRegistrationsByParticipants(ctx context.Context, pids []int) (_ []models.Registration, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.FindRegistrationsByParticipants")
+	defer func() { tracing.End(span, err) }()
+	registrations := []models.Registration{}
+	if len(pids) == 0 {
+		return registrations, nil
+	}
+	filter := bson.M{"$or": bson.A{bson.M{"participants": bson.M{"$in": pids}}, bson.M{"cancelledParticipants": bson.M{"$in": pids}}}}
+	cursor, err := d.Db.Collection("registrations").Find(ctx, filter, options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		return nil, dbErr(err)
+	}
+	err = cursor.All(ctx, &registrations)
+	return registrations, dbErr(err)
+}
+
+// TeamNameTaken reports whether an active registration of the event uses the team name
+func (d DbAdapter) TeamNameTaken(ctx context.Context, eventID models.ID, teamNameKey string) (_ bool, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.TeamNameTaken")
+	defer func() { tracing.End(span, err) }()
+	filter := bson.M{"eventId": eventID, "teamNameHeld": teamNameKey}
+	count, err := d.Db.Collection("registrations").CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	return count > 0, dbErr(err)
+}
+
+// CancelParticipants moves the given participants of a registration to its
+// cancelled list, cancelling the registration once nobody is left.
+func (d DbAdapter) CancelParticipants(ctx context.Context, reg models.Registration, pids []int) (_ models.Registration, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.CancelParticipants")
+	defer func() { tracing.End(span, err) }()
+	// The status follows the stored list, not the caller's copy
+	remaining := bson.M{"$filter": bson.M{
+		"input": "$participants",
+		"cond":  bson.M{"$not": bson.A{bson.M{"$in": bson.A{"$$this", pids}}}},
+	}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"participants":          remaining,
+			"cancelledParticipants": bson.M{"$concatArrays": bson.A{bson.M{"$ifNull": bson.A{"$cancelledParticipants", bson.A{}}}, pids}},
+			"numOfParticipants":     bson.M{"$subtract": bson.A{"$numOfParticipants", len(pids)}},
+			"updatedAt":             time.Now(),
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"status": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{bson.M{"$size": "$participants"}, 0}}, models.RegistrationCancelled, "$status"}},
+		}}},
+		{{Key: "$set", Value: bson.M{"teamNameHeld": holdsTeamName}}},
+	}
+	// Only match while every participant is still active so concurrent
+	// cancellations cannot cancel anyone twice
+	filter := bson.M{"_id": reg.ID, "participants": bson.M{"$all": pids}, "deletedAt": nil}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var updated models.Registration
+	err = d.Db.Collection("registrations").FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if err != nil {
+		return updated, dbErr(err)
+	}
+	_, err = d.Db.Collection("participants").UpdateMany(ctx,
+		bson.M{"pid": bson.M{"$in": pids}},
+		bson.M{"$set": bson.M{"cancelled": true, "updatedAt": time.Now()}},
+	)
+	return updated, dbErr(err)
+}
+
+func (d DbAdapter) DeleteRegistration(ctx context.Context, id models.ID) (err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.DeleteRegistration")
+	defer func() { tracing.End(span, err) }()
+	// Mongo keeps milliseconds, so truncate for the participants to match on restore
+	now := time.Now().Truncate(time.Millisecond)
+	var reg models.Registration
+	err = d.Db.Collection("registrations").FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "deletedAt": nil},
+		bson.M{"$set": bson.M{"deletedAt": now, "updatedAt": now}, "$unset": bson.M{"teamNameHeld": ""}},
+	).Decode(&reg)
 	if err != nil {
-		return "", err
+		return dbErr(err)
 	}
-	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+	_, err = d.Db.Collection("participants").UpdateMany(ctx,
+		bson.M{"pid": bson.M{"$in": allPIDs(reg)}, "deletedAt": nil},
+		bson.M{"$set": bson.M{"deletedAt": now, "updatedAt": now}},
+	)
+	return dbErr(err)
 }
 
-func (d DbAdapter) GetRegistration(ctx context.Context, id string) (models.Registration, error) {
+// RestoreRegistration restores the participants first so that a failure
+// halfway can be retried
+func (d DbAdapter) RestoreRegistration(ctx context.Context, id models.ID) (err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.RestoreRegistration")
+	defer func() { tracing.End(span, err) }()
 	var reg models.Registration
-	objID, err := primitive.ObjectIDFromHex(id)
+	err = d.Db.Collection("registrations").FindOne(ctx, bson.M{"_id": id, "deletedAt": bson.M{"$ne": nil}}).Decode(&reg)
 	if err != nil {
-		return reg, err
+		return dbErr(err)
 	}
-	err = d.Db.Collection("registrations").FindOne(ctx, bson.M{"_id": objID}).Decode(&reg)
-	return reg, err
+	// Check the team name before restoring anyone, the index settles races
+	if reg.Status != models.RegistrationCancelled && reg.TeamNameKey != "" {
+		taken, err := d.TeamNameTaken(ctx, reg.EventID, reg.TeamNameKey)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return fmt.Errorf("%w: team name is taken", repository.ErrDuplicate)
+		}
+	}
+	restore := bson.M{"$unset": bson.M{"deletedAt": ""}, "$set": bson.M{"updatedAt": time.Now()}}
+	_, err = d.Db.Collection("participants").UpdateMany(ctx,
+		bson.M{"pid": bson.M{"$in": allPIDs(reg)}, "deletedAt": *reg.DeletedAt},
+		restore,
+	)
+	if err != nil {
+		return dbErr(err)
+	}
+	return d.updateOne(ctx, "registrations", bson.M{"_id": id, "deletedAt": *reg.DeletedAt}, mongo.Pipeline{
+		{{Key: "$unset", Value: "deletedAt"}},
+		{{Key: "$set", Value: bson.M{"updatedAt": time.Now(), "teamNameHeld": holdsTeamName}}},
+	})
+}
+
+func (d DbAdapter) RegistrationsToAnonymize(ctx context.Context, eventIDs []models.ID, deletedBefore time.Time, afterID models.ID, limit int) (_ []models.Registration, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.RegistrationsToAnonymize")
+	defer func() { tracing.End(span, err) }()
+	filter := expiredFilter(eventIDs, deletedBefore)
+	filter["_id"] = bson.M{"$gt": afterID}
+	registrations := []models.Registration{}
+	opts := options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(limit))
+	cursor, err := d.Db.Collection("registrations").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, dbErr(err)
+	}
+	err = cursor.All(ctx, &registrations)
+	return registrations, dbErr(err)
+}
+
+// expiredFilter matches documents that still hold personal data and either
+// belong to one of eventIDs or were deleted before deletedBefore
+func expiredFilter(eventIDs []models.ID, deletedBefore time.Time) bson.M {
+	if eventIDs == nil {
+		eventIDs = []models.ID{}
+	}
+	return bson.M{
+		"anonymizedAt": nil,
+		"$or": bson.A{
+			bson.M{"eventId": bson.M{"$in": eventIDs}},
+			bson.M{"deletedAt": bson.M{"$lt": deletedBefore}},
+		},
+	}
+}
+
+// AnonymizeRegistration clears the participants first so that a failure
+// halfway leaves the registration to be picked up again
+func (d DbAdapter) AnonymizeRegistration(ctx context.Context, id models.ID) (err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.AnonymizeRegistration")
+	defer func() { tracing.End(span, err) }()
+	var reg models.Registration
+	if err := d.Db.Collection("registrations").FindOne(ctx, bson.M{"_id": id}).Decode(&reg); err != nil {
+		return dbErr(err)
+	}
+	now := time.Now()
+	if err := d.anonymizeParticipants(ctx, allPIDs(reg), now); err != nil {
+		return dbErr(err)
+	}
+	return d.updateOne(ctx, "registrations", bson.M{"_id": id}, bson.M{
+		"$set":   bson.M{"anonymizedAt": now, "updatedAt": now},
+		"$unset": bson.M{"teamName": "", "teamNameKey": "", "teamNameHeld": "", "transactionId": "", "transactionImage": ""},
+	})
+}
+
+// allPIDs lists the active and cancelled participants of a registration
+func allPIDs(reg models.Registration) []int {
+	return append(append([]int{}, reg.Participants...), reg.Cancelled...)
+}
+
+// dbErr translates driver errors into the ones the repository promises
+func dbErr(err error) error {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return repository.ErrNotFound
+	case mongo.IsDuplicateKeyError(err):
+		return fmt.Errorf("%w: %w", repository.ErrDuplicate, err)
+	}
+	return err
+}
+
+// Upload Operations
+func (d DbAdapter) CreateUpload(ctx context.Context, upload models.Upload) (_ models.ID, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.CreateUpload")
+	defer func() { tracing.End(span, err) }()
+	upload.CreatedAt = time.Now()
+	upload.ID = models.NewID()
+	_, err = d.Db.Collection("uploads").InsertOne(ctx, upload)
+	if err != nil {
+		return models.ID{}, dbErr(err)
+	}
+	return upload.ID, nil
+}
+
+// ConsumeUpload marks an upload as used so it can only back one registration
+func (d DbAdapter) ConsumeUpload(ctx context.Context, id, eventID models.ID) (_ models.Upload, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.ConsumeUpload")
+	defer func() { tracing.End(span, err) }()
+	return d.setUploadConsumed(ctx, id, eventID, true)
+}
+
+// ReleaseUpload makes a consumed upload available again after a failed registration
+func (d DbAdapter) ReleaseUpload(ctx context.Context, id, eventID models.ID) (err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.ReleaseUpload")
+	defer func() { tracing.End(span, err) }()
+	_, err = d.setUploadConsumed(ctx, id, eventID, false)
+	return dbErr(err)
+}
+
+// setUploadConsumed runs in the span of its caller
+func (d DbAdapter) setUploadConsumed(ctx context.Context, id, eventID models.ID, consumed bool) (models.Upload, error) {
+	var upload models.Upload
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := d.Db.Collection("uploads").FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "eventId": eventID, "consumed": !consumed},
+		bson.M{"$set": bson.M{"consumed": consumed}},
+		opts,
+	).Decode(&upload)
+	return upload, dbErr(err)
+}
+
+// ExpiredUploads lists the uploads of the given events, and unused uploads
+// of any event created before createdBefore
+func (d DbAdapter) ExpiredUploads(ctx context.Context, eventIDs []models.ID, createdBefore time.Time) (_ []models.Upload, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.ExpiredUploads")
+	defer func() { tracing.End(span, err) }()
+	if eventIDs == nil {
+		eventIDs = []models.ID{}
+	}
+	filter := bson.M{"$or": bson.A{
+		bson.M{"eventId": bson.M{"$in": eventIDs}},
+		bson.M{"consumed": false, "createdAt": bson.M{"$lt": createdBefore}},
+	}}
+	uploads := []models.Upload{}
+	cursor, err := d.Db.Collection("uploads").Find(ctx, filter)
+	if err != nil {
+		return nil, dbErr(err)
+	}
+	err = cursor.All(ctx, &uploads)
+	return uploads, dbErr(err)
+}
+
+func (d DbAdapter) DeleteUpload(ctx context.Context, id models.ID) (err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.DeleteUpload")
+	defer func() { tracing.End(span, err) }()
+	_, err = d.Db.Collection("uploads").DeleteOne(ctx, bson.M{"_id": id})
+	return dbErr(err)
+}
+
+// Event Operations
+func (d DbAdapter) CreateEvent(ctx context.Context, event models.Event) (_ models.ID, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.CreateEvent")
+	defer func() { tracing.End(span, err) }()
+	event.CreatedAt = time.Now()
+	event.UpdatedAt = time.Now()
+	event.ID = models.NewID()
+	_, err = d.Db.Collection("events").InsertOne(ctx, event)
+	if err != nil {
+		return models.ID{}, dbErr(err)
+	}
+	return event.ID, nil
+}
+
+func (d DbAdapter) GetEventBySlug(ctx context.Context, slug string) (_ models.Event, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.GetEventBySlug")
+	defer func() { tracing.End(span, err) }()
+	var event models.Event
+	err = d.Db.Collection("events").FindOne(ctx, bson.M{"slug": slug}).Decode(&event)
+	return event, dbErr(err)
+}
+
+func (d DbAdapter) ListEvents(ctx context.Context) (_ []models.Event, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.ListEvents")
+	defer func() { tracing.End(span, err) }()
+	events := []models.Event{}
+	opts := options.Find().SetSort(bson.M{"startsAt": -1})
+	cursor, err := d.Db.Collection("events").Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, dbErr(err)
+	}
+	err = cursor.All(ctx, &events)
+	return events, dbErr(err)
+}
+
+func (d DbAdapter) UpdateEvent(ctx context.Context, event models.Event) (err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.UpdateEvent")
+	defer func() { tracing.End(span, err) }()
+	event.UpdatedAt = time.Now()
+	result, err := d.Db.Collection("events").ReplaceOne(ctx, bson.M{"_id": event.ID}, event)
+	if err == nil && result.MatchedCount == 0 {
+		return repository.ErrNotFound
+	}
+	return dbErr(err)
+}
+
+func (d DbAdapter) SetEventPaused(ctx context.Context, slug string, paused bool, resumesAt *time.Time) (_ models.Event, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.SetEventPaused")
+	defer func() { tracing.End(span, err) }()
+	var event models.Event
+	update := bson.M{"$set": bson.M{"paused": paused, "resumesAt": resumesAt, "updatedAt": time.Now()}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = d.Db.Collection("events").FindOneAndUpdate(ctx, bson.M{"slug": slug}, update, opts).Decode(&event)
+	return event, dbErr(err)
+}
+
+// AdoptOrphans claims the documents written before registrations carried an
+// eventId. Each update is idempotent, so a partial run is finished by the next.
+func (d DbAdapter) AdoptOrphans(ctx context.Context, eventID models.ID) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.AdoptOrphans")
+	defer func() { tracing.End(span, err) }()
+	orphan := bson.M{"eventId": bson.M{"$in": bson.A{nil, models.ID{}}}}
+	update := bson.M{"$set": bson.M{"eventId": eventID}}
+	if _, err := d.Db.Collection("participants").UpdateMany(ctx, orphan, update); err != nil {
+		return 0, err
+	}
+	result, err := d.Db.Collection("registrations").UpdateMany(ctx, orphan, update)
+	if err != nil {
+		return 0, err
+	}
+	return int(result.ModifiedCount), nil
+}
+
+// Refund Operations
+func (d DbAdapter) CreateRefund(ctx context.Context, refund models.Refund) (_ models.ID, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.CreateRefund")
+	defer func() { tracing.End(span, err) }()
+	refund.CreatedAt = time.Now()
+	refund.UpdatedAt = time.Now()
+	refund.ID = models.NewID()
+	_, err = d.Db.Collection("refunds").InsertOne(ctx, refund)
+	if err != nil {
+		return models.ID{}, dbErr(err)
+	}
+	return refund.ID, nil
+}
+
+func (d DbAdapter) ListRefunds(ctx context.Context, f repository.RefundFilter) (_ []models.Refund, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.ListRefunds")
+	defer func() { tracing.End(span, err) }()
+	filter := bson.M{}
+	if !f.EventID.IsZero() {
+		filter["eventId"] = f.EventID
+	}
+	if f.Status != "" {
+		filter["status"] = f.Status
+	}
+	if f.RegistrationIDs != nil {
+		filter["registrationId"] = bson.M{"$in": f.RegistrationIDs}
+	}
+	if f.RequestedBy != "" {
+		filter["requestedBy"] = f.RequestedBy
+	}
+	refunds := []models.Refund{}
+	opts := options.Find().SetSort(bson.M{"createdAt": 1})
+	cursor, err := d.Db.Collection("refunds").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, dbErr(err)
+	}
+	err = cursor.All(ctx, &refunds)
+	return refunds, dbErr(err)
+}
+
+func (d DbAdapter) UpdateRefundStatus(ctx context.Context, id models.ID, from, status, reviewedBy, reference string) (_ models.Refund, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.UpdateRefundStatus")
+	defer func() { tracing.End(span, err) }()
+	var refund models.Refund
+	set := bson.M{"status": status, "reviewedBy": reviewedBy, "updatedAt": time.Now()}
+	if reference != "" {
+		set["reference"] = reference
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = d.Db.Collection("refunds").FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "status": from},
+		bson.M{"$set": set},
+		opts,
+	).Decode(&refund)
+	return refund, dbErr(err)
+}
+
+// OTP Operations
+func (d DbAdapter) GetOTP(ctx context.Context, eventID models.ID, emailHash string) (_ models.OTP, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.GetOTP")
+	defer func() { tracing.End(span, err) }()
+	var otp models.OTP
+	err = d.Db.Collection("otps").FindOne(ctx, bson.M{"eventId": eventID, "emailHash": emailHash}).Decode(&otp)
+	return otp, dbErr(err)
+}
+
+// SaveOTP replaces any pending code for the address
+func (d DbAdapter) SaveOTP(ctx context.Context, otp models.OTP) (err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.SaveOTP")
+	defer func() { tracing.End(span, err) }()
+	otp.CreatedAt = time.Now()
+	opts := options.Replace().SetUpsert(true)
+	_, err = d.Db.Collection("otps").ReplaceOne(ctx, bson.M{"eventId": otp.EventID, "emailHash": otp.EmailHash}, otp, opts)
+	return dbErr(err)
+}
+
+// ClaimOTPAttempt counts a verification attempt against the pending code,
+// returning mongo.ErrNoDocuments once maxAttempts have been used. Counting
+// before comparing keeps parallel guesses within the limit.
+func (d DbAdapter) ClaimOTPAttempt(ctx context.Context, eventID models.ID, emailHash string, maxAttempts int) (_ models.OTP, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.ClaimOTPAttempt")
+	defer func() { tracing.End(span, err) }()
+	var otp models.OTP
+	filter := bson.M{"eventId": eventID, "emailHash": emailHash, "attempts": bson.M{"$lt": maxAttempts}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = d.Db.Collection("otps").FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{"attempts": 1}}, opts).Decode(&otp)
+	return otp, dbErr(err)
+}
+
+func (d DbAdapter) DeleteOTP(ctx context.Context, id models.ID) (err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.DeleteOTP")
+	defer func() { tracing.End(span, err) }()
+	_, err = d.Db.Collection("otps").DeleteOne(ctx, bson.M{"_id": id})
+	return dbErr(err)
+}
+
+// DeleteOTPsByEmailHash removes the pending codes of an address in every event
+func (d DbAdapter) DeleteOTPsByEmailHash(ctx context.Context, emailHash string) (err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.DeleteOTPsByEmailHash")
+	defer func() { tracing.End(span, err) }()
+	_, err = d.Db.Collection("otps").DeleteMany(ctx, bson.M{"emailHash": emailHash})
+	return dbErr(err)
+}
+
+// DeleteExpiredOTPs removes what the TTL index has not got to yet
+func (d DbAdapter) DeleteExpiredOTPs(ctx context.Context, now time.Time) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.DeleteExpiredOTPs")
+	defer func() { tracing.End(span, err) }()
+	result, err := d.Db.Collection("otps").DeleteMany(ctx, bson.M{"expiresAt": bson.M{"$lt": now}})
+	if err != nil {
+		return 0, dbErr(err)
+	}
+	return int(result.DeletedCount), nil
+}
+
+// Rate Limit Operations
+
+// TakeRateLimitToken refills and takes a token from the bucket for key in a
+// single atomic update, so every instance shares the same buckets
+func (d DbAdapter) TakeRateLimitToken(ctx context.Context, key string, burst, perSecond float64) (_ bool, _ float64, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.TakeRateLimitToken")
+	defer func() { tracing.End(span, err) }()
+	var result struct {
+		Tokens  float64 `bson:"tokens"`
+		Allowed bool    `bson:"allowed"`
+	}
+	elapsed := bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{"$$NOW", bson.M{"$ifNull": bson.A{"$updatedAt", "$$NOW"}}}}, 1000}}
+	refilled := bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$tokens", burst}}, bson.M{"$multiply": bson.A{elapsed, perSecond}}}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"tokens": bson.M{"$min": bson.A{burst, refilled}}, "updatedAt": "$$NOW"}}},
+		{{Key: "$set", Value: bson.M{"allowed": bson.M{"$gte": bson.A{"$tokens", 1}}}}},
+		{{Key: "$set", Value: bson.M{"tokens": bson.M{"$cond": bson.A{"$allowed", bson.M{"$subtract": bson.A{"$tokens", 1}}, "$tokens"}}}}},
+		// Expires the bucket once it has refilled, when it behaves like a missing one
+		{{Key: "$set", Value: bson.M{"fullAt": bson.M{"$add": bson.A{"$$NOW",
+			bson.M{"$multiply": bson.A{bson.M{"$subtract": bson.A{burst, "$tokens"}}, 1000 / perSecond}}}}}}},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err = d.Db.Collection("rate_limits").FindOneAndUpdate(ctx, bson.M{"_id": key}, pipeline, opts).Decode(&result)
+	return result.Allowed, result.Tokens, dbErr(err)
+}
+
+// Admin User Operations
+func (d DbAdapter) CreateAdminUser(ctx context.Context, user models.AdminUser) (_ models.ID, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.CreateAdminUser")
+	defer func() { tracing.End(span, err) }()
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+	user.ID = models.NewID()
+	_, err = d.Db.Collection("admins").InsertOne(ctx, user)
+	if err != nil {
+		return models.ID{}, dbErr(err)
+	}
+	return user.ID, nil
+}
+
+func (d DbAdapter) GetAdminUser(ctx context.Context, username string) (_ models.AdminUser, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.GetAdminUser")
+	defer func() { tracing.End(span, err) }()
+	var user models.AdminUser
+	err = d.Db.Collection("admins").FindOne(ctx, bson.M{"username": username}).Decode(&user)
+	return user, dbErr(err)
+}
+
+func (d DbAdapter) ListAdminUsers(ctx context.Context) (_ []models.AdminUser, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.ListAdminUsers")
+	defer func() { tracing.End(span, err) }()
+	users := []models.AdminUser{}
+	cursor, err := d.Db.Collection("admins").Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"username": 1}))
+	if err != nil {
+		return nil, dbErr(err)
+	}
+	err = cursor.All(ctx, &users)
+	return users, dbErr(err)
+}
+
+func (d DbAdapter) UpdateAdminUser(ctx context.Context, username string, u repository.AdminUserUpdate, endSessions bool) (_ models.AdminUser, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.UpdateAdminUser")
+	defer func() { tracing.End(span, err) }()
+	var user models.AdminUser
+	set := bson.M{"updatedAt": time.Now()}
+	setIf(set, "passwordHash", u.PasswordHash)
+	setIf(set, "role", u.Role)
+	setIf(set, "disabled", u.Disabled)
+	setIf(set, "lastLoginAt", u.LastLoginAt)
+	update := bson.M{"$set": set}
+	if endSessions {
+		update["$inc"] = bson.M{"sessionVersion": 1}
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = d.Db.Collection("admins").FindOneAndUpdate(ctx, bson.M{"username": username}, update, opts).Decode(&user)
+	return user, dbErr(err)
+}
+
+// Audit Operations
+func (d DbAdapter) CreateAuditEntry(ctx context.Context, entry models.AuditEntry) (err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.CreateAuditEntry")
+	defer func() { tracing.End(span, err) }()
+	entry.CreatedAt = time.Now()
+	_, err = d.Db.Collection("audit").InsertOne(ctx, entry)
+	return dbErr(err)
+}
+
+func (d DbAdapter) ListAuditEntries(ctx context.Context, filter repository.AuditFilter, limit int) (_ []models.AuditEntry, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.ListAuditEntries")
+	defer func() { tracing.End(span, err) }()
+	entries := []models.AuditEntry{}
+	opts := options.Find().SetSort(bson.M{"_id": -1}).SetLimit(int64(limit))
+	cursor, err := d.Db.Collection("audit").Find(ctx, auditQuery(filter), opts)
+	if err != nil {
+		return nil, dbErr(err)
+	}
+	err = cursor.All(ctx, &entries)
+	return entries, dbErr(err)
+}
+
+// auditQuery translates an audit filter into a query
+func auditQuery(f repository.AuditFilter) bson.M {
+	query := bson.M{}
+	for field, value := range map[string]string{"actor": f.Actor, "action": f.Action, "targetType": f.TargetType} {
+		if value != "" {
+			query[field] = value
+		}
+	}
+	if f.TargetIDs != nil {
+		query["targetId"] = bson.M{"$in": f.TargetIDs}
+	}
+	if !f.EventID.IsZero() {
+		query["eventId"] = f.EventID
+	}
+	createdAt := bson.M{}
+	if !f.Since.IsZero() {
+		createdAt["$gte"] = f.Since
+	}
+	if !f.Until.IsZero() {
+		createdAt["$lt"] = f.Until
+	}
+	if len(createdAt) > 0 {
+		query["createdAt"] = createdAt
+	}
+	if !f.Before.IsZero() {
+		query["_id"] = bson.M{"$lt": f.Before}
+	}
+	return query
+}
+
+func (d DbAdapter) EachAuditEntry(ctx context.Context, filter repository.AuditFilter, fn func(models.AuditEntry) error) (err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.EachAuditEntry")
+	defer func() { tracing.End(span, err) }()
+	cursor, err := d.Db.Collection("audit").Find(ctx, auditQuery(filter), options.Find().SetSort(bson.M{"_id": -1}))
+	if err != nil {
+		return dbErr(err)
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var entry models.AuditEntry
+		if err := cursor.Decode(&entry); err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return dbErr(cursor.Err())
+}
+
+func (d DbAdapter) RedactActor(ctx context.Context, actor, replacement string, pids []int) (err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.RedactActor")
+	defer func() { tracing.End(span, err) }()
+	_, err = d.Db.Collection("refunds").UpdateMany(ctx,
+		bson.M{"requestedBy": actor},
+		bson.M{"$set": bson.M{"requestedBy": replacement}},
+	)
+	if err != nil {
+		return dbErr(err)
+	}
+	_, err = d.Db.Collection("audit").UpdateMany(ctx, bson.M{"actor": actor}, bson.M{"$set": bson.M{"actor": replacement}})
+	if err != nil {
+		return dbErr(err)
+	}
+	targets := make([]string, len(pids))
+	for i, pid := range pids {
+		targets[i] = strconv.Itoa(pid)
+	}
+	_, err = d.Db.Collection("audit").UpdateMany(ctx,
+		bson.M{"targetType": "participant", "targetId": bson.M{"$in": targets}},
+		bson.M{"$unset": bson.M{"changes": ""}},
+	)
+	return dbErr(err)
+}
+
+func (d DbAdapter) PlaintextActors(ctx context.Context, limit int) (_ []string, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.PlaintextActors")
+	defer func() { tracing.End(span, err) }()
+	plaintext := primitive.Regex{Pattern: "^participant:.*@"}
+	actors := []string{}
+	for _, source := range []struct{ collection, field string }{{"audit", "actor"}, {"refunds", "requestedBy"}} {
+		values, err := d.Db.Collection(source.collection).Distinct(ctx, source.field, bson.M{source.field: plaintext})
+		if err != nil {
+			return nil, dbErr(err)
+		}
+		for _, value := range values {
+			if actor, ok := value.(string); ok && len(actors) < limit && !slices.Contains(actors, actor) {
+				actors = append(actors, actor)
+			}
+		}
+	}
+	return actors, nil
+}
+
+// Lock Operations
+
+// AcquireLock takes a free or expired lock, or extends the lease of its
+// owner. The upsert collides with the live lock of another owner.
+func (d DbAdapter) AcquireLock(ctx context.Context, name, owner string, ttl time.Duration) (_ bool, err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.AcquireLock")
+	defer func() { tracing.End(span, err) }()
+	now := time.Now()
+	_, err = d.Db.Collection("locks").UpdateOne(ctx,
+		bson.M{"_id": name, "$or": bson.A{bson.M{"owner": owner}, bson.M{"expiresAt": bson.M{"$lt": now}}}},
+		bson.M{"$set": bson.M{"owner": owner, "expiresAt": now.Add(ttl)}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, dbErr(err)
+}
+
+// LockInTransaction writes a document of the locks collection in the
+// transaction, so that a concurrent one writing it fails with a write
+// conflict and is retried. The document is created outside the transaction
+// first, as concurrent inserts would fail for good.
+func (d DbAdapter) LockInTransaction(ctx context.Context, name string) (err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.LockInTransaction")
+	defer func() { tracing.End(span, err) }()
+	if !d.transactions || mongo.SessionFromContext(ctx) == nil {
+		return nil
+	}
+	locks := d.Db.Collection("locks")
+	_, err = locks.UpdateOne(mongo.NewSessionContext(ctx, nil), bson.M{"_id": "tx:" + name},
+		bson.M{"$setOnInsert": bson.M{"writes": 0}}, options.Update().SetUpsert(true))
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return dbErr(err)
+	}
+	_, err = locks.UpdateOne(ctx, bson.M{"_id": "tx:" + name}, bson.M{"$inc": bson.M{"writes": 1}})
+	return dbErr(err)
+}
+
+func (d DbAdapter) ReleaseLock(ctx context.Context, name, owner string) (err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.ReleaseLock")
+	defer func() { tracing.End(span, err) }()
+	_, err = d.Db.Collection("locks").DeleteOne(ctx, bson.M{"_id": name, "owner": owner})
+	return dbErr(err)
 }
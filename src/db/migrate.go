@@ -0,0 +1,338 @@
+package db
+
+import (
+	"backend/src/models"
+	"backend/src/tracing"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migration is one versioned change to the collections. Up must be safe to
+// run again in case an instance dies between applying and recording it.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, db *mongo.Database) error
+}
+
+// emailCollation makes email lookups case-insensitive while still using the
+// index, which an /i regex cannot
+var emailCollation = &options.Collation{Locale: "en", Strength: 2}
+
+var migrations = []Migration{
+	{1, "create participant indexes", func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection("participants").Indexes().CreateMany(ctx, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "pid", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "eventId", Value: 1}, {Key: "email", Value: 1}}, Options: options.Index().SetCollation(emailCollation)},
+			{Keys: bson.D{{Key: "createdAt", Value: 1}}},
+		})
+		return err
+	}},
+	{2, "create registration indexes", func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection("registrations").Indexes().CreateMany(ctx, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "transactionId", Value: 1}}},
+			{Keys: bson.D{{Key: "participants", Value: 1}}},
+			{Keys: bson.D{{Key: "eventId", Value: 1}, {Key: "teamNameKey", Value: 1}}},
+			{Keys: bson.D{{Key: "createdAt", Value: 1}}},
+		})
+		return err
+	}},
+	{3, "create lookup indexes", func(ctx context.Context, db *mongo.Database) error {
+		unique := options.Index().SetUnique(true)
+		indexes := map[string]mongo.IndexModel{
+			"counters": {Keys: bson.D{{Key: "name", Value: 1}}, Options: unique},
+			"events":   {Keys: bson.D{{Key: "slug", Value: 1}}, Options: unique},
+			"otps":     {Keys: bson.D{{Key: "eventId", Value: 1}, {Key: "email", Value: 1}}},
+			"refunds":  {Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: 1}}},
+		}
+		for collection, index := range indexes {
+			if _, err := db.Collection(collection).Indexes().CreateOne(ctx, index); err != nil {
+				return fmt.Errorf("%s: %w", collection, err)
+			}
+		}
+		return nil
+	}},
+	{4, "backfill registration status", func(ctx context.Context, db *mongo.Database) error {
+		registrations := db.Collection("registrations")
+		_, err := registrations.UpdateMany(ctx,
+			bson.M{"status": bson.M{"$exists": false}, "participants.0": bson.M{"$exists": true}},
+			bson.M{"$set": bson.M{"status": models.RegistrationActive}},
+		)
+		if err != nil {
+			return err
+		}
+		_, err = registrations.UpdateMany(ctx,
+			bson.M{"status": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"status": models.RegistrationCancelled}},
+		)
+		return err
+	}},
+	{5, "create participant code index", func(ctx context.Context, db *mongo.Database) error {
+		// Participants from before codes existed have none
+		_, err := db.Collection("participants").Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: "eventId", Value: 1}, {Key: "code", Value: 1}},
+			Options: options.Index().SetName(participantCodeIndex).SetUnique(true).
+				SetPartialFilterExpression(bson.M{"code": bson.M{"$type": "string"}}),
+		})
+		return err
+	}},
+	{6, "create participant email blind index", func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection("participants").Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: "eventId", Value: 1}, {Key: "emailIndex", Value: 1}},
+		})
+		return err
+	}},
+	{7, "create audit indexes", func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection("audit").Indexes().CreateMany(ctx, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "targetType", Value: 1}, {Key: "targetId", Value: 1}, {Key: "_id", Value: -1}}},
+			{Keys: bson.D{{Key: "eventId", Value: 1}, {Key: "_id", Value: -1}}},
+			{Keys: bson.D{{Key: "actor", Value: 1}, {Key: "_id", Value: -1}}},
+		})
+		return err
+	}},
+	{8, "create admin username index", func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection("admins").Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "username", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		})
+		return err
+	}},
+	{9, "make team names unique", func(ctx context.Context, db *mongo.Database) error {
+		registrations := db.Collection("registrations")
+		_, err := registrations.UpdateMany(ctx,
+			bson.M{"status": bson.M{"$ne": models.RegistrationCancelled}, "deletedAt": nil, "teamNameKey": bson.M{"$gt": ""}},
+			mongo.Pipeline{{{Key: "$set", Value: bson.M{"teamNameHeld": "$teamNameKey"}}}},
+		)
+		if err != nil {
+			return err
+		}
+		// Fails on data that already breaks the rule, which has to be fixed by hand
+		_, err = registrations.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: "eventId", Value: 1}, {Key: "teamNameHeld", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"teamNameHeld": bson.M{"$type": "string"}}),
+		})
+		if err != nil {
+			return err
+		}
+		return dropIndex(ctx, registrations, "eventId_1_teamNameKey_1")
+	}},
+	{10, "expire short-lived records", func(ctx context.Context, db *mongo.Database) error {
+		indexes := map[string]mongo.IndexModel{
+			// Expired codes can no longer be verified
+			"otps": {Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+			// A bucket idle for a day has refilled at any sensible rate
+			"rate_limits": {Keys: bson.D{{Key: "updatedAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(24 * 60 * 60)},
+			// The retention job deletes unused uploads with their files; this
+			// only bounds the collection where retention is off or longer
+			"uploads": {Keys: bson.D{{Key: "createdAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(90 * 24 * 60 * 60).
+				SetPartialFilterExpression(bson.M{"consumed": false})},
+		}
+		for collection, index := range indexes {
+			if _, err := db.Collection(collection).Indexes().CreateOne(ctx, index); err != nil {
+				return fmt.Errorf("%s: %w", collection, err)
+			}
+		}
+		return nil
+	}},
+	{11, "expire rate limits once refilled", func(ctx context.Context, db *mongo.Database) error {
+		// Buckets know when they have refilled under their own rate; those
+		// written before keep the day-long expiry
+		limits := db.Collection("rate_limits")
+		_, err := limits.UpdateMany(ctx, bson.M{"fullAt": nil},
+			mongo.Pipeline{{{Key: "$set", Value: bson.M{"fullAt": bson.M{"$add": bson.A{"$updatedAt", 24 * 60 * 60 * 1000}}}}}})
+		if err != nil {
+			return err
+		}
+		_, err = limits.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: "fullAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0),
+		})
+		if err != nil {
+			return err
+		}
+		return dropIndex(ctx, limits, "updatedAt_1")
+	}},
+	{12, "keep no plaintext lookup keys", func(ctx context.Context, db *mongo.Database) error {
+		// Phones are never looked up, so they need no blind index
+		_, err := db.Collection("participants").UpdateMany(ctx, bson.M{"phoneIndex": bson.M{"$exists": true}},
+			bson.M{"$unset": bson.M{"phoneIndex": ""}})
+		if err != nil {
+			return err
+		}
+		// Pending codes are kept under a keyed hash of the address. Codes
+		// last minutes, so the pending ones are dropped.
+		otps := db.Collection("otps")
+		if _, err := otps.DeleteMany(ctx, bson.M{}); err != nil {
+			return err
+		}
+		if err := dropIndex(ctx, otps, "eventId_1_email_1"); err != nil {
+			return err
+		}
+		_, err = otps.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "eventId", Value: 1}, {Key: "emailHash", Value: 1}}})
+		return err
+	}},
+	{13, "redact personal fields in audit changes", func(ctx context.Context, db *mongo.Database) error {
+		// Participant edits only record that the name or phone changed
+		redacted := bson.M{"before": "[redacted]", "after": "[redacted]"}
+		for _, field := range []string{"name", "phone"} {
+			_, err := db.Collection("audit").UpdateMany(ctx,
+				bson.M{"action": "participant.update", "changes." + field: bson.M{"$exists": true}},
+				bson.M{"$set": bson.M{"changes." + field: redacted}},
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+}
+
+// dropIndex drops the named index, doing nothing when it is already gone
+func dropIndex(ctx context.Context, collection *mongo.Collection, name string) error {
+	_, err := collection.Indexes().DropOne(ctx, name)
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && (cmdErr.Name == "IndexNotFound" || cmdErr.Name == "NamespaceNotFound") {
+		return nil
+	}
+	return err
+}
+
+const participantCodeIndex = "eventId_code"
+
+const (
+	migrationLockTTL  = 5 * time.Minute
+	migrationLockPoll = time.Second
+)
+
+// Migrate applies the migrations that are not recorded in the migrations
+// collection yet, in order. A lock document keeps instances that start
+// together from running them concurrently. Its lease is renewed while the
+// migrations run, so a lock left by a crashed instance expires after a few
+// minutes but a slow migration keeps it.
+func (d DbAdapter) Migrate(ctx context.Context) (err error) {
+	ctx, span := tracer.Start(ctx, "DbAdapter.Migrate")
+	defer func() { tracing.End(span, err) }()
+	owner, err := d.lockMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, err := d.Db.Collection("migration_lock").DeleteOne(context.WithoutCancel(ctx), bson.M{"_id": "lock", "owner": owner})
+		if err != nil {
+			slog.ErrorContext(ctx, "Error releasing migration lock", "error", err)
+		}
+	}()
+	ctx, release := d.renewMigrationLock(ctx, owner)
+	defer release()
+
+	applied := map[int]bool{}
+	cursor, err := d.Db.Collection("migrations").Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	var records []struct {
+		Version int `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &records); err != nil {
+		return err
+	}
+	for _, record := range records {
+		applied[record.Version] = true
+	}
+
+	for _, migration := range migrations {
+		if applied[migration.Version] {
+			continue
+		}
+		slog.InfoContext(ctx, "Applying migration", "version", migration.Version, "name", migration.Name)
+		if err := migration.Up(ctx, d.Db); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+		_, err := d.Db.Collection("migrations").InsertOne(ctx, bson.M{
+			"_id":       migration.Version,
+			"name":      migration.Name,
+			"appliedAt": time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockMigrations waits until this instance holds the migration lock and
+// returns its owner ID
+func (d DbAdapter) lockMigrations(ctx context.Context) (string, error) {
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%s/%d/%s", host, os.Getpid(), primitive.NewObjectID().Hex())
+	locks := d.Db.Collection("migration_lock")
+	for {
+		now := time.Now()
+		_, err := locks.UpdateOne(ctx,
+			bson.M{"_id": "lock", "expiresAt": bson.M{"$lt": now}},
+			bson.M{"$set": bson.M{"owner": owner, "expiresAt": now.Add(migrationLockTTL)}},
+			options.Update().SetUpsert(true),
+		)
+		if err == nil {
+			return owner, nil
+		}
+		// The upsert collides with the live lock of another instance
+		if !mongo.IsDuplicateKeyError(err) {
+			return "", err
+		}
+		slog.InfoContext(ctx, "Waiting for another instance to finish migrations")
+		select {
+		case <-time.After(migrationLockPoll):
+		case <-ctx.Done():
+			return "", errors.Join(errors.New("waiting for the migration lock"), ctx.Err())
+		}
+	}
+}
+
+// renewMigrationLock extends the lease of the lock held by owner until the
+// returned function is called. The returned context is cancelled when the
+// lock is lost, so the migrations stop rather than race another instance.
+func (d DbAdapter) renewMigrationLock(ctx context.Context, owner string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(migrationLockTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			result, err := d.Db.Collection("migration_lock").UpdateOne(ctx,
+				bson.M{"_id": "lock", "owner": owner},
+				bson.M{"$set": bson.M{"expiresAt": time.Now().Add(migrationLockTTL)}},
+			)
+			if err != nil {
+				// The lease still has time left for the next attempt
+				slog.WarnContext(ctx, "Error renewing migration lock", "error", err)
+				continue
+			}
+			if result.MatchedCount == 0 {
+				slog.ErrorContext(ctx, "Lost the migration lock")
+				cancel()
+				return
+			}
+		}
+	}()
+	return ctx, func() {
+		close(done)
+		cancel()
+	}
+}
@@ -0,0 +1,36 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
+)
+
+// commandMonitor traces every Mongo command and logs it at debug level. The
+// context is the one passed to the DbAdapter method, so spans nest under the
+// method's span and log entries carry the request ID.
+func commandMonitor() *event.CommandMonitor {
+	tracing := otelmongo.NewMonitor()
+	return &event.CommandMonitor{
+		Started: tracing.Started,
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			tracing.Succeeded(ctx, e)
+			slog.DebugContext(ctx, "Mongo command",
+				"command", e.CommandName,
+				"database", e.DatabaseName,
+				"duration_ms", e.Duration.Milliseconds(),
+			)
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			tracing.Failed(ctx, e)
+			slog.WarnContext(ctx, "Mongo command failed",
+				"command", e.CommandName,
+				"database", e.DatabaseName,
+				"duration_ms", e.Duration.Milliseconds(),
+				"error", e.Failure,
+			)
+		},
+	}
+}
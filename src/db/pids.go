@@ -0,0 +1,35 @@
+package db
+
+import (
+	"context"
+	"sync"
+)
+
+// pidLease hands out PIDs from a block reserved in advance, so a
+// registration rush costs one counter update per block instead of one per
+// group. PIDs stay unique across instances because every block is reserved
+// atomically. Whatever is left of a block when the process exits is skipped.
+type pidLease struct {
+	mu        sync.Mutex
+	size      int
+	next, end int // the unused part of the block is [next, end)
+}
+
+func (l *pidLease) take(ctx context.Context, n int, reserve func(context.Context, int) (int, error)) ([]int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	pids := make([]int, 0, n)
+	for len(pids) < n {
+		if l.next == l.end {
+			size := max(l.size, n-len(pids))
+			first, err := reserve(ctx, size)
+			if err != nil {
+				return nil, err
+			}
+			l.next, l.end = first, first+size
+		}
+		pids = append(pids, l.next)
+		l.next++
+	}
+	return pids, nil
+}
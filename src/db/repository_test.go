@@ -0,0 +1,47 @@
+package db_test
+
+import (
+	"backend/src/config"
+	"backend/src/db"
+	"backend/src/repository"
+	"backend/src/repository/repotest"
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestMongoRepository runs the conformance suite against a real server. Each
+// subtest gets a scratch database that is dropped afterwards.
+func TestMongoRepository(t *testing.T) {
+	uri := os.Getenv("BACKEND_TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("BACKEND_TEST_MONGO_URI is not set")
+	}
+	for _, lease := range []int{0, 3} {
+		t.Run(fmt.Sprintf("PIDLease=%d", lease), func(t *testing.T) {
+			repotest.Run(t, newStore(uri, lease))
+		})
+	}
+}
+
+func newStore(uri string, lease int) func(t *testing.T) repository.Store {
+	return func(t *testing.T) repository.Store {
+		ctx := context.Background()
+		adapter, err := db.NewDbAdapter(ctx, config.Mongo{
+			URI:      config.Secret(uri),
+			Database: "repotest_" + primitive.NewObjectID().Hex(),
+			PIDLease: lease,
+		})
+		if err != nil {
+			t.Fatalf("connecting to mongo: %v", err)
+		}
+		t.Cleanup(func() {
+			adapter.Db.Drop(ctx)
+			adapter.Close(ctx)
+		})
+		return adapter
+	}
+}
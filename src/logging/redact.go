@@ -0,0 +1,109 @@
+package logging
+
+import (
+	"encoding/json"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const redacted = "[redacted]"
+
+var (
+	emailPattern = regexp.MustCompile(`([A-Za-z0-9._%+-])[A-Za-z0-9._%+-]*@([A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)+)`)
+	phonePattern = regexp.MustCompile(`\+?\b\d(?: ?\d){9,14}\b`)
+
+	// Attributes with these words in their key are never logged
+	secretKeys = []string{"password", "secret", "token", "authorization", "cookie"}
+)
+
+// Redact masks email addresses and phone numbers in s, keeping enough of
+// them to tell entries apart, e.g. "j***@example.com" and "********3210"
+func Redact(s string) string {
+	s = emailPattern.ReplaceAllString(s, "$1***@$2")
+	return phonePattern.ReplaceAllStringFunc(s, func(phone string) string {
+		digits := 0
+		masked := []byte(phone)
+		for i := len(masked) - 1; i >= 0; i-- {
+			if masked[i] < '0' || masked[i] > '9' {
+				continue
+			}
+			if digits++; digits > 4 {
+				masked[i] = '*'
+			}
+		}
+		return string(masked)
+	})
+}
+
+func isSecret(key string) bool {
+	key = strings.ToLower(key)
+	for _, secret := range secretKeys {
+		if strings.Contains(key, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactAttr is called with the attributes inside groups too, so a group
+// named like a secret hides everything in it
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if isSecret(a.Key) || slices.ContainsFunc(groups, isSecret) {
+		return slog.String(a.Key, redacted)
+	}
+	return slog.Attr{Key: a.Key, Value: redactValue(a.Value)}
+}
+
+func redactValue(v slog.Value) slog.Value {
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.StringValue(Redact(v.String()))
+	case slog.KindGroup:
+		// Groups nested in values, which the handler does not pass here
+		attrs := v.Group()
+		redactedAttrs := make([]slog.Attr, len(attrs))
+		for i, a := range attrs {
+			redactedAttrs[i] = redactAttr(nil, a)
+		}
+		return slog.GroupValue(redactedAttrs...)
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			return slog.StringValue(Redact(err.Error()))
+		}
+		// Structs, maps and slices are logged as JSON, so redact what they
+		// would encode to
+		data, err := json.Marshal(v.Any())
+		if err != nil {
+			return v
+		}
+		var decoded any
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			return v
+		}
+		return slog.AnyValue(redactJSON(decoded))
+	}
+	return v
+}
+
+// redactJSON redacts the strings and secret-named fields of decoded JSON
+func redactJSON(v any) any {
+	switch v := v.(type) {
+	case string:
+		return Redact(v)
+	case []any:
+		for i := range v {
+			v[i] = redactJSON(v[i])
+		}
+	case map[string]any:
+		for key, value := range v {
+			if isSecret(key) {
+				v[key] = redacted
+			} else {
+				v[key] = redactJSON(value)
+			}
+		}
+	}
+	return v
+}
@@ -0,0 +1,66 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{"nothing personal", "nothing personal"},
+		{"ada@example.com", "a***@example.com"},
+		{"mail Ada.Lovelace@mail.example.org now", "mail A***@mail.example.org now"},
+		{"+919876543210", "+********3210"},
+		{"call 98765 43210", "call ***** *3210"},
+		{"ada@example.com, +919876543210", "a***@example.com, +********3210"},
+		// Short numbers such as counts and PIDs are left alone
+		{"pid 1234", "pid 1234"},
+	} {
+		if got := Redact(tt.in); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRedactAttr(t *testing.T) {
+	type participant struct {
+		Name     string
+		Email    string
+		Password string
+		Phones   []string
+	}
+	for _, tt := range []struct {
+		name    string
+		attr    slog.Attr
+		leaks   []string
+		redacts bool // the value is replaced entirely
+	}{
+		{"string", slog.String("email", "ada@example.com"), []string{"ada@"}, false},
+		{"error", slog.Any("error", errors.New("no such user ada@example.com")), []string{"ada@"}, false},
+		{"secret key", slog.String("tokenSecret", "hunter2"), []string{"hunter2"}, true},
+		{"struct", slog.Any("participant", participant{Name: "Ada", Email: "ada@example.com", Password: "hunter2",
+			Phones: []string{"+919876543210"}}), []string{"ada@", "hunter2", "9876543210"}, false},
+		{"map", slog.Any("fields", map[string]any{"contact": "ada@example.com", "authorization": "Bearer x"}),
+			[]string{"ada@", "Bearer"}, false},
+		{"group", slog.Group("user", slog.String("email", "ada@example.com"), slog.Int("year", 2)), []string{"ada@"}, false},
+		{"secret group", slog.Group("password", slog.String("new", "hunter2")), []string{"hunter2"}, true},
+		{"nested group value", slog.Any("user", slog.GroupValue(slog.String("email", "ada@example.com"))), []string{"ada@"}, false},
+	} {
+		var out bytes.Buffer
+		logger := slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{ReplaceAttr: redactAttr}))
+		logger.Info("test", tt.attr)
+		for _, leak := range tt.leaks {
+			if strings.Contains(out.String(), leak) {
+				t.Errorf("%s: %q leaked in %s", tt.name, leak, out.String())
+			}
+		}
+		if tt.redacts && !strings.Contains(out.String(), redacted) {
+			t.Errorf("%s: %s is not redacted", tt.name, out.String())
+		}
+	}
+}
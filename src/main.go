@@ -1,62 +1,325 @@
 package main
 
 import (
+	"backend/src/config"
 	"backend/src/controllers"
 	"backend/src/db"
+	"backend/src/logging"
+	"backend/src/metrics"
+	"backend/src/models"
+	"backend/src/pii"
+	"backend/src/protection"
+	"backend/src/repository"
+	"backend/src/sqlstore"
+	"backend/src/tracing"
 	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 )
 
 func main() {
-	port := os.Getenv("BACKEND_PORT")
+	configFile := flag.String("config", os.Getenv("BACKEND_CONFIG_FILE"), "path to a YAML config file")
+	printConfig := flag.Bool("print-config", false, "print the configuration with secrets redacted and exit")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [serve|migrate]\n\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "serve runs the server (default), migrate applies pending migrations and exits.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	command := flag.Arg(0)
+	if command != "" && command != "serve" && command != "migrate" {
+		flag.Usage()
+		os.Exit(2)
+	}
 
-	if port == "" {
-		port = "5000"
+	cfg, err := config.Load(*configFile)
+	if *printConfig {
+		fmt.Print(cfg.Dump())
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
 	}
+	if *printConfig {
+		return
+	}
+	level, _ := logging.ParseLevel(cfg.LogLevel)
+	logging.Level.Set(level)
+	logging.Setup()
 
-	muxRouter := mux.NewRouter()
-	dbServ, err := db.NewDbAdapter(context.Background())
+	stopTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
 	if err != nil {
-		panic(err)
+		slog.Error("Error setting up tracing", "error", err)
+		os.Exit(1)
 	}
 
-	userService := controllers.NewUserService(dbServ)
+	muxRouter := mux.NewRouter()
+	muxRouter.Use(tracing.Route, metrics.Route)
+	store, closeStore, err := openStore(context.Background(), cfg, command == "migrate")
+	if err != nil {
+		slog.Error("Error opening storage", "backend", cfg.Storage.Backend, "error", err)
+		os.Exit(1)
+	}
+	var encrypted *repository.Encrypted
+	if cfg.Encryption.Keys != "" {
+		keys, _ := pii.ParseKeys(cfg.Encryption.Keys.Value())
+		indexKey, _ := base64.StdEncoding.DecodeString(cfg.Encryption.IndexKey.Value())
+		box, err := pii.New(keys, indexKey)
+		if err != nil {
+			slog.Error("Invalid encryption configuration", "error", err)
+			os.Exit(1)
+		}
+		encrypted = repository.NewEncrypted(store, box)
+		store = encrypted
+	}
+	if command == "migrate" {
+		if encrypted != nil {
+			resealParticipants(context.Background(), encrypted)
+		}
+		closeStore(context.Background())
+		slog.Info("Migrations applied")
+		return
+	}
+	userService := controllers.NewUserService(store, cfg)
+	healthService := controllers.NewHealthService(userService)
+	adminService := controllers.NewAdminService(store, cfg.AdminToken)
+	guard, limiters, pow, err := protection.New(cfg.Protection, store, userService.Tokens)
+	if err != nil {
+		slog.Error("Invalid protection configuration", "error", err)
+		os.Exit(1)
+	}
+	muxRouter.Use(controllers.ClientIPMiddleware(guard.Proxies))
+	userService.EmailLimiter = limiters.Email
+	adminService.Tokens = userService.Tokens
+	adminService.LoginLimiter = limiters.Login
+	adminService.LoginIPLimiter = limiters.LoginIP
+	metrics.OutboxDepth(userService.Jobs.Pending)
+	if err := userService.EnsureDefaultEvent(context.Background()); err != nil {
+		slog.Error("Error creating the default event", "error", err)
+		os.Exit(1)
+	}
 
 	muxRouter.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.Write([]byte(`{"message": "Welcome to Metamorphosis"}`))
 	}).Methods("GET")
+	muxRouter.HandleFunc("/healthz", healthService.Healthz).Methods("GET")
+	muxRouter.HandleFunc("/readyz", healthService.Readyz).Methods("GET")
+	muxRouter.HandleFunc("/version", healthService.Version).Methods("GET")
 
-	muxRouter.HandleFunc("/user/registration", func(w http.ResponseWriter, r *http.Request) {
-		done := userService.RegisterParticipants(w, r)
-		if done {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte(`{"message": "Registration successful"}`))
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Header().Set("Content-Type", "application/json")
-			w.Write([]byte(`{"message": "Registration failed"}`))
-		}
-	}).Methods("POST")
+	registrationHandler := guard.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userService.RegisterParticipants(w, r)
+	}))
+	if pow != nil {
+		muxRouter.HandleFunc("/challenge", pow.ServeChallenge).Methods("GET")
+	}
+
+	// Legacy routes serve the default event
+	muxRouter.Handle("/user/registration", registrationHandler).Methods("POST")
+	muxRouter.HandleFunc("/event/status", userService.GetEventStatus).Methods("GET")
+	muxRouter.Handle("/user/manage", guard.Protect(http.HandlerFunc(userService.RequestManageLink))).Methods("POST")
+	muxRouter.Handle("/user/otp", guard.Protect(http.HandlerFunc(userService.RequestOTP))).Methods("POST")
+	muxRouter.Handle("/user/privacy", guard.Protect(http.HandlerFunc(userService.RequestPrivacyLink))).Methods("POST")
+	muxRouter.HandleFunc("/user/otp/verify", userService.VerifyOTP).Methods("POST")
+
+	muxRouter.Handle("/events/{slug}/registration", registrationHandler).Methods("POST")
+	muxRouter.Handle("/events/{slug}/uploads", guard.Protect(http.HandlerFunc(userService.UploadScreenshot))).Methods("POST")
+	muxRouter.Handle("/events/{slug}/registrations", guard.Protect(http.HandlerFunc(userService.RegisterParticipantsJSON))).Methods("POST")
+	muxRouter.HandleFunc("/events/{slug}/status", userService.GetEventStatus).Methods("GET")
+	muxRouter.Handle("/events/{slug}/manage", guard.Protect(http.HandlerFunc(userService.RequestManageLink))).Methods("POST")
+	muxRouter.Handle("/events/{slug}/otp", guard.Protect(http.HandlerFunc(userService.RequestOTP))).Methods("POST")
+	muxRouter.Handle("/events/{slug}/privacy", guard.Protect(http.HandlerFunc(userService.RequestPrivacyLink))).Methods("POST")
+	muxRouter.HandleFunc("/events/{slug}/otp/verify", userService.VerifyOTP).Methods("POST")
+
+	muxRouter.HandleFunc("/manage", userService.GetManagedRegistration).Methods("GET")
+	muxRouter.HandleFunc("/manage/participants/{pid:[0-9]+}", userService.EditManagedParticipant).Methods("PATCH")
+	muxRouter.HandleFunc("/privacy/export", userService.ExportPersonalData).Methods("GET")
+	muxRouter.HandleFunc("/privacy/erasure", userService.ErasePersonalData).Methods("POST")
+	muxRouter.HandleFunc("/registrations/{id}/cancel", userService.CancelRegistration).Methods("POST")
+
+	// Login is registered first so the admin subrouter does not require a session for it
+	muxRouter.HandleFunc("/admin/login", adminService.Login).Methods("POST")
+	adminRouter := muxRouter.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(adminService.RequireAdmin)
+	allow := func(permission string, handler http.HandlerFunc) http.Handler {
+		return controllers.RequirePermission(permission)(handler)
+	}
+	adminRouter.HandleFunc("/logout", adminService.Logout).Methods("POST")
+	adminRouter.HandleFunc("/events", adminService.ListEvents).Methods("GET")
+	adminRouter.Handle("/events", allow(controllers.PermEvents, adminService.CreateEvent)).Methods("POST")
+	adminRouter.Handle("/events/{slug}", allow(controllers.PermEvents, adminService.UpdateEvent)).Methods("PUT")
+	adminRouter.Handle("/events/{slug}/pause", allow(controllers.PermEvents, adminService.PauseRegistrations)).Methods("PUT")
+	adminRouter.Handle("/events/{slug}/participants/{code}", allow(controllers.PermParticipants, adminService.GetParticipantByCode)).Methods("GET")
+	adminRouter.Handle("/events/{slug}/stats", allow(controllers.PermEvents, adminService.ParticipantStats)).Methods("GET")
+	adminRouter.Handle("/registrations/{id}", allow(controllers.PermRecords, adminService.DeleteRegistration)).Methods("DELETE")
+	adminRouter.Handle("/registrations/{id}/restore", allow(controllers.PermRecords, adminService.RestoreRegistration)).Methods("POST")
+	adminRouter.Handle("/participants/{pid:[0-9]+}", allow(controllers.PermRecords, adminService.DeleteParticipant)).Methods("DELETE")
+	adminRouter.Handle("/participants/{pid:[0-9]+}/restore", allow(controllers.PermRecords, adminService.RestoreParticipant)).Methods("POST")
+	adminRouter.Handle("/refunds", allow(controllers.PermRefunds, adminService.ListRefunds)).Methods("GET")
+	adminRouter.Handle("/refunds/{id}/approve", allow(controllers.PermRefunds, adminService.ApproveRefund)).Methods("POST")
+	adminRouter.Handle("/refunds/{id}/reject", allow(controllers.PermRefunds, adminService.RejectRefund)).Methods("POST")
+	adminRouter.Handle("/refunds/{id}/paid", allow(controllers.PermRefunds, adminService.MarkRefundPaid)).Methods("POST")
+	adminRouter.Handle("/audit", allow(controllers.PermAudit, adminService.ListAuditEntries)).Methods("GET")
+	adminRouter.Handle("/audit/export", allow(controllers.PermAudit, adminService.ExportAuditEntries)).Methods("GET")
+	adminRouter.Handle("/log-level", allow(controllers.PermSystem, adminService.GetLogLevel)).Methods("GET")
+	adminRouter.Handle("/log-level", allow(controllers.PermSystem, adminService.SetLogLevel)).Methods("PUT")
+	adminRouter.Handle("/users", allow(controllers.PermAdmins, adminService.ListAdminUsers)).Methods("GET")
+	adminRouter.Handle("/users", allow(controllers.PermAdmins, adminService.CreateAdminUser)).Methods("POST")
+	adminRouter.Handle("/users/{username}", allow(controllers.PermAdmins, adminService.UpdateAdminUser)).Methods("PATCH")
+	if cfg.Server.MetricsAddr == "" {
+		adminRouter.Handle("/metrics", allow(controllers.PermSystem, metrics.Handler().ServeHTTP)).Methods("GET")
+	}
 
 	corsOptions := cors.New(
 		cors.Options{
 			AllowedOrigins:   []string{"**", "*"},
-			AllowedHeaders:   []string{"X-Requested-With", "Content-Type", "Authorization"},
-			AllowedMethods:   []string{"POST", "GET", "OPTIONS"},
+			AllowedHeaders:   []string{"X-Requested-With", "Content-Type", "Authorization", "X-Challenge", "X-Challenge-Nonce", logging.RequestIDHeader},
+			AllowedMethods:   []string{"POST", "GET", "PUT", "PATCH", "DELETE", "OPTIONS"},
+			ExposedHeaders:   []string{logging.RequestIDHeader},
 			AllowCredentials: true,
 		},
 	)
 
-	httpRouter := corsOptions.Handler(muxRouter)
+	// The span starts before the request is logged so the log carries its trace
+	httpRouter := metrics.Middleware(tracing.Middleware(logging.Middleware(corsOptions.Handler(muxRouter))))
+
+	server := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           httpRouter,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go userService.RunRetention(ctx)
+	go backfillParticipantCodes(ctx, userService)
+	if encrypted != nil {
+		go resealParticipants(ctx, encrypted)
+	}
+
+	serverErr := make(chan error, 2)
+	go func() {
+		slog.Info("Server started", "port", cfg.Port)
+		serverErr <- server.ListenAndServe()
+	}()
+	var metricsServer *http.Server
+	if cfg.Server.MetricsAddr != "" {
+		metricsRouter := http.NewServeMux()
+		metricsRouter.Handle("GET /metrics", metrics.Handler())
+		metricsServer = &http.Server{Addr: cfg.Server.MetricsAddr, Handler: metricsRouter, ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout}
+		go func() {
+			slog.Info("Metrics server started", "addr", cfg.Server.MetricsAddr)
+			serverErr <- metricsServer.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-serverErr:
+		slog.Error("Server failed", "error", err)
+	case <-ctx.Done():
+		// Fail readiness but keep serving until the load balancer has seen
+		// it, so requests routed here meanwhile are not refused
+		slog.Info("Shutting down", "grace", cfg.Server.DrainGrace)
+		healthService.Drain()
+		select {
+		case <-time.After(cfg.Server.DrainGrace):
+		case err := <-serverErr:
+			slog.Error("Server failed", "error", err)
+		}
+	}
+	stop()
+
+	// Stop accepting requests and drain in-flight ones, then let background
+	// jobs such as emails finish, and close the database last
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Error draining requests", "error", err)
+	}
+	if metricsServer != nil {
+		metricsServer.Close()
+	}
+	if err := userService.Jobs.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Background jobs did not finish", "error", err, "pending", userService.Jobs.Pending())
+	}
+	if err := stopTracing(shutdownCtx); err != nil {
+		slog.Error("Error flushing traces", "error", err)
+	}
+	if err := closeStore(shutdownCtx); err != nil {
+		slog.Error("Error closing storage", "error", err)
+	}
+	slog.Info("Server stopped")
+}
+
+// openStore connects to the configured backend and applies pending
+// migrations. SQL backends always migrate; Mongo does when asked to or
+// configured to on start.
+func openStore(ctx context.Context, cfg config.Config, migrate bool) (repository.Store, func(context.Context) error, error) {
+	if cfg.Storage.Backend != "mongo" {
+		store, err := sqlstore.Open(ctx, cfg.Storage)
+		if err != nil {
+			return nil, nil, err
+		}
+		return store, func(context.Context) error { return store.Close() }, nil
+	}
+	adapter, err := db.NewDbAdapter(ctx, cfg.Mongo)
+	if err != nil {
+		return nil, nil, err
+	}
+	if migrate || cfg.Mongo.MigrateOnStart {
+		if err := adapter.Migrate(ctx); err != nil {
+			adapter.Close(ctx)
+			return nil, nil, fmt.Errorf("migrating mongo: %w", err)
+		}
+	}
+	return adapter, adapter.Close, nil
+}
 
-	log.Println("Server started at port " + port)
-	slog.Error(http.ListenAndServe(":"+port, httpRouter).Error())
+// backfillParticipantCodes gives participants stored before codes existed
+// their code, in the background since it reads every participant
+func backfillParticipantCodes(ctx context.Context, userService *controllers.UserService) {
+	n, err := userService.BackfillParticipantCodes(ctx)
+	if err != nil && ctx.Err() == nil {
+		slog.ErrorContext(ctx, "Error assigning participant codes", "error", err, "assigned", n)
+		return
+	}
+	if n > 0 {
+		slog.InfoContext(ctx, "Assigned participant codes", "count", n)
+	}
+}
+
+// resealParticipants encrypts participants stored before encryption was
+// enabled or under a key that has since been rotated
+func resealParticipants(ctx context.Context, encrypted *repository.Encrypted) {
+	n, err := encrypted.Reseal(ctx)
+	if err != nil && ctx.Err() == nil {
+		slog.ErrorContext(ctx, "Error resealing participants", "error", err, "resealed", n)
+		return
+	}
+	if n > 0 {
+		slog.InfoContext(ctx, "Resealed participants", "count", n)
+		err := encrypted.CreateAuditEntry(ctx, models.AuditEntry{
+			Actor:      "system",
+			Action:     "participant.reseal",
+			TargetType: "participant",
+			Changes:    map[string]models.Change{"participants": {After: n}},
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Error writing audit entry", "error", err)
+		}
+	}
 }
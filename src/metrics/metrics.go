@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registration outcomes
+const (
+	Created  = "created"
+	Rejected = "rejected"
+	Failed   = "failed"
+)
+
+// Email outcomes
+const (
+	EmailSent   = "sent"
+	EmailFailed = "failed"
+	EmailRetry  = "retry"
+)
+
+var (
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by mux route and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	registrations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "registrations_total",
+		Help: "Registration attempts by outcome and reason.",
+	}, []string{"outcome", "reason"})
+
+	participants = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "registration_participants_total",
+		Help: "Participants in successful registrations.",
+	})
+
+	uploadDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "upload_duration_seconds",
+		Help:    "Time taken to store payment screenshots.",
+		Buckets: []float64{0.25, 0.5, 1, 2, 4, 8, 16, 32},
+	})
+
+	uploadFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "upload_failures_total",
+		Help: "Payment screenshot uploads that failed.",
+	})
+
+	emails = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "emails_total",
+		Help: "Email delivery attempts by kind and outcome.",
+	}, []string{"kind", "outcome"})
+)
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+type routeKey struct{}
+
+// Middleware records the latency of every request against its route template
+// so that path variables don't explode the label cardinality. It wraps the
+// whole handler so that requests the router turns away, such as 404s and
+// 405s, are counted too, as "unmatched"; Route reports the template of the
+// others from inside the router.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		route := "unmatched"
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), routeKey{}, &route)))
+		requestDuration.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Route is a mux middleware passing the matched route template to Middleware
+func Route(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := r.Context().Value(routeKey{}).(*string); ok {
+			if current := mux.CurrentRoute(r); current != nil {
+				if tmpl, err := current.GetPathTemplate(); err == nil {
+					*route = tmpl
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Registration counts a registration attempt. Reason is empty for created ones.
+func Registration(outcome, reason string) {
+	registrations.WithLabelValues(outcome, reason).Inc()
+}
+
+// Participants counts the participants of a successful registration
+func Participants(n int) {
+	participants.Add(float64(n))
+}
+
+// Upload records how long a screenshot upload took and whether it failed
+func Upload(took time.Duration, ok bool) {
+	uploadDuration.Observe(took.Seconds())
+	if !ok {
+		uploadFailures.Inc()
+	}
+}
+
+// Email counts one email delivery attempt
+func Email(kind, outcome string) {
+	emails.WithLabelValues(kind, outcome).Inc()
+}
+
+// OutboxDepth exposes the number of emails waiting to be sent
+func OutboxDepth(depth func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "email_outbox_depth",
+		Help: "Emails queued or being sent.",
+	}, func() float64 { return float64(depth()) })
+}
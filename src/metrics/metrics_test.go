@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestMiddlewareCountsEveryRequest(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(Route)
+	router.HandleFunc("/items/{id}", func(w http.ResponseWriter, r *http.Request) {}).Methods("GET")
+	handler := Middleware(router)
+
+	for _, tt := range []struct {
+		method, path, route, status string
+	}{
+		{"GET", "/items/1", "/items/{id}", "200"},
+		{"GET", "/missing", "unmatched", "404"},
+		{"DELETE", "/items/1", "unmatched", "405"},
+	} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+		var m dto.Metric
+		if err := requestDuration.WithLabelValues(tt.route, tt.method, tt.status).(prometheus.Histogram).Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		if count := m.GetHistogram().GetSampleCount(); count != 1 {
+			t.Errorf("%s %s: %d observations for route %q status %s, want 1", tt.method, tt.path, count, tt.route, tt.status)
+		}
+	}
+}
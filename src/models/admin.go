@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+)
+
+// Admin roles: organizers run events, finance handles money and volunteers
+// staff the registration desk
+const (
+	RoleOrganizer = "organizer"
+	RoleFinance   = "finance"
+	RoleVolunteer = "volunteer"
+)
+
+// AdminUser model, an account that signs in to the admin API
+type AdminUser struct {
+	ID           ID     `bson:"_id,omitempty" json:"id"`
+	Username     string `bson:"username" json:"username"`
+	PasswordHash string `bson:"passwordHash" json:"-"` // bcrypt
+	Role         string `bson:"role" json:"role"`
+	Disabled     bool   `bson:"disabled,omitempty" json:"disabled"`
+	// SessionVersion is part of every session token, so bumping it signs
+	// the account out everywhere
+	SessionVersion int        `bson:"sessionVersion" json:"-"`
+	LastLoginAt    *time.Time `bson:"lastLoginAt,omitempty" json:"lastLoginAt,omitempty"`
+	CreatedAt      time.Time  `bson:"createdAt" json:"createdAt"`
+	UpdatedAt      time.Time  `bson:"updatedAt" json:"updatedAt"`
+}
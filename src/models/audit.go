@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+)
+
+// Change records a field value before and after an edit
+type Change struct {
+	Before interface{} `bson:"before" json:"before"`
+	After  interface{} `bson:"after" json:"after"`
+}
+
+// AuditEntry model, appended for every change made through the API. Entries
+// are never edited, except to redact an erased participant.
+type AuditEntry struct {
+	ID         ID                `bson:"_id,omitempty" json:"id"`
+	Actor      string            `bson:"actor" json:"actor"`
+	Action     string            `bson:"action" json:"action"`
+	TargetType string            `bson:"targetType" json:"targetType"`
+	TargetID   string            `bson:"targetId" json:"targetId"`
+	EventID    ID                `bson:"eventId" json:"eventId"`
+	Changes    map[string]Change `bson:"changes,omitempty" json:"changes,omitempty"`
+	IP         string            `bson:"ip,omitempty" json:"ip,omitempty"`
+	CreatedAt  time.Time         `bson:"createdAt" json:"createdAt"`
+}
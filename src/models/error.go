@@ -1,5 +1,16 @@
 package models
 
 type Error struct {
+	Message string       `json:"message"`
+	Code    string       `json:"code,omitempty"`
+	Details interface{}  `json:"details,omitempty"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError describes one invalid field. Index is the position of the
+// participant in the request, or -1 for fields outside the participant list.
+type FieldError struct {
+	Index   int    `json:"index"`
+	Field   string `json:"field"`
 	Message string `json:"message"`
 }
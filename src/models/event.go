@@ -0,0 +1,225 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Registration phases reported by the status endpoint
+const (
+	PhaseUpcoming  = "upcoming"
+	PhaseEarlyBird = "early_bird"
+	PhaseOpen      = "open"
+	PhasePaused    = "paused"
+	PhaseFull      = "full"
+	PhaseClosed    = "closed"
+)
+
+// Event model, one per edition or workshop
+type Event struct {
+	ID                   ID             `bson:"_id,omitempty" json:"id"`
+	Slug                 string         `bson:"slug" json:"slug"`
+	Name                 string         `bson:"name" json:"name"`
+	Description          string         `bson:"description" json:"description"`
+	StartsAt             time.Time      `bson:"startsAt" json:"startsAt"`
+	EndsAt               time.Time      `bson:"endsAt" json:"endsAt"`
+	Timezone             string         `bson:"timezone" json:"timezone"` // IANA name used in emails
+	Venue                string         `bson:"venue" json:"venue"`
+	Website              string         `bson:"website" json:"website"`
+	BannerURL            string         `bson:"bannerUrl" json:"bannerUrl"`
+	Fee                  int            `bson:"fee" json:"fee"`
+	EarlyBirdFee         int            `bson:"earlyBirdFee" json:"earlyBirdFee"`
+	Capacity             int            `bson:"capacity" json:"capacity"`         // 0 means unlimited
+	MinTeamSize          int            `bson:"minTeamSize" json:"minTeamSize"`   // 0 means 1
+	MaxTeamSize          int            `bson:"maxTeamSize" json:"maxTeamSize"`   // 0 means unlimited
+	VerifyEmails         bool           `bson:"verifyEmails" json:"verifyEmails"` // require an OTP per participant email
+	RegistrationOpensAt  time.Time      `bson:"registrationOpensAt,omitempty" json:"registrationOpensAt"`
+	RegistrationClosesAt time.Time      `bson:"registrationClosesAt,omitempty" json:"registrationClosesAt"`
+	EarlyBirdEndsAt      time.Time      `bson:"earlyBirdEndsAt,omitempty" json:"earlyBirdEndsAt"`
+	EditsCloseAt         time.Time      `bson:"editsCloseAt,omitempty" json:"editsCloseAt"`
+	RefundPolicy         []RefundRule   `bson:"refundPolicy" json:"refundPolicy"`
+	Paused               bool           `bson:"paused" json:"paused"`
+	ResumesAt            *time.Time     `bson:"resumesAt,omitempty" json:"resumesAt,omitempty"` // ends the pause, nil keeps it until lifted
+	UploadFolder         string         `bson:"uploadFolder" json:"uploadFolder"`
+	CodePrefix           string         `bson:"codePrefix,omitempty" json:"codePrefix,omitempty"` // starts participant codes
+	Templates            EventTemplates `bson:"templates" json:"templates"`
+	CreatedAt            time.Time      `bson:"createdAt,omitempty" json:"createdAt"`
+	UpdatedAt            time.Time      `bson:"updatedAt,omitempty" json:"updatedAt"`
+}
+
+// EventTemplates overrides the built-in email copy for an event
+type EventTemplates struct {
+	ConfirmationSubject string `bson:"confirmationSubject" json:"confirmationSubject"`
+	Confirmation        string `bson:"confirmation" json:"confirmation"` // html/template source
+	ReceiptSubject      string `bson:"receiptSubject" json:"receiptSubject"`
+}
+
+// EventStatus is the public view of the registration window
+type EventStatus struct {
+	Slug            string     `json:"slug"`
+	Name            string     `json:"name"`
+	Phase           string     `json:"phase"`
+	Open            bool       `json:"open"`
+	OpensAt         *time.Time `json:"opensAt,omitempty"`
+	ClosesAt        *time.Time `json:"closesAt,omitempty"`
+	EarlyBirdEndsAt *time.Time `json:"earlyBirdEndsAt,omitempty"`
+	NextOpeningAt   *time.Time `json:"nextOpeningAt,omitempty"`
+	Capacity        int        `json:"capacity,omitempty"`
+	RemainingSeats  *int       `json:"remainingSeats,omitempty"`
+	Fee             int        `json:"fee"`
+	ServerTime      time.Time  `json:"serverTime"`
+}
+
+// Status computes the registration phase of the event at the given time
+func (e Event) Status(now time.Time, registered int) EventStatus {
+	status := EventStatus{
+		Slug:            e.Slug,
+		Name:            e.Name,
+		Phase:           PhaseOpen,
+		OpensAt:         timePtr(e.RegistrationOpensAt),
+		ClosesAt:        timePtr(e.RegistrationClosesAt),
+		EarlyBirdEndsAt: timePtr(e.EarlyBirdEndsAt),
+		Capacity:        e.Capacity,
+		Fee:             e.Fee,
+		ServerTime:      now,
+	}
+	if e.Capacity > 0 {
+		remaining := max(e.Capacity-registered, 0)
+		status.RemainingSeats = &remaining
+	}
+
+	switch {
+	case !e.RegistrationClosesAt.IsZero() && !now.Before(e.RegistrationClosesAt):
+		status.Phase = PhaseClosed
+	case !e.RegistrationOpensAt.IsZero() && now.Before(e.RegistrationOpensAt):
+		status.Phase = PhaseUpcoming
+		status.NextOpeningAt = timePtr(e.RegistrationOpensAt)
+	case e.Paused && (e.ResumesAt == nil || now.Before(*e.ResumesAt)):
+		status.Phase = PhasePaused
+		status.NextOpeningAt = e.ResumesAt
+	case status.RemainingSeats != nil && *status.RemainingSeats == 0:
+		status.Phase = PhaseFull
+	case !e.EarlyBirdEndsAt.IsZero() && now.Before(e.EarlyBirdEndsAt):
+		status.Phase = PhaseEarlyBird
+		if e.EarlyBirdFee > 0 {
+			status.Fee = e.EarlyBirdFee
+		}
+	}
+	status.Open = status.Phase == PhaseOpen || status.Phase == PhaseEarlyBird
+	return status
+}
+
+// Dates renders the event dates for emails, e.g. "15 & 16 February, 2025"
+func (e Event) Dates() string {
+	loc := e.location()
+	start, end := e.StartsAt.In(loc), e.EndsAt.In(loc)
+	switch {
+	case start.IsZero():
+		return ""
+	case end.IsZero() || sameDay(start, end):
+		return start.Format("2 January, 2006")
+	case start.Year() == end.Year() && start.Month() == end.Month():
+		sep := " - "
+		if end.Day()-start.Day() == 1 {
+			sep = " & "
+		}
+		return fmt.Sprintf("%d%s%s", start.Day(), sep, end.Format("2 January, 2006"))
+	default:
+		return start.Format("2 January, 2006") + " - " + end.Format("2 January, 2006")
+	}
+}
+
+// StartTime renders the event start time for emails, e.g. "9:00 AM"
+func (e Event) StartTime() string {
+	if e.StartsAt.IsZero() {
+		return ""
+	}
+	return e.StartsAt.In(e.location()).Format("3:04 PM")
+}
+
+func (e Event) location() *time.Location {
+	if loc, err := time.LoadLocation(e.Timezone); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// EditDeadline is when participants can no longer edit their details.
+// It defaults to the event start.
+func (e Event) EditDeadline() time.Time {
+	if !e.EditsCloseAt.IsZero() {
+		return e.EditsCloseAt
+	}
+	return e.StartsAt
+}
+
+// EndTime is when the event ends, or its start when no end is set
+func (e Event) EndTime() time.Time {
+	if !e.EndsAt.IsZero() {
+		return e.EndsAt
+	}
+	return e.StartsAt
+}
+
+// RefundPercent is the share of the fee refunded for a cancellation at the
+// given time. Without a policy the full fee is refunded until the event starts.
+func (e Event) RefundPercent(now time.Time) int {
+	if len(e.RefundPolicy) == 0 {
+		if e.StartsAt.IsZero() || now.Before(e.StartsAt) {
+			return 100
+		}
+		return 0
+	}
+	percent := 0
+	var deadline time.Time
+	for _, rule := range e.RefundPolicy {
+		if now.Before(rule.Before) && (deadline.IsZero() || rule.Before.Before(deadline)) {
+			percent, deadline = rule.Percent, rule.Before
+		}
+	}
+	return percent
+}
+
+// TeamSizeLimits returns the minimum and maximum group size, where a
+// maximum of 0 means unlimited
+func (e Event) TeamSizeLimits() (int, int) {
+	return max(e.MinTeamSize, 1), e.MaxTeamSize
+}
+
+// Folder is where uploads for this event are stored
+func (e Event) Folder() string {
+	if e.UploadFolder != "" {
+		return e.UploadFolder
+	}
+	return e.Slug
+}
+
+// ParticipantCodePrefix starts the event's participant codes. It defaults to
+// the first six letters and digits of the slug, uppercased.
+func (e Event) ParticipantCodePrefix() string {
+	if e.CodePrefix != "" {
+		return e.CodePrefix
+	}
+	var prefix strings.Builder
+	for _, r := range strings.ToUpper(e.Slug) {
+		if prefix.Len() == 6 {
+			break
+		}
+		if ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			prefix.WriteRune(r)
+		}
+	}
+	return prefix.String()
+}
+
+func sameDay(a, b time.Time) bool {
+	return a.Year() == b.Year() && a.YearDay() == b.YearDay()
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
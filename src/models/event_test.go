@@ -0,0 +1,65 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventStatus(t *testing.T) {
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	hour := time.Hour
+	at := func(d time.Duration) time.Time { return now.Add(d) }
+	ptr := func(t time.Time) *time.Time { return &t }
+	seats := func(n int) *int { return &n }
+	window := Event{
+		RegistrationOpensAt:  at(-24 * hour),
+		RegistrationClosesAt: at(24 * hour),
+		Fee:                  500,
+	}
+	with := func(change func(e *Event)) Event {
+		e := window
+		change(&e)
+		return e
+	}
+	for _, tt := range []struct {
+		name       string
+		event      Event
+		registered int
+		phase      string
+		fee        int
+		next       *time.Time
+		remaining  *int
+	}{
+		{"no window", Event{Fee: 500}, 0, PhaseOpen, 500, nil, nil},
+		{"before opening", with(func(e *Event) { e.RegistrationOpensAt = at(hour) }), 0, PhaseUpcoming, 500, ptr(at(hour)), nil},
+		{"open", window, 0, PhaseOpen, 500, nil, nil},
+		{"early bird", with(func(e *Event) { e.EarlyBirdEndsAt, e.EarlyBirdFee = at(hour), 300 }), 0, PhaseEarlyBird, 300, nil, nil},
+		{"after early bird", with(func(e *Event) { e.EarlyBirdEndsAt, e.EarlyBirdFee = at(-hour), 300 }), 0, PhaseOpen, 500, nil, nil},
+		{"seats left", with(func(e *Event) { e.Capacity = 10 }), 7, PhaseOpen, 500, nil, seats(3)},
+		{"full", with(func(e *Event) { e.Capacity = 10 }), 10, PhaseFull, 500, nil, seats(0)},
+		{"over capacity", with(func(e *Event) { e.Capacity = 10 }), 12, PhaseFull, 500, nil, seats(0)},
+		{"paused", with(func(e *Event) { e.Paused = true }), 0, PhasePaused, 500, nil, nil},
+		{"paused until later", with(func(e *Event) { e.Paused, e.ResumesAt = true, ptr(at(hour)) }), 0, PhasePaused, 500, ptr(at(hour)), nil},
+		{"pause over", with(func(e *Event) { e.Paused, e.ResumesAt = true, ptr(at(-hour)) }), 0, PhaseOpen, 500, nil, nil},
+		{"paused when full", with(func(e *Event) { e.Paused, e.Capacity = true, 1 }), 1, PhasePaused, 500, nil, seats(0)},
+		{"closed", with(func(e *Event) { e.RegistrationClosesAt = at(-hour) }), 0, PhaseClosed, 500, nil, nil},
+		{"closed at the closing time", with(func(e *Event) { e.RegistrationClosesAt = now }), 0, PhaseClosed, 500, nil, nil},
+		{"closed while paused", with(func(e *Event) { e.RegistrationClosesAt, e.Paused = at(-hour), true }), 0, PhaseClosed, 500, nil, nil},
+	} {
+		status := tt.event.Status(now, tt.registered)
+		open := tt.phase == PhaseOpen || tt.phase == PhaseEarlyBird
+		if status.Phase != tt.phase || status.Open != open || status.Fee != tt.fee {
+			t.Errorf("%s: phase %s, open %v, fee %d, want %s, %v, %d", tt.name, status.Phase, status.Open, status.Fee, tt.phase, open, tt.fee)
+		}
+		if !equalPtr(status.NextOpeningAt, tt.next) {
+			t.Errorf("%s: next opening %v, want %v", tt.name, status.NextOpeningAt, tt.next)
+		}
+		if !equalPtr(status.RemainingSeats, tt.remaining) {
+			t.Errorf("%s: remaining seats %v, want %v", tt.name, status.RemainingSeats, tt.remaining)
+		}
+	}
+}
+
+func equalPtr[T comparable](a, b *T) bool {
+	return (a == nil) == (b == nil) && (a == nil || *a == *b)
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrInvalidID is returned when parsing a malformed ID
+var ErrInvalidID = errors.New("invalid id")
+
+// ID identifies a stored record. It is stored as an ObjectID in Mongo and
+// as 24 hex characters in SQL, and travels as hex in JSON.
+type ID [12]byte
+
+// NewID returns a new ID that sorts after the ones generated before it
+func NewID() ID {
+	return ID(primitive.NewObjectID())
+}
+
+// ParseID parses the hex form of an ID
+func ParseID(s string) (ID, error) {
+	var id ID
+	if len(s) != 2*len(id) {
+		return id, ErrInvalidID
+	}
+	if _, err := hex.Decode(id[:], []byte(s)); err != nil {
+		return id, ErrInvalidID
+	}
+	return id, nil
+}
+
+func (id ID) Hex() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id ID) String() string {
+	return id.Hex()
+}
+
+func (id ID) IsZero() bool {
+	return id == ID{}
+}
+
+func (id ID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(id.Hex())
+}
+
+// UnmarshalJSON accepts the hex form, or an empty string for no ID
+func (id *ID) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s == "" {
+		*id = ID{}
+		return nil
+	}
+	parsed, err := ParseID(s)
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
+
+func (id ID) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bson.MarshalValue(primitive.ObjectID(id))
+}
+
+func (id *ID) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	switch t {
+	case bson.TypeNull, bson.TypeUndefined:
+		*id = ID{}
+		return nil
+	case bson.TypeObjectID:
+		oid, ok := bson.RawValue{Type: t, Value: data}.ObjectIDOK()
+		if !ok {
+			return ErrInvalidID
+		}
+		*id = ID(oid)
+		return nil
+	}
+	return fmt.Errorf("cannot decode %v into an ID", t)
+}
+
+// Value stores the hex form in SQL
+func (id ID) Value() (driver.Value, error) {
+	return id.Hex(), nil
+}
+
+func (id *ID) Scan(src any) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into an ID", src)
+	}
+	parsed, err := ParseID(s)
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// OTP model, the pending email verification code for an address, which is
+// stored as a keyed hash
+type OTP struct {
+	ID        ID        `bson:"_id,omitempty" json:"id"`
+	EventID   ID        `bson:"eventId" json:"eventId"`
+	EmailHash string    `bson:"emailHash" json:"-"`
+	CodeHash  string    `bson:"codeHash" json:"-"`
+	Attempts  int       `bson:"attempts" json:"attempts"`
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
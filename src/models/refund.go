@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+)
+
+// Refund states, moving requested -> approved -> paid or requested -> rejected
+const (
+	RefundRequested = "requested"
+	RefundApproved  = "approved"
+	RefundRejected  = "rejected"
+	RefundPaid      = "paid"
+)
+
+// RefundRule refunds Percent of the fee for cancellations before Before
+type RefundRule struct {
+	Before  time.Time `bson:"before" json:"before"`
+	Percent int       `bson:"percent" json:"percent"`
+}
+
+// Refund model, one per cancellation
+type Refund struct {
+	ID             ID        `bson:"_id,omitempty" json:"id"`
+	EventID        ID        `bson:"eventId" json:"eventId"`
+	RegistrationID ID        `bson:"registrationId" json:"registrationId"`
+	Participants   []int     `bson:"participants" json:"participants"`
+	Amount         int       `bson:"amount" json:"amount"`
+	Status         string    `bson:"status" json:"status"`
+	RequestedBy    string    `bson:"requestedBy" json:"requestedBy"`
+	Reason         string    `bson:"reason" json:"reason"`
+	TransactionID  string    `bson:"transactionId" json:"transactionId"` // original payment
+	Reference      string    `bson:"reference,omitempty" json:"reference,omitempty"`
+	ReviewedBy     string    `bson:"reviewedBy,omitempty" json:"reviewedBy,omitempty"`
+	CreatedAt      time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt      time.Time `bson:"updatedAt" json:"updatedAt"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+)
+
+// Upload model, a payment screenshot waiting to be attached to a registration
+type Upload struct {
+	ID        ID        `bson:"_id,omitempty" json:"id"`
+	EventID   ID        `bson:"eventId" json:"eventId"`
+	URL       string    `bson:"url" json:"url"`
+	Consumed  bool      `bson:"consumed" json:"consumed"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
@@ -2,41 +2,67 @@ package models
 
 import (
 	"time"
-
-	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // Counter model
 type Counter struct {
-	ID  primitive.ObjectID `bson:"_id,omitempty"`
-	Seq int                `bson:"seq" json:"seq"`
+	ID  ID  `bson:"_id,omitempty"`
+	Seq int `bson:"seq" json:"seq"`
 }
 
 // Participant model
 type Participant struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	PID         int                `bson:"pid" json:"pid"` // Unique participant ID
-	Name        string             `bson:"name" json:"name"`
-	Email       string             `bson:"email" json:"email"`
-	Phone       string             `bson:"phone" json:"phone"`
-	CollegeName string             `bson:"collegeName" json:"collegeName"`
-	YearOfStudy int                `bson:"yearOfStudy" json:"yearOfStudy"`
-	DualBoot    bool               `bson:"dualBoot" json:"dualBoot"`
-	MailSent    bool               `bson:"mailSent,omitempty" json:"mailSent"`
-	CreatedAt   time.Time          `bson:"createdAt,omitempty" json:"createdAt"`
-	UpdatedAt   time.Time          `bson:"updatedAt,omitempty" json:"updatedAt"`
+	ID           ID         `bson:"_id,omitempty" json:"id"`
+	PID          int        `bson:"pid" json:"pid"`                       // Unique participant ID
+	Code         string     `bson:"code,omitempty" json:"code,omitempty"` // e.g. META25-7KX9Q, unique per event
+	EventID      ID         `bson:"eventId" json:"eventId"`
+	Name         string     `bson:"name" json:"name"`
+	Email        string     `bson:"email" json:"email"`
+	Phone        string     `bson:"phone" json:"phone"`
+	EmailIndex   string     `bson:"emailIndex,omitempty" json:"-"` // blind index when emails are encrypted
+	CollegeName  string     `bson:"collegeName" json:"collegeName"`
+	YearOfStudy  int        `bson:"yearOfStudy" json:"yearOfStudy"`
+	DualBoot     bool       `bson:"dualBoot" json:"dualBoot"`
+	MailSent     bool       `bson:"mailSent,omitempty" json:"mailSent"`
+	Cancelled    bool       `bson:"cancelled,omitempty" json:"cancelled"`
+	CreatedAt    time.Time  `bson:"createdAt,omitempty" json:"createdAt"`
+	UpdatedAt    time.Time  `bson:"updatedAt,omitempty" json:"updatedAt"`
+	DeletedAt    *time.Time `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	AnonymizedAt *time.Time `bson:"anonymizedAt,omitempty" json:"anonymizedAt,omitempty"`
+}
+
+// ParticipantStat is the number of participants from a college and year,
+// which outlives their personal data
+type ParticipantStat struct {
+	CollegeName string `bson:"collegeName" json:"collegeName"`
+	YearOfStudy int    `bson:"yearOfStudy" json:"yearOfStudy"`
+	Count       int    `bson:"count" json:"count"`
 }
 
+// Registration statuses; registrations created before statuses existed have none
+const (
+	RegistrationActive    = "active"
+	RegistrationCancelled = "cancelled"
+)
+
 // Registration model
 type Registration struct {
-	ID                primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	NumOfParticipants int                `bson:"numOfParticipants" json:"numOfParticipants"`
-	Participants      []int              `bson:"participants" json:"participants"` // Storing participant IDs
-	TotalAmount       int                `bson:"totalAmount" json:"totalAmount"`
-	TransactionID     string             `bson:"transactionId" json:"transactionId"`
-	TransactionImage  string             `bson:"transactionImage" json:"transactionImage"`
-	MailSent          bool               `bson:"mailSent,omitempty" json:"mailSent"`
-	ReferralCode      string             `bson:"referralCode" json:"referralCode"`
-	CreatedAt         time.Time          `bson:"createdAt,omitempty" json:"createdAt"`
-	UpdatedAt         time.Time          `bson:"updatedAt,omitempty" json:"updatedAt"`
+	ID                ID         `bson:"_id,omitempty" json:"id"`
+	EventID           ID         `bson:"eventId" json:"eventId"`
+	TeamName          string     `bson:"teamName,omitempty" json:"teamName,omitempty"`
+	TeamNameKey       string     `bson:"teamNameKey,omitempty" json:"-"` // lowercased for uniqueness
+	LeaderPID         int        `bson:"leaderPid,omitempty" json:"leaderPid,omitempty"`
+	NumOfParticipants int        `bson:"numOfParticipants" json:"numOfParticipants"`
+	Participants      []int      `bson:"participants" json:"participants"` // Storing participant IDs
+	TotalAmount       int        `bson:"totalAmount" json:"totalAmount"`
+	TransactionID     string     `bson:"transactionId" json:"transactionId"`
+	TransactionImage  string     `bson:"transactionImage" json:"transactionImage"`
+	MailSent          bool       `bson:"mailSent,omitempty" json:"mailSent"`
+	ReferralCode      string     `bson:"referralCode" json:"referralCode"`
+	Status            string     `bson:"status,omitempty" json:"status"`
+	Cancelled         []int      `bson:"cancelledParticipants,omitempty" json:"cancelledParticipants,omitempty"`
+	CreatedAt         time.Time  `bson:"createdAt,omitempty" json:"createdAt"`
+	UpdatedAt         time.Time  `bson:"updatedAt,omitempty" json:"updatedAt"`
+	DeletedAt         *time.Time `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	AnonymizedAt      *time.Time `bson:"anonymizedAt,omitempty" json:"anonymizedAt,omitempty"`
 }
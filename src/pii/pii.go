@@ -0,0 +1,163 @@
+// Package pii encrypts personal data fields at rest and derives blind
+// indexes so encrypted values can still be looked up by equality.
+package pii
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// prefix marks an encrypted value as "enc2:<key id>:<base64 nonce+ciphertext>",
+// authenticated with the context it was sealed for, so a value copied to
+// another row or field fails to decrypt. legacyPrefix marks values
+// authenticated with their key ID only. Values without either are legacy
+// plaintext and are returned unchanged.
+const (
+	prefix       = "enc2:"
+	legacyPrefix = "enc:"
+)
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,16}$`)
+
+// Key is a 32-byte AES-256 key and the ID stored with values it encrypts
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// ParseKeys reads a comma-separated list of id:base64key pairs. The first
+// key encrypts new values; the others only decrypt until rotated away.
+func ParseKeys(spec string) ([]Key, error) {
+	var keys []Key
+	for _, part := range strings.Split(spec, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok || !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("key %q must be id:base64key with a short alphanumeric id", id)
+		}
+		secret, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(secret) != 32 {
+			return nil, fmt.Errorf("key %q must be 32 bytes of base64", id)
+		}
+		keys = append(keys, Key{ID: id, Secret: secret})
+	}
+	return keys, nil
+}
+
+// Box encrypts with the current key, decrypts with any known key and
+// computes blind indexes with a separate HMAC key
+type Box struct {
+	current  string
+	aeads    map[string]cipher.AEAD
+	indexKey []byte
+}
+
+// New builds a Box whose current key is keys[0]
+func New(keys []Key, indexKey []byte) (*Box, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one key is required")
+	}
+	if len(indexKey) < 32 {
+		return nil, errors.New("index key must be at least 32 bytes")
+	}
+	b := &Box{current: keys[0].ID, aeads: map[string]cipher.AEAD{}, indexKey: indexKey}
+	for _, key := range keys {
+		if _, ok := b.aeads[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		block, err := aes.NewCipher(key.Secret)
+		if err != nil {
+			return nil, err
+		}
+		if b.aeads[key.ID], err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// FieldContext names a field of a stored record, such as
+// "participants/42/email", to bind its encrypted value to
+func FieldContext(collection string, id int, field string) string {
+	return fmt.Sprintf("%s/%d/%s", collection, id, field)
+}
+
+// Encrypt seals a value with the current key for the given context, which
+// Decrypt must be given again. Empty values, such as anonymized fields,
+// stay empty.
+func (b *Box) Encrypt(value, context string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	aead := b.aeads[b.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(context))
+	return prefix + b.current + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value sealed with any known key for context, and legacy
+// values sealed for their key ID only, and passes plaintext through
+func (b *Box) Decrypt(value, context string) (string, error) {
+	id, sealed, legacy, ok := cut(value)
+	if !ok {
+		return value, nil
+	}
+	aead, known := b.aeads[id]
+	if !known {
+		return "", fmt.Errorf("value encrypted with unknown key %q", id)
+	}
+	data, err := base64.RawStdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted value")
+	}
+	if legacy {
+		context = id
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(context))
+	if err != nil {
+		return "", fmt.Errorf("decrypting value with key %q: %w", id, err)
+	}
+	return string(plain), nil
+}
+
+// Stale reports whether a non-empty value is plaintext, sealed without a
+// context or sealed with a key other than the current one
+func (b *Box) Stale(value string) bool {
+	id, _, legacy, ok := cut(value)
+	return value != "" && (!ok || legacy || id != b.current)
+}
+
+// Index is a deterministic HMAC of the value, lowercased and trimmed so it
+// matches the way emails are compared. Empty values have no index.
+func (b *Box) Index(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, b.indexKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func cut(value string) (id, sealed string, legacy, ok bool) {
+	rest, ok := strings.CutPrefix(value, prefix)
+	if !ok {
+		if rest, ok = strings.CutPrefix(value, legacyPrefix); !ok {
+			return "", "", false, false
+		}
+		legacy = true
+	}
+	id, sealed, ok = strings.Cut(rest, ":")
+	return id, sealed, legacy, ok
+}
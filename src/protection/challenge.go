@@ -0,0 +1,145 @@
+package protection
+
+import (
+	"backend/src/tokens"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/bits"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrChallengeFailed is returned when a request does not pass the challenge
+var ErrChallengeFailed = errors.New("challenge failed")
+
+// ChallengeVerifier decides whether a request comes from a human client.
+// Implementations can call out to external services such as a captcha
+// provider; the built-in ones need none.
+type ChallengeVerifier interface {
+	Verify(ctx context.Context, r *http.Request) error
+}
+
+// NoChallenge accepts every request
+type NoChallenge struct{}
+
+func (NoChallenge) Verify(ctx context.Context, r *http.Request) error {
+	return nil
+}
+
+// Honeypot rejects requests that fill in a field hidden from humans, in
+// either a form or a JSON body
+type Honeypot struct {
+	Field string
+}
+
+func (h Honeypot) Verify(ctx context.Context, r *http.Request) error {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		// Read the body and put it back for the handler
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			return err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(body, &fields) == nil {
+			if value, ok := fields[h.Field]; ok && string(value) != `""` && string(value) != "null" {
+				return ErrChallengeFailed
+			}
+		}
+		return nil
+	}
+	if err := r.ParseMultipartForm(10 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return err
+	}
+	if r.FormValue(h.Field) != "" {
+		return ErrChallengeFailed
+	}
+	return nil
+}
+
+const powPurpose = "pow"
+
+// ProofOfWork requires the client to find a nonce such that
+// sha256(challenge + nonce) starts with Difficulty zero bits. Challenges are
+// signed tokens from Issue, sent back in the X-Challenge header with the
+// nonce in X-Challenge-Nonce. Each solved challenge is accepted once.
+type ProofOfWork struct {
+	Tokens     *tokens.Signer
+	Difficulty int
+	TTL        time.Duration
+	// Used remembers solved challenges as buckets holding a single token,
+	// which only comes back once the challenge has expired
+	Used Store
+}
+
+func NewProofOfWork(signer *tokens.Signer, difficulty int, used Store) *ProofOfWork {
+	return &ProofOfWork{Tokens: signer, Difficulty: difficulty, TTL: 5 * time.Minute, Used: used}
+}
+
+// Challenge is handed to clients to solve
+type Challenge struct {
+	Challenge  string    `json:"challenge"`
+	Difficulty int       `json:"difficulty"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+func (p *ProofOfWork) Issue() (Challenge, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return Challenge{}, err
+	}
+	token, err := p.Tokens.Sign(powPurpose, hex.EncodeToString(nonce), "", p.TTL)
+	if err != nil {
+		return Challenge{}, err
+	}
+	return Challenge{Challenge: token, Difficulty: p.Difficulty, ExpiresAt: time.Now().Add(p.TTL)}, nil
+}
+
+func (p *ProofOfWork) Verify(ctx context.Context, r *http.Request) error {
+	challenge := r.Header.Get("X-Challenge")
+	nonce := r.Header.Get("X-Challenge-Nonce")
+	claims, err := p.Tokens.Verify(challenge, powPurpose)
+	if err != nil || nonce == "" || leadingZeroBits(challenge+nonce) < p.Difficulty {
+		return ErrChallengeFailed
+	}
+
+	first, _, err := p.Used.Take(ctx, powPurpose+":"+claims.Subject, Rate{Burst: 1, Per: p.TTL})
+	if err != nil {
+		return err
+	}
+	if !first {
+		return ErrChallengeFailed
+	}
+	return nil
+}
+
+func leadingZeroBits(s string) int {
+	sum := sha256.Sum256([]byte(s))
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// ServeChallenge hands out a new proof-of-work challenge
+func (p *ProofOfWork) ServeChallenge(w http.ResponseWriter, r *http.Request) {
+	challenge, err := p.Issue()
+	if err != nil {
+		http.Error(w, "Could not issue challenge", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(challenge)
+}
@@ -0,0 +1,200 @@
+package protection
+
+import (
+	"backend/src/config"
+	"backend/src/models"
+	"backend/src/repository"
+	"backend/src/tokens"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Guard protects the public write endpoints with a challenge and a per-IP
+// rate limit
+type Guard struct {
+	Challenge ChallengeVerifier
+	IPLimiter *Limiter
+	Proxies   TrustedProxies
+}
+
+// Limiters are the rate limits applied by handlers rather than the guard
+type Limiters struct {
+	Email   *Limiter // per participant email
+	Login   *Limiter // per admin username and client IP
+	LoginIP *Limiter // admin sign-ins per client IP
+}
+
+// New builds the guard, the handlers' limiters and, when configured, the
+// proof-of-work challenge whose issuing endpoint must be routed
+func New(cfg config.Protection, limits repository.RateLimitRepository, signer *tokens.Signer) (Guard, Limiters, *ProofOfWork, error) {
+	ipRate, err := ParseRate(cfg.RateLimitIP)
+	if err != nil {
+		return Guard{}, Limiters{}, nil, fmt.Errorf("protection.rateLimitIp: %w", err)
+	}
+	emailRate, err := ParseRate(cfg.RateLimitEmail)
+	if err != nil {
+		return Guard{}, Limiters{}, nil, fmt.Errorf("protection.rateLimitEmail: %w", err)
+	}
+	loginRate, err := ParseRate(cfg.RateLimitLogin)
+	if err != nil {
+		return Guard{}, Limiters{}, nil, fmt.Errorf("protection.rateLimitLogin: %w", err)
+	}
+	loginIPRate, err := ParseRate(cfg.RateLimitLoginIP)
+	if err != nil {
+		return Guard{}, Limiters{}, nil, fmt.Errorf("protection.rateLimitLoginIp: %w", err)
+	}
+
+	proxies, err := ParseTrustedProxies(cfg.TrustProxy, cfg.TrustedProxies)
+	if err != nil {
+		return Guard{}, Limiters{}, nil, fmt.Errorf("protection.trustedProxies: %w", err)
+	}
+
+	var store Store = NewMemoryStore()
+	if cfg.RateLimitStore == "database" || cfg.RateLimitStore == "mongo" {
+		store = SharedStore{Limits: limits}
+	}
+	guard := Guard{
+		Challenge: NoChallenge{},
+		IPLimiter: &Limiter{Store: store, Rate: ipRate, Prefix: "ip"},
+		Proxies:   proxies,
+	}
+	limiters := Limiters{
+		Email:   &Limiter{Store: store, Rate: emailRate, Prefix: "email"},
+		Login:   &Limiter{Store: store, Rate: loginRate, Prefix: "login"},
+		LoginIP: &Limiter{Store: store, Rate: loginIPRate, Prefix: "login-ip"},
+	}
+
+	var pow *ProofOfWork
+	switch cfg.Challenge {
+	case "pow":
+		// Solved challenges are remembered where the rate limits are, so
+		// that a solution is accepted once across instances too
+		pow = NewProofOfWork(signer, cfg.PowDifficulty, store)
+		guard.Challenge = pow
+	case "honeypot":
+		guard.Challenge = Honeypot{Field: cfg.HoneypotField}
+	}
+	return guard, limiters, pow, nil
+}
+
+// Protect rejects requests over the per-IP limit with 429 and requests that
+// fail the challenge with 403. Rate limit store errors let requests through.
+func (g Guard) Protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if g.IPLimiter != nil {
+			allowed, retryAfter, err := g.IPLimiter.Allow(r.Context(), ClientIP(r, g.Proxies))
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Rate limiter error", "error", err)
+			} else if !allowed {
+				WriteTooManyRequests(w, retryAfter)
+				return
+			}
+		}
+		if err := g.Challenge.Verify(r.Context(), r); err != nil {
+			if !errors.Is(err, ErrChallengeFailed) {
+				slog.ErrorContext(r.Context(), "Challenge error", "error", err)
+			}
+			writeJSON(w, http.StatusForbidden, models.Error{Message: "Request could not be verified", Code: "challenge_failed"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// TrustedProxies are the proxies whose X-Forwarded-For entries are believed.
+// The zero value trusts none.
+type TrustedProxies struct {
+	Enabled  bool
+	Prefixes []netip.Prefix // empty trusts only the direct peer
+}
+
+// ParseTrustedProxies reads a comma-separated list of addresses and CIDR
+// ranges. An empty list with trust enabled trusts only the direct peer.
+func ParseTrustedProxies(trust bool, list string) (TrustedProxies, error) {
+	proxies := TrustedProxies{Enabled: trust}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				return TrustedProxies{}, fmt.Errorf("invalid address or range %q", entry)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		proxies.Prefixes = append(proxies.Prefixes, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+func (p TrustedProxies) trusts(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range p.Prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP is the address of the client. Behind trusted proxies it is the
+// rightmost X-Forwarded-For entry that no trusted proxy added, since a
+// client can put anything to the left of what its first proxy appends.
+func ClientIP(r *http.Request, proxies TrustedProxies) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if !proxies.Enabled || (len(proxies.Prefixes) > 0 && !proxies.trusts(peer)) {
+		return peer
+	}
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if len(proxies.Prefixes) == 0 || !proxies.trusts(hops[i]) {
+			return hops[i]
+		}
+	}
+	// Every hop is a trusted proxy
+	if len(hops) > 0 {
+		return hops[0]
+	}
+	return peer
+}
+
+// RetryAfterSeconds rounds a wait up to the whole seconds of a Retry-After header
+func RetryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int((d + time.Second - 1) / time.Second))
+}
+
+func WriteTooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", RetryAfterSeconds(retryAfter))
+	writeJSON(w, http.StatusTooManyRequests, models.Error{Message: "Too many requests, please try again later", Code: "rate_limited"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
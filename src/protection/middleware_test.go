@@ -0,0 +1,58 @@
+package protection
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies(true, "10.0.0.0/8, 192.0.2.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	anyPeer, _ := ParseTrustedProxies(true, "")
+	for _, tt := range []struct {
+		name      string
+		proxies   TrustedProxies
+		peer      string
+		forwarded []string
+		want      string
+	}{
+		{"trust off", TrustedProxies{}, "203.0.113.5:1234", []string{"198.51.100.1"}, "203.0.113.5"},
+		{"untrusted peer", proxies, "203.0.113.5:1234", []string{"198.51.100.1"}, "203.0.113.5"},
+		{"no header", proxies, "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"one proxy", proxies, "10.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		// A client can prepend anything; only what the proxies added counts
+		{"spoofed", proxies, "10.0.0.1:1234", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"proxy chain", proxies, "10.0.0.1:1234", []string{"1.2.3.4, 198.51.100.1, 192.0.2.10, 10.1.2.3"}, "198.51.100.1"},
+		{"repeated header", proxies, "10.0.0.1:1234", []string{"1.2.3.4", "198.51.100.1, 10.1.2.3"}, "198.51.100.1"},
+		{"all trusted", proxies, "10.0.0.1:1234", []string{"10.0.0.7, 10.0.0.8"}, "10.0.0.7"},
+		{"direct peer only", anyPeer, "203.0.113.5:1234", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.peer
+		for _, header := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", header)
+		}
+		if got := ClientIP(r, tt.proxies); got != tt.want {
+			t.Errorf("%s: ClientIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	for _, tt := range []struct {
+		list string
+		ok   bool
+	}{
+		{"", true},
+		{"10.0.0.1", true},
+		{"10.0.0.0/8, ::1, fd00::/8", true},
+		{"10.0.0.0/33", false},
+		{"proxy.internal", false},
+	} {
+		if _, err := ParseTrustedProxies(true, tt.list); (err == nil) != tt.ok {
+			t.Errorf("ParseTrustedProxies(%q) err = %v, want ok %v", tt.list, err, tt.ok)
+		}
+	}
+}
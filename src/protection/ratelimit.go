@@ -0,0 +1,137 @@
+package protection
+
+import (
+	"backend/src/repository"
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate is a token bucket: Burst requests at once, refilled at Burst per Per
+type Rate struct {
+	Burst int
+	Per   time.Duration
+}
+
+// ParseRate reads rates such as "30/h", "5/m" or "100/24h"
+func ParseRate(s string) (Rate, error) {
+	count, period, found := strings.Cut(s, "/")
+	n, err := strconv.Atoi(count)
+	if !found || err != nil || n <= 0 {
+		return Rate{}, fmt.Errorf("invalid rate %q", s)
+	}
+	switch period {
+	case "s", "m", "h":
+		period = "1" + period
+	}
+	per, err := time.ParseDuration(period)
+	if err != nil || per <= 0 {
+		return Rate{}, fmt.Errorf("invalid rate %q", s)
+	}
+	return Rate{Burst: n, Per: per}, nil
+}
+
+func (r Rate) perSecond() float64 {
+	return float64(r.Burst) / r.Per.Seconds()
+}
+
+// retryAfter is how long until the bucket holds a whole token again
+func (r Rate) retryAfter(tokens float64) time.Duration {
+	return time.Duration(math.Ceil((1 - tokens) / r.perSecond() * float64(time.Second)))
+}
+
+// refilledAt is when a bucket holding tokens is full again
+func (r Rate) refilledAt(now time.Time, tokens float64) time.Time {
+	return now.Add(time.Duration(math.Ceil((float64(r.Burst) - tokens) / r.perSecond() * float64(time.Second))))
+}
+
+// Store keeps token buckets. Take removes one token from the bucket for key
+// if one is available and returns the tokens left.
+type Store interface {
+	Take(ctx context.Context, key string, rate Rate) (allowed bool, tokens float64, err error)
+}
+
+// Limiter applies a rate to keys, such as client IPs or emails
+type Limiter struct {
+	Store  Store
+	Rate   Rate
+	Prefix string
+}
+
+// Allow takes a token for key and, when refused, reports how long to wait
+func (l Limiter) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	allowed, tokens, err := l.Store.Take(ctx, l.Prefix+":"+key, l.Rate)
+	if err != nil || allowed {
+		return allowed, 0, err
+	}
+	return false, l.Rate.retryAfter(tokens), nil
+}
+
+// MemoryStore keeps buckets in process, for single-instance deployments
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time // when the bucket has refilled, under its own rate
+}
+
+// sweepInterval is how often buckets that have refilled are dropped
+const sweepInterval = time.Minute
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}}
+}
+
+func (m *MemoryStore) Take(ctx context.Context, key string, rate Rate) (bool, float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.sweep(now)
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rate.Burst), updated: now}
+		m.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(rate.Burst), b.tokens+now.Sub(b.updated).Seconds()*rate.perSecond())
+	b.updated = now
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	b.full = rate.refilledAt(now, b.tokens)
+	return allowed, b.tokens, nil
+}
+
+// sweep drops buckets that have refilled completely, which behave like
+// missing ones. Each bucket keeps its own refill time, since the limiters
+// sharing the store have different rates.
+func (m *MemoryStore) sweep(now time.Time) {
+	if now.Sub(m.swept) < sweepInterval {
+		return
+	}
+	m.swept = now
+	for key, b := range m.buckets {
+		if !now.Before(b.full) {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+// SharedStore keeps buckets in the database, shared by every instance
+type SharedStore struct {
+	Limits repository.RateLimitRepository
+}
+
+func (s SharedStore) Take(ctx context.Context, key string, rate Rate) (bool, float64, error) {
+	return s.Limits.TakeRateLimitToken(ctx, key, float64(rate.Burst), rate.perSecond())
+}
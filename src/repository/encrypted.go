@@ -0,0 +1,255 @@
+package repository
+
+import (
+	"backend/src/models"
+	"backend/src/pii"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// resealBatch is how many participants Reseal reads per query
+const resealBatch = 200
+
+// Encrypted stores participants' emails and phones encrypted with a
+// pii.Box, each bound to its participant and field, along with a blind
+// index of the email for lookups, and decrypts them on the way out.
+// Plaintext rows written before encryption was enabled still read and match
+// until Reseal rewrites them.
+type Encrypted struct {
+	Store
+	Box *pii.Box
+}
+
+func NewEncrypted(inner Store, box *pii.Box) *Encrypted {
+	return &Encrypted{Store: inner, Box: box}
+}
+
+func fieldContext(pid int, field string) string {
+	return pii.FieldContext("participants", pid, field)
+}
+
+// seal needs the participant's PID, which its values are bound to
+func (e *Encrypted) seal(p models.Participant) (models.Participant, error) {
+	p.EmailIndex = e.Box.Index(p.Email)
+	var err error
+	if p.Email, err = e.Box.Encrypt(p.Email, fieldContext(p.PID, "email")); err != nil {
+		return p, err
+	}
+	p.Phone, err = e.Box.Encrypt(p.Phone, fieldContext(p.PID, "phone"))
+	return p, err
+}
+
+func (e *Encrypted) open(p models.Participant) (models.Participant, error) {
+	var err error
+	if p.Email, err = e.Box.Decrypt(p.Email, fieldContext(p.PID, "email")); err != nil {
+		return p, fmt.Errorf("participant %d email: %w", p.PID, err)
+	}
+	if p.Phone, err = e.Box.Decrypt(p.Phone, fieldContext(p.PID, "phone")); err != nil {
+		return p, fmt.Errorf("participant %d phone: %w", p.PID, err)
+	}
+	return p, nil
+}
+
+func (e *Encrypted) openAll(participants []models.Participant, err error) ([]models.Participant, error) {
+	if err != nil {
+		return nil, err
+	}
+	for i := range participants {
+		if participants[i], err = e.open(participants[i]); err != nil {
+			return nil, err
+		}
+	}
+	return participants, nil
+}
+
+func (e *Encrypted) CreateParticipant(ctx context.Context, participant models.Participant) (int, error) {
+	pids, err := e.CreateParticipants(ctx, []models.Participant{participant})
+	if err != nil {
+		return 0, err
+	}
+	return pids[0], nil
+}
+
+// CreateParticipants stores the group without emails and phones, since
+// they are sealed for PIDs the store assigns, and then writes them in the
+// same transaction. What the store fills in, such as PIDs and rerolled
+// codes, is copied back to the plaintext participants.
+func (e *Encrypted) CreateParticipants(ctx context.Context, participants []models.Participant) ([]int, error) {
+	stored := make([]models.Participant, len(participants))
+	for i, participant := range participants {
+		participant.EmailIndex = e.Box.Index(participant.Email)
+		participant.Email, participant.Phone = "", ""
+		stored[i] = participant
+	}
+	var pids []int
+	err := e.Store.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if pids, err = e.Store.CreateParticipants(ctx, stored); err != nil {
+			return err
+		}
+		for i := range stored {
+			stored[i].Email, stored[i].Phone = participants[i].Email, participants[i].Phone
+			if err := e.ResealParticipant(ctx, stored[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	copy(participants, stored)
+	return pids, nil
+}
+
+func (e *Encrypted) GetParticipant(ctx context.Context, pid int) (models.Participant, error) {
+	participant, err := e.Store.GetParticipant(ctx, pid)
+	if err != nil {
+		return participant, err
+	}
+	return e.open(participant)
+}
+
+func (e *Encrypted) GetParticipantByCode(ctx context.Context, eventID models.ID, code string) (models.Participant, error) {
+	participant, err := e.Store.GetParticipantByCode(ctx, eventID, code)
+	if err != nil {
+		return participant, err
+	}
+	return e.open(participant)
+}
+
+func (e *Encrypted) GetParticipants(ctx context.Context, pids []int) ([]models.Participant, error) {
+	return e.openAll(e.Store.GetParticipants(ctx, pids))
+}
+
+// GetParticipantsByEmail matches the blind index, and the plaintext email
+// of rows not resealed yet
+func (e *Encrypted) GetParticipantsByEmail(ctx context.Context, eventID models.ID, email string) ([]models.Participant, error) {
+	return e.byEmail(email,
+		func() ([]models.Participant, error) { return e.Store.GetParticipantsByEmail(ctx, eventID, email) },
+		func(index string) ([]models.Participant, error) {
+			return e.Store.GetParticipantsByEmailIndex(ctx, eventID, index)
+		},
+	)
+}
+
+// FindParticipantsByEmail matches like GetParticipantsByEmail
+func (e *Encrypted) FindParticipantsByEmail(ctx context.Context, email string) ([]models.Participant, error) {
+	return e.byEmail(email,
+		func() ([]models.Participant, error) { return e.Store.FindParticipantsByEmail(ctx, email) },
+		func(index string) ([]models.Participant, error) {
+			return e.Store.FindParticipantsByEmailIndex(ctx, index)
+		},
+	)
+}
+
+// byEmail merges the participants found by plaintext email and by the
+// email's blind index, in PID order
+func (e *Encrypted) byEmail(email string, plain func() ([]models.Participant, error), indexed func(index string) ([]models.Participant, error)) ([]models.Participant, error) {
+	participants, err := plain()
+	if err != nil {
+		return nil, err
+	}
+	if index := e.Box.Index(email); index != "" {
+		found, err := indexed(index)
+		if err != nil {
+			return nil, err
+		}
+		for _, participant := range found {
+			if !slices.ContainsFunc(participants, func(p models.Participant) bool { return p.PID == participant.PID }) {
+				participants = append(participants, participant)
+			}
+		}
+		slices.SortFunc(participants, func(a, b models.Participant) int { return a.PID - b.PID })
+	}
+	return e.openAll(participants, nil)
+}
+
+func (e *Encrypted) GetParticipantsByEmailIndex(ctx context.Context, eventID models.ID, index string) ([]models.Participant, error) {
+	return e.openAll(e.Store.GetParticipantsByEmailIndex(ctx, eventID, index))
+}
+
+func (e *Encrypted) FindParticipantsByEmailIndex(ctx context.Context, index string) ([]models.Participant, error) {
+	return e.openAll(e.Store.FindParticipantsByEmailIndex(ctx, index))
+}
+
+func (e *Encrypted) ScanParticipants(ctx context.Context, afterPID, limit int) ([]models.Participant, error) {
+	return e.openAll(e.Store.ScanParticipants(ctx, afterPID, limit))
+}
+
+func (e *Encrypted) ParticipantsToAnonymize(ctx context.Context, eventIDs []models.ID, deletedBefore time.Time, limit int) ([]models.Participant, error) {
+	return e.openAll(e.Store.ParticipantsToAnonymize(ctx, eventIDs, deletedBefore, limit))
+}
+
+// UpdateParticipant encrypts a new email or phone and updates the email's
+// index
+func (e *Encrypted) UpdateParticipant(ctx context.Context, pid int, update ParticipantUpdate) error {
+	if update.Email != nil {
+		index := e.Box.Index(*update.Email)
+		update.EmailIndex = &index
+	}
+	var err error
+	if update.Email, err = e.sealField(update.Email, fieldContext(pid, "email")); err != nil {
+		return err
+	}
+	if update.Phone, err = e.sealField(update.Phone, fieldContext(pid, "phone")); err != nil {
+		return err
+	}
+	return e.Store.UpdateParticipant(ctx, pid, update)
+}
+
+// sealField encrypts a field of an update, leaving nil alone
+func (e *Encrypted) sealField(plain *string, context string) (*string, error) {
+	if plain == nil {
+		return nil, nil
+	}
+	encrypted, err := e.Box.Encrypt(*plain, context)
+	if err != nil {
+		return nil, err
+	}
+	return &encrypted, nil
+}
+
+func (e *Encrypted) ResealParticipant(ctx context.Context, participant models.Participant) error {
+	sealed, err := e.seal(participant)
+	if err != nil {
+		return err
+	}
+	return e.Store.ResealParticipant(ctx, sealed)
+}
+
+// Reseal encrypts plaintext rows with the current key, re-encrypts rows
+// sealed with an older one or without their context and recomputes stale
+// blind indexes, returning
+// how many participants it rewrote. Once it has run, keys other than the
+// current one can be removed.
+func (e *Encrypted) Reseal(ctx context.Context) (int, error) {
+	resealed, after := 0, 0
+	for {
+		// Read through the inner store so stale values can be recognized
+		participants, err := e.Store.ScanParticipants(ctx, after, resealBatch)
+		if err != nil {
+			return resealed, err
+		}
+		for _, stored := range participants {
+			after = stored.PID
+			participant, err := e.open(stored)
+			if err != nil {
+				return resealed, err
+			}
+			if !e.Box.Stale(stored.Email) && !e.Box.Stale(stored.Phone) &&
+				stored.EmailIndex == e.Box.Index(participant.Email) {
+				continue
+			}
+			if err := e.ResealParticipant(ctx, participant); err != nil {
+				return resealed, err
+			}
+			resealed++
+		}
+		if len(participants) < resealBatch {
+			return resealed, nil
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package repository_test
+
+import (
+	"backend/src/models"
+	"backend/src/pii"
+	"backend/src/repository"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func newBox(t *testing.T, keys ...string) *pii.Box {
+	t.Helper()
+	var parsed []pii.Key
+	for _, id := range keys {
+		parsed = append(parsed, pii.Key{ID: id, Secret: bytes.Repeat([]byte(id[:1]), 32)})
+	}
+	box, err := pii.New(parsed, bytes.Repeat([]byte("i"), 32))
+	if err != nil {
+		t.Fatalf("pii.New: %v", err)
+	}
+	return box
+}
+
+// legacySeal encrypts a value the way it was stored before fields were bound
+// to their participant, authenticated with the key ID only
+func legacySeal(t *testing.T, id, value string) string {
+	t.Helper()
+	block, _ := aes.NewCipher(bytes.Repeat([]byte(id[:1]), 32))
+	aead, _ := cipher.NewGCM(block)
+	nonce := make([]byte, aead.NonceSize())
+	return "enc:" + id + ":" + base64.RawStdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(value), []byte(id)))
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	inner := repository.NewMemory()
+	store := repository.NewEncrypted(inner, newBox(t, "k1"))
+	event := models.NewID()
+
+	participants := []models.Participant{{EventID: event, Name: "Asha", Email: "asha@example.com", Phone: "+919876543210"}}
+	if _, err := store.CreateParticipants(ctx, participants); err != nil {
+		t.Fatalf("CreateParticipants: %v", err)
+	}
+	pid := participants[0].PID
+	if participants[0].Email != "asha@example.com" || pid == 0 {
+		t.Errorf("CreateParticipants left %+v", participants[0])
+	}
+
+	stored, _ := inner.GetParticipant(ctx, pid)
+	if !strings.HasPrefix(stored.Email, "enc2:k1:") || !strings.HasPrefix(stored.Phone, "enc2:k1:") || stored.EmailIndex == "" {
+		t.Errorf("stored in plaintext: %+v", stored)
+	}
+	got, err := store.GetParticipantsByEmail(ctx, event, "Asha@Example.com")
+	if err != nil || len(got) != 1 || got[0].Email != "asha@example.com" || got[0].Phone != "+919876543210" {
+		t.Errorf("GetParticipantsByEmail = %+v, %v", got, err)
+	}
+
+	// A sealed value only opens for the participant and field it was sealed for
+	other, _ := store.CreateParticipant(ctx, models.Participant{EventID: event, Email: "ravi@example.com"})
+	copied := stored.Email
+	if err := inner.UpdateParticipant(ctx, other, repository.ParticipantUpdate{Email: &copied}); err != nil {
+		t.Fatalf("UpdateParticipant: %v", err)
+	}
+	if got, err := store.GetParticipant(ctx, other); err == nil {
+		t.Errorf("value copied to another participant decrypted: %+v", got)
+	}
+
+	email := "rao@example.com"
+	if err := store.UpdateParticipant(ctx, pid, repository.ParticipantUpdate{Email: &email}); err != nil {
+		t.Fatalf("UpdateParticipant: %v", err)
+	}
+	if got, _ := store.GetParticipantsByEmail(ctx, event, "rao@example.com"); len(got) != 1 {
+		t.Errorf("updated email not found by index: %+v", got)
+	}
+	if got, _ := store.GetParticipantsByEmail(ctx, event, "asha@example.com"); len(got) != 0 {
+		t.Errorf("old email still matches: %+v", got)
+	}
+}
+
+func TestEncryptedReseal(t *testing.T) {
+	ctx := context.Background()
+	inner := repository.NewMemory()
+	event := models.NewID()
+	legacy, _ := inner.CreateParticipant(ctx, models.Participant{EventID: event, Email: "old@example.com", Phone: "+911111111111"})
+	sealed, _ := inner.CreateParticipant(ctx, models.Participant{EventID: event, Email: legacySeal(t, "k1", "sealed@example.com")})
+	old := repository.NewEncrypted(inner, newBox(t, "k1"))
+	rotated, _ := old.CreateParticipant(ctx, models.Participant{EventID: event, Email: "new@example.com"})
+	if err := inner.DeleteParticipant(ctx, rotated); err != nil {
+		t.Fatalf("DeleteParticipant: %v", err)
+	}
+
+	store := repository.NewEncrypted(inner, newBox(t, "k2", "k1"))
+	if got, _ := store.GetParticipantsByEmail(ctx, event, "old@example.com"); len(got) != 1 {
+		t.Errorf("plaintext participant not found before reseal: %+v", got)
+	}
+	n, err := store.Reseal(ctx)
+	if err != nil || n != 3 {
+		t.Fatalf("Reseal = %d, %v, want 3", n, err)
+	}
+	if n, err := store.Reseal(ctx); err != nil || n != 0 {
+		t.Errorf("second Reseal = %d, %v, want 0", n, err)
+	}
+
+	// Only the current key is needed from now on
+	current := repository.NewEncrypted(inner, newBox(t, "k2"))
+	if err := inner.RestoreParticipant(ctx, rotated); err != nil {
+		t.Fatalf("RestoreParticipant: %v", err)
+	}
+	for pid, email := range map[int]string{legacy: "old@example.com", sealed: "sealed@example.com", rotated: "new@example.com"} {
+		got, err := current.GetParticipant(ctx, pid)
+		if err != nil || got.Email != email {
+			t.Errorf("GetParticipant(%d) = %+v, %v", pid, got, err)
+		}
+		if stored, _ := inner.GetParticipant(ctx, pid); !strings.HasPrefix(stored.Email, "enc2:k2:") {
+			t.Errorf("participant %d not resealed: %q", pid, stored.Email)
+		}
+	}
+}
@@ -0,0 +1,1011 @@
+package repository
+
+import (
+	"backend/src/codes"
+	"backend/src/models"
+	"bytes"
+	"context"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory is a thread-safe in-memory Store for tests and local development.
+// Slices are kept in creation order.
+type Memory struct {
+	mu            sync.Mutex
+	tx            sync.Mutex // held by the running transaction
+	seq           int
+	participants  map[int]models.Participant
+	registrations []models.Registration
+	events        []models.Event
+	uploads       []models.Upload
+	otps          []models.OTP
+	refunds       []models.Refund
+	audit         []models.AuditEntry
+	admins        []models.AdminUser
+	buckets       map[string]memoryBucket // not rolled back, like a sequence
+	bucketsSwept  time.Time
+	locks         map[string]memoryLock // not rolled back either
+}
+
+type memoryLock struct {
+	owner   string
+	expires time.Time
+}
+
+type memoryBucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{participants: map[int]models.Participant{}, buckets: map[string]memoryBucket{}, locks: map[string]memoryLock{}}
+}
+
+var _ Store = (*Memory)(nil)
+
+func (m *Memory) GetNextPID(ctx context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.nextPID(), nil
+}
+
+func (m *Memory) nextPID() int {
+	m.seq++
+	return m.seq
+}
+
+func (m *Memory) CreateParticipant(ctx context.Context, participant models.Participant) (int, error) {
+	pids, err := m.CreateParticipants(ctx, []models.Participant{participant})
+	if err != nil {
+		return 0, err
+	}
+	return pids[0], nil
+}
+
+func (m *Memory) CreateParticipants(ctx context.Context, participants []models.Participant) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pids := []int{}
+	for i := range participants {
+		for participants[i].Code != "" && m.codeTaken(participants[i].EventID, participants[i].Code) {
+			participants[i].Code = codes.Reroll(participants[i].Code)
+		}
+		participants[i].PID = m.nextPID()
+		participant := participants[i]
+		participant.ID = models.NewID()
+		participant.CreatedAt = time.Now()
+		participant.UpdatedAt = time.Now()
+		m.participants[participant.PID] = participant
+		pids = append(pids, participant.PID)
+	}
+	return pids, nil
+}
+
+func (m *Memory) codeTaken(eventID models.ID, code string) bool {
+	for _, participant := range m.participants {
+		if participant.EventID == eventID && participant.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Memory) GetParticipantByCode(ctx context.Context, eventID models.ID, code string) (models.Participant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, participant := range m.participants {
+		if participant.EventID == eventID && participant.Code == code && participant.DeletedAt == nil {
+			return participant, nil
+		}
+	}
+	return models.Participant{}, ErrNotFound
+}
+
+func (m *Memory) GetParticipant(ctx context.Context, pid int) (models.Participant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	participant, ok := m.participants[pid]
+	if !ok || participant.DeletedAt != nil {
+		return models.Participant{}, ErrNotFound
+	}
+	return participant, nil
+}
+
+func (m *Memory) GetParticipants(ctx context.Context, pids []int) ([]models.Participant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	participants := []models.Participant{}
+	for pid, participant := range m.participants {
+		if slices.Contains(pids, pid) && participant.DeletedAt == nil {
+			participants = append(participants, participant)
+		}
+	}
+	slices.SortFunc(participants, func(a, b models.Participant) int { return a.PID - b.PID })
+	return participants, nil
+}
+
+func (m *Memory) GetParticipantsByEmail(ctx context.Context, eventID models.ID, email string) ([]models.Participant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	participants := []models.Participant{}
+	for _, participant := range m.participants {
+		if participant.EventID == eventID && strings.EqualFold(participant.Email, email) && participant.DeletedAt == nil {
+			participants = append(participants, participant)
+		}
+	}
+	slices.SortFunc(participants, func(a, b models.Participant) int { return a.PID - b.PID })
+	return participants, nil
+}
+
+func (m *Memory) GetParticipantsByEmailIndex(ctx context.Context, eventID models.ID, index string) ([]models.Participant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	participants := []models.Participant{}
+	for _, participant := range m.participants {
+		if participant.EventID == eventID && participant.EmailIndex == index && participant.DeletedAt == nil {
+			participants = append(participants, participant)
+		}
+	}
+	slices.SortFunc(participants, func(a, b models.Participant) int { return a.PID - b.PID })
+	return participants, nil
+}
+
+func (m *Memory) FindParticipantsByEmail(ctx context.Context, email string) ([]models.Participant, error) {
+	return m.findParticipants(func(p models.Participant) bool { return strings.EqualFold(p.Email, email) }), nil
+}
+
+func (m *Memory) FindParticipantsByEmailIndex(ctx context.Context, index string) ([]models.Participant, error) {
+	return m.findParticipants(func(p models.Participant) bool { return p.EmailIndex == index }), nil
+}
+
+// findParticipants returns the participants matching, deleted or not, by PID
+func (m *Memory) findParticipants(match func(models.Participant) bool) []models.Participant {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	participants := []models.Participant{}
+	for _, participant := range m.participants {
+		if match(participant) {
+			participants = append(participants, participant)
+		}
+	}
+	slices.SortFunc(participants, func(a, b models.Participant) int { return a.PID - b.PID })
+	return participants
+}
+
+func (m *Memory) ScanParticipants(ctx context.Context, afterPID, limit int) ([]models.Participant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	participants := []models.Participant{}
+	for pid, participant := range m.participants {
+		if pid > afterPID {
+			participants = append(participants, participant)
+		}
+	}
+	slices.SortFunc(participants, func(a, b models.Participant) int { return a.PID - b.PID })
+	if len(participants) > limit {
+		participants = participants[:limit]
+	}
+	return participants, nil
+}
+
+func (m *Memory) ResealParticipant(ctx context.Context, p models.Participant) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	participant, ok := m.participants[p.PID]
+	if !ok {
+		return ErrNotFound
+	}
+	participant.Email, participant.Phone = p.Email, p.Phone
+	participant.EmailIndex = p.EmailIndex
+	m.participants[p.PID] = participant
+	return nil
+}
+
+func (m *Memory) SetParticipantCode(ctx context.Context, pid int, code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	participant, ok := m.participants[pid]
+	if !ok || participant.Code != "" {
+		return ErrNotFound
+	}
+	if m.codeTaken(participant.EventID, code) {
+		return ErrDuplicate
+	}
+	participant.Code = code
+	m.participants[pid] = participant
+	return nil
+}
+
+func (m *Memory) UpdateParticipant(ctx context.Context, pid int, update ParticipantUpdate) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	participant, ok := m.participants[pid]
+	if !ok || participant.DeletedAt != nil {
+		return ErrNotFound
+	}
+	setIf(&participant.Name, update.Name)
+	setIf(&participant.Email, update.Email)
+	setIf(&participant.Phone, update.Phone)
+	setIf(&participant.EmailIndex, update.EmailIndex)
+	setIf(&participant.CollegeName, update.CollegeName)
+	setIf(&participant.YearOfStudy, update.YearOfStudy)
+	setIf(&participant.DualBoot, update.DualBoot)
+	participant.UpdatedAt = time.Now()
+	m.participants[pid] = participant
+	return nil
+}
+
+// setIf copies value to field unless it is nil
+func setIf[T any](field *T, value *T) {
+	if value != nil {
+		*field = *value
+	}
+}
+
+func (m *Memory) CountParticipants(ctx context.Context, eventID models.ID) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	for _, participant := range m.participants {
+		if participant.EventID == eventID && !participant.Cancelled && participant.DeletedAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *Memory) DeleteParticipant(ctx context.Context, pid int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	return m.setParticipantDeleted(pid, nil, &now)
+}
+
+func (m *Memory) RestoreParticipant(ctx context.Context, pid int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	participant, ok := m.participants[pid]
+	if !ok || participant.DeletedAt == nil {
+		return ErrNotFound
+	}
+	return m.setParticipantDeleted(pid, participant.DeletedAt, nil)
+}
+
+// setParticipantDeleted moves a participant from one deletion time to
+// another, where nil means not deleted
+func (m *Memory) setParticipantDeleted(pid int, from, to *time.Time) error {
+	participant, ok := m.participants[pid]
+	if !ok || !sameTime(participant.DeletedAt, from) {
+		return ErrNotFound
+	}
+	participant.DeletedAt = to
+	participant.UpdatedAt = time.Now()
+	m.participants[pid] = participant
+	return nil
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func (m *Memory) AnonymizeParticipants(ctx context.Context, pids []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.anonymizeParticipants(pids, time.Now())
+	return nil
+}
+
+func (m *Memory) anonymizeParticipants(pids []int, now time.Time) {
+	for _, pid := range pids {
+		if participant, ok := m.participants[pid]; ok {
+			participant.Name, participant.Email, participant.Phone = "", "", ""
+			participant.EmailIndex = ""
+			participant.AnonymizedAt = &now
+			participant.UpdatedAt = now
+			m.participants[pid] = participant
+		}
+	}
+}
+
+func (m *Memory) ParticipantsToAnonymize(ctx context.Context, eventIDs []models.ID, deletedBefore time.Time, limit int) ([]models.Participant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	participants := []models.Participant{}
+	for _, participant := range m.participants {
+		if participant.AnonymizedAt != nil {
+			continue
+		}
+		if slices.Contains(eventIDs, participant.EventID) || (participant.DeletedAt != nil && participant.DeletedAt.Before(deletedBefore)) {
+			participants = append(participants, participant)
+		}
+	}
+	slices.SortFunc(participants, func(a, b models.Participant) int { return a.PID - b.PID })
+	if len(participants) > limit {
+		participants = participants[:limit]
+	}
+	return participants, nil
+}
+
+func (m *Memory) ParticipantStats(ctx context.Context, eventID models.ID) ([]models.ParticipantStat, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := []models.ParticipantStat{}
+	for _, participant := range m.participants {
+		if participant.EventID != eventID || participant.DeletedAt != nil {
+			continue
+		}
+		i := slices.IndexFunc(stats, func(stat models.ParticipantStat) bool {
+			return stat.CollegeName == participant.CollegeName && stat.YearOfStudy == participant.YearOfStudy
+		})
+		if i < 0 {
+			stats = append(stats, models.ParticipantStat{CollegeName: participant.CollegeName, YearOfStudy: participant.YearOfStudy})
+			i = len(stats) - 1
+		}
+		stats[i].Count++
+	}
+	slices.SortFunc(stats, compareStats)
+	return stats, nil
+}
+
+// compareStats orders statistics by college, then year
+func compareStats(a, b models.ParticipantStat) int {
+	if c := strings.Compare(a.CollegeName, b.CollegeName); c != 0 {
+		return c
+	}
+	return a.YearOfStudy - b.YearOfStudy
+}
+
+func (m *Memory) CreateRegistration(ctx context.Context, reg models.Registration) (models.ID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.holdsTeamName(reg) {
+		return models.ID{}, ErrDuplicate
+	}
+	reg.ID = models.NewID()
+	reg.Participants = slices.Clone(reg.Participants)
+	reg.Cancelled = slices.Clone(reg.Cancelled)
+	reg.CreatedAt = time.Now()
+	reg.UpdatedAt = time.Now()
+	m.registrations = append(m.registrations, reg)
+	return reg.ID, nil
+}
+
+func (m *Memory) GetRegistration(ctx context.Context, id models.ID) (models.Registration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.registrationIndex(id)
+	if i < 0 || m.registrations[i].DeletedAt != nil {
+		return models.Registration{}, ErrNotFound
+	}
+	return cloneRegistration(m.registrations[i]), nil
+}
+
+func (m *Memory) registrationIndex(id models.ID) int {
+	return slices.IndexFunc(m.registrations, func(reg models.Registration) bool { return reg.ID == id })
+}
+
+func (m *Memory) GetRegistrationsByParticipants(ctx context.Context, pids []int) ([]models.Registration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	registrations := []models.Registration{}
+	for _, reg := range m.registrations {
+		if reg.DeletedAt == nil && slices.ContainsFunc(reg.Participants, func(pid int) bool { return slices.Contains(pids, pid) }) {
+			registrations = append(registrations, cloneRegistration(reg))
+		}
+	}
+	return registrations, nil
+}
+
+func (m *Memory) FindRegistrationsByParticipants(ctx context.Context, pids []int) ([]models.Registration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	registrations := []models.Registration{}
+	for _, reg := range m.registrations {
+		if slices.ContainsFunc(slices.Concat(reg.Participants, reg.Cancelled), func(pid int) bool { return slices.Contains(pids, pid) }) {
+			registrations = append(registrations, cloneRegistration(reg))
+		}
+	}
+	return registrations, nil
+}
+
+func (m *Memory) TeamNameTaken(ctx context.Context, eventID models.ID, teamNameKey string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.holdsTeamName(models.Registration{EventID: eventID, TeamNameKey: teamNameKey}), nil
+}
+
+// holdsTeamName reports whether another active registration of the event
+// uses the team name of reg, as the unique index of the other stores does
+func (m *Memory) holdsTeamName(reg models.Registration) bool {
+	if reg.TeamNameKey == "" || reg.Status == models.RegistrationCancelled {
+		return false
+	}
+	return slices.ContainsFunc(m.registrations, func(other models.Registration) bool {
+		return other.ID != reg.ID && other.EventID == reg.EventID && other.TeamNameKey == reg.TeamNameKey &&
+			other.Status != models.RegistrationCancelled && other.DeletedAt == nil
+	})
+}
+
+func (m *Memory) CancelParticipants(ctx context.Context, reg models.Registration, pids []int) (models.Registration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.registrationIndex(reg.ID)
+	if i < 0 || m.registrations[i].DeletedAt != nil {
+		return models.Registration{}, ErrNotFound
+	}
+	stored := &m.registrations[i]
+	for _, pid := range pids {
+		if !slices.Contains(stored.Participants, pid) {
+			return models.Registration{}, ErrNotFound
+		}
+	}
+
+	stored.Participants = slices.DeleteFunc(slices.Clone(stored.Participants), func(pid int) bool { return slices.Contains(pids, pid) })
+	stored.Cancelled = append(slices.Clone(stored.Cancelled), pids...)
+	stored.NumOfParticipants -= len(pids)
+	stored.UpdatedAt = time.Now()
+	if len(stored.Participants) == 0 {
+		stored.Status = models.RegistrationCancelled
+	}
+	for _, pid := range pids {
+		if participant, ok := m.participants[pid]; ok {
+			participant.Cancelled = true
+			participant.UpdatedAt = time.Now()
+			m.participants[pid] = participant
+		}
+	}
+	return cloneRegistration(*stored), nil
+}
+
+func (m *Memory) DeleteRegistration(ctx context.Context, id models.ID) error {
+	now := time.Now()
+	return m.setRegistrationDeleted(id, false, &now)
+}
+
+func (m *Memory) RestoreRegistration(ctx context.Context, id models.ID) error {
+	return m.setRegistrationDeleted(id, true, nil)
+}
+
+// setRegistrationDeleted deletes or restores a registration together with
+// the participants that share its deletion time
+func (m *Memory) setRegistrationDeleted(id models.ID, deleted bool, to *time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.registrationIndex(id)
+	if i < 0 || (m.registrations[i].DeletedAt != nil) != deleted {
+		return ErrNotFound
+	}
+	stored := &m.registrations[i]
+	if deleted && m.holdsTeamName(*stored) {
+		return ErrDuplicate
+	}
+	from := stored.DeletedAt
+	for _, pid := range slices.Concat(stored.Participants, stored.Cancelled) {
+		// Participants deleted on their own keep their own deletion
+		m.setParticipantDeleted(pid, from, to)
+	}
+	stored.DeletedAt = to
+	stored.UpdatedAt = time.Now()
+	return nil
+}
+
+func (m *Memory) RegistrationsToAnonymize(ctx context.Context, eventIDs []models.ID, deletedBefore time.Time, afterID models.ID, limit int) ([]models.Registration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	registrations := []models.Registration{}
+	for _, reg := range m.registrations {
+		if reg.AnonymizedAt != nil || bytes.Compare(reg.ID[:], afterID[:]) <= 0 {
+			continue
+		}
+		if slices.Contains(eventIDs, reg.EventID) || (reg.DeletedAt != nil && reg.DeletedAt.Before(deletedBefore)) {
+			registrations = append(registrations, cloneRegistration(reg))
+		}
+	}
+	slices.SortFunc(registrations, func(a, b models.Registration) int { return bytes.Compare(a.ID[:], b.ID[:]) })
+	if len(registrations) > limit {
+		registrations = registrations[:limit]
+	}
+	return registrations, nil
+}
+
+func (m *Memory) AnonymizeRegistration(ctx context.Context, id models.ID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.registrationIndex(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	now := time.Now()
+	stored := &m.registrations[i]
+	m.anonymizeParticipants(slices.Concat(stored.Participants, stored.Cancelled), now)
+	stored.TeamName, stored.TeamNameKey = "", ""
+	stored.TransactionID, stored.TransactionImage = "", ""
+	stored.AnonymizedAt = &now
+	stored.UpdatedAt = now
+	return nil
+}
+
+// cloneRegistration keeps callers from mutating stored slices
+func cloneRegistration(reg models.Registration) models.Registration {
+	reg.Participants = slices.Clone(reg.Participants)
+	reg.Cancelled = slices.Clone(reg.Cancelled)
+	return reg
+}
+
+type memoryTxKey struct{}
+
+// WithTransaction runs transactions one at a time and puts everything back
+// as it was when fn fails. Slices inside records are replaced rather than
+// modified, so shallow copies are enough to roll back.
+func (m *Memory) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(memoryTxKey{}) != nil {
+		return fn(ctx)
+	}
+	m.tx.Lock()
+	defer m.tx.Unlock()
+
+	m.mu.Lock()
+	snapshot := Memory{
+		seq:           m.seq,
+		participants:  maps.Clone(m.participants),
+		registrations: slices.Clone(m.registrations),
+		events:        slices.Clone(m.events),
+		uploads:       slices.Clone(m.uploads),
+		otps:          slices.Clone(m.otps),
+		refunds:       slices.Clone(m.refunds),
+		audit:         slices.Clone(m.audit),
+		admins:        slices.Clone(m.admins),
+	}
+	m.mu.Unlock()
+
+	err := fn(context.WithValue(ctx, memoryTxKey{}, true))
+	if err != nil {
+		m.mu.Lock()
+		// PIDs are never handed out twice, as with a sequence
+		m.participants, m.registrations = snapshot.participants, snapshot.registrations
+		m.events, m.uploads, m.otps = snapshot.events, snapshot.uploads, snapshot.otps
+		m.refunds, m.audit, m.admins = snapshot.refunds, snapshot.audit, snapshot.admins
+		m.mu.Unlock()
+	}
+	return err
+}
+
+func (m *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *Memory) CreateEvent(ctx context.Context, event models.Event) (models.ID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if slices.ContainsFunc(m.events, func(e models.Event) bool { return e.Slug == event.Slug }) {
+		return models.ID{}, ErrDuplicate
+	}
+	event.ID = models.NewID()
+	event.CreatedAt = time.Now()
+	event.UpdatedAt = event.CreatedAt
+	m.events = append(m.events, event)
+	return event.ID, nil
+}
+
+func (m *Memory) GetEventBySlug(ctx context.Context, slug string) (models.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.events, func(e models.Event) bool { return e.Slug == slug })
+	if i < 0 {
+		return models.Event{}, ErrNotFound
+	}
+	return m.events[i], nil
+}
+
+func (m *Memory) ListEvents(ctx context.Context) ([]models.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	events := append([]models.Event{}, m.events...)
+	slices.SortStableFunc(events, func(a, b models.Event) int { return b.StartsAt.Compare(a.StartsAt) })
+	return events, nil
+}
+
+func (m *Memory) UpdateEvent(ctx context.Context, event models.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.events, func(e models.Event) bool { return e.ID == event.ID })
+	if i < 0 {
+		return ErrNotFound
+	}
+	if slices.ContainsFunc(m.events, func(e models.Event) bool { return e.Slug == event.Slug && e.ID != event.ID }) {
+		return ErrDuplicate
+	}
+	event.UpdatedAt = time.Now()
+	m.events[i] = event
+	return nil
+}
+
+func (m *Memory) SetEventPaused(ctx context.Context, slug string, paused bool, resumesAt *time.Time) (models.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.events, func(e models.Event) bool { return e.Slug == slug })
+	if i < 0 {
+		return models.Event{}, ErrNotFound
+	}
+	m.events[i].Paused, m.events[i].ResumesAt = paused, resumesAt
+	m.events[i].UpdatedAt = time.Now()
+	return m.events[i], nil
+}
+
+func (m *Memory) AdoptOrphans(ctx context.Context, eventID models.ID) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for pid, participant := range m.participants {
+		if participant.EventID.IsZero() {
+			participant.EventID = eventID
+			m.participants[pid] = participant
+		}
+	}
+	adopted := 0
+	for i := range m.registrations {
+		if m.registrations[i].EventID.IsZero() {
+			m.registrations[i].EventID = eventID
+			adopted++
+		}
+	}
+	return adopted, nil
+}
+
+func (m *Memory) CreateUpload(ctx context.Context, upload models.Upload) (models.ID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	upload.ID = models.NewID()
+	upload.CreatedAt = time.Now()
+	m.uploads = append(m.uploads, upload)
+	return upload.ID, nil
+}
+
+func (m *Memory) ConsumeUpload(ctx context.Context, id, eventID models.ID) (models.Upload, error) {
+	return m.setUploadConsumed(id, eventID, true)
+}
+
+func (m *Memory) ReleaseUpload(ctx context.Context, id, eventID models.ID) error {
+	_, err := m.setUploadConsumed(id, eventID, false)
+	return err
+}
+
+func (m *Memory) setUploadConsumed(id, eventID models.ID, consumed bool) (models.Upload, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.uploads, func(u models.Upload) bool {
+		return u.ID == id && u.EventID == eventID && u.Consumed != consumed
+	})
+	if i < 0 {
+		return models.Upload{}, ErrNotFound
+	}
+	m.uploads[i].Consumed = consumed
+	return m.uploads[i], nil
+}
+
+func (m *Memory) ExpiredUploads(ctx context.Context, eventIDs []models.ID, createdBefore time.Time) ([]models.Upload, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	uploads := []models.Upload{}
+	for _, upload := range m.uploads {
+		if slices.Contains(eventIDs, upload.EventID) || (!upload.Consumed && upload.CreatedAt.Before(createdBefore)) {
+			uploads = append(uploads, upload)
+		}
+	}
+	return uploads, nil
+}
+
+func (m *Memory) DeleteUpload(ctx context.Context, id models.ID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.uploads = slices.DeleteFunc(m.uploads, func(u models.Upload) bool { return u.ID == id })
+	return nil
+}
+
+func (m *Memory) otpIndex(eventID models.ID, emailHash string) int {
+	return slices.IndexFunc(m.otps, func(o models.OTP) bool { return o.EventID == eventID && o.EmailHash == emailHash })
+}
+
+func (m *Memory) GetOTP(ctx context.Context, eventID models.ID, emailHash string) (models.OTP, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.otpIndex(eventID, emailHash)
+	if i < 0 {
+		return models.OTP{}, ErrNotFound
+	}
+	return m.otps[i], nil
+}
+
+func (m *Memory) SaveOTP(ctx context.Context, otp models.OTP) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	otp.CreatedAt = time.Now()
+	if i := m.otpIndex(otp.EventID, otp.EmailHash); i >= 0 {
+		otp.ID = m.otps[i].ID
+		m.otps[i] = otp
+		return nil
+	}
+	otp.ID = models.NewID()
+	m.otps = append(m.otps, otp)
+	return nil
+}
+
+func (m *Memory) ClaimOTPAttempt(ctx context.Context, eventID models.ID, emailHash string, maxAttempts int) (models.OTP, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.otpIndex(eventID, emailHash)
+	if i < 0 || m.otps[i].Attempts >= maxAttempts {
+		return models.OTP{}, ErrNotFound
+	}
+	m.otps[i].Attempts++
+	return m.otps[i], nil
+}
+
+func (m *Memory) DeleteOTP(ctx context.Context, id models.ID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.otps = slices.DeleteFunc(m.otps, func(o models.OTP) bool { return o.ID == id })
+	return nil
+}
+
+func (m *Memory) DeleteOTPsByEmailHash(ctx context.Context, emailHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.otps = slices.DeleteFunc(m.otps, func(o models.OTP) bool { return o.EmailHash == emailHash })
+	return nil
+}
+
+func (m *Memory) DeleteExpiredOTPs(ctx context.Context, now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	before := len(m.otps)
+	m.otps = slices.DeleteFunc(m.otps, func(o models.OTP) bool { return o.ExpiresAt.Before(now) })
+	return before - len(m.otps), nil
+}
+
+func (m *Memory) CreateRefund(ctx context.Context, refund models.Refund) (models.ID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	refund.ID = models.NewID()
+	refund.Participants = slices.Clone(refund.Participants)
+	refund.CreatedAt = time.Now()
+	refund.UpdatedAt = refund.CreatedAt
+	m.refunds = append(m.refunds, refund)
+	return refund.ID, nil
+}
+
+func (m *Memory) ListRefunds(ctx context.Context, filter RefundFilter) ([]models.Refund, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	refunds := []models.Refund{}
+	for _, refund := range m.refunds {
+		if filter.matches(refund) {
+			refunds = append(refunds, refund)
+		}
+	}
+	return refunds, nil
+}
+
+func (f RefundFilter) matches(refund models.Refund) bool {
+	return (f.EventID.IsZero() || refund.EventID == f.EventID) &&
+		(f.Status == "" || refund.Status == f.Status) &&
+		(f.RegistrationIDs == nil || slices.Contains(f.RegistrationIDs, refund.RegistrationID)) &&
+		(f.RequestedBy == "" || refund.RequestedBy == f.RequestedBy)
+}
+
+func (m *Memory) UpdateRefundStatus(ctx context.Context, id models.ID, from, status, reviewedBy, reference string) (models.Refund, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.refunds, func(r models.Refund) bool { return r.ID == id && r.Status == from })
+	if i < 0 {
+		return models.Refund{}, ErrNotFound
+	}
+	refund := &m.refunds[i]
+	refund.Status, refund.ReviewedBy = status, reviewedBy
+	if reference != "" {
+		refund.Reference = reference
+	}
+	refund.UpdatedAt = time.Now()
+	return *refund, nil
+}
+
+func (m *Memory) CreateAuditEntry(ctx context.Context, entry models.AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry.ID = models.NewID()
+	entry.CreatedAt = time.Now()
+	m.audit = append(m.audit, entry)
+	return nil
+}
+
+func (m *Memory) ListAuditEntries(ctx context.Context, filter AuditFilter, limit int) ([]models.AuditEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := []models.AuditEntry{}
+	for i := len(m.audit) - 1; i >= 0 && (limit == 0 || len(entries) < limit); i-- {
+		if filter.matches(m.audit[i]) {
+			entries = append(entries, m.audit[i])
+		}
+	}
+	return entries, nil
+}
+
+// EachAuditEntry calls fn on a copy of the matching entries, so fn may use
+// the store
+func (m *Memory) EachAuditEntry(ctx context.Context, filter AuditFilter, fn func(models.AuditEntry) error) error {
+	entries, _ := m.ListAuditEntries(ctx, filter, 0)
+	for _, entry := range entries {
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f AuditFilter) matches(entry models.AuditEntry) bool {
+	return (f.Actor == "" || entry.Actor == f.Actor) &&
+		(f.Action == "" || entry.Action == f.Action) &&
+		(f.TargetType == "" || entry.TargetType == f.TargetType) &&
+		(f.TargetIDs == nil || slices.Contains(f.TargetIDs, entry.TargetID)) &&
+		(f.EventID.IsZero() || entry.EventID == f.EventID) &&
+		(f.Since.IsZero() || !entry.CreatedAt.Before(f.Since)) &&
+		(f.Until.IsZero() || entry.CreatedAt.Before(f.Until)) &&
+		(f.Before.IsZero() || bytes.Compare(entry.ID[:], f.Before[:]) < 0)
+}
+
+func (m *Memory) RedactActor(ctx context.Context, actor, replacement string, pids []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.refunds {
+		if m.refunds[i].RequestedBy == actor {
+			m.refunds[i].RequestedBy = replacement
+		}
+	}
+	for i := range m.audit {
+		entry := &m.audit[i]
+		if entry.Actor == actor {
+			entry.Actor = replacement
+		}
+		if entry.TargetType == "participant" && slices.ContainsFunc(pids, func(pid int) bool { return strconv.Itoa(pid) == entry.TargetID }) {
+			entry.Changes = nil
+		}
+	}
+	return nil
+}
+
+func (m *Memory) PlaintextActors(ctx context.Context, limit int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	actors := []string{}
+	add := func(actor string) {
+		if len(actors) < limit && isPlaintextActor(actor) && !slices.Contains(actors, actor) {
+			actors = append(actors, actor)
+		}
+	}
+	for _, entry := range m.audit {
+		add(entry.Actor)
+	}
+	for _, refund := range m.refunds {
+		add(refund.RequestedBy)
+	}
+	return actors, nil
+}
+
+// isPlaintextActor matches participant actors holding an email address
+func isPlaintextActor(actor string) bool {
+	email, ok := strings.CutPrefix(actor, "participant:")
+	return ok && strings.Contains(email, "@")
+}
+
+func (m *Memory) CreateAdminUser(ctx context.Context, user models.AdminUser) (models.ID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if slices.ContainsFunc(m.admins, func(u models.AdminUser) bool { return u.Username == user.Username }) {
+		return models.ID{}, ErrDuplicate
+	}
+	user.ID = models.NewID()
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+	m.admins = append(m.admins, user)
+	return user.ID, nil
+}
+
+func (m *Memory) GetAdminUser(ctx context.Context, username string) (models.AdminUser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.admins, func(u models.AdminUser) bool { return u.Username == username })
+	if i < 0 {
+		return models.AdminUser{}, ErrNotFound
+	}
+	return m.admins[i], nil
+}
+
+func (m *Memory) ListAdminUsers(ctx context.Context) ([]models.AdminUser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	users := append([]models.AdminUser{}, m.admins...)
+	slices.SortFunc(users, func(a, b models.AdminUser) int { return strings.Compare(a.Username, b.Username) })
+	return users, nil
+}
+
+func (m *Memory) UpdateAdminUser(ctx context.Context, username string, update AdminUserUpdate, endSessions bool) (models.AdminUser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.admins, func(u models.AdminUser) bool { return u.Username == username })
+	if i < 0 {
+		return models.AdminUser{}, ErrNotFound
+	}
+	user := &m.admins[i]
+	setIf(&user.PasswordHash, update.PasswordHash)
+	setIf(&user.Role, update.Role)
+	setIf(&user.Disabled, update.Disabled)
+	if update.LastLoginAt != nil {
+		user.LastLoginAt = update.LastLoginAt
+	}
+	if endSessions {
+		user.SessionVersion++
+	}
+	user.UpdatedAt = time.Now()
+	return *user, nil
+}
+
+func (m *Memory) TakeRateLimitToken(ctx context.Context, key string, burst, perSecond float64) (bool, float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	b, ok := m.buckets[key]
+	if !ok {
+		b = memoryBucket{tokens: burst, updated: now}
+	}
+	b.tokens = min(burst, b.tokens+now.Sub(b.updated).Seconds()*perSecond)
+	b.updated = now
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	b.full = now.Add(time.Duration((burst - b.tokens) / perSecond * float64(time.Second)))
+	m.buckets[key] = b
+	// Buckets that have refilled behave like missing ones
+	if now.Sub(m.bucketsSwept) >= time.Minute {
+		m.bucketsSwept = now
+		for key, b := range m.buckets {
+			if !now.Before(b.full) {
+				delete(m.buckets, key)
+			}
+		}
+	}
+	return allowed, b.tokens, nil
+}
+
+func (m *Memory) AcquireLock(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if lock, ok := m.locks[name]; ok && lock.owner != owner && now.Before(lock.expires) {
+		return false, nil
+	}
+	m.locks[name] = memoryLock{owner: owner, expires: now.Add(ttl)}
+	return true, nil
+}
+
+// LockInTransaction has nothing to do, as transactions run one at a time
+func (m *Memory) LockInTransaction(ctx context.Context, name string) error {
+	return nil
+}
+
+func (m *Memory) ReleaseLock(ctx context.Context, name, owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.locks[name].owner == owner {
+		delete(m.locks, name)
+	}
+	return nil
+}
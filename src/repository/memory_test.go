@@ -0,0 +1,40 @@
+package repository_test
+
+import (
+	"backend/src/models"
+	"backend/src/repository"
+	"backend/src/repository/repotest"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMemory(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repository.Store {
+		return repository.NewMemory()
+	})
+}
+
+func TestMemoryTransactionRollsBack(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemory()
+	failure := errors.New("failure")
+	err := store.WithTransaction(ctx, func(ctx context.Context) error {
+		if _, err := store.CreateEvent(ctx, models.Event{Slug: "rolled-back"}); err != nil {
+			return err
+		}
+		if _, err := store.CreateParticipant(ctx, models.Participant{Name: "A"}); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("WithTransaction = %v, want the error of fn", err)
+	}
+	if _, err := store.GetEventBySlug(ctx, "rolled-back"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("event survived the rollback: err = %v", err)
+	}
+	if participants, _ := store.ScanParticipants(ctx, 0, 10); len(participants) != 0 {
+		t.Errorf("participants survived the rollback: %+v", participants)
+	}
+}
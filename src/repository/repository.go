@@ -0,0 +1,275 @@
+package repository
+
+import (
+	"backend/src/models"
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned when a lookup or conditional update matches
+// nothing
+var ErrNotFound = errors.New("not found")
+
+// ErrDuplicate is returned, possibly wrapped, when a write would break a
+// uniqueness constraint
+var ErrDuplicate = errors.New("duplicate")
+
+// ParticipantUpdate lists the participant fields to change; nil fields are
+// left alone
+type ParticipantUpdate struct {
+	Name        *string
+	Email       *string
+	Phone       *string
+	EmailIndex  *string
+	CollegeName *string
+	YearOfStudy *int
+	DualBoot    *bool
+}
+
+// ParticipantRepository stores participants and allocates their PIDs
+type ParticipantRepository interface {
+	GetNextPID(ctx context.Context) (int, error)
+	// CreateParticipant assigns the next PID and returns it
+	CreateParticipant(ctx context.Context, participant models.Participant) (int, error)
+	// CreateParticipants stores a group at once, filling in participants'
+	// PIDs, which increase in order, and returning them. A code already taken
+	// in the event is replaced by a new one with the same prefix.
+	CreateParticipants(ctx context.Context, participants []models.Participant) ([]int, error)
+	GetParticipant(ctx context.Context, pid int) (models.Participant, error)
+	// GetParticipantByCode looks up a normalized participant code
+	GetParticipantByCode(ctx context.Context, eventID models.ID, code string) (models.Participant, error)
+	// GetParticipants returns the existing participants among pids, by PID
+	GetParticipants(ctx context.Context, pids []int) ([]models.Participant, error)
+	// GetParticipantsByEmail matches the email case-insensitively
+	GetParticipantsByEmail(ctx context.Context, eventID models.ID, email string) ([]models.Participant, error)
+	// GetParticipantsByEmailIndex matches the blind index of encrypted emails
+	GetParticipantsByEmailIndex(ctx context.Context, eventID models.ID, index string) ([]models.Participant, error)
+	// FindParticipantsByEmail and FindParticipantsByEmailIndex match like the
+	// methods above, but in every event and including deleted participants,
+	// for requests about a person's own data
+	FindParticipantsByEmail(ctx context.Context, email string) ([]models.Participant, error)
+	FindParticipantsByEmailIndex(ctx context.Context, index string) ([]models.Participant, error)
+	UpdateParticipant(ctx context.Context, pid int, update ParticipantUpdate) error
+	// CountParticipants counts the event's participants that have not cancelled
+	CountParticipants(ctx context.Context, eventID models.ID) (int, error)
+	// DeleteParticipant soft-deletes a participant, hiding it from the other
+	// methods until RestoreParticipant. Both return ErrNotFound when the
+	// participant is missing or already in that state.
+	DeleteParticipant(ctx context.Context, pid int) error
+	RestoreParticipant(ctx context.Context, pid int) error
+	// AnonymizeParticipants clears the name, email and phone of participants,
+	// deleted or not
+	AnonymizeParticipants(ctx context.Context, pids []int) error
+	// ParticipantsToAnonymize returns up to limit participants, deleted or
+	// not, that still hold personal data and either belong to one of
+	// eventIDs or were deleted before deletedBefore
+	ParticipantsToAnonymize(ctx context.Context, eventIDs []models.ID, deletedBefore time.Time, limit int) ([]models.Participant, error)
+	// ScanParticipants pages through all participants, deleted or not, in
+	// PID order starting after afterPID
+	ScanParticipants(ctx context.Context, afterPID, limit int) ([]models.Participant, error)
+	// ResealParticipant rewrites the email, phone and their blind indexes of
+	// a participant, deleted or not, leaving updatedAt alone
+	ResealParticipant(ctx context.Context, participant models.Participant) error
+	// SetParticipantCode gives a participant stored before codes existed its
+	// code. It returns ErrNotFound when the participant already has one and
+	// an ErrDuplicate error when the code is taken in the event.
+	SetParticipantCode(ctx context.Context, pid int, code string) error
+	// ParticipantStats counts the event's participants that are not deleted
+	// by college and year of study, including anonymized ones
+	ParticipantStats(ctx context.Context, eventID models.ID) ([]models.ParticipantStat, error)
+}
+
+// RegistrationRepository stores registrations
+type RegistrationRepository interface {
+	// CreateRegistration returns an ErrDuplicate error when another active
+	// registration of the event has the same team name key
+	CreateRegistration(ctx context.Context, reg models.Registration) (models.ID, error)
+	GetRegistration(ctx context.Context, id models.ID) (models.Registration, error)
+	// GetRegistrationsByParticipants returns registrations including any of
+	// pids, oldest first
+	GetRegistrationsByParticipants(ctx context.Context, pids []int) ([]models.Registration, error)
+	// FindRegistrationsByParticipants returns registrations including any of
+	// pids as active or cancelled members, deleted or not, oldest first
+	FindRegistrationsByParticipants(ctx context.Context, pids []int) ([]models.Registration, error)
+	// TeamNameTaken reports whether an active registration of the event uses
+	// the lowercased team name
+	TeamNameTaken(ctx context.Context, eventID models.ID, teamNameKey string) (bool, error)
+	// CancelParticipants moves pids to the registration's cancelled list and
+	// marks the participants cancelled, cancelling the registration once
+	// nobody is left. It returns ErrNotFound unless every pid is still active.
+	CancelParticipants(ctx context.Context, reg models.Registration, pids []int) (models.Registration, error)
+	// DeleteRegistration soft-deletes the registration and its participants,
+	// hiding them from the other methods
+	DeleteRegistration(ctx context.Context, id models.ID) error
+	// RestoreRegistration undoes DeleteRegistration, including for the
+	// participants deleted with it but not those deleted on their own before.
+	// It returns an ErrDuplicate error when the team name was taken meanwhile.
+	RestoreRegistration(ctx context.Context, id models.ID) error
+	// RegistrationsToAnonymize returns up to limit registrations, deleted or
+	// not, that still hold personal data and either belong to one of eventIDs
+	// or were deleted before deletedBefore, in ID order starting after
+	// afterID
+	RegistrationsToAnonymize(ctx context.Context, eventIDs []models.ID, deletedBefore time.Time, afterID models.ID, limit int) ([]models.Registration, error)
+	// AnonymizeRegistration clears the personal data of a registration and
+	// its participants, keeping what the statistics need
+	AnonymizeRegistration(ctx context.Context, id models.ID) error
+}
+
+// EventRepository stores events
+type EventRepository interface {
+	CreateEvent(ctx context.Context, event models.Event) (models.ID, error)
+	GetEventBySlug(ctx context.Context, slug string) (models.Event, error)
+	// ListEvents returns every event, latest start first
+	ListEvents(ctx context.Context) ([]models.Event, error)
+	// UpdateEvent replaces the event with the same ID
+	UpdateEvent(ctx context.Context, event models.Event) error
+	SetEventPaused(ctx context.Context, slug string, paused bool, resumesAt *time.Time) (models.Event, error)
+	// AdoptOrphans assigns the participants and registrations stored before
+	// events existed to the event, returning how many registrations moved
+	AdoptOrphans(ctx context.Context, eventID models.ID) (int, error)
+}
+
+// UploadRepository stores payment screenshots until they back a registration
+type UploadRepository interface {
+	CreateUpload(ctx context.Context, upload models.Upload) (models.ID, error)
+	// ConsumeUpload marks an upload of the event as used so it can only back
+	// one registration, returning ErrNotFound when it already does
+	ConsumeUpload(ctx context.Context, id, eventID models.ID) (models.Upload, error)
+	// ReleaseUpload makes a consumed upload available again
+	ReleaseUpload(ctx context.Context, id, eventID models.ID) error
+	// ExpiredUploads lists the uploads of the given events, and unused
+	// uploads of any event created before createdBefore
+	ExpiredUploads(ctx context.Context, eventIDs []models.ID, createdBefore time.Time) ([]models.Upload, error)
+	DeleteUpload(ctx context.Context, id models.ID) error
+}
+
+// OTPRepository stores the pending email verification codes
+type OTPRepository interface {
+	GetOTP(ctx context.Context, eventID models.ID, emailHash string) (models.OTP, error)
+	// SaveOTP replaces any pending code for the address
+	SaveOTP(ctx context.Context, otp models.OTP) error
+	// ClaimOTPAttempt counts a verification attempt against the pending
+	// code, returning ErrNotFound once maxAttempts have been used. Counting
+	// before comparing keeps parallel guesses within the limit.
+	ClaimOTPAttempt(ctx context.Context, eventID models.ID, emailHash string, maxAttempts int) (models.OTP, error)
+	DeleteOTP(ctx context.Context, id models.ID) error
+	// DeleteOTPsByEmailHash removes the pending codes of an address in every event
+	DeleteOTPsByEmailHash(ctx context.Context, emailHash string) error
+	// DeleteExpiredOTPs removes the codes that expired before now, returning
+	// how many
+	DeleteExpiredOTPs(ctx context.Context, now time.Time) (int, error)
+}
+
+// RefundFilter selects refunds matching every field that is set
+type RefundFilter struct {
+	EventID         models.ID
+	Status          string
+	RegistrationIDs []models.ID
+	RequestedBy     string
+}
+
+// RefundRepository stores refund requests
+type RefundRepository interface {
+	CreateRefund(ctx context.Context, refund models.Refund) (models.ID, error)
+	// ListRefunds returns matching refunds, oldest first
+	ListRefunds(ctx context.Context, filter RefundFilter) ([]models.Refund, error)
+	// UpdateRefundStatus moves a refund to status if it is currently in
+	// from, returning ErrNotFound otherwise. An empty reference is left alone.
+	UpdateRefundStatus(ctx context.Context, id models.ID, from, status, reviewedBy, reference string) (models.Refund, error)
+}
+
+// AuditFilter selects audit entries matching every field that is set.
+// Before pages backwards from an entry ID.
+type AuditFilter struct {
+	Actor      string
+	Action     string
+	TargetType string
+	TargetIDs  []string
+	EventID    models.ID
+	Since      time.Time
+	Until      time.Time
+	Before     models.ID
+}
+
+// AuditRepository stores the audit log
+type AuditRepository interface {
+	CreateAuditEntry(ctx context.Context, entry models.AuditEntry) error
+	// ListAuditEntries returns matching entries newest first, all of them
+	// when limit is 0
+	ListAuditEntries(ctx context.Context, filter AuditFilter, limit int) ([]models.AuditEntry, error)
+	// EachAuditEntry calls fn with every matching entry, newest first,
+	// without holding them all in memory, and stops at the first error
+	EachAuditEntry(ctx context.Context, filter AuditFilter, fn func(models.AuditEntry) error) error
+	// RedactActor replaces an actor in refunds and audit entries, and drops
+	// the recorded values of changes made to the given participants
+	RedactActor(ctx context.Context, actor, replacement string, pids []int) error
+	// PlaintextActors returns up to limit distinct audit actors and refund
+	// requesters that hold an email address, left by records written before
+	// actors were hashed
+	PlaintextActors(ctx context.Context, limit int) ([]string, error)
+}
+
+// AdminUserUpdate lists the account fields to change; nil fields are left
+// alone
+type AdminUserUpdate struct {
+	PasswordHash *string
+	Role         *string
+	Disabled     *bool
+	LastLoginAt  *time.Time
+}
+
+// AdminRepository stores admin accounts
+type AdminRepository interface {
+	// CreateAdminUser returns an ErrDuplicate error when the username is taken
+	CreateAdminUser(ctx context.Context, user models.AdminUser) (models.ID, error)
+	GetAdminUser(ctx context.Context, username string) (models.AdminUser, error)
+	// ListAdminUsers returns every account by username
+	ListAdminUsers(ctx context.Context) ([]models.AdminUser, error)
+	// UpdateAdminUser applies the update and returns the account.
+	// endSessions invalidates the sessions issued so far.
+	UpdateAdminUser(ctx context.Context, username string, update AdminUserUpdate, endSessions bool) (models.AdminUser, error)
+}
+
+// RateLimitRepository keeps token buckets that every instance shares
+type RateLimitRepository interface {
+	// TakeRateLimitToken refills the bucket for key at perSecond, up to
+	// burst, and takes a token when one is available, returning the tokens
+	// left
+	TakeRateLimitToken(ctx context.Context, key string, burst, perSecond float64) (bool, float64, error)
+}
+
+// LockRepository hands out leases on named locks so that a job runs on one
+// instance at a time
+type LockRepository interface {
+	// AcquireLock takes the lock for owner until ttl has passed, returning
+	// false while another owner holds it. The owner extends its lease by
+	// acquiring the lock again.
+	AcquireLock(ctx context.Context, name, owner string, ttl time.Duration) (bool, error)
+	// ReleaseLock gives up the lock if owner still holds it
+	ReleaseLock(ctx context.Context, name, owner string) error
+	// LockInTransaction makes the transaction in ctx conflict with every
+	// other one taking the same lock, so that they see each other's writes
+	// as if they ran one after the other. The lock is separate from leases
+	// and does nothing outside a transaction.
+	LockInTransaction(ctx context.Context, name string) error
+}
+
+// Store is everything the services need from a storage backend
+type Store interface {
+	ParticipantRepository
+	RegistrationRepository
+	EventRepository
+	UploadRepository
+	OTPRepository
+	RefundRepository
+	AuditRepository
+	AdminRepository
+	RateLimitRepository
+	LockRepository
+	// WithTransaction runs fn so that its writes through the given context
+	// are applied together or not at all, where the backend allows it. fn
+	// may be retried.
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+	Ping(ctx context.Context) error
+}
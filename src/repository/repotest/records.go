@@ -0,0 +1,500 @@
+package repotest
+
+import (
+	"backend/src/models"
+	"backend/src/repository"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func testEvents(t *testing.T, ctx context.Context, store repository.Store) {
+	now := time.Now().Truncate(time.Millisecond)
+	early := models.Event{Slug: "early", Name: "Early", StartsAt: now.Add(time.Hour)}
+	late := models.Event{Slug: "late", Name: "Late", StartsAt: now.Add(48 * time.Hour), RefundPolicy: []models.RefundRule{{Before: now, Percent: 50}}}
+	var err error
+	if early.ID, err = store.CreateEvent(ctx, early); err != nil || early.ID.IsZero() {
+		t.Fatalf("CreateEvent = %v, %v", early.ID, err)
+	}
+	if late.ID, err = store.CreateEvent(ctx, late); err != nil {
+		t.Fatalf("CreateEvent: %v", err)
+	}
+	if _, err := store.CreateEvent(ctx, models.Event{Slug: "early"}); !errors.Is(err, repository.ErrDuplicate) {
+		t.Errorf("CreateEvent with a taken slug: err = %v, want ErrDuplicate", err)
+	}
+
+	got, err := store.GetEventBySlug(ctx, "late")
+	if err != nil || got.ID != late.ID || got.Name != "Late" || !got.StartsAt.Equal(late.StartsAt) ||
+		len(got.RefundPolicy) != 1 || got.RefundPolicy[0].Percent != 50 {
+		t.Errorf("GetEventBySlug = %+v, %v", got, err)
+	}
+	if _, err := store.GetEventBySlug(ctx, "missing"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetEventBySlug of unknown slug: err = %v, want ErrNotFound", err)
+	}
+
+	events, err := store.ListEvents(ctx)
+	if err != nil || len(events) != 2 || events[0].Slug != "late" || events[1].Slug != "early" {
+		t.Errorf("ListEvents = %+v, %v, want latest start first", events, err)
+	}
+
+	early.Name = "Renamed"
+	if err := store.UpdateEvent(ctx, early); err != nil {
+		t.Fatalf("UpdateEvent: %v", err)
+	}
+	if got, _ := store.GetEventBySlug(ctx, "early"); got.Name != "Renamed" {
+		t.Errorf("after UpdateEvent Name = %q", got.Name)
+	}
+	if err := store.UpdateEvent(ctx, models.Event{ID: models.NewID(), Slug: "ghost"}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("UpdateEvent of unknown ID: err = %v, want ErrNotFound", err)
+	}
+
+	resumes := now.Add(2 * time.Hour)
+	paused, err := store.SetEventPaused(ctx, "early", true, &resumes)
+	if err != nil || !paused.Paused || paused.ResumesAt == nil || !paused.ResumesAt.Equal(resumes) {
+		t.Errorf("SetEventPaused = %+v, %v", paused, err)
+	}
+	if resumed, err := store.SetEventPaused(ctx, "early", false, nil); err != nil || resumed.Paused || resumed.ResumesAt != nil {
+		t.Errorf("SetEventPaused(false) = %+v, %v", resumed, err)
+	}
+	if _, err := store.SetEventPaused(ctx, "missing", true, nil); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("SetEventPaused of unknown slug: err = %v, want ErrNotFound", err)
+	}
+}
+
+func testAdoptOrphans(t *testing.T, ctx context.Context, store repository.Store) {
+	orphan := createParticipant(t, ctx, store, models.Participant{Name: "Legacy"})
+	other := models.NewID()
+	owned := createParticipant(t, ctx, store, models.Participant{Name: "Owned", EventID: other})
+	legacy := createRegistration(t, ctx, store, models.Registration{Participants: []int{orphan}, NumOfParticipants: 1, Status: models.RegistrationActive})
+	current := createRegistration(t, ctx, store, models.Registration{EventID: other, Participants: []int{owned}, NumOfParticipants: 1, Status: models.RegistrationActive})
+
+	event := models.NewID()
+	if n, err := store.AdoptOrphans(ctx, event); err != nil || n != 1 {
+		t.Fatalf("AdoptOrphans = %d, %v, want 1", n, err)
+	}
+	if got, _ := store.GetRegistration(ctx, legacy.ID); got.EventID != event {
+		t.Errorf("orphan registration EventID = %v, want %v", got.EventID, event)
+	}
+	if got, _ := store.GetParticipant(ctx, orphan); got.EventID != event {
+		t.Errorf("orphan participant EventID = %v, want %v", got.EventID, event)
+	}
+	if got, _ := store.GetRegistration(ctx, current.ID); got.EventID != other {
+		t.Errorf("AdoptOrphans moved a registration of another event")
+	}
+	if got, _ := store.GetParticipant(ctx, owned); got.EventID != other {
+		t.Errorf("AdoptOrphans moved a participant of another event")
+	}
+	if n, err := store.AdoptOrphans(ctx, models.NewID()); err != nil || n != 0 {
+		t.Errorf("second AdoptOrphans = %d, %v, want 0", n, err)
+	}
+}
+
+func testUploads(t *testing.T, ctx context.Context, store repository.Store) {
+	event, other := models.NewID(), models.NewID()
+	id, err := store.CreateUpload(ctx, models.Upload{EventID: event, URL: "https://example.com/a.png"})
+	if err != nil || id.IsZero() {
+		t.Fatalf("CreateUpload = %v, %v", id, err)
+	}
+	if _, err := store.ConsumeUpload(ctx, id, other); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("ConsumeUpload for another event: err = %v, want ErrNotFound", err)
+	}
+	upload, err := store.ConsumeUpload(ctx, id, event)
+	if err != nil || upload.URL != "https://example.com/a.png" || !upload.Consumed {
+		t.Fatalf("ConsumeUpload = %+v, %v", upload, err)
+	}
+	if _, err := store.ConsumeUpload(ctx, id, event); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("consuming twice: err = %v, want ErrNotFound", err)
+	}
+	if err := store.ReleaseUpload(ctx, id, event); err != nil {
+		t.Fatalf("ReleaseUpload: %v", err)
+	}
+	if _, err := store.ConsumeUpload(ctx, id, event); err != nil {
+		t.Errorf("ConsumeUpload after release: %v", err)
+	}
+
+	unused, err := store.CreateUpload(ctx, models.Upload{EventID: other})
+	if err != nil {
+		t.Fatalf("CreateUpload: %v", err)
+	}
+	ids := func(uploads []models.Upload) []models.ID {
+		var out []models.ID
+		for _, upload := range uploads {
+			out = append(out, upload.ID)
+		}
+		return out
+	}
+	if got, err := store.ExpiredUploads(ctx, []models.ID{event}, time.Now().Add(-time.Hour)); err != nil || !slices.Equal(ids(got), []models.ID{id}) {
+		t.Errorf("ExpiredUploads by event = %v, %v", ids(got), err)
+	}
+	if got, err := store.ExpiredUploads(ctx, nil, time.Now().Add(time.Hour)); err != nil || !slices.Equal(ids(got), []models.ID{unused}) {
+		t.Errorf("ExpiredUploads by age = %v, %v, want only the unused upload", ids(got), err)
+	}
+	if err := store.DeleteUpload(ctx, unused); err != nil {
+		t.Fatalf("DeleteUpload: %v", err)
+	}
+	if got, _ := store.ExpiredUploads(ctx, []models.ID{other}, time.Time{}); len(got) != 0 {
+		t.Errorf("deleted upload is still listed: %+v", got)
+	}
+}
+
+func testOTPs(t *testing.T, ctx context.Context, store repository.Store) {
+	event, other := models.NewID(), models.NewID()
+	expires := time.Now().Add(10 * time.Minute).Truncate(time.Millisecond)
+	if err := store.SaveOTP(ctx, models.OTP{EventID: event, EmailHash: "hash-a", CodeHash: "h1", ExpiresAt: expires}); err != nil {
+		t.Fatalf("SaveOTP: %v", err)
+	}
+	if err := store.SaveOTP(ctx, models.OTP{EventID: other, EmailHash: "hash-a", CodeHash: "h2", ExpiresAt: expires}); err != nil {
+		t.Fatalf("SaveOTP: %v", err)
+	}
+	otp, err := store.GetOTP(ctx, event, "hash-a")
+	if err != nil || otp.CodeHash != "h1" || otp.Attempts != 0 || !otp.ExpiresAt.Equal(expires) || otp.ID.IsZero() {
+		t.Fatalf("GetOTP = %+v, %v", otp, err)
+	}
+	if _, err := store.GetOTP(ctx, event, "hash-b"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetOTP of unknown hash: err = %v, want ErrNotFound", err)
+	}
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		if claimed, err := store.ClaimOTPAttempt(ctx, event, "hash-a", 2); err != nil || claimed.Attempts != attempt {
+			t.Fatalf("ClaimOTPAttempt %d = %+v, %v", attempt, claimed, err)
+		}
+	}
+	if _, err := store.ClaimOTPAttempt(ctx, event, "hash-a", 2); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("ClaimOTPAttempt past the limit: err = %v, want ErrNotFound", err)
+	}
+
+	// Saving a new code replaces the old one and its attempts
+	if err := store.SaveOTP(ctx, models.OTP{EventID: event, EmailHash: "hash-a", CodeHash: "h3", ExpiresAt: expires}); err != nil {
+		t.Fatalf("SaveOTP: %v", err)
+	}
+	if otp, err := store.GetOTP(ctx, event, "hash-a"); err != nil || otp.CodeHash != "h3" || otp.Attempts != 0 {
+		t.Errorf("after replacing GetOTP = %+v, %v", otp, err)
+	}
+
+	otp, _ = store.GetOTP(ctx, event, "hash-a")
+	if err := store.DeleteOTP(ctx, otp.ID); err != nil {
+		t.Fatalf("DeleteOTP: %v", err)
+	}
+	if _, err := store.GetOTP(ctx, event, "hash-a"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetOTP after DeleteOTP: err = %v, want ErrNotFound", err)
+	}
+	if err := store.DeleteOTPsByEmailHash(ctx, "hash-a"); err != nil {
+		t.Fatalf("DeleteOTPsByEmailHash: %v", err)
+	}
+	if _, err := store.GetOTP(ctx, other, "hash-a"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetOTP after DeleteOTPsByEmailHash: err = %v, want ErrNotFound", err)
+	}
+
+	if err := store.SaveOTP(ctx, models.OTP{EventID: event, EmailHash: "hash-a", CodeHash: "h4", ExpiresAt: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatalf("SaveOTP: %v", err)
+	}
+	if err := store.SaveOTP(ctx, models.OTP{EventID: event, EmailHash: "hash-b", CodeHash: "h5", ExpiresAt: expires}); err != nil {
+		t.Fatalf("SaveOTP: %v", err)
+	}
+	if n, err := store.DeleteExpiredOTPs(ctx, time.Now()); err != nil || n != 1 {
+		t.Errorf("DeleteExpiredOTPs = %d, %v, want 1", n, err)
+	}
+	if _, err := store.GetOTP(ctx, event, "hash-b"); err != nil {
+		t.Errorf("DeleteExpiredOTPs removed a pending code: %v", err)
+	}
+}
+
+func testRefunds(t *testing.T, ctx context.Context, store repository.Store) {
+	event, reg := models.NewID(), models.NewID()
+	first, err := store.CreateRefund(ctx, models.Refund{EventID: event, RegistrationID: reg, Participants: []int{1, 2},
+		Amount: 500, Status: models.RefundRequested, RequestedBy: "participant:1"})
+	if err != nil || first.IsZero() {
+		t.Fatalf("CreateRefund = %v, %v", first, err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	second, err := store.CreateRefund(ctx, models.Refund{EventID: models.NewID(), RegistrationID: models.NewID(),
+		Status: models.RefundRequested, RequestedBy: "admin:root"})
+	if err != nil {
+		t.Fatalf("CreateRefund: %v", err)
+	}
+
+	ids := func(refunds []models.Refund) []models.ID {
+		var out []models.ID
+		for _, refund := range refunds {
+			out = append(out, refund.ID)
+		}
+		return out
+	}
+	for _, tt := range []struct {
+		name   string
+		filter repository.RefundFilter
+		want   []models.ID
+	}{
+		{"all", repository.RefundFilter{}, []models.ID{first, second}},
+		{"event", repository.RefundFilter{EventID: event}, []models.ID{first}},
+		{"status", repository.RefundFilter{Status: models.RefundPaid}, nil},
+		{"registrations", repository.RefundFilter{RegistrationIDs: []models.ID{reg}}, []models.ID{first}},
+		{"no registrations", repository.RefundFilter{RegistrationIDs: []models.ID{}}, nil},
+		{"requester", repository.RefundFilter{RequestedBy: "admin:root"}, []models.ID{second}},
+	} {
+		got, err := store.ListRefunds(ctx, tt.filter)
+		if err != nil || !slices.Equal(ids(got), tt.want) {
+			t.Errorf("ListRefunds(%s) = %v, %v, want %v", tt.name, ids(got), err, tt.want)
+		}
+	}
+	if got, _ := store.ListRefunds(ctx, repository.RefundFilter{EventID: event}); len(got) == 1 &&
+		(got[0].Amount != 500 || !slices.Equal(got[0].Participants, []int{1, 2}) || got[0].CreatedAt.IsZero()) {
+		t.Errorf("ListRefunds returned %+v", got[0])
+	}
+
+	if _, err := store.UpdateRefundStatus(ctx, first, models.RefundApproved, models.RefundPaid, "admin:root", "UTR1"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("UpdateRefundStatus from the wrong state: err = %v, want ErrNotFound", err)
+	}
+	refund, err := store.UpdateRefundStatus(ctx, first, models.RefundRequested, models.RefundApproved, "admin:root", "")
+	if err != nil || refund.Status != models.RefundApproved || refund.ReviewedBy != "admin:root" || refund.Reference != "" {
+		t.Fatalf("UpdateRefundStatus = %+v, %v", refund, err)
+	}
+	refund, err = store.UpdateRefundStatus(ctx, first, models.RefundApproved, models.RefundPaid, "admin:finance", "UTR1")
+	if err != nil || refund.Status != models.RefundPaid || refund.ReviewedBy != "admin:finance" || refund.Reference != "UTR1" {
+		t.Errorf("UpdateRefundStatus = %+v, %v", refund, err)
+	}
+}
+
+func testAuditEntries(t *testing.T, ctx context.Context, store repository.Store) {
+	event := models.NewID()
+	entries := []models.AuditEntry{
+		{Actor: "admin:root", Action: "event.create", TargetType: "event", TargetID: "e1", EventID: event},
+		{Actor: "participant:1", Action: "participant.update", TargetType: "participant", TargetID: "1", EventID: event,
+			Changes: map[string]models.Change{"collegeName": {Before: "A", After: "B"}}},
+		{Actor: "participant:2", Action: "participant.update", TargetType: "participant", TargetID: "2", IP: "192.0.2.1"},
+	}
+	for _, entry := range entries {
+		if err := store.CreateAuditEntry(ctx, entry); err != nil {
+			t.Fatalf("CreateAuditEntry: %v", err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	targets := func(entries []models.AuditEntry) []string {
+		var out []string
+		for _, entry := range entries {
+			out = append(out, entry.TargetID)
+		}
+		return out
+	}
+	all, err := store.ListAuditEntries(ctx, repository.AuditFilter{}, 0)
+	if err != nil || !slices.Equal(targets(all), []string{"2", "1", "e1"}) {
+		t.Fatalf("ListAuditEntries = %v, %v, want newest first", targets(all), err)
+	}
+	if all[0].IP != "192.0.2.1" || all[0].CreatedAt.IsZero() || all[1].Changes["collegeName"].After != "B" {
+		t.Errorf("ListAuditEntries lost fields: %+v", all)
+	}
+	for _, tt := range []struct {
+		name   string
+		filter repository.AuditFilter
+		limit  int
+		want   []string
+	}{
+		{"limit", repository.AuditFilter{}, 2, []string{"2", "1"}},
+		{"actor", repository.AuditFilter{Actor: "admin:root"}, 0, []string{"e1"}},
+		{"action", repository.AuditFilter{Action: "participant.update"}, 0, []string{"2", "1"}},
+		{"targets", repository.AuditFilter{TargetType: "participant", TargetIDs: []string{"1", "3"}}, 0, []string{"1"}},
+		{"event", repository.AuditFilter{EventID: event}, 0, []string{"1", "e1"}},
+		{"since", repository.AuditFilter{Since: time.Now().Add(time.Hour)}, 0, nil},
+		{"until", repository.AuditFilter{Until: time.Now().Add(-time.Hour)}, 0, nil},
+		{"before", repository.AuditFilter{Before: all[0].ID}, 0, []string{"1", "e1"}},
+	} {
+		got, err := store.ListAuditEntries(ctx, tt.filter, tt.limit)
+		if err != nil || !slices.Equal(targets(got), tt.want) {
+			t.Errorf("ListAuditEntries(%s) = %v, %v, want %v", tt.name, targets(got), err, tt.want)
+		}
+	}
+
+	var streamed []models.AuditEntry
+	err = store.EachAuditEntry(ctx, repository.AuditFilter{Action: "participant.update"}, func(entry models.AuditEntry) error {
+		streamed = append(streamed, entry)
+		return nil
+	})
+	if err != nil || !slices.Equal(targets(streamed), []string{"2", "1"}) || streamed[1].Changes["collegeName"].After != "B" {
+		t.Errorf("EachAuditEntry = %+v, %v, want 2 then 1", streamed, err)
+	}
+	stop := errors.New("stop")
+	calls := 0
+	err = store.EachAuditEntry(ctx, repository.AuditFilter{}, func(models.AuditEntry) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("EachAuditEntry after an error = %v after %d calls, want stop after 1", err, calls)
+	}
+
+	if _, err := store.CreateRefund(ctx, models.Refund{RequestedBy: "participant:1"}); err != nil {
+		t.Fatalf("CreateRefund: %v", err)
+	}
+	if err := store.RedactActor(ctx, "participant:1", "participant:erased", []int{1}); err != nil {
+		t.Fatalf("RedactActor: %v", err)
+	}
+	redacted, _ := store.ListAuditEntries(ctx, repository.AuditFilter{TargetIDs: []string{"1"}}, 0)
+	if len(redacted) != 1 || redacted[0].Actor != "participant:erased" || len(redacted[0].Changes) != 0 {
+		t.Errorf("after RedactActor got %+v", redacted)
+	}
+	if refunds, _ := store.ListRefunds(ctx, repository.RefundFilter{RequestedBy: "participant:erased"}); len(refunds) != 1 {
+		t.Errorf("RedactActor left the refund requester: %+v", refunds)
+	}
+	if kept, _ := store.ListAuditEntries(ctx, repository.AuditFilter{TargetIDs: []string{"2"}}, 0); len(kept) != 1 || kept[0].Actor != "participant:2" {
+		t.Errorf("RedactActor changed other entries: %+v", kept)
+	}
+
+	if actors, err := store.PlaintextActors(ctx, 10); err != nil || len(actors) != 0 {
+		t.Errorf("PlaintextActors = %v, %v, want none", actors, err)
+	}
+	store.CreateAuditEntry(ctx, models.AuditEntry{Actor: "participant:ada@example.com", Action: "registration.create"})
+	store.CreateAuditEntry(ctx, models.AuditEntry{Actor: "participant:ada@example.com", Action: "registration.cancel"})
+	store.CreateRefund(ctx, models.Refund{RequestedBy: "participant:ada@example.com"})
+	store.CreateRefund(ctx, models.Refund{RequestedBy: "participant:rao@example.com"})
+	actors, err := store.PlaintextActors(ctx, 10)
+	slices.Sort(actors)
+	if err != nil || !slices.Equal(actors, []string{"participant:ada@example.com", "participant:rao@example.com"}) {
+		t.Errorf("PlaintextActors = %v, %v", actors, err)
+	}
+	if actors, _ := store.PlaintextActors(ctx, 1); len(actors) != 1 {
+		t.Errorf("PlaintextActors ignored the limit: %v", actors)
+	}
+}
+
+func testAdminUsers(t *testing.T, ctx context.Context, store repository.Store) {
+	id, err := store.CreateAdminUser(ctx, models.AdminUser{Username: "riya", PasswordHash: "h1", Role: models.RoleFinance})
+	if err != nil || id.IsZero() {
+		t.Fatalf("CreateAdminUser = %v, %v", id, err)
+	}
+	if _, err := store.CreateAdminUser(ctx, models.AdminUser{Username: "arjun", Role: models.RoleOrganizer}); err != nil {
+		t.Fatalf("CreateAdminUser: %v", err)
+	}
+	if _, err := store.CreateAdminUser(ctx, models.AdminUser{Username: "riya"}); !errors.Is(err, repository.ErrDuplicate) {
+		t.Errorf("CreateAdminUser with a taken username: err = %v, want ErrDuplicate", err)
+	}
+
+	user, err := store.GetAdminUser(ctx, "riya")
+	if err != nil || user.ID != id || user.PasswordHash != "h1" || user.Role != models.RoleFinance || user.SessionVersion != 0 {
+		t.Errorf("GetAdminUser = %+v, %v", user, err)
+	}
+	if _, err := store.GetAdminUser(ctx, "nobody"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetAdminUser of unknown username: err = %v, want ErrNotFound", err)
+	}
+	if users, err := store.ListAdminUsers(ctx); err != nil || len(users) != 2 || users[0].Username != "arjun" {
+		t.Errorf("ListAdminUsers = %+v, %v, want by username", users, err)
+	}
+
+	login := time.Now().Truncate(time.Millisecond)
+	user, err = store.UpdateAdminUser(ctx, "riya", repository.AdminUserUpdate{LastLoginAt: &login}, false)
+	if err != nil || user.LastLoginAt == nil || !user.LastLoginAt.Equal(login) || user.SessionVersion != 0 || user.PasswordHash != "h1" {
+		t.Errorf("UpdateAdminUser = %+v, %v", user, err)
+	}
+	role, disabled := models.RoleVolunteer, true
+	user, err = store.UpdateAdminUser(ctx, "riya", repository.AdminUserUpdate{Role: &role, Disabled: &disabled}, true)
+	if err != nil || user.Role != role || !user.Disabled || user.SessionVersion != 1 {
+		t.Errorf("UpdateAdminUser = %+v, %v", user, err)
+	}
+	if _, err := store.UpdateAdminUser(ctx, "nobody", repository.AdminUserUpdate{}, true); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("UpdateAdminUser of unknown username: err = %v, want ErrNotFound", err)
+	}
+}
+
+func testRateLimits(t *testing.T, ctx context.Context, store repository.Store) {
+	// A refill this slow adds nothing while the test runs
+	for i, want := range []bool{true, true, false} {
+		allowed, tokens, err := store.TakeRateLimitToken(ctx, "ip:192.0.2.1", 2, 1e-6)
+		if err != nil || allowed != want {
+			t.Fatalf("take %d = %v, %v, %v, want allowed %v", i+1, allowed, tokens, err, want)
+		}
+		if !allowed && tokens >= 1 {
+			t.Errorf("refused with %v tokens left", tokens)
+		}
+	}
+	if allowed, _, err := store.TakeRateLimitToken(ctx, "ip:192.0.2.2", 2, 1e-6); err != nil || !allowed {
+		t.Errorf("another key = %v, %v, want its own bucket", allowed, err)
+	}
+	// A fast refill brings the bucket back at once
+	time.Sleep(10 * time.Millisecond)
+	if allowed, _, err := store.TakeRateLimitToken(ctx, "ip:192.0.2.1", 2, 1000); err != nil || !allowed {
+		t.Errorf("after refilling = %v, %v, want allowed", allowed, err)
+	}
+}
+
+func testLocks(t *testing.T, ctx context.Context, store repository.Store) {
+	for _, tt := range []struct {
+		name, owner string
+		ttl         time.Duration
+		want        bool
+	}{
+		{"free", "a", time.Hour, true},
+		{"held", "b", time.Hour, false},
+		{"renewed", "a", 10 * time.Millisecond, true},
+		{"still held", "b", time.Hour, false},
+	} {
+		if held, err := store.AcquireLock(ctx, "job", tt.owner, tt.ttl); err != nil || held != tt.want {
+			t.Fatalf("AcquireLock(%s) = %v, %v, want %v", tt.name, held, err, tt.want)
+		}
+	}
+	if held, err := store.AcquireLock(ctx, "other", "b", time.Hour); err != nil || !held {
+		t.Errorf("AcquireLock of another lock = %v, %v, want held", held, err)
+	}
+	// An expired lease is up for grabs
+	time.Sleep(20 * time.Millisecond)
+	if held, err := store.AcquireLock(ctx, "job", "b", time.Hour); err != nil || !held {
+		t.Fatalf("AcquireLock after expiry = %v, %v, want held", held, err)
+	}
+	if err := store.ReleaseLock(ctx, "job", "a"); err != nil {
+		t.Fatalf("ReleaseLock: %v", err)
+	}
+	if held, _ := store.AcquireLock(ctx, "job", "a", time.Hour); held {
+		t.Errorf("ReleaseLock by a former owner freed the lock")
+	}
+	if err := store.ReleaseLock(ctx, "job", "b"); err != nil {
+		t.Fatalf("ReleaseLock: %v", err)
+	}
+	if held, err := store.AcquireLock(ctx, "job", "a", time.Hour); err != nil || !held {
+		t.Errorf("AcquireLock after release = %v, %v, want held", held, err)
+	}
+}
+
+// testLockInTransaction only checks that the lock can be taken wherever
+// the services take it, as standalone Mongo servers have no transactions
+func testLockInTransaction(t *testing.T, ctx context.Context, store repository.Store) {
+	if err := store.LockInTransaction(ctx, "seats"); err != nil {
+		t.Errorf("LockInTransaction outside a transaction: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		err := store.WithTransaction(ctx, func(ctx context.Context) error {
+			return store.LockInTransaction(ctx, "seats")
+		})
+		if err != nil {
+			t.Errorf("LockInTransaction in transaction %d: %v", i+1, err)
+		}
+	}
+	if held, err := store.AcquireLock(ctx, "seats", "a", time.Hour); err != nil || !held {
+		t.Errorf("AcquireLock after LockInTransaction = %v, %v, want a separate lock", held, err)
+	}
+}
+
+// testWithTransaction only checks what every backend guarantees; standalone
+// Mongo servers cannot roll back
+func testWithTransaction(t *testing.T, ctx context.Context, store repository.Store) {
+	err := store.WithTransaction(ctx, func(ctx context.Context) error {
+		if _, err := store.CreateEvent(ctx, models.Event{Slug: "tx"}); err != nil {
+			return err
+		}
+		return store.CreateAuditEntry(ctx, models.AuditEntry{Action: "event.create", TargetID: "tx"})
+	})
+	if err != nil {
+		t.Fatalf("WithTransaction: %v", err)
+	}
+	if _, err := store.GetEventBySlug(ctx, "tx"); err != nil {
+		t.Errorf("event written in the transaction: %v", err)
+	}
+
+	failure := errors.New("failure")
+	if err := store.WithTransaction(ctx, func(ctx context.Context) error { return failure }); !errors.Is(err, failure) {
+		t.Errorf("WithTransaction = %v, want the error of fn", err)
+	}
+	if err := store.Ping(ctx); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+}
@@ -0,0 +1,892 @@
+// Package repotest is the conformance suite that every repository
+// implementation must pass
+package repotest
+
+import (
+	"backend/src/codes"
+	"backend/src/models"
+	"backend/src/repository"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Run checks the behaviour callers rely on. newStore must return an empty
+// store that is independent of the ones returned earlier.
+func Run(t *testing.T, newStore func(t *testing.T) repository.Store) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, ctx context.Context, store repository.Store)
+	}{
+		{"NextPIDIncreases", testNextPIDIncreases},
+		{"CreateAndGetParticipant", testCreateAndGetParticipant},
+		{"CreateParticipants", testCreateParticipants},
+		{"ParticipantCodes", testParticipantCodes},
+		{"ParticipantNotFound", testParticipantNotFound},
+		{"GetParticipants", testGetParticipants},
+		{"GetParticipantsByEmail", testGetParticipantsByEmail},
+		{"GetParticipantsByEmailIndex", testGetParticipantsByEmailIndex},
+		{"FindParticipantsByEmail", testFindParticipantsByEmail},
+		{"ScanAndResealParticipants", testScanAndResealParticipants},
+		{"UpdateParticipant", testUpdateParticipant},
+		{"CountParticipants", testCountParticipants},
+		{"CreateAndGetRegistration", testCreateAndGetRegistration},
+		{"RegistrationNotFound", testRegistrationNotFound},
+		{"GetRegistrationsByParticipants", testGetRegistrationsByParticipants},
+		{"TeamNameTaken", testTeamNameTaken},
+		{"TeamNamesUnique", testTeamNamesUnique},
+		{"CancelParticipants", testCancelParticipants},
+		{"DeleteAndRestoreParticipant", testDeleteAndRestoreParticipant},
+		{"DeleteAndRestoreRegistration", testDeleteAndRestoreRegistration},
+		{"ParticipantStats", testParticipantStats},
+		{"AnonymizeRegistration", testAnonymizeRegistration},
+		{"AnonymizeParticipants", testAnonymizeParticipants},
+		{"ParticipantsToAnonymize", testParticipantsToAnonymize},
+		{"ConcurrentCreates", testConcurrentCreates},
+		{"Events", testEvents},
+		{"AdoptOrphans", testAdoptOrphans},
+		{"Uploads", testUploads},
+		{"OTPs", testOTPs},
+		{"Refunds", testRefunds},
+		{"AuditEntries", testAuditEntries},
+		{"AdminUsers", testAdminUsers},
+		{"RateLimits", testRateLimits},
+		{"Locks", testLocks},
+		{"LockInTransaction", testLockInTransaction},
+		{"WithTransaction", testWithTransaction},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			tt.run(t, ctx, newStore(t))
+		})
+	}
+}
+
+func createParticipant(t *testing.T, ctx context.Context, store repository.Store, participant models.Participant) int {
+	t.Helper()
+	pid, err := store.CreateParticipant(ctx, participant)
+	if err != nil {
+		t.Fatalf("CreateParticipant: %v", err)
+	}
+	return pid
+}
+
+func createRegistration(t *testing.T, ctx context.Context, store repository.Store, reg models.Registration) models.Registration {
+	t.Helper()
+	id, err := store.CreateRegistration(ctx, reg)
+	if err != nil {
+		t.Fatalf("CreateRegistration: %v", err)
+	}
+	created, err := store.GetRegistration(ctx, id)
+	if err != nil {
+		t.Fatalf("GetRegistration: %v", err)
+	}
+	return created
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
+
+func pids(participants []models.Participant) []int {
+	var out []int
+	for _, participant := range participants {
+		out = append(out, participant.PID)
+	}
+	return out
+}
+
+func testNextPIDIncreases(t *testing.T, ctx context.Context, store repository.Store) {
+	first, err := store.GetNextPID(ctx)
+	if err != nil {
+		t.Fatalf("GetNextPID: %v", err)
+	}
+	second, err := store.GetNextPID(ctx)
+	if err != nil {
+		t.Fatalf("GetNextPID: %v", err)
+	}
+	pid := createParticipant(t, ctx, store, models.Participant{Name: "Asha"})
+	if !(first > 0 && second > first && pid > second) {
+		t.Errorf("PIDs %d, %d, %d are not increasing", first, second, pid)
+	}
+}
+
+func testCreateAndGetParticipant(t *testing.T, ctx context.Context, store repository.Store) {
+	eventID := models.NewID()
+	want := models.Participant{
+		EventID:     eventID,
+		Code:        codes.New("TEST"),
+		Name:        "Asha Rao",
+		Email:       "asha@example.com",
+		Phone:       "+919876543210",
+		CollegeName: "PESU",
+		YearOfStudy: 2,
+		DualBoot:    true,
+	}
+	pid := createParticipant(t, ctx, store, want)
+
+	got, err := store.GetParticipant(ctx, pid)
+	if err != nil {
+		t.Fatalf("GetParticipant: %v", err)
+	}
+	if got.ID.IsZero() || got.CreatedAt.IsZero() || got.UpdatedAt.IsZero() {
+		t.Errorf("ID and timestamps not set: %+v", got)
+	}
+	want.PID = pid
+	want.ID, want.CreatedAt, want.UpdatedAt = got.ID, got.CreatedAt, got.UpdatedAt
+	want.EmailIndex = got.EmailIndex // set by encrypting stores
+	if got != want {
+		t.Errorf("GetParticipant = %+v, want %+v", got, want)
+	}
+}
+
+func testCreateParticipants(t *testing.T, ctx context.Context, store repository.Store) {
+	before := createParticipant(t, ctx, store, models.Participant{Name: "Before"})
+	group := []models.Participant{{Name: "A", Email: "a@example.com"}, {Name: "B"}, {Name: "C"}, {Name: "D"}}
+	got, err := store.CreateParticipants(ctx, group)
+	if err != nil {
+		t.Fatalf("CreateParticipants: %v", err)
+	}
+	if len(got) != len(group) || !slices.IsSorted(got) || got[0] <= before {
+		t.Fatalf("CreateParticipants PIDs = %v, want %d increasing PIDs after %d", got, len(group), before)
+	}
+	if len(slices.Compact(slices.Clone(got))) != len(group) {
+		t.Fatalf("CreateParticipants PIDs = %v are not distinct", got)
+	}
+	stored, err := store.GetParticipants(ctx, got)
+	if err != nil {
+		t.Fatalf("GetParticipants: %v", err)
+	}
+	for i, participant := range stored {
+		if participant.PID != got[i] || participant.Name != group[i].Name {
+			t.Errorf("participant %d = %+v, want PID %d named %s", i, participant, got[i], group[i].Name)
+		}
+	}
+	if len(stored) != len(group) {
+		t.Errorf("stored %d participants, want %d", len(stored), len(group))
+	}
+
+	if empty, err := store.CreateParticipants(ctx, nil); err != nil || len(empty) != 0 {
+		t.Errorf("CreateParticipants(nil) = %v, %v, want no PIDs", empty, err)
+	}
+}
+
+func testParticipantCodes(t *testing.T, ctx context.Context, store repository.Store) {
+	eventID, otherEventID := models.NewID(), models.NewID()
+	taken, repeated := codes.New("TEST"), codes.New("TEST")
+	createParticipant(t, ctx, store, models.Participant{EventID: eventID, Code: taken, Name: "First"})
+	// The same code in another event is fine
+	createParticipant(t, ctx, store, models.Participant{EventID: otherEventID, Code: taken, Name: "Elsewhere"})
+
+	group := []models.Participant{
+		{EventID: eventID, Code: taken, Name: "A"},
+		{EventID: eventID, Code: repeated, Name: "B"},
+		{EventID: eventID, Code: repeated, Name: "C"},
+		{EventID: eventID, Name: "No code"},
+	}
+	if _, err := store.CreateParticipants(ctx, group); err != nil {
+		t.Fatalf("CreateParticipants: %v", err)
+	}
+	if group[0].Code == taken || group[2].Code == group[1].Code {
+		t.Errorf("codes %s, %s, %s were not rerolled", group[0].Code, group[1].Code, group[2].Code)
+	}
+	for _, participant := range group[:3] {
+		if !codes.Valid(participant.Code) || !strings.HasPrefix(participant.Code, "TEST-") {
+			t.Errorf("code %q is not a valid TEST code", participant.Code)
+		}
+		got, err := store.GetParticipantByCode(ctx, eventID, participant.Code)
+		if err != nil {
+			t.Fatalf("GetParticipantByCode(%s): %v", participant.Code, err)
+		}
+		if got.PID != participant.PID || got.Name != participant.Name {
+			t.Errorf("GetParticipantByCode(%s) = %+v, want PID %d named %s", participant.Code, got, participant.PID, participant.Name)
+		}
+	}
+	if group[3].Code != "" {
+		t.Errorf("participant without a code got %q", group[3].Code)
+	}
+
+	got, err := store.GetParticipantByCode(ctx, otherEventID, taken)
+	if err != nil || got.Name != "Elsewhere" {
+		t.Errorf("GetParticipantByCode in other event = %+v, %v, want Elsewhere", got, err)
+	}
+	if _, err := store.GetParticipantByCode(ctx, otherEventID, group[1].Code); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetParticipantByCode of another event's code: err = %v, want ErrNotFound", err)
+	}
+
+	// Backfilling a code
+	legacy := group[3].PID
+	if err := store.SetParticipantCode(ctx, legacy, taken); !errors.Is(err, repository.ErrDuplicate) {
+		t.Errorf("SetParticipantCode with a taken code: err = %v, want ErrDuplicate", err)
+	}
+	code := codes.New("TEST")
+	if err := store.SetParticipantCode(ctx, legacy, code); err != nil {
+		t.Fatalf("SetParticipantCode: %v", err)
+	}
+	if got, err := store.GetParticipantByCode(ctx, eventID, code); err != nil || got.PID != legacy {
+		t.Errorf("GetParticipantByCode(%s) = %+v, %v, want PID %d", code, got, err, legacy)
+	}
+	if err := store.SetParticipantCode(ctx, legacy, codes.New("TEST")); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("SetParticipantCode of a participant with a code: err = %v, want ErrNotFound", err)
+	}
+}
+
+func testParticipantNotFound(t *testing.T, ctx context.Context, store repository.Store) {
+	if _, err := store.GetParticipant(ctx, 424242); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetParticipant of unknown PID: err = %v, want ErrNotFound", err)
+	}
+	if err := store.UpdateParticipant(ctx, 424242, repository.ParticipantUpdate{Name: ptr("Nobody")}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("UpdateParticipant of unknown PID: err = %v, want ErrNotFound", err)
+	}
+}
+
+func testGetParticipants(t *testing.T, ctx context.Context, store repository.Store) {
+	a := createParticipant(t, ctx, store, models.Participant{Name: "A"})
+	createParticipant(t, ctx, store, models.Participant{Name: "B"})
+	c := createParticipant(t, ctx, store, models.Participant{Name: "C"})
+
+	got, err := store.GetParticipants(ctx, []int{c, a, 424242})
+	if err != nil {
+		t.Fatalf("GetParticipants: %v", err)
+	}
+	if want := []int{a, c}; !slices.Equal(pids(got), want) {
+		t.Errorf("GetParticipants PIDs = %v, want %v", pids(got), want)
+	}
+
+	got, err = store.GetParticipants(ctx, []int{424242})
+	if err != nil || got == nil || len(got) != 0 {
+		t.Errorf("GetParticipants of unknown PIDs = %v, %v, want an empty list", got, err)
+	}
+}
+
+func testGetParticipantsByEmail(t *testing.T, ctx context.Context, store repository.Store) {
+	event, other := models.NewID(), models.NewID()
+	a := createParticipant(t, ctx, store, models.Participant{EventID: event, Email: "Asha@Example.com"})
+	createParticipant(t, ctx, store, models.Participant{EventID: other, Email: "asha@example.com"})
+	createParticipant(t, ctx, store, models.Participant{EventID: event, Email: "asha+1@example.com"})
+	createParticipant(t, ctx, store, models.Participant{EventID: event, Email: "xasha@example.com"})
+
+	got, err := store.GetParticipantsByEmail(ctx, event, "asha@example.com")
+	if err != nil {
+		t.Fatalf("GetParticipantsByEmail: %v", err)
+	}
+	if want := []int{a}; !slices.Equal(pids(got), want) {
+		t.Errorf("GetParticipantsByEmail PIDs = %v, want %v", pids(got), want)
+	}
+}
+
+func testGetParticipantsByEmailIndex(t *testing.T, ctx context.Context, store repository.Store) {
+	event := models.NewID()
+	a := createParticipant(t, ctx, store, models.Participant{EventID: event, EmailIndex: "idx-a"})
+	createParticipant(t, ctx, store, models.Participant{EventID: models.NewID(), EmailIndex: "idx-a"})
+	createParticipant(t, ctx, store, models.Participant{EventID: event, EmailIndex: "idx-b"})
+	deleted := createParticipant(t, ctx, store, models.Participant{EventID: event, EmailIndex: "idx-a"})
+	if err := store.DeleteParticipant(ctx, deleted); err != nil {
+		t.Fatalf("DeleteParticipant: %v", err)
+	}
+
+	got, err := store.GetParticipantsByEmailIndex(ctx, event, "idx-a")
+	if err != nil {
+		t.Fatalf("GetParticipantsByEmailIndex: %v", err)
+	}
+	if want := []int{a}; !slices.Equal(pids(got), want) {
+		t.Errorf("GetParticipantsByEmailIndex PIDs = %v, want %v", pids(got), want)
+	}
+}
+
+func testScanAndResealParticipants(t *testing.T, ctx context.Context, store repository.Store) {
+	var created []int
+	for i := 0; i < 3; i++ {
+		created = append(created, createParticipant(t, ctx, store, models.Participant{Name: "A", Email: "a@example.com", Phone: "+911111111111"}))
+	}
+	if err := store.DeleteParticipant(ctx, created[1]); err != nil {
+		t.Fatalf("DeleteParticipant: %v", err)
+	}
+
+	first, err := store.ScanParticipants(ctx, 0, 2)
+	if err != nil {
+		t.Fatalf("ScanParticipants: %v", err)
+	}
+	rest, err := store.ScanParticipants(ctx, first[len(first)-1].PID, 10)
+	if err != nil {
+		t.Fatalf("ScanParticipants: %v", err)
+	}
+	if got := pids(append(first, rest...)); len(first) != 2 || !slices.Equal(got, created) {
+		t.Errorf("ScanParticipants pages = %v then %v, want %v", pids(first), pids(rest), created)
+	}
+
+	before, _ := store.GetParticipant(ctx, created[0])
+	resealed := before
+	resealed.Email, resealed.Phone, resealed.EmailIndex = "b@example.com", "+912222222222", "idx-b"
+	if err := store.ResealParticipant(ctx, resealed); err != nil {
+		t.Fatalf("ResealParticipant: %v", err)
+	}
+	got, err := store.GetParticipant(ctx, created[0])
+	if err != nil || got.Email != "b@example.com" || got.Phone != "+912222222222" || got.Name != "A" || !got.UpdatedAt.Equal(before.UpdatedAt) {
+		t.Errorf("after reseal got %+v, %v", got, err)
+	}
+	deleted := models.Participant{PID: created[1], Email: "c@example.com"}
+	if err := store.ResealParticipant(ctx, deleted); err != nil {
+		t.Errorf("ResealParticipant of a deleted participant: %v", err)
+	}
+	if err := store.ResealParticipant(ctx, models.Participant{PID: 424242}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("ResealParticipant of a missing participant = %v, want ErrNotFound", err)
+	}
+}
+
+func testUpdateParticipant(t *testing.T, ctx context.Context, store repository.Store) {
+	pid := createParticipant(t, ctx, store, models.Participant{Name: "Asha", Phone: "+911111111111", YearOfStudy: 1})
+	before, _ := store.GetParticipant(ctx, pid)
+	time.Sleep(5 * time.Millisecond)
+
+	if err := store.UpdateParticipant(ctx, pid, repository.ParticipantUpdate{Name: ptr("Asha Rao"), YearOfStudy: ptr(3)}); err != nil {
+		t.Fatalf("UpdateParticipant: %v", err)
+	}
+	got, err := store.GetParticipant(ctx, pid)
+	if err != nil {
+		t.Fatalf("GetParticipant: %v", err)
+	}
+	if got.Name != "Asha Rao" || got.YearOfStudy != 3 || got.Phone != "+911111111111" {
+		t.Errorf("after update got %+v", got)
+	}
+	if !got.UpdatedAt.After(before.UpdatedAt) {
+		t.Errorf("UpdatedAt not bumped: %v, was %v", got.UpdatedAt, before.UpdatedAt)
+	}
+}
+
+func testCountParticipants(t *testing.T, ctx context.Context, store repository.Store) {
+	event := models.NewID()
+	a := createParticipant(t, ctx, store, models.Participant{EventID: event})
+	b := createParticipant(t, ctx, store, models.Participant{EventID: event})
+	createParticipant(t, ctx, store, models.Participant{EventID: models.NewID()})
+	reg := createRegistration(t, ctx, store, models.Registration{EventID: event, Participants: []int{a, b}, NumOfParticipants: 2})
+
+	if count, err := store.CountParticipants(ctx, event); err != nil || count != 2 {
+		t.Errorf("CountParticipants = %d, %v, want 2", count, err)
+	}
+	if _, err := store.CancelParticipants(ctx, reg, []int{b}); err != nil {
+		t.Fatalf("CancelParticipants: %v", err)
+	}
+	if count, err := store.CountParticipants(ctx, event); err != nil || count != 1 {
+		t.Errorf("CountParticipants after cancelling = %d, %v, want 1", count, err)
+	}
+}
+
+func testCreateAndGetRegistration(t *testing.T, ctx context.Context, store repository.Store) {
+	want := models.Registration{
+		EventID:           models.NewID(),
+		TeamName:          "Null Pointers",
+		TeamNameKey:       "null pointers",
+		LeaderPID:         2,
+		NumOfParticipants: 2,
+		Participants:      []int{1, 2},
+		TotalAmount:       500,
+		TransactionID:     "TXN1",
+		TransactionImage:  "https://example.com/txn.png",
+		ReferralCode:      "REF",
+		Status:            models.RegistrationActive,
+	}
+	id, err := store.CreateRegistration(ctx, want)
+	if err != nil {
+		t.Fatalf("CreateRegistration: %v", err)
+	}
+	got, err := store.GetRegistration(ctx, id)
+	if err != nil {
+		t.Fatalf("GetRegistration: %v", err)
+	}
+	if got.ID != id || got.CreatedAt.IsZero() {
+		t.Errorf("ID or CreatedAt not set: %+v", got)
+	}
+	want.ID = got.ID
+	if !registrationsEqual(got, want) {
+		t.Errorf("GetRegistration = %+v, want %+v", got, want)
+	}
+}
+
+func registrationsEqual(a, b models.Registration) bool {
+	return slices.Equal(a.Participants, b.Participants) && slices.Equal(a.Cancelled, b.Cancelled) &&
+		a.ID == b.ID && a.EventID == b.EventID && a.TeamName == b.TeamName && a.TeamNameKey == b.TeamNameKey &&
+		a.LeaderPID == b.LeaderPID && a.NumOfParticipants == b.NumOfParticipants && a.TotalAmount == b.TotalAmount &&
+		a.TransactionID == b.TransactionID && a.TransactionImage == b.TransactionImage &&
+		a.ReferralCode == b.ReferralCode && a.Status == b.Status
+}
+
+func testRegistrationNotFound(t *testing.T, ctx context.Context, store repository.Store) {
+	if _, err := store.GetRegistration(ctx, models.NewID()); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetRegistration of unknown ID: err = %v, want ErrNotFound", err)
+	}
+	if _, err := models.ParseID("not-an-id"); !errors.Is(err, models.ErrInvalidID) {
+		t.Errorf("ParseID of a malformed ID: err = %v, want ErrInvalidID", err)
+	}
+}
+
+func testFindParticipantsByEmail(t *testing.T, ctx context.Context, store repository.Store) {
+	a := createParticipant(t, ctx, store, models.Participant{EventID: models.NewID(), Email: "ada@example.com", EmailIndex: "idx-a"})
+	b := createParticipant(t, ctx, store, models.Participant{EventID: models.NewID(), Email: "Ada@Example.com", EmailIndex: "idx-a"})
+	createParticipant(t, ctx, store, models.Participant{EventID: models.NewID(), Email: "rao@example.com", EmailIndex: "idx-b"})
+	if err := store.DeleteParticipant(ctx, b); err != nil {
+		t.Fatalf("DeleteParticipant: %v", err)
+	}
+
+	got, err := store.FindParticipantsByEmail(ctx, "ADA@example.com")
+	if want := []int{a, b}; err != nil || !slices.Equal(pids(got), want) {
+		t.Errorf("FindParticipantsByEmail = %v, %v, want %v", pids(got), err, want)
+	}
+	got, err = store.FindParticipantsByEmailIndex(ctx, "idx-a")
+	if want := []int{a, b}; err != nil || !slices.Equal(pids(got), want) {
+		t.Errorf("FindParticipantsByEmailIndex = %v, %v, want %v", pids(got), err, want)
+	}
+}
+
+func testGetRegistrationsByParticipants(t *testing.T, ctx context.Context, store repository.Store) {
+	first := createRegistration(t, ctx, store, models.Registration{Participants: []int{1, 2}})
+	time.Sleep(5 * time.Millisecond)
+	createRegistration(t, ctx, store, models.Registration{Participants: []int{3}})
+	time.Sleep(5 * time.Millisecond)
+	third := createRegistration(t, ctx, store, models.Registration{Participants: []int{4, 5}})
+
+	got, err := store.GetRegistrationsByParticipants(ctx, []int{5, 2})
+	if err != nil {
+		t.Fatalf("GetRegistrationsByParticipants: %v", err)
+	}
+	if len(got) != 2 || got[0].ID != first.ID || got[1].ID != third.ID {
+		t.Errorf("GetRegistrationsByParticipants = %+v, want the first and third registrations in order", got)
+	}
+	got, err = store.GetRegistrationsByParticipants(ctx, []int{424242})
+	if err != nil || got == nil || len(got) != 0 {
+		t.Errorf("GetRegistrationsByParticipants of unknown PIDs = %v, %v, want an empty list", got, err)
+	}
+
+	// Finding also covers deleted registrations and cancelled members
+	time.Sleep(5 * time.Millisecond)
+	cancelled := createRegistration(t, ctx, store, models.Registration{Participants: []int{6}, Cancelled: []int{7}})
+	if err := store.DeleteRegistration(ctx, first.ID); err != nil {
+		t.Fatalf("DeleteRegistration: %v", err)
+	}
+	got, err = store.FindRegistrationsByParticipants(ctx, []int{2, 7})
+	if err != nil || len(got) != 2 || got[0].ID != first.ID || got[1].ID != cancelled.ID {
+		t.Errorf("FindRegistrationsByParticipants = %+v, %v, want the deleted and the cancelled one in order", got, err)
+	}
+	got, err = store.FindRegistrationsByParticipants(ctx, nil)
+	if err != nil || got == nil || len(got) != 0 {
+		t.Errorf("FindRegistrationsByParticipants(nil) = %v, %v, want an empty list", got, err)
+	}
+}
+
+func testTeamNameTaken(t *testing.T, ctx context.Context, store repository.Store) {
+	event := models.NewID()
+	reg := createRegistration(t, ctx, store, models.Registration{
+		EventID: event, TeamNameKey: "null pointers", Participants: []int{1}, NumOfParticipants: 1, Status: models.RegistrationActive,
+	})
+
+	check := func(eventID models.ID, key string, want bool) {
+		t.Helper()
+		if taken, err := store.TeamNameTaken(ctx, eventID, key); err != nil || taken != want {
+			t.Errorf("TeamNameTaken(%q) = %v, %v, want %v", key, taken, err, want)
+		}
+	}
+	check(event, "null pointers", true)
+	check(event, "segfaults", false)
+	check(models.NewID(), "null pointers", false)
+
+	if _, err := store.CancelParticipants(ctx, reg, []int{1}); err != nil {
+		t.Fatalf("CancelParticipants: %v", err)
+	}
+	check(event, "null pointers", false)
+}
+
+func testTeamNamesUnique(t *testing.T, ctx context.Context, store repository.Store) {
+	event := models.NewID()
+	team := func(pid int) models.Registration {
+		return models.Registration{EventID: event, TeamNameKey: "null pointers", Participants: []int{pid}, NumOfParticipants: 1, Status: models.RegistrationActive}
+	}
+	first := createRegistration(t, ctx, store, team(1))
+	if _, err := store.CreateRegistration(ctx, team(2)); !errors.Is(err, repository.ErrDuplicate) {
+		t.Errorf("CreateRegistration with a taken team name: err = %v, want ErrDuplicate", err)
+	}
+	// Registrations without a team name never collide
+	for pid := 3; pid <= 4; pid++ {
+		createRegistration(t, ctx, store, models.Registration{EventID: event, Participants: []int{pid}, NumOfParticipants: 1, Status: models.RegistrationActive})
+	}
+
+	if err := store.DeleteRegistration(ctx, first.ID); err != nil {
+		t.Fatalf("DeleteRegistration: %v", err)
+	}
+	second := createRegistration(t, ctx, store, team(5))
+	if err := store.RestoreRegistration(ctx, first.ID); !errors.Is(err, repository.ErrDuplicate) {
+		t.Errorf("RestoreRegistration with a taken team name: err = %v, want ErrDuplicate", err)
+	}
+	if _, err := store.CancelParticipants(ctx, second, []int{5}); err != nil {
+		t.Fatalf("CancelParticipants: %v", err)
+	}
+	if err := store.RestoreRegistration(ctx, first.ID); err != nil {
+		t.Errorf("RestoreRegistration once the team name is free: %v", err)
+	}
+}
+
+func testCancelParticipants(t *testing.T, ctx context.Context, store repository.Store) {
+	a := createParticipant(t, ctx, store, models.Participant{Name: "A"})
+	b := createParticipant(t, ctx, store, models.Participant{Name: "B"})
+	c := createParticipant(t, ctx, store, models.Participant{Name: "C"})
+	reg := createRegistration(t, ctx, store, models.Registration{
+		Participants: []int{a, b, c}, NumOfParticipants: 3, Status: models.RegistrationActive,
+	})
+
+	updated, err := store.CancelParticipants(ctx, reg, []int{b})
+	if err != nil {
+		t.Fatalf("CancelParticipants: %v", err)
+	}
+	if !slices.Equal(updated.Participants, []int{a, c}) || !slices.Equal(updated.Cancelled, []int{b}) ||
+		updated.NumOfParticipants != 2 || updated.Status != models.RegistrationActive {
+		t.Errorf("after partial cancel got %+v", updated)
+	}
+	if participant, _ := store.GetParticipant(ctx, b); !participant.Cancelled {
+		t.Error("cancelled participant is not marked cancelled")
+	}
+	if participant, _ := store.GetParticipant(ctx, a); participant.Cancelled {
+		t.Error("remaining participant is marked cancelled")
+	}
+
+	// A stale copy still lists b, which is no longer active
+	if _, err := store.CancelParticipants(ctx, reg, []int{b}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("cancelling twice: err = %v, want ErrNotFound", err)
+	}
+
+	// The status follows what is stored, not the stale copy passed in
+	updated, err = store.CancelParticipants(ctx, reg, []int{a, c})
+	if err != nil {
+		t.Fatalf("CancelParticipants: %v", err)
+	}
+	if len(updated.Participants) != 0 || updated.NumOfParticipants != 0 || updated.Status != models.RegistrationCancelled {
+		t.Errorf("after cancelling everyone got %+v", updated)
+	}
+	stored, err := store.GetRegistration(ctx, reg.ID)
+	if err != nil || !registrationsEqual(stored, updated) {
+		t.Errorf("GetRegistration = %+v, %v, want %+v", stored, err, updated)
+	}
+}
+
+func testDeleteAndRestoreParticipant(t *testing.T, ctx context.Context, store repository.Store) {
+	eventID := models.NewID()
+	code := codes.New("TEST")
+	pid := createParticipant(t, ctx, store, models.Participant{EventID: eventID, Code: code, Name: "A", Email: "a@example.com"})
+
+	if err := store.DeleteParticipant(ctx, pid); err != nil {
+		t.Fatalf("DeleteParticipant: %v", err)
+	}
+	if err := store.DeleteParticipant(ctx, pid); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("deleting twice: err = %v, want ErrNotFound", err)
+	}
+	if _, err := store.GetParticipant(ctx, pid); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetParticipant of deleted participant: err = %v, want ErrNotFound", err)
+	}
+	if _, err := store.GetParticipantByCode(ctx, eventID, code); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetParticipantByCode of deleted participant: err = %v, want ErrNotFound", err)
+	}
+	if got, _ := store.GetParticipants(ctx, []int{pid}); len(got) != 0 {
+		t.Errorf("GetParticipants returned deleted participant: %+v", got)
+	}
+	if got, _ := store.GetParticipantsByEmail(ctx, eventID, "a@example.com"); len(got) != 0 {
+		t.Errorf("GetParticipantsByEmail returned deleted participant: %+v", got)
+	}
+	if count, _ := store.CountParticipants(ctx, eventID); count != 0 {
+		t.Errorf("CountParticipants = %d with the only participant deleted", count)
+	}
+	if err := store.UpdateParticipant(ctx, pid, repository.ParticipantUpdate{Name: ptr("B")}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("UpdateParticipant of deleted participant: err = %v, want ErrNotFound", err)
+	}
+
+	if err := store.RestoreParticipant(ctx, pid); err != nil {
+		t.Fatalf("RestoreParticipant: %v", err)
+	}
+	if err := store.RestoreParticipant(ctx, pid); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("restoring twice: err = %v, want ErrNotFound", err)
+	}
+	got, err := store.GetParticipant(ctx, pid)
+	if err != nil || got.Name != "A" || got.DeletedAt != nil {
+		t.Errorf("GetParticipant after restore = %+v, %v", got, err)
+	}
+	if err := store.DeleteParticipant(ctx, 424242); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("DeleteParticipant of unknown PID: err = %v, want ErrNotFound", err)
+	}
+}
+
+func testDeleteAndRestoreRegistration(t *testing.T, ctx context.Context, store repository.Store) {
+	eventID := models.NewID()
+	a := createParticipant(t, ctx, store, models.Participant{EventID: eventID, Name: "A"})
+	b := createParticipant(t, ctx, store, models.Participant{EventID: eventID, Name: "B"})
+	c := createParticipant(t, ctx, store, models.Participant{EventID: eventID, Name: "C"})
+	reg := createRegistration(t, ctx, store, models.Registration{
+		EventID: eventID, TeamNameKey: "team", Participants: []int{a, b}, Cancelled: []int{c},
+		NumOfParticipants: 2, Status: models.RegistrationActive,
+	})
+	// b was deleted on its own and stays deleted after the registration is restored
+	if err := store.DeleteParticipant(ctx, b); err != nil {
+		t.Fatalf("DeleteParticipant: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	id := reg.ID
+	if err := store.DeleteRegistration(ctx, id); err != nil {
+		t.Fatalf("DeleteRegistration: %v", err)
+	}
+	if err := store.DeleteRegistration(ctx, id); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("deleting twice: err = %v, want ErrNotFound", err)
+	}
+	if _, err := store.GetRegistration(ctx, id); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetRegistration of deleted registration: err = %v, want ErrNotFound", err)
+	}
+	if got, _ := store.GetRegistrationsByParticipants(ctx, []int{a}); len(got) != 0 {
+		t.Errorf("GetRegistrationsByParticipants returned deleted registration: %+v", got)
+	}
+	if taken, _ := store.TeamNameTaken(ctx, eventID, "team"); taken {
+		t.Error("deleted registration still holds its team name")
+	}
+	if _, err := store.CancelParticipants(ctx, reg, []int{a}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("CancelParticipants of deleted registration: err = %v, want ErrNotFound", err)
+	}
+	if got, _ := store.GetParticipants(ctx, []int{a, b, c}); len(got) != 0 {
+		t.Errorf("participants of deleted registration are visible: %+v", got)
+	}
+
+	if err := store.RestoreRegistration(ctx, id); err != nil {
+		t.Fatalf("RestoreRegistration: %v", err)
+	}
+	if err := store.RestoreRegistration(ctx, id); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("restoring twice: err = %v, want ErrNotFound", err)
+	}
+	if got, err := store.GetRegistration(ctx, id); err != nil || !registrationsEqual(got, reg) {
+		t.Errorf("GetRegistration after restore = %+v, %v, want %+v", got, err, reg)
+	}
+	got, err := store.GetParticipants(ctx, []int{a, b, c})
+	if err != nil || !slices.Equal(pids(got), []int{a, c}) {
+		t.Errorf("participants after restore = %v, %v, want %v", pids(got), err, []int{a, c})
+	}
+}
+
+func testParticipantStats(t *testing.T, ctx context.Context, store repository.Store) {
+	eventID := models.NewID()
+	group := []models.Participant{
+		{EventID: eventID, CollegeName: "PESU", YearOfStudy: 2},
+		{EventID: eventID, CollegeName: "PESU", YearOfStudy: 2},
+		{EventID: eventID, CollegeName: "PESU", YearOfStudy: 1},
+		{EventID: eventID, CollegeName: "BMS", YearOfStudy: 3},
+		{EventID: eventID, CollegeName: "BMS", YearOfStudy: 3},
+		{EventID: models.NewID(), CollegeName: "PESU", YearOfStudy: 2},
+	}
+	created, err := store.CreateParticipants(ctx, group)
+	if err != nil {
+		t.Fatalf("CreateParticipants: %v", err)
+	}
+	if err := store.DeleteParticipant(ctx, created[4]); err != nil {
+		t.Fatalf("DeleteParticipant: %v", err)
+	}
+
+	got, err := store.ParticipantStats(ctx, eventID)
+	if err != nil {
+		t.Fatalf("ParticipantStats: %v", err)
+	}
+	want := []models.ParticipantStat{
+		{CollegeName: "BMS", YearOfStudy: 3, Count: 1},
+		{CollegeName: "PESU", YearOfStudy: 1, Count: 1},
+		{CollegeName: "PESU", YearOfStudy: 2, Count: 2},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("ParticipantStats = %+v, want %+v", got, want)
+	}
+	if got, err := store.ParticipantStats(ctx, models.NewID()); err != nil || got == nil || len(got) != 0 {
+		t.Errorf("ParticipantStats of an empty event = %v, %v, want an empty list", got, err)
+	}
+}
+
+func testAnonymizeRegistration(t *testing.T, ctx context.Context, store repository.Store) {
+	ended, other := models.NewID(), models.NewID()
+	newRegistration := func(eventID models.ID, team string) models.Registration {
+		t.Helper()
+		pids, err := store.CreateParticipants(ctx, []models.Participant{
+			{EventID: eventID, Name: "A", Email: "a@example.com", Phone: "+919876543210", CollegeName: "PESU", YearOfStudy: 2},
+			{EventID: eventID, Name: "B", Email: "b@example.com", Phone: "+919876543211", CollegeName: "BMS", YearOfStudy: 1},
+		})
+		if err != nil {
+			t.Fatalf("CreateParticipants: %v", err)
+		}
+		return createRegistration(t, ctx, store, models.Registration{
+			EventID: eventID, TeamName: team, TeamNameKey: strings.ToLower(team), LeaderPID: pids[0], Participants: pids[:1],
+			Cancelled: pids[1:], NumOfParticipants: 1, TransactionID: "TXN", TransactionImage: "https://example.com/txn.png",
+			Status: models.RegistrationActive,
+		})
+	}
+	expired := newRegistration(ended, "Team")
+	active := newRegistration(other, "Team")
+	deleted := newRegistration(other, "Other Team")
+	if err := store.DeleteRegistration(ctx, deleted.ID); err != nil {
+		t.Fatalf("DeleteRegistration: %v", err)
+	}
+
+	ids := func(registrations []models.Registration) []models.ID {
+		var out []models.ID
+		for _, reg := range registrations {
+			out = append(out, reg.ID)
+		}
+		return out
+	}
+	// Only deletions before the cutoff count
+	got, err := store.RegistrationsToAnonymize(ctx, []models.ID{ended}, time.Now().Add(-time.Hour), models.ID{}, 10)
+	if err != nil || !slices.Equal(ids(got), []models.ID{expired.ID}) {
+		t.Fatalf("RegistrationsToAnonymize = %v, %v, want only the registration of the ended event", ids(got), err)
+	}
+	got, err = store.RegistrationsToAnonymize(ctx, nil, time.Now().Add(time.Hour), models.ID{}, 10)
+	if err != nil || !slices.Equal(ids(got), []models.ID{deleted.ID}) {
+		t.Fatalf("RegistrationsToAnonymize = %v, %v, want only the deleted registration", ids(got), err)
+	}
+	first, _ := store.RegistrationsToAnonymize(ctx, []models.ID{ended, other}, time.Now(), models.ID{}, 1)
+	if len(first) != 1 {
+		t.Fatalf("RegistrationsToAnonymize ignored the limit: got %d", len(first))
+	}
+	rest, err := store.RegistrationsToAnonymize(ctx, []models.ID{ended, other}, time.Now(), first[0].ID, 10)
+	if err != nil || len(rest) != 2 || slices.Contains(ids(rest), first[0].ID) ||
+		strings.Compare(rest[0].ID.Hex(), rest[1].ID.Hex()) >= 0 || strings.Compare(first[0].ID.Hex(), rest[0].ID.Hex()) >= 0 {
+		t.Errorf("RegistrationsToAnonymize after %s = %v, %v, want the other two in ID order", first[0].ID.Hex(), ids(rest), err)
+	}
+
+	if err := store.AnonymizeRegistration(ctx, expired.ID); err != nil {
+		t.Fatalf("AnonymizeRegistration: %v", err)
+	}
+	reg, err := store.GetRegistration(ctx, expired.ID)
+	if err != nil {
+		t.Fatalf("GetRegistration: %v", err)
+	}
+	if reg.AnonymizedAt == nil || reg.TeamName != "" || reg.TransactionID != "" || reg.TransactionImage != "" ||
+		!slices.Equal(reg.Participants, expired.Participants) {
+		t.Errorf("anonymized registration = %+v", reg)
+	}
+	participants, err := store.GetParticipants(ctx, allPIDs(expired))
+	if err != nil || len(participants) != 2 {
+		t.Fatalf("GetParticipants = %v, %v", participants, err)
+	}
+	for _, participant := range participants {
+		if participant.AnonymizedAt == nil || participant.Name != "" || participant.Email != "" || participant.Phone != "" ||
+			participant.CollegeName == "" || participant.YearOfStudy == 0 {
+			t.Errorf("anonymized participant = %+v", participant)
+		}
+	}
+	if stats, _ := store.ParticipantStats(ctx, ended); len(stats) != 2 {
+		t.Errorf("ParticipantStats after anonymizing = %+v, want both participants counted", stats)
+	}
+	got, err = store.RegistrationsToAnonymize(ctx, []models.ID{ended}, time.Time{}, models.ID{}, 10)
+	if err != nil || len(got) != 0 {
+		t.Errorf("RegistrationsToAnonymize after anonymizing = %v, %v, want none", ids(got), err)
+	}
+	if participant, _ := store.GetParticipant(ctx, active.Participants[0]); participant.Name != "A" {
+		t.Errorf("participant of another registration was changed: %+v", participant)
+	}
+}
+
+func testAnonymizeParticipants(t *testing.T, ctx context.Context, store repository.Store) {
+	person := models.Participant{Name: "A", Email: "a@example.com", Phone: "+919876543210", CollegeName: "PESU", YearOfStudy: 2}
+	a := createParticipant(t, ctx, store, person)
+	deleted := createParticipant(t, ctx, store, person)
+	other := createParticipant(t, ctx, store, person)
+	if err := store.DeleteParticipant(ctx, deleted); err != nil {
+		t.Fatalf("DeleteParticipant: %v", err)
+	}
+
+	if err := store.AnonymizeParticipants(ctx, []int{a, deleted, 424242}); err != nil {
+		t.Fatalf("AnonymizeParticipants: %v", err)
+	}
+	if err := store.AnonymizeParticipants(ctx, nil); err != nil {
+		t.Errorf("AnonymizeParticipants(nil): %v", err)
+	}
+	if err := store.RestoreParticipant(ctx, deleted); err != nil {
+		t.Fatalf("RestoreParticipant: %v", err)
+	}
+	for _, pid := range []int{a, deleted} {
+		got, err := store.GetParticipant(ctx, pid)
+		if err != nil || got.AnonymizedAt == nil || got.Name != "" || got.Email != "" || got.Phone != "" || got.CollegeName != "PESU" {
+			t.Errorf("anonymized participant = %+v, %v", got, err)
+		}
+	}
+	if got, _ := store.GetParticipant(ctx, other); got.Name != "A" || got.AnonymizedAt != nil {
+		t.Errorf("other participant was changed: %+v", got)
+	}
+}
+
+func testParticipantsToAnonymize(t *testing.T, ctx context.Context, store repository.Store) {
+	ended, other := models.NewID(), models.NewID()
+	person := func(eventID models.ID) models.Participant {
+		return models.Participant{EventID: eventID, Name: "A", Email: "a@example.com", Phone: "+919876543210"}
+	}
+	expired := createParticipant(t, ctx, store, person(ended))
+	active := createParticipant(t, ctx, store, person(other))
+	deleted := createParticipant(t, ctx, store, person(other))
+	if err := store.DeleteParticipant(ctx, deleted); err != nil {
+		t.Fatalf("DeleteParticipant: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name          string
+		eventIDs      []models.ID
+		deletedBefore time.Time
+		limit         int
+		want          []int
+	}{
+		{"ended event", []models.ID{ended}, time.Now().Add(-time.Hour), 10, []int{expired}},
+		{"deleted", nil, time.Now().Add(time.Hour), 10, []int{deleted}},
+		{"both", []models.ID{ended}, time.Now().Add(time.Hour), 10, []int{expired, deleted}},
+		{"limit", []models.ID{ended, other}, time.Now(), 2, []int{expired, active}},
+	} {
+		got, err := store.ParticipantsToAnonymize(ctx, tt.eventIDs, tt.deletedBefore, tt.limit)
+		if err != nil || !slices.Equal(pids(got), tt.want) {
+			t.Errorf("ParticipantsToAnonymize(%s) = %v, %v, want %v", tt.name, pids(got), err, tt.want)
+		}
+	}
+
+	if err := store.AnonymizeParticipants(ctx, []int{expired, deleted}); err != nil {
+		t.Fatalf("AnonymizeParticipants: %v", err)
+	}
+	got, err := store.ParticipantsToAnonymize(ctx, []models.ID{ended}, time.Now().Add(time.Hour), 10)
+	if err != nil || len(got) != 0 {
+		t.Errorf("ParticipantsToAnonymize after anonymizing = %v, %v, want none", pids(got), err)
+	}
+}
+
+func allPIDs(reg models.Registration) []int {
+	return append(slices.Clone(reg.Participants), reg.Cancelled...)
+}
+
+func testConcurrentCreates(t *testing.T, ctx context.Context, store repository.Store) {
+	const n = 20
+	var wg sync.WaitGroup
+	results := make(chan int, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pid, err := store.CreateParticipant(ctx, models.Participant{Name: "P"})
+			if err != nil {
+				t.Errorf("CreateParticipant: %v", err)
+				return
+			}
+			results <- pid
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	seen := map[int]bool{}
+	for pid := range results {
+		if seen[pid] {
+			t.Errorf("PID %d allocated twice", pid)
+		}
+		seen[pid] = true
+	}
+	if len(seen) != n {
+		t.Errorf("got %d distinct PIDs, want %d", len(seen), n)
+	}
+}
@@ -0,0 +1,80 @@
+package sqlstore
+
+import (
+	"backend/src/models"
+	"backend/src/repository"
+	"backend/src/tracing"
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const adminColumns = "id, username, password_hash, role, disabled, session_version, last_login_at, created_at, updated_at"
+
+func scanAdminUser(row scanner) (models.AdminUser, error) {
+	var u models.AdminUser
+	var lastLoginAt sql.NullTime
+	err := row.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &u.Disabled, &u.SessionVersion, &lastLoginAt,
+		&u.CreatedAt, &u.UpdatedAt)
+	u.LastLoginAt = timePtr(lastLoginAt)
+	return u, sqlErr(err)
+}
+
+func (s *Store) CreateAdminUser(ctx context.Context, user models.AdminUser) (_ models.ID, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.CreateAdminUser")
+	defer func() { tracing.End(span, err) }()
+	user.ID = models.NewID()
+	now := time.Now().UTC()
+	_, err = s.conn(ctx).ExecContext(ctx, "INSERT INTO admin_users ("+adminColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		user.ID, user.Username, user.PasswordHash, user.Role, user.Disabled, user.SessionVersion,
+		nullTimePtr(user.LastLoginAt), now, now)
+	if err != nil {
+		return models.ID{}, sqlErr(err)
+	}
+	return user.ID, nil
+}
+
+func (s *Store) GetAdminUser(ctx context.Context, username string) (_ models.AdminUser, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.GetAdminUser")
+	defer func() { tracing.End(span, err) }()
+	return scanAdminUser(s.conn(ctx).QueryRowContext(ctx, "SELECT "+adminColumns+" FROM admin_users WHERE username = $1", username))
+}
+
+func (s *Store) ListAdminUsers(ctx context.Context) (_ []models.AdminUser, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.ListAdminUsers")
+	defer func() { tracing.End(span, err) }()
+	rows, err := s.conn(ctx).QueryContext(ctx, "SELECT "+adminColumns+" FROM admin_users ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := []models.AdminUser{}
+	for rows.Next() {
+		user, err := scanAdminUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (s *Store) UpdateAdminUser(ctx context.Context, username string, update repository.AdminUserUpdate, endSessions bool) (_ models.AdminUser, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.UpdateAdminUser")
+	defer func() { tracing.End(span, err) }()
+	u := updateBuilder{sets: []string{"updated_at = $1"}, args: []any{time.Now().UTC()}}
+	setIf(&u, "password_hash", update.PasswordHash)
+	setIf(&u, "role", update.Role)
+	setIf(&u, "disabled", update.Disabled)
+	if update.LastLoginAt != nil {
+		u.set("last_login_at", update.LastLoginAt.UTC())
+	}
+	if endSessions {
+		u.sets = append(u.sets, "session_version = session_version + 1")
+	}
+	u.args = append(u.args, username)
+	query := fmt.Sprintf("UPDATE admin_users SET %s WHERE username = $%d RETURNING %s", strings.Join(u.sets, ", "), len(u.args), adminColumns)
+	return scanAdminUser(s.conn(ctx).QueryRowContext(ctx, query, u.args...))
+}
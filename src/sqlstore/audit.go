@@ -0,0 +1,181 @@
+package sqlstore
+
+import (
+	"backend/src/models"
+	"backend/src/repository"
+	"backend/src/tracing"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const auditColumns = "id, actor, action, target_type, target_id, event_id, changes, ip, created_at"
+
+func scanAuditEntry(row scanner) (models.AuditEntry, error) {
+	var e models.AuditEntry
+	var changes sql.NullString
+	err := row.Scan(&e.ID, &e.Actor, &e.Action, &e.TargetType, &e.TargetID, &e.EventID, &changes, &e.IP, &e.CreatedAt)
+	if err != nil || !changes.Valid {
+		return e, sqlErr(err)
+	}
+	return e, json.Unmarshal([]byte(changes.String), &e.Changes)
+}
+
+func (s *Store) CreateAuditEntry(ctx context.Context, entry models.AuditEntry) (err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.CreateAuditEntry")
+	defer func() { tracing.End(span, err) }()
+	var changes sql.NullString
+	if len(entry.Changes) > 0 {
+		data, err := json.Marshal(entry.Changes)
+		if err != nil {
+			return err
+		}
+		changes = sql.NullString{String: string(data), Valid: true}
+	}
+	_, err = s.conn(ctx).ExecContext(ctx, "INSERT INTO audit_entries ("+auditColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		models.NewID(), entry.Actor, entry.Action, entry.TargetType, entry.TargetID, entry.EventID, changes, entry.IP, time.Now().UTC())
+	return sqlErr(err)
+}
+
+// auditWhere renders the filter as a WHERE condition and its arguments
+func auditWhere(f repository.AuditFilter) (string, []any) {
+	conds := []string{"TRUE"}
+	var args []any
+	add := func(cond string, value any) {
+		args = append(args, value)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if f.Actor != "" {
+		add("actor = $%d", f.Actor)
+	}
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if f.TargetType != "" {
+		add("target_type = $%d", f.TargetType)
+	}
+	if f.TargetIDs != nil {
+		list := make([]string, len(f.TargetIDs))
+		for i, id := range f.TargetIDs {
+			args = append(args, id)
+			list[i] = fmt.Sprintf("$%d", len(args))
+		}
+		if len(list) == 0 {
+			conds = append(conds, "FALSE")
+		} else {
+			conds = append(conds, "target_id IN ("+strings.Join(list, ", ")+")")
+		}
+	}
+	if !f.EventID.IsZero() {
+		add("event_id = $%d", f.EventID)
+	}
+	if !f.Since.IsZero() {
+		add("created_at >= $%d", f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		add("created_at < $%d", f.Until.UTC())
+	}
+	if !f.Before.IsZero() {
+		add("id < $%d", f.Before)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// ListAuditEntries orders by ID, which grows with time, newest first
+func (s *Store) ListAuditEntries(ctx context.Context, filter repository.AuditFilter, limit int) (_ []models.AuditEntry, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.ListAuditEntries")
+	defer func() { tracing.End(span, err) }()
+	where, args := auditWhere(filter)
+	query := "SELECT " + auditColumns + " FROM audit_entries WHERE " + where + " ORDER BY id DESC"
+	if limit > 0 {
+		args = append(args, limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	rows, err := s.conn(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *Store) EachAuditEntry(ctx context.Context, filter repository.AuditFilter, fn func(models.AuditEntry) error) (err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.EachAuditEntry")
+	defer func() { tracing.End(span, err) }()
+	where, args := auditWhere(filter)
+	rows, err := s.conn(ctx).QueryContext(ctx, "SELECT "+auditColumns+" FROM audit_entries WHERE "+where+" ORDER BY id DESC", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *Store) RedactActor(ctx context.Context, actor, replacement string, pids []int) (err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.RedactActor")
+	defer func() { tracing.End(span, err) }()
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "UPDATE refunds SET requested_by = $1 WHERE requested_by = $2", replacement, actor); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE audit_entries SET actor = $1 WHERE actor = $2", replacement, actor); err != nil {
+		return err
+	}
+	if len(pids) > 0 {
+		targets := make([]any, len(pids))
+		for i, pid := range pids {
+			targets[i] = strconv.Itoa(pid)
+		}
+		_, err := tx.ExecContext(ctx, "UPDATE audit_entries SET changes = NULL WHERE target_type = 'participant' AND target_id IN ("+
+			marks(1, len(targets))+")", targets...)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *Store) PlaintextActors(ctx context.Context, limit int) (_ []string, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.PlaintextActors")
+	defer func() { tracing.End(span, err) }()
+	rows, err := s.conn(ctx).QueryContext(ctx, `SELECT actor FROM audit_entries WHERE actor LIKE 'participant:%@%'
+		UNION SELECT requested_by FROM refunds WHERE requested_by LIKE 'participant:%@%' LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	actors := []string{}
+	for rows.Next() {
+		var actor string
+		if err := rows.Scan(&actor); err != nil {
+			return nil, err
+		}
+		actors = append(actors, actor)
+	}
+	return actors, rows.Err()
+}
@@ -0,0 +1,159 @@
+package sqlstore
+
+import (
+	"backend/src/models"
+	"backend/src/tracing"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+const eventColumns = `id, slug, name, description, starts_at, ends_at, timezone, venue, website, banner_url,
+	fee, early_bird_fee, capacity, min_team_size, max_team_size, verify_emails, registration_opens_at,
+	registration_closes_at, early_bird_ends_at, edits_close_at, refund_policy, paused, resumes_at,
+	upload_folder, code_prefix, templates, created_at, updated_at`
+
+func scanEvent(row scanner) (models.Event, error) {
+	var e models.Event
+	var startsAt, endsAt, opensAt, closesAt, earlyBirdEndsAt, editsCloseAt, resumesAt sql.NullTime
+	var refundPolicy, templates string
+	err := row.Scan(&e.ID, &e.Slug, &e.Name, &e.Description, &startsAt, &endsAt, &e.Timezone, &e.Venue,
+		&e.Website, &e.BannerURL, &e.Fee, &e.EarlyBirdFee, &e.Capacity, &e.MinTeamSize, &e.MaxTeamSize,
+		&e.VerifyEmails, &opensAt, &closesAt, &earlyBirdEndsAt, &editsCloseAt, &refundPolicy, &e.Paused,
+		&resumesAt, &e.UploadFolder, &e.CodePrefix, &templates, &e.CreatedAt, &e.UpdatedAt)
+	if err != nil {
+		return e, sqlErr(err)
+	}
+	e.StartsAt, e.EndsAt = startsAt.Time, endsAt.Time
+	e.RegistrationOpensAt, e.RegistrationClosesAt = opensAt.Time, closesAt.Time
+	e.EarlyBirdEndsAt, e.EditsCloseAt = earlyBirdEndsAt.Time, editsCloseAt.Time
+	e.ResumesAt = timePtr(resumesAt)
+	if err := json.Unmarshal([]byte(refundPolicy), &e.RefundPolicy); err != nil {
+		return e, err
+	}
+	return e, json.Unmarshal([]byte(templates), &e.Templates)
+}
+
+// nullTime stores the zero time as NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
+
+func nullTimePtr(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return nullTime(*t)
+}
+
+// eventArgs lists the values of eventColumns
+func eventArgs(e models.Event) ([]any, error) {
+	refundPolicy, err := json.Marshal(e.RefundPolicy)
+	if err != nil {
+		return nil, err
+	}
+	templates, err := json.Marshal(e.Templates)
+	if err != nil {
+		return nil, err
+	}
+	return []any{e.ID, e.Slug, e.Name, e.Description, nullTime(e.StartsAt), nullTime(e.EndsAt), e.Timezone,
+		e.Venue, e.Website, e.BannerURL, e.Fee, e.EarlyBirdFee, e.Capacity, e.MinTeamSize, e.MaxTeamSize,
+		e.VerifyEmails, nullTime(e.RegistrationOpensAt), nullTime(e.RegistrationClosesAt),
+		nullTime(e.EarlyBirdEndsAt), nullTime(e.EditsCloseAt), string(refundPolicy), e.Paused,
+		nullTimePtr(e.ResumesAt), e.UploadFolder, e.CodePrefix, string(templates), e.CreatedAt.UTC(), e.UpdatedAt.UTC()}, nil
+}
+
+func (s *Store) CreateEvent(ctx context.Context, event models.Event) (_ models.ID, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.CreateEvent")
+	defer func() { tracing.End(span, err) }()
+	event.ID = models.NewID()
+	event.CreatedAt = time.Now()
+	event.UpdatedAt = event.CreatedAt
+	args, err := eventArgs(event)
+	if err != nil {
+		return models.ID{}, err
+	}
+	_, err = s.conn(ctx).ExecContext(ctx, "INSERT INTO events ("+eventColumns+") VALUES ("+marks(1, len(args))+")", args...)
+	if err != nil {
+		return models.ID{}, sqlErr(err)
+	}
+	return event.ID, nil
+}
+
+func (s *Store) GetEventBySlug(ctx context.Context, slug string) (_ models.Event, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.GetEventBySlug")
+	defer func() { tracing.End(span, err) }()
+	return scanEvent(s.conn(ctx).QueryRowContext(ctx, "SELECT "+eventColumns+" FROM events WHERE slug = $1", slug))
+}
+
+func (s *Store) ListEvents(ctx context.Context) (_ []models.Event, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.ListEvents")
+	defer func() { tracing.End(span, err) }()
+	// Events without a start sort last, as the zero time does elsewhere
+	rows, err := s.conn(ctx).QueryContext(ctx, "SELECT "+eventColumns+" FROM events ORDER BY starts_at IS NULL, starts_at DESC, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []models.Event{}
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (s *Store) UpdateEvent(ctx context.Context, event models.Event) (err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.UpdateEvent")
+	defer func() { tracing.End(span, err) }()
+	event.UpdatedAt = time.Now()
+	args, err := eventArgs(event)
+	if err != nil {
+		return err
+	}
+	// Every column but created_at, in the order of eventColumns
+	args = slices.Delete(args, 26, 27)
+	return sqlErr(s.execOne(ctx, s.conn(ctx), `UPDATE events SET slug = $2, name = $3, description = $4, starts_at = $5,
+		ends_at = $6, timezone = $7, venue = $8, website = $9, banner_url = $10, fee = $11, early_bird_fee = $12,
+		capacity = $13, min_team_size = $14, max_team_size = $15, verify_emails = $16, registration_opens_at = $17,
+		registration_closes_at = $18, early_bird_ends_at = $19, edits_close_at = $20, refund_policy = $21,
+		paused = $22, resumes_at = $23, upload_folder = $24, code_prefix = $25, templates = $26, updated_at = $27
+		WHERE id = $1`, args...))
+}
+
+func (s *Store) SetEventPaused(ctx context.Context, slug string, paused bool, resumesAt *time.Time) (_ models.Event, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.SetEventPaused")
+	defer func() { tracing.End(span, err) }()
+	row := s.conn(ctx).QueryRowContext(ctx, `UPDATE events SET paused = $1, resumes_at = $2, updated_at = $3
+		WHERE slug = $4 RETURNING `+eventColumns, paused, nullTimePtr(resumesAt), time.Now().UTC(), slug)
+	return scanEvent(row)
+}
+
+// AdoptOrphans claims the rows without an event, which only exist when the
+// data was imported from a database that predates events
+func (s *Store) AdoptOrphans(ctx context.Context, eventID models.ID) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.AdoptOrphans")
+	defer func() { tracing.End(span, err) }()
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "UPDATE participants SET event_id = $1 WHERE event_id IN ('', $2)", eventID, models.ID{}); err != nil {
+		return 0, err
+	}
+	result, err := tx.ExecContext(ctx, "UPDATE registrations SET event_id = $1 WHERE event_id IN ('', $2)", eventID, models.ID{})
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), tx.Commit()
+}
@@ -0,0 +1,46 @@
+package sqlstore
+
+import (
+	"backend/src/tracing"
+	"context"
+	"database/sql"
+	"time"
+)
+
+// AcquireLock takes a free or expired lock, or extends the lease of its
+// owner, in a single upsert. expires_at is in Unix seconds like the rate
+// limits.
+func (s *Store) AcquireLock(ctx context.Context, name, owner string, ttl time.Duration) (_ bool, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.AcquireLock")
+	defer func() { tracing.End(span, err) }()
+	now := float64(time.Now().UnixMicro()) / 1e6
+	result, err := s.conn(ctx).ExecContext(ctx, `INSERT INTO locks (name, owner, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET owner = excluded.owner, expires_at = excluded.expires_at
+		WHERE locks.owner = excluded.owner OR locks.expires_at < $4`, name, owner, now+ttl.Seconds(), now)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func (s *Store) ReleaseLock(ctx context.Context, name, owner string) (err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.ReleaseLock")
+	defer func() { tracing.End(span, err) }()
+	_, err = s.conn(ctx).ExecContext(ctx, "DELETE FROM locks WHERE name = $1 AND owner = $2", name, owner)
+	return err
+}
+
+// LockInTransaction writes a row of the locks table that no lease uses:
+// Postgres makes other writers of the row wait until the transaction ends,
+// and SQLite has a single writer anyway
+func (s *Store) LockInTransaction(ctx context.Context, name string) (err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.LockInTransaction")
+	defer func() { tracing.End(span, err) }()
+	if _, ok := ctx.Value(txKey{}).(*sql.Tx); !ok {
+		return nil
+	}
+	_, err = s.conn(ctx).ExecContext(ctx, `INSERT INTO locks (name, owner, expires_at) VALUES ($1, '', 0)
+		ON CONFLICT (name) DO UPDATE SET expires_at = 0`, "tx:"+name)
+	return err
+}
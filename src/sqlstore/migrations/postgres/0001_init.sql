@@ -0,0 +1,47 @@
+CREATE SEQUENCE participant_pid;
+
+CREATE TABLE participants (
+    pid           BIGINT PRIMARY KEY,
+    id            TEXT NOT NULL UNIQUE,
+    event_id      TEXT NOT NULL,
+    name          TEXT NOT NULL,
+    email         TEXT NOT NULL,
+    phone         TEXT NOT NULL,
+    college_name  TEXT NOT NULL,
+    year_of_study INTEGER NOT NULL,
+    dual_boot     BOOLEAN NOT NULL,
+    mail_sent     BOOLEAN NOT NULL DEFAULT FALSE,
+    cancelled     BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at    TIMESTAMPTZ NOT NULL,
+    updated_at    TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX participants_event_email ON participants (event_id, lower(email));
+
+CREATE TABLE registrations (
+    id                  TEXT PRIMARY KEY,
+    event_id            TEXT NOT NULL,
+    team_name           TEXT NOT NULL,
+    team_name_key       TEXT NOT NULL,
+    leader_pid          BIGINT NOT NULL,
+    num_of_participants INTEGER NOT NULL,
+    total_amount        INTEGER NOT NULL,
+    transaction_id      TEXT NOT NULL,
+    transaction_image   TEXT NOT NULL,
+    mail_sent           BOOLEAN NOT NULL DEFAULT FALSE,
+    referral_code       TEXT NOT NULL,
+    status              TEXT NOT NULL,
+    created_at          TIMESTAMPTZ NOT NULL,
+    updated_at          TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX registrations_event_team ON registrations (event_id, team_name_key);
+
+-- One row per participant of a registration. Position keeps the submitted
+-- order and cancelled_seq the order in which participants cancelled.
+CREATE TABLE registration_participants (
+    registration_id TEXT NOT NULL REFERENCES registrations (id),
+    pid             BIGINT NOT NULL,
+    position        INTEGER NOT NULL,
+    cancelled_seq   INTEGER,
+    PRIMARY KEY (registration_id, pid)
+);
+CREATE INDEX registration_participants_pid ON registration_participants (pid);
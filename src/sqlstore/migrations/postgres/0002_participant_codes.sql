@@ -0,0 +1,3 @@
+-- Participants from before codes existed have none, and NULLs never collide
+ALTER TABLE participants ADD COLUMN code TEXT;
+CREATE UNIQUE INDEX participants_event_code ON participants (event_id, code);
@@ -0,0 +1,6 @@
+-- Deleted rows are hidden until restored; anonymized rows have had their
+-- personal data cleared by the retention job
+ALTER TABLE participants ADD COLUMN deleted_at TIMESTAMPTZ;
+ALTER TABLE participants ADD COLUMN anonymized_at TIMESTAMPTZ;
+ALTER TABLE registrations ADD COLUMN deleted_at TIMESTAMPTZ;
+ALTER TABLE registrations ADD COLUMN anonymized_at TIMESTAMPTZ;
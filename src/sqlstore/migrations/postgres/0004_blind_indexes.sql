@@ -0,0 +1,4 @@
+-- Blind indexes let encrypted emails and phones be looked up by equality
+ALTER TABLE participants ADD COLUMN email_index TEXT;
+ALTER TABLE participants ADD COLUMN phone_index TEXT;
+CREATE INDEX participants_event_email_index ON participants (event_id, email_index);
@@ -0,0 +1,111 @@
+-- Everything that used to need Mongo. Lists and nested documents are
+-- stored as JSON text.
+CREATE TABLE events (
+    id                     TEXT PRIMARY KEY,
+    slug                   TEXT NOT NULL UNIQUE,
+    name                   TEXT NOT NULL,
+    description            TEXT NOT NULL,
+    starts_at              TIMESTAMPTZ,
+    ends_at                TIMESTAMPTZ,
+    timezone               TEXT NOT NULL,
+    venue                  TEXT NOT NULL,
+    website                TEXT NOT NULL,
+    banner_url             TEXT NOT NULL,
+    fee                    INTEGER NOT NULL,
+    early_bird_fee         INTEGER NOT NULL,
+    capacity               INTEGER NOT NULL,
+    min_team_size          INTEGER NOT NULL,
+    max_team_size          INTEGER NOT NULL,
+    verify_emails          BOOLEAN NOT NULL,
+    registration_opens_at  TIMESTAMPTZ,
+    registration_closes_at TIMESTAMPTZ,
+    early_bird_ends_at     TIMESTAMPTZ,
+    edits_close_at         TIMESTAMPTZ,
+    refund_policy          TEXT NOT NULL,
+    paused                 BOOLEAN NOT NULL,
+    resumes_at             TIMESTAMPTZ,
+    upload_folder          TEXT NOT NULL,
+    code_prefix            TEXT NOT NULL,
+    templates              TEXT NOT NULL,
+    created_at             TIMESTAMPTZ NOT NULL,
+    updated_at             TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE uploads (
+    id         TEXT PRIMARY KEY,
+    event_id   TEXT NOT NULL,
+    url        TEXT NOT NULL,
+    consumed   BOOLEAN NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX uploads_event ON uploads (event_id);
+CREATE INDEX uploads_consumed_created ON uploads (consumed, created_at);
+
+CREATE TABLE otps (
+    id         TEXT PRIMARY KEY,
+    event_id   TEXT NOT NULL,
+    email      TEXT NOT NULL,
+    code_hash  TEXT NOT NULL,
+    attempts   INTEGER NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    UNIQUE (event_id, email)
+);
+CREATE INDEX otps_email ON otps (email);
+
+CREATE TABLE refunds (
+    id              TEXT PRIMARY KEY,
+    event_id        TEXT NOT NULL,
+    registration_id TEXT NOT NULL,
+    participants    TEXT NOT NULL,
+    amount          INTEGER NOT NULL,
+    status          TEXT NOT NULL,
+    requested_by    TEXT NOT NULL,
+    reason          TEXT NOT NULL,
+    transaction_id  TEXT NOT NULL,
+    reference       TEXT NOT NULL,
+    reviewed_by     TEXT NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL,
+    updated_at      TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX refunds_event_status ON refunds (event_id, status);
+CREATE INDEX refunds_registration ON refunds (registration_id);
+CREATE INDEX refunds_requested_by ON refunds (requested_by);
+
+-- IDs grow over time, so ordering by id lists the newest entries first
+CREATE TABLE audit_entries (
+    id          TEXT PRIMARY KEY,
+    actor       TEXT NOT NULL,
+    action      TEXT NOT NULL,
+    target_type TEXT NOT NULL,
+    target_id   TEXT NOT NULL,
+    event_id    TEXT NOT NULL,
+    changes     TEXT,
+    ip          TEXT NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX audit_entries_actor ON audit_entries (actor);
+CREATE INDEX audit_entries_target ON audit_entries (target_type, target_id);
+CREATE INDEX audit_entries_event ON audit_entries (event_id);
+CREATE INDEX audit_entries_created ON audit_entries (created_at);
+
+CREATE TABLE admin_users (
+    id              TEXT PRIMARY KEY,
+    username        TEXT NOT NULL UNIQUE,
+    password_hash   TEXT NOT NULL,
+    role            TEXT NOT NULL,
+    disabled        BOOLEAN NOT NULL,
+    session_version INTEGER NOT NULL,
+    last_login_at   TIMESTAMPTZ,
+    created_at      TIMESTAMPTZ NOT NULL,
+    updated_at      TIMESTAMPTZ NOT NULL
+);
+
+-- Token buckets. updated_at is in Unix seconds so that both dialects can
+-- refill with plain arithmetic.
+CREATE TABLE rate_limits (
+    key        TEXT PRIMARY KEY,
+    tokens     DOUBLE PRECISION NOT NULL,
+    allowed    BOOLEAN NOT NULL,
+    updated_at DOUBLE PRECISION NOT NULL
+);
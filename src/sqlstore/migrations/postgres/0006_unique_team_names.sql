@@ -0,0 +1,5 @@
+-- Team names are unique among the active registrations of an event. This
+-- fails on data that already breaks the rule, which has to be fixed by hand.
+DROP INDEX registrations_event_team;
+CREATE UNIQUE INDEX registrations_event_team ON registrations (event_id, team_name_key)
+    WHERE team_name_key <> '' AND status <> 'cancelled' AND deleted_at IS NULL;
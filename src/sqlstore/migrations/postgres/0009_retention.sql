@@ -0,0 +1,13 @@
+-- Leases that keep a job such as retention on one instance at a time.
+-- expires_at is in Unix seconds like the rate limits.
+CREATE TABLE locks (
+    name       TEXT PRIMARY KEY,
+    owner      TEXT NOT NULL,
+    expires_at DOUBLE PRECISION NOT NULL
+);
+
+-- Participant edits only record that the name or phone changed
+UPDATE audit_entries SET changes = jsonb_set(changes::jsonb, '{name}', '{"before":"[redacted]","after":"[redacted]"}')::text
+    WHERE action = 'participant.update' AND changes::jsonb ? 'name';
+UPDATE audit_entries SET changes = jsonb_set(changes::jsonb, '{phone}', '{"before":"[redacted]","after":"[redacted]"}')::text
+    WHERE action = 'participant.update' AND changes::jsonb ? 'phone';
@@ -0,0 +1,6 @@
+-- full_at is when a bucket has refilled, in unix seconds like updated_at.
+-- Buckets past it behave like missing ones and are pruned. The rate of
+-- existing buckets is unknown, so they are kept for a day.
+ALTER TABLE rate_limits ADD COLUMN full_at REAL NOT NULL DEFAULT 0;
+UPDATE rate_limits SET full_at = updated_at + 86400;
+CREATE INDEX rate_limits_full_at ON rate_limits (full_at);
@@ -0,0 +1,9 @@
+-- Phones are never looked up, so they need no blind index
+ALTER TABLE participants DROP COLUMN phone_index;
+
+-- Pending codes are kept under a keyed hash of the address rather than the
+-- address itself. Codes last minutes, so the pending ones are dropped.
+DELETE FROM otps;
+DROP INDEX otps_email;
+ALTER TABLE otps RENAME COLUMN email TO email_hash;
+CREATE INDEX otps_email_hash ON otps (email_hash);
@@ -0,0 +1,13 @@
+-- Leases that keep a job such as retention on one instance at a time.
+-- expires_at is in Unix seconds like the rate limits.
+CREATE TABLE locks (
+    name       TEXT PRIMARY KEY,
+    owner      TEXT NOT NULL,
+    expires_at REAL NOT NULL
+);
+
+-- Participant edits only record that the name or phone changed
+UPDATE audit_entries SET changes = json_set(changes, '$.name', json('{"before":"[redacted]","after":"[redacted]"}'))
+    WHERE action = 'participant.update' AND json_extract(changes, '$.name') IS NOT NULL;
+UPDATE audit_entries SET changes = json_set(changes, '$.phone', json('{"before":"[redacted]","after":"[redacted]"}'))
+    WHERE action = 'participant.update' AND json_extract(changes, '$.phone') IS NOT NULL;
@@ -0,0 +1,66 @@
+package sqlstore
+
+import (
+	"backend/src/models"
+	"backend/src/tracing"
+	"context"
+	"time"
+)
+
+const otpColumns = "id, event_id, email_hash, code_hash, attempts, expires_at, created_at"
+
+func scanOTP(row scanner) (models.OTP, error) {
+	var o models.OTP
+	err := row.Scan(&o.ID, &o.EventID, &o.EmailHash, &o.CodeHash, &o.Attempts, &o.ExpiresAt, &o.CreatedAt)
+	return o, sqlErr(err)
+}
+
+func (s *Store) GetOTP(ctx context.Context, eventID models.ID, emailHash string) (_ models.OTP, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.GetOTP")
+	defer func() { tracing.End(span, err) }()
+	return scanOTP(s.conn(ctx).QueryRowContext(ctx, "SELECT "+otpColumns+" FROM otps WHERE event_id = $1 AND email_hash = $2", eventID, emailHash))
+}
+
+// SaveOTP replaces any pending code for the address, resetting its attempts
+func (s *Store) SaveOTP(ctx context.Context, otp models.OTP) (err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.SaveOTP")
+	defer func() { tracing.End(span, err) }()
+	_, err = s.conn(ctx).ExecContext(ctx, `INSERT INTO otps (`+otpColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (event_id, email_hash) DO UPDATE SET code_hash = excluded.code_hash, attempts = excluded.attempts,
+			expires_at = excluded.expires_at, created_at = excluded.created_at`,
+		models.NewID(), otp.EventID, otp.EmailHash, otp.CodeHash, otp.Attempts, otp.ExpiresAt.UTC(), time.Now().UTC())
+	return sqlErr(err)
+}
+
+func (s *Store) ClaimOTPAttempt(ctx context.Context, eventID models.ID, emailHash string, maxAttempts int) (_ models.OTP, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.ClaimOTPAttempt")
+	defer func() { tracing.End(span, err) }()
+	row := s.conn(ctx).QueryRowContext(ctx, `UPDATE otps SET attempts = attempts + 1
+		WHERE event_id = $1 AND email_hash = $2 AND attempts < $3 RETURNING `+otpColumns, eventID, emailHash, maxAttempts)
+	return scanOTP(row)
+}
+
+func (s *Store) DeleteOTP(ctx context.Context, id models.ID) (err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.DeleteOTP")
+	defer func() { tracing.End(span, err) }()
+	_, err = s.conn(ctx).ExecContext(ctx, "DELETE FROM otps WHERE id = $1", id)
+	return err
+}
+
+func (s *Store) DeleteOTPsByEmailHash(ctx context.Context, emailHash string) (err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.DeleteOTPsByEmailHash")
+	defer func() { tracing.End(span, err) }()
+	_, err = s.conn(ctx).ExecContext(ctx, "DELETE FROM otps WHERE email_hash = $1", emailHash)
+	return err
+}
+
+func (s *Store) DeleteExpiredOTPs(ctx context.Context, now time.Time) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.DeleteExpiredOTPs")
+	defer func() { tracing.End(span, err) }()
+	result, err := s.conn(ctx).ExecContext(ctx, "DELETE FROM otps WHERE expires_at < $1", now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,409 @@
+package sqlstore
+
+import (
+	"backend/src/codes"
+	"backend/src/models"
+	"backend/src/repository"
+	"backend/src/tracing"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const participantColumns = `pid, id, event_id, name, email, phone, college_name, year_of_study,
+	dual_boot, mail_sent, cancelled, created_at, updated_at, code, deleted_at, anonymized_at, email_index`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanParticipant(row scanner) (models.Participant, error) {
+	var p models.Participant
+	var id, eventID string
+	var code, emailIndex sql.NullString
+	var deletedAt, anonymizedAt sql.NullTime
+	err := row.Scan(&p.PID, &id, &eventID, &p.Name, &p.Email, &p.Phone, &p.CollegeName, &p.YearOfStudy,
+		&p.DualBoot, &p.MailSent, &p.Cancelled, &p.CreatedAt, &p.UpdatedAt, &code, &deletedAt, &anonymizedAt,
+		&emailIndex)
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, repository.ErrNotFound
+	}
+	if err != nil {
+		return p, err
+	}
+	p.Code, p.EmailIndex = code.String, emailIndex.String
+	p.DeletedAt, p.AnonymizedAt = timePtr(deletedAt), timePtr(anonymizedAt)
+	if p.ID, err = models.ParseID(id); err != nil {
+		return p, err
+	}
+	p.EventID, err = models.ParseID(eventID)
+	return p, err
+}
+
+func (s *Store) queryParticipants(ctx context.Context, query string, args ...any) ([]models.Participant, error) {
+	rows, err := s.conn(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	participants := []models.Participant{}
+	for rows.Next() {
+		participant, err := scanParticipant(rows)
+		if err != nil {
+			return nil, err
+		}
+		participants = append(participants, participant)
+	}
+	return participants, rows.Err()
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// reservePIDs takes n values from the PID sequence in one statement
+func (s *Store) reservePIDs(ctx context.Context, q querier, n int) ([]int, error) {
+	pids := make([]int, 0, n)
+	if s.dialect == SQLite {
+		// The counter ends up holding the last PID of the block
+		var last int
+		err := q.QueryRowContext(ctx, "UPDATE sequences SET value = value + $1 WHERE name = 'participant_pid' RETURNING value", n).Scan(&last)
+		if err != nil {
+			return nil, err
+		}
+		for pid := last - n + 1; pid <= last; pid++ {
+			pids = append(pids, pid)
+		}
+		return pids, nil
+	}
+
+	rows, err := q.QueryContext(ctx, "SELECT nextval('participant_pid') FROM generate_series(1, $1)", n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pid int
+		if err := rows.Scan(&pid); err != nil {
+			return nil, err
+		}
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	return pids, rows.Err()
+}
+
+func (s *Store) GetNextPID(ctx context.Context) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.GetNextPID")
+	defer func() { tracing.End(span, err) }()
+	pids, err := s.reservePIDs(ctx, s.conn(ctx), 1)
+	if err != nil {
+		return 0, err
+	}
+	return pids[0], nil
+}
+
+func (s *Store) CreateParticipant(ctx context.Context, participant models.Participant) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.CreateParticipant")
+	defer func() { tracing.End(span, err) }()
+	pids, err := s.CreateParticipants(ctx, []models.Participant{participant})
+	if err != nil {
+		return 0, err
+	}
+	return pids[0], nil
+}
+
+// CreateParticipants reserves the group's PIDs and inserts it with a single
+// multi-row statement
+func (s *Store) CreateParticipants(ctx context.Context, participants []models.Participant) (_ []int, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.CreateParticipants")
+	defer func() { tracing.End(span, err) }()
+	if len(participants) == 0 {
+		return []int{}, nil
+	}
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	pids, err := s.reservePIDs(ctx, tx, len(participants))
+	if err != nil {
+		return nil, err
+	}
+	for i := range participants {
+		participants[i].PID = pids[i]
+	}
+	// A savepoint lets the insert be retried when a concurrent registration
+	// takes one of the codes between the check and the insert, as Postgres
+	// aborts the whole transaction on a failed statement
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT create_participants"); err != nil {
+		return nil, err
+	}
+	for attempt := 1; ; attempt++ {
+		if err := s.rerollTakenCodes(ctx, tx, participants); err != nil {
+			return nil, err
+		}
+		err := s.insertParticipants(ctx, tx, participants)
+		if err == nil {
+			break
+		}
+		if attempt == maxCodeAttempts || !isUniqueViolation(err) {
+			return nil, sqlErr(err)
+		}
+		if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT create_participants"); err != nil {
+			return nil, err
+		}
+	}
+	return pids, tx.Commit()
+}
+
+// maxCodeAttempts bounds the retries when participant codes collide
+const maxCodeAttempts = 5
+
+func (s *Store) insertParticipants(ctx context.Context, e execer, participants []models.Participant) error {
+	now := time.Now().UTC()
+	var rows []string
+	var args []any
+	for _, p := range participants {
+		marks := make([]string, 17)
+		for j := range marks {
+			marks[j] = fmt.Sprintf("$%d", len(args)+j+1)
+		}
+		rows = append(rows, "("+strings.Join(marks, ", ")+")")
+		args = append(args, p.PID, models.NewID().Hex(), p.EventID.Hex(), p.Name, p.Email, p.Phone,
+			p.CollegeName, p.YearOfStudy, p.DualBoot, p.MailSent, p.Cancelled, now, now, nullString(p.Code), nil, nil,
+			nullString(p.EmailIndex))
+	}
+	_, err := e.ExecContext(ctx, "INSERT INTO participants ("+participantColumns+") VALUES "+strings.Join(rows, ", "), args...)
+	return err
+}
+
+// rerollTakenCodes replaces codes that are already used in their event or
+// earlier in the group. The unique index still rejects a code taken by a
+// concurrent registration.
+func (s *Store) rerollTakenCodes(ctx context.Context, q querier, participants []models.Participant) error {
+	seen := map[string]bool{}
+	for i := range participants {
+		p := &participants[i]
+		if p.Code == "" {
+			continue
+		}
+		for {
+			key := p.EventID.Hex() + "/" + p.Code
+			var taken bool
+			err := q.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM participants WHERE event_id = $1 AND code = $2)",
+				p.EventID.Hex(), p.Code).Scan(&taken)
+			if err != nil {
+				return err
+			}
+			if !taken && !seen[key] {
+				seen[key] = true
+				break
+			}
+			p.Code = codes.Reroll(p.Code)
+		}
+	}
+	return nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func (s *Store) GetParticipantByCode(ctx context.Context, eventID models.ID, code string) (_ models.Participant, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.GetParticipantByCode")
+	defer func() { tracing.End(span, err) }()
+	row := s.conn(ctx).QueryRowContext(ctx, "SELECT "+participantColumns+" FROM participants WHERE event_id = $1 AND code = $2 AND deleted_at IS NULL", eventID.Hex(), code)
+	return scanParticipant(row)
+}
+
+func (s *Store) GetParticipant(ctx context.Context, pid int) (_ models.Participant, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.GetParticipant")
+	defer func() { tracing.End(span, err) }()
+	row := s.conn(ctx).QueryRowContext(ctx, "SELECT "+participantColumns+" FROM participants WHERE pid = $1 AND deleted_at IS NULL", pid)
+	return scanParticipant(row)
+}
+
+func (s *Store) GetParticipants(ctx context.Context, pids []int) (_ []models.Participant, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.GetParticipants")
+	defer func() { tracing.End(span, err) }()
+	if len(pids) == 0 {
+		return []models.Participant{}, nil
+	}
+	list, args := inList(1, pids)
+	return s.queryParticipants(ctx, "SELECT "+participantColumns+" FROM participants WHERE pid IN ("+list+") AND deleted_at IS NULL ORDER BY pid", args...)
+}
+
+func (s *Store) GetParticipantsByEmail(ctx context.Context, eventID models.ID, email string) (_ []models.Participant, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.GetParticipantsByEmail")
+	defer func() { tracing.End(span, err) }()
+	return s.queryParticipants(ctx, "SELECT "+participantColumns+` FROM participants
+		WHERE event_id = $1 AND lower(email) = lower($2) AND deleted_at IS NULL ORDER BY pid`, eventID.Hex(), email)
+}
+
+func (s *Store) GetParticipantsByEmailIndex(ctx context.Context, eventID models.ID, index string) (_ []models.Participant, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.GetParticipantsByEmailIndex")
+	defer func() { tracing.End(span, err) }()
+	return s.queryParticipants(ctx, "SELECT "+participantColumns+` FROM participants
+		WHERE event_id = $1 AND email_index = $2 AND deleted_at IS NULL ORDER BY pid`, eventID.Hex(), index)
+}
+
+func (s *Store) FindParticipantsByEmail(ctx context.Context, email string) (_ []models.Participant, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.FindParticipantsByEmail")
+	defer func() { tracing.End(span, err) }()
+	return s.queryParticipants(ctx, "SELECT "+participantColumns+" FROM participants WHERE lower(email) = lower($1) ORDER BY pid", email)
+}
+
+func (s *Store) FindParticipantsByEmailIndex(ctx context.Context, index string) (_ []models.Participant, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.FindParticipantsByEmailIndex")
+	defer func() { tracing.End(span, err) }()
+	return s.queryParticipants(ctx, "SELECT "+participantColumns+" FROM participants WHERE email_index = $1 ORDER BY pid", index)
+}
+
+func (s *Store) ScanParticipants(ctx context.Context, afterPID, limit int) (_ []models.Participant, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.ScanParticipants")
+	defer func() { tracing.End(span, err) }()
+	return s.queryParticipants(ctx, "SELECT "+participantColumns+" FROM participants WHERE pid > $1 ORDER BY pid LIMIT $2", afterPID, limit)
+}
+
+func (s *Store) ResealParticipant(ctx context.Context, p models.Participant) (err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.ResealParticipant")
+	defer func() { tracing.End(span, err) }()
+	return s.execOne(ctx, s.conn(ctx), "UPDATE participants SET email = $1, phone = $2, email_index = $3 WHERE pid = $4",
+		p.Email, p.Phone, nullString(p.EmailIndex), p.PID)
+}
+
+func (s *Store) SetParticipantCode(ctx context.Context, pid int, code string) (err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.SetParticipantCode")
+	defer func() { tracing.End(span, err) }()
+	return sqlErr(s.execOne(ctx, s.conn(ctx), "UPDATE participants SET code = $1 WHERE pid = $2 AND (code IS NULL OR code = '')", code, pid))
+}
+
+func (s *Store) UpdateParticipant(ctx context.Context, pid int, update repository.ParticipantUpdate) (err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.UpdateParticipant")
+	defer func() { tracing.End(span, err) }()
+	u := updateBuilder{sets: []string{"updated_at = $1"}, args: []any{time.Now().UTC()}}
+	setIf(&u, "name", update.Name)
+	setIf(&u, "email", update.Email)
+	setIf(&u, "phone", update.Phone)
+	if update.EmailIndex != nil {
+		u.set("email_index", nullString(*update.EmailIndex))
+	}
+	setIf(&u, "college_name", update.CollegeName)
+	setIf(&u, "year_of_study", update.YearOfStudy)
+	setIf(&u, "dual_boot", update.DualBoot)
+	u.args = append(u.args, pid)
+	query := fmt.Sprintf("UPDATE participants SET %s WHERE pid = $%d AND deleted_at IS NULL", strings.Join(u.sets, ", "), len(u.args))
+	return s.execOne(ctx, s.conn(ctx), query, u.args...)
+}
+
+// updateBuilder collects the SET clauses of an UPDATE and their arguments
+type updateBuilder struct {
+	sets []string
+	args []any
+}
+
+func (u *updateBuilder) set(column string, value any) {
+	u.args = append(u.args, value)
+	u.sets = append(u.sets, fmt.Sprintf("%s = $%d", column, len(u.args)))
+}
+
+// setIf sets a column from a field of a typed update unless it is nil
+func setIf[T any](u *updateBuilder, column string, value *T) {
+	if value != nil {
+		u.set(column, *value)
+	}
+}
+
+func (s *Store) CountParticipants(ctx context.Context, eventID models.ID) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.CountParticipants")
+	defer func() { tracing.End(span, err) }()
+	var count int
+	err = s.conn(ctx).QueryRowContext(ctx, "SELECT COUNT(*) FROM participants WHERE event_id = $1 AND NOT cancelled AND deleted_at IS NULL", eventID.Hex()).Scan(&count)
+	return count, err
+}
+
+func (s *Store) DeleteParticipant(ctx context.Context, pid int) (err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.DeleteParticipant")
+	defer func() { tracing.End(span, err) }()
+	now := time.Now().UTC()
+	return s.execOne(ctx, s.conn(ctx), "UPDATE participants SET deleted_at = $1, updated_at = $1 WHERE pid = $2 AND deleted_at IS NULL", now, pid)
+}
+
+func (s *Store) RestoreParticipant(ctx context.Context, pid int) (err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.RestoreParticipant")
+	defer func() { tracing.End(span, err) }()
+	now := time.Now().UTC()
+	return s.execOne(ctx, s.conn(ctx), "UPDATE participants SET deleted_at = NULL, updated_at = $1 WHERE pid = $2 AND deleted_at IS NOT NULL", now, pid)
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// execOne returns ErrNotFound when the statement changes no rows
+func (s *Store) execOne(ctx context.Context, e execer, query string, args ...any) error {
+	result, err := e.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) AnonymizeParticipants(ctx context.Context, pids []int) (err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.AnonymizeParticipants")
+	defer func() { tracing.End(span, err) }()
+	if len(pids) == 0 {
+		return nil
+	}
+	list, args := inList(2, pids)
+	_, err = s.conn(ctx).ExecContext(ctx, `UPDATE participants SET name = '', email = '', phone = '', email_index = NULL, anonymized_at = $1, updated_at = $1
+		WHERE pid IN (`+list+`)`, append([]any{time.Now().UTC()}, args...)...)
+	return err
+}
+
+func (s *Store) ParticipantsToAnonymize(ctx context.Context, eventIDs []models.ID, deletedBefore time.Time, limit int) (_ []models.Participant, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.ParticipantsToAnonymize")
+	defer func() { tracing.End(span, err) }()
+	expired, args := expiredCondition(eventIDs, deletedBefore.UTC(), limit)
+	return s.queryParticipants(ctx, "SELECT "+participantColumns+` FROM participants
+		WHERE anonymized_at IS NULL AND `+expired+` ORDER BY pid LIMIT $2`, args...)
+}
+
+func (s *Store) ParticipantStats(ctx context.Context, eventID models.ID) (_ []models.ParticipantStat, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.ParticipantStats")
+	defer func() { tracing.End(span, err) }()
+	rows, err := s.conn(ctx).QueryContext(ctx, `SELECT college_name, year_of_study, COUNT(*) FROM participants
+		WHERE event_id = $1 AND deleted_at IS NULL
+		GROUP BY college_name, year_of_study ORDER BY college_name, year_of_study`, eventID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stats := []models.ParticipantStat{}
+	for rows.Next() {
+		var stat models.ParticipantStat
+		if err := rows.Scan(&stat.CollegeName, &stat.YearOfStudy, &stat.Count); err != nil {
+			return nil, err
+		}
+		stats = append(stats, stat)
+	}
+	return stats, rows.Err()
+}
@@ -0,0 +1,50 @@
+package sqlstore
+
+import (
+	"backend/src/tracing"
+	"context"
+	"time"
+)
+
+// rateLimitPruneInterval is how often buckets that have refilled are deleted
+const rateLimitPruneInterval = time.Minute
+
+// TakeRateLimitToken refills and takes a token in a single upsert, so
+// concurrent requests from every instance see each other's takes. The SET
+// expressions all read the row as it was before the update.
+func (s *Store) TakeRateLimitToken(ctx context.Context, key string, burst, perSecond float64) (_ bool, _ float64, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.TakeRateLimitToken")
+	defer func() { tracing.End(span, err) }()
+	least := "min"
+	if s.dialect == Postgres {
+		least = "LEAST"
+	}
+	refilled := least + "($2, rate_limits.tokens + ($3 - rate_limits.updated_at) * $4)"
+	taken := "CASE WHEN " + refilled + " >= 1 THEN " + refilled + " - 1 ELSE " + refilled + " END"
+	now := float64(time.Now().UnixMicro()) / 1e6
+	if err := s.pruneRateLimits(ctx, now); err != nil {
+		return false, 0, err
+	}
+	var allowed bool
+	var tokens float64
+	err = s.conn(ctx).QueryRowContext(ctx, `INSERT INTO rate_limits (key, tokens, allowed, updated_at, full_at)
+		VALUES ($1, $2 - 1, TRUE, $3, $5)
+		ON CONFLICT (key) DO UPDATE SET
+			tokens = `+taken+`,
+			allowed = `+refilled+` >= 1,
+			updated_at = $3,
+			full_at = $3 + ($2 - (`+taken+`)) / $4
+		RETURNING allowed, tokens`, key, burst, now, perSecond, now+1/perSecond).Scan(&allowed, &tokens)
+	return allowed, tokens, err
+}
+
+// pruneRateLimits deletes the buckets that have refilled, which behave like
+// missing ones, at most once per interval on each instance
+func (s *Store) pruneRateLimits(ctx context.Context, now float64) error {
+	last := s.rateLimitsPruned.Load()
+	if now-float64(last) < rateLimitPruneInterval.Seconds() || !s.rateLimitsPruned.CompareAndSwap(last, int64(now)) {
+		return nil
+	}
+	_, err := s.conn(ctx).ExecContext(ctx, "DELETE FROM rate_limits WHERE full_at < $1", now)
+	return err
+}
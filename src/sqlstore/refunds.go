@@ -0,0 +1,106 @@
+package sqlstore
+
+import (
+	"backend/src/models"
+	"backend/src/repository"
+	"backend/src/tracing"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const refundColumns = `id, event_id, registration_id, participants, amount, status, requested_by, reason,
+	transaction_id, reference, reviewed_by, created_at, updated_at`
+
+func scanRefund(row scanner) (models.Refund, error) {
+	var r models.Refund
+	var participants string
+	err := row.Scan(&r.ID, &r.EventID, &r.RegistrationID, &participants, &r.Amount, &r.Status, &r.RequestedBy,
+		&r.Reason, &r.TransactionID, &r.Reference, &r.ReviewedBy, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return r, sqlErr(err)
+	}
+	return r, json.Unmarshal([]byte(participants), &r.Participants)
+}
+
+func (s *Store) CreateRefund(ctx context.Context, refund models.Refund) (_ models.ID, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.CreateRefund")
+	defer func() { tracing.End(span, err) }()
+	participants, err := json.Marshal(refund.Participants)
+	if err != nil {
+		return models.ID{}, err
+	}
+	refund.ID = models.NewID()
+	now := time.Now().UTC()
+	_, err = s.conn(ctx).ExecContext(ctx, "INSERT INTO refunds ("+refundColumns+") VALUES ("+marks(1, 13)+")",
+		refund.ID, refund.EventID, refund.RegistrationID, string(participants), refund.Amount, refund.Status,
+		refund.RequestedBy, refund.Reason, refund.TransactionID, refund.Reference, refund.ReviewedBy, now, now)
+	if err != nil {
+		return models.ID{}, sqlErr(err)
+	}
+	return refund.ID, nil
+}
+
+// refundWhere renders the filter as a WHERE condition and its arguments
+func refundWhere(f repository.RefundFilter) (string, []any) {
+	conds := []string{"TRUE"}
+	var args []any
+	add := func(cond string, value any) {
+		args = append(args, value)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if !f.EventID.IsZero() {
+		add("event_id = $%d", f.EventID)
+	}
+	if f.Status != "" {
+		add("status = $%d", f.Status)
+	}
+	if f.RequestedBy != "" {
+		add("requested_by = $%d", f.RequestedBy)
+	}
+	if f.RegistrationIDs != nil {
+		list := make([]string, len(f.RegistrationIDs))
+		for i, id := range f.RegistrationIDs {
+			args = append(args, id)
+			list[i] = fmt.Sprintf("$%d", len(args))
+		}
+		if len(list) == 0 {
+			conds = append(conds, "FALSE")
+		} else {
+			conds = append(conds, "registration_id IN ("+strings.Join(list, ", ")+")")
+		}
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+func (s *Store) ListRefunds(ctx context.Context, filter repository.RefundFilter) (_ []models.Refund, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.ListRefunds")
+	defer func() { tracing.End(span, err) }()
+	where, args := refundWhere(filter)
+	rows, err := s.conn(ctx).QueryContext(ctx, "SELECT "+refundColumns+" FROM refunds WHERE "+where+" ORDER BY created_at, id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	refunds := []models.Refund{}
+	for rows.Next() {
+		refund, err := scanRefund(rows)
+		if err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, refund)
+	}
+	return refunds, rows.Err()
+}
+
+func (s *Store) UpdateRefundStatus(ctx context.Context, id models.ID, from, status, reviewedBy, reference string) (_ models.Refund, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.UpdateRefundStatus")
+	defer func() { tracing.End(span, err) }()
+	row := s.conn(ctx).QueryRowContext(ctx, `UPDATE refunds SET status = $1, reviewed_by = $2,
+		reference = CASE WHEN $3 = '' THEN reference ELSE $3 END, updated_at = $4
+		WHERE id = $5 AND status = $6 RETURNING `+refundColumns,
+		status, reviewedBy, reference, time.Now().UTC(), id, from)
+	return scanRefund(row)
+}
@@ -0,0 +1,344 @@
+package sqlstore
+
+import (
+	"backend/src/models"
+	"backend/src/repository"
+	"backend/src/tracing"
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+)
+
+const registrationColumns = `id, event_id, team_name, team_name_key, leader_pid, num_of_participants,
+	total_amount, transaction_id, transaction_image, mail_sent, referral_code, status, created_at, updated_at,
+	deleted_at, anonymized_at`
+
+func scanRegistration(row scanner) (models.Registration, error) {
+	var r models.Registration
+	var id, eventID string
+	var deletedAt, anonymizedAt sql.NullTime
+	err := row.Scan(&id, &eventID, &r.TeamName, &r.TeamNameKey, &r.LeaderPID, &r.NumOfParticipants,
+		&r.TotalAmount, &r.TransactionID, &r.TransactionImage, &r.MailSent, &r.ReferralCode, &r.Status,
+		&r.CreatedAt, &r.UpdatedAt, &deletedAt, &anonymizedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r, repository.ErrNotFound
+	}
+	if err != nil {
+		return r, err
+	}
+	r.DeletedAt, r.AnonymizedAt = timePtr(deletedAt), timePtr(anonymizedAt)
+	if r.ID, err = models.ParseID(id); err != nil {
+		return r, err
+	}
+	r.EventID, err = models.ParseID(eventID)
+	return r, err
+}
+
+// loadMembers fills in the active and cancelled participants from the
+// junction table
+func (s *Store) loadMembers(ctx context.Context, reg *models.Registration) error {
+	rows, err := s.conn(ctx).QueryContext(ctx, `SELECT pid, cancelled_seq FROM registration_participants
+		WHERE registration_id = $1 ORDER BY position`, reg.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type cancelled struct{ pid, seq int }
+	var gone []cancelled
+	reg.Participants = []int{}
+	for rows.Next() {
+		var pid int
+		var seq sql.NullInt64
+		if err := rows.Scan(&pid, &seq); err != nil {
+			return err
+		}
+		if seq.Valid {
+			gone = append(gone, cancelled{pid, int(seq.Int64)})
+		} else {
+			reg.Participants = append(reg.Participants, pid)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	slices.SortFunc(gone, func(a, b cancelled) int { return a.seq - b.seq })
+	reg.Cancelled = nil
+	for _, c := range gone {
+		reg.Cancelled = append(reg.Cancelled, c.pid)
+	}
+	return nil
+}
+
+func (s *Store) CreateRegistration(ctx context.Context, reg models.Registration) (_ models.ID, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.CreateRegistration")
+	defer func() { tracing.End(span, err) }()
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return models.ID{}, err
+	}
+	defer tx.Rollback()
+
+	id := models.NewID()
+	now := time.Now().UTC()
+	_, err = tx.ExecContext(ctx, `INSERT INTO registrations (`+registrationColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`,
+		id, reg.EventID.Hex(), reg.TeamName, reg.TeamNameKey, reg.LeaderPID, reg.NumOfParticipants,
+		reg.TotalAmount, reg.TransactionID, reg.TransactionImage, reg.MailSent, reg.ReferralCode, reg.Status,
+		now, now, nil, nil)
+	if err != nil {
+		return models.ID{}, sqlErr(err)
+	}
+	members := append(slices.Clone(reg.Participants), reg.Cancelled...)
+	for position, pid := range members {
+		var seq any
+		if position >= len(reg.Participants) {
+			seq = position - len(reg.Participants) + 1
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO registration_participants (registration_id, pid, position, cancelled_seq)
+			VALUES ($1, $2, $3, $4)`, id, pid, position, seq)
+		if err != nil {
+			return models.ID{}, err
+		}
+	}
+	return id, tx.Commit()
+}
+
+func (s *Store) GetRegistration(ctx context.Context, id models.ID) (_ models.Registration, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.GetRegistration")
+	defer func() { tracing.End(span, err) }()
+	reg, err := scanRegistration(s.conn(ctx).QueryRowContext(ctx, "SELECT "+registrationColumns+" FROM registrations WHERE id = $1 AND deleted_at IS NULL", id))
+	if err != nil {
+		return reg, err
+	}
+	return reg, s.loadMembers(ctx, &reg)
+}
+
+func (s *Store) GetRegistrationsByParticipants(ctx context.Context, pids []int) (_ []models.Registration, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.GetRegistrationsByParticipants")
+	defer func() { tracing.End(span, err) }()
+	if len(pids) == 0 {
+		return []models.Registration{}, nil
+	}
+	list, args := inList(1, pids)
+	return s.queryRegistrations(ctx, "SELECT "+registrationColumns+` FROM registrations WHERE id IN (
+		SELECT registration_id FROM registration_participants WHERE pid IN (`+list+`) AND cancelled_seq IS NULL
+	) AND deleted_at IS NULL ORDER BY created_at, id`, args...)
+}
+
+func (s *Store) FindRegistrationsByParticipants(ctx context.Context, pids []int) (_ []models.Registration, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.FindRegistrationsByParticipants")
+	defer func() { tracing.End(span, err) }()
+	if len(pids) == 0 {
+		return []models.Registration{}, nil
+	}
+	list, args := inList(1, pids)
+	return s.queryRegistrations(ctx, "SELECT "+registrationColumns+` FROM registrations WHERE id IN (
+		SELECT registration_id FROM registration_participants WHERE pid IN (`+list+`)
+	) ORDER BY created_at, id`, args...)
+}
+
+// queryRegistrations runs a query for registrationColumns and loads the
+// members of each registration
+func (s *Store) queryRegistrations(ctx context.Context, query string, args ...any) ([]models.Registration, error) {
+	rows, err := s.conn(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	registrations := []models.Registration{}
+	for rows.Next() {
+		reg, err := scanRegistration(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		registrations = append(registrations, reg)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range registrations {
+		if err := s.loadMembers(ctx, &registrations[i]); err != nil {
+			return nil, err
+		}
+	}
+	return registrations, nil
+}
+
+func (s *Store) TeamNameTaken(ctx context.Context, eventID models.ID, teamNameKey string) (_ bool, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.TeamNameTaken")
+	defer func() { tracing.End(span, err) }()
+	var count int
+	err = s.conn(ctx).QueryRowContext(ctx, `SELECT COUNT(*) FROM registrations
+		WHERE event_id = $1 AND team_name_key = $2 AND status <> $3 AND deleted_at IS NULL`,
+		eventID.Hex(), teamNameKey, models.RegistrationCancelled).Scan(&count)
+	return count > 0, err
+}
+
+func (s *Store) CancelParticipants(ctx context.Context, reg models.Registration, pids []int) (_ models.Registration, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.CancelParticipants")
+	defer func() { tracing.End(span, err) }()
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return models.Registration{}, err
+	}
+	defer tx.Rollback()
+
+	id := reg.ID
+	if s.dialect == Postgres {
+		// Serialize concurrent cancellations of the same registration.
+		// SQLite transactions already run one at a time.
+		if _, err := tx.ExecContext(ctx, "SELECT id FROM registrations WHERE id = $1 FOR UPDATE", id); err != nil {
+			return models.Registration{}, err
+		}
+	}
+	var deleted bool
+	err = tx.QueryRowContext(ctx, "SELECT deleted_at IS NOT NULL FROM registrations WHERE id = $1", id).Scan(&deleted)
+	if errors.Is(err, sql.ErrNoRows) || deleted {
+		return models.Registration{}, repository.ErrNotFound
+	}
+	if err != nil {
+		return models.Registration{}, err
+	}
+
+	list, args := inList(2, pids)
+	var active, lastSeq int
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM registration_participants
+		WHERE registration_id = $1 AND cancelled_seq IS NULL AND pid IN (`+list+`)`,
+		append([]any{id}, args...)...).Scan(&active)
+	if err != nil {
+		return models.Registration{}, err
+	}
+	if len(pids) == 0 || active != len(pids) {
+		return models.Registration{}, repository.ErrNotFound
+	}
+	err = tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(cancelled_seq), 0) FROM registration_participants
+		WHERE registration_id = $1`, id).Scan(&lastSeq)
+	if err != nil {
+		return models.Registration{}, err
+	}
+
+	now := time.Now().UTC()
+	for i, pid := range pids {
+		_, err = tx.ExecContext(ctx, `UPDATE registration_participants SET cancelled_seq = $1
+			WHERE registration_id = $2 AND pid = $3`, lastSeq+i+1, id, pid)
+		if err != nil {
+			return models.Registration{}, err
+		}
+	}
+	// The status follows the stored members, not the caller's copy
+	_, err = tx.ExecContext(ctx, `UPDATE registrations
+		SET num_of_participants = num_of_participants - $1, updated_at = $2,
+			status = CASE WHEN EXISTS (SELECT 1 FROM registration_participants
+				WHERE registration_id = $3 AND cancelled_seq IS NULL) THEN status ELSE $4 END
+		WHERE id = $3`,
+		len(pids), now, id, models.RegistrationCancelled)
+	if err != nil {
+		return models.Registration{}, err
+	}
+	list, args = inList(2, pids)
+	_, err = tx.ExecContext(ctx, "UPDATE participants SET cancelled = TRUE, updated_at = $1 WHERE pid IN ("+list+")",
+		append([]any{now}, args...)...)
+	if err != nil {
+		return models.Registration{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return models.Registration{}, err
+	}
+	return s.GetRegistration(ctx, id)
+}
+
+// registrationMembers selects the PIDs of a registration's participants
+const registrationMembers = "SELECT pid FROM registration_participants WHERE registration_id = $2"
+
+func (s *Store) DeleteRegistration(ctx context.Context, id models.ID) (err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.DeleteRegistration")
+	defer func() { tracing.End(span, err) }()
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	err = s.execOne(ctx, tx, "UPDATE registrations SET deleted_at = $1, updated_at = $1 WHERE id = $2 AND deleted_at IS NULL", now, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE participants SET deleted_at = $1, updated_at = $1
+		WHERE pid IN (`+registrationMembers+`) AND deleted_at IS NULL`, now, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Store) RestoreRegistration(ctx context.Context, id models.ID) (err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.RestoreRegistration")
+	defer func() { tracing.End(span, err) }()
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	// Compare deletion times in SQL so they are not rounded on the way through Go
+	_, err = tx.ExecContext(ctx, `UPDATE participants SET deleted_at = NULL, updated_at = $1
+		WHERE pid IN (`+registrationMembers+`)
+		AND deleted_at = (SELECT deleted_at FROM registrations WHERE id = $2)`, now, id)
+	if err != nil {
+		return err
+	}
+	err = s.execOne(ctx, tx, "UPDATE registrations SET deleted_at = NULL, updated_at = $1 WHERE id = $2 AND deleted_at IS NOT NULL", now, id)
+	if err != nil {
+		return sqlErr(err)
+	}
+	return tx.Commit()
+}
+
+func (s *Store) RegistrationsToAnonymize(ctx context.Context, eventIDs []models.ID, deletedBefore time.Time, afterID models.ID, limit int) (_ []models.Registration, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.RegistrationsToAnonymize")
+	defer func() { tracing.End(span, err) }()
+	expired, args := expiredCondition(eventIDs, deletedBefore.UTC(), afterID.Hex(), limit)
+	return s.queryRegistrations(ctx, "SELECT "+registrationColumns+` FROM registrations
+		WHERE anonymized_at IS NULL AND `+expired+` AND id > $2 ORDER BY id LIMIT $3`, args...)
+}
+
+// expiredCondition matches rows that belong to one of eventIDs or were
+// deleted before $1, which is deletedBefore. The other arguments follow it
+// and come before the event IDs.
+func expiredCondition(eventIDs []models.ID, deletedBefore time.Time, args ...any) (string, []any) {
+	args = append([]any{deletedBefore}, args...)
+	events := "FALSE"
+	if len(eventIDs) > 0 {
+		for _, eventID := range eventIDs {
+			args = append(args, eventID.Hex())
+		}
+		events = "event_id IN (" + marks(len(args)-len(eventIDs)+1, len(eventIDs)) + ")"
+	}
+	return "(" + events + " OR deleted_at < $1)", args
+}
+
+func (s *Store) AnonymizeRegistration(ctx context.Context, id models.ID) (err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.AnonymizeRegistration")
+	defer func() { tracing.End(span, err) }()
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	err = s.execOne(ctx, tx, `UPDATE registrations SET team_name = '', team_name_key = '', transaction_id = '',
+		transaction_image = '', anonymized_at = $1, updated_at = $1 WHERE id = $2`, now, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE participants SET name = '', email = '', phone = '', email_index = NULL, anonymized_at = $1, updated_at = $1
+		WHERE pid IN (`+registrationMembers+`)`, now, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
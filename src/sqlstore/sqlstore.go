@@ -0,0 +1,192 @@
+// Package sqlstore keeps every record in SQLite or Postgres through
+// database/sql
+package sqlstore
+
+import (
+	"backend/src/config"
+	"backend/src/repository"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"go.opentelemetry.io/otel"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const (
+	SQLite   = "sqlite"
+	Postgres = "postgres"
+)
+
+// DefaultSQLiteDSN is used when the sqlite backend is chosen without a DSN
+const DefaultSQLiteDSN = "file:metamorphosis.db?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"
+
+var tracer = otel.Tracer("backend/src/sqlstore")
+
+// Store implements repository.Store. Queries use $n placeholders, which
+// both dialects understand.
+type Store struct {
+	DB      *sql.DB
+	dialect string
+
+	rateLimitsPruned atomic.Int64 // unix seconds
+}
+
+var _ repository.Store = (*Store)(nil)
+
+// Open connects to the configured database and applies pending migrations
+func Open(ctx context.Context, cfg config.Storage) (*Store, error) {
+	dsn := cfg.DSN.Value()
+	var driver string
+	switch cfg.Backend {
+	case SQLite:
+		driver = "sqlite"
+		if dsn == "" {
+			dsn = DefaultSQLiteDSN
+		}
+	case Postgres:
+		driver = "pgx"
+	default:
+		return nil, fmt.Errorf("unknown SQL backend %q", cfg.Backend)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Backend == SQLite {
+		// SQLite allows a single writer, so queue writes here rather than
+		// failing with SQLITE_BUSY
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	store := &Store{DB: db, dialect: cfg.Backend}
+	if err := store.Migrate(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating %s: %w", cfg.Backend, err)
+	}
+	return store, nil
+}
+
+func (s *Store) Close() error {
+	return s.DB.Close()
+}
+
+func (s *Store) Ping(ctx context.Context) error {
+	return s.DB.PingContext(ctx)
+}
+
+type txKey struct{}
+
+// conn is what statements run on: the transaction carried by ctx, if any,
+// or the pool. With SQLite's single connection, a statement on the pool
+// inside a transaction would wait forever.
+func (s *Store) conn(ctx context.Context) dbConn {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return tx
+	}
+	return s.DB
+}
+
+type dbConn interface {
+	querier
+	execer
+}
+
+// WithTransaction runs fn in a transaction that the store's methods join
+// through the context. Nested calls join the outer transaction.
+func (s *Store) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return fn(ctx)
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// tx is a transaction begun by a single method. Inside WithTransaction it
+// is the outer transaction, which the outer call commits or rolls back.
+type tx struct {
+	*sql.Tx
+	nested bool
+}
+
+func (s *Store) begin(ctx context.Context) (tx, error) {
+	if outer, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return tx{Tx: outer, nested: true}, nil
+	}
+	t, err := s.DB.BeginTx(ctx, nil)
+	return tx{Tx: t}, err
+}
+
+func (t tx) Commit() error {
+	if t.nested {
+		return nil
+	}
+	return t.Tx.Commit()
+}
+
+func (t tx) Rollback() error {
+	if t.nested {
+		return nil
+	}
+	return t.Tx.Rollback()
+}
+
+// sqlErr maps missing rows to repository.ErrNotFound and unique violations
+// to repository.ErrDuplicate
+func sqlErr(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.ErrNotFound
+	}
+	if isUniqueViolation(err) {
+		return fmt.Errorf("%w: %w", repository.ErrDuplicate, err)
+	}
+	return err
+}
+
+func isUniqueViolation(err error) bool {
+	var liteErr *sqlite.Error
+	if errors.As(err, &liteErr) {
+		return liteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || liteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+	}
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// inList renders "$start, $start+1, ..." for n values and returns them as
+// query arguments
+func inList(start int, values []int) (string, []any) {
+	marks := make([]string, len(values))
+	args := make([]any, len(values))
+	for i, value := range values {
+		marks[i] = fmt.Sprintf("$%d", start+i)
+		args[i] = value
+	}
+	return strings.Join(marks, ", "), args
+}
+
+// marks renders "$start, $start+1, ..." for n placeholders
+func marks(start, n int) string {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = fmt.Sprintf("$%d", start+i)
+	}
+	return strings.Join(list, ", ")
+}
@@ -0,0 +1,101 @@
+package sqlstore_test
+
+import (
+	"backend/src/config"
+	"backend/src/models"
+	"backend/src/repository"
+	"backend/src/repository/repotest"
+	"backend/src/sqlstore"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLite(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repository.Store {
+		dsn := "file:" + filepath.Join(t.TempDir(), "test.db") + "?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)"
+		return open(t, config.Storage{Backend: sqlstore.SQLite, DSN: config.Secret(dsn)})
+	})
+}
+
+// TestSQLiteTransactionRollsBack also covers methods that begin their own
+// transaction, which join the outer one
+func TestSQLiteTransactionRollsBack(t *testing.T) {
+	ctx := context.Background()
+	dsn := "file:" + filepath.Join(t.TempDir(), "test.db") + "?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)"
+	store := open(t, config.Storage{Backend: sqlstore.SQLite, DSN: config.Secret(dsn)})
+	failure := errors.New("failure")
+	err := store.WithTransaction(ctx, func(ctx context.Context) error {
+		if _, err := store.CreateEvent(ctx, models.Event{Slug: "rolled-back"}); err != nil {
+			return err
+		}
+		pids, err := store.CreateParticipants(ctx, []models.Participant{{Name: "A"}})
+		if err != nil {
+			return err
+		}
+		if _, err := store.CreateRegistration(ctx, models.Registration{Participants: pids}); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("WithTransaction = %v, want the error of fn", err)
+	}
+	if _, err := store.GetEventBySlug(ctx, "rolled-back"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("event survived the rollback: err = %v", err)
+	}
+	if participants, _ := store.ScanParticipants(ctx, 0, 10); len(participants) != 0 {
+		t.Errorf("participants survived the rollback: %+v", participants)
+	}
+}
+
+// TestSQLiteMigrateConcurrently starts two instances on a new database at
+// once; the second must wait for the first rather than fail
+func TestSQLiteMigrateConcurrently(t *testing.T) {
+	dsn := "file:" + filepath.Join(t.TempDir(), "test.db") + "?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)"
+	errs := make(chan error, 2)
+	for range 2 {
+		go func() {
+			store, err := sqlstore.Open(context.Background(), config.Storage{Backend: sqlstore.SQLite, DSN: config.Secret(dsn)})
+			if err == nil {
+				store.Close()
+			}
+			errs <- err
+		}()
+	}
+	for range 2 {
+		if err := <-errs; err != nil {
+			t.Errorf("Open: %v", err)
+		}
+	}
+}
+
+// TestPostgres needs an empty scratch database, which each subtest resets
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("BACKEND_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("BACKEND_TEST_POSTGRES_DSN is not set")
+	}
+	repotest.Run(t, func(t *testing.T) repository.Store {
+		store := open(t, config.Storage{Backend: sqlstore.Postgres, DSN: config.Secret(dsn)})
+		_, err := store.DB.Exec(`TRUNCATE registration_participants, registrations, participants, events, uploads,
+			otps, refunds, audit_entries, admin_users, rate_limits;
+			ALTER SEQUENCE participant_pid RESTART`)
+		if err != nil {
+			t.Fatalf("resetting database: %v", err)
+		}
+		return store
+	})
+}
+
+func open(t *testing.T, cfg config.Storage) *sqlstore.Store {
+	t.Helper()
+	store, err := sqlstore.Open(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
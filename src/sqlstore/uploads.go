@@ -0,0 +1,86 @@
+package sqlstore
+
+import (
+	"backend/src/models"
+	"backend/src/tracing"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const uploadColumns = "id, event_id, url, consumed, created_at"
+
+func scanUpload(row scanner) (models.Upload, error) {
+	var u models.Upload
+	err := row.Scan(&u.ID, &u.EventID, &u.URL, &u.Consumed, &u.CreatedAt)
+	return u, sqlErr(err)
+}
+
+func (s *Store) CreateUpload(ctx context.Context, upload models.Upload) (_ models.ID, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.CreateUpload")
+	defer func() { tracing.End(span, err) }()
+	upload.ID = models.NewID()
+	_, err = s.conn(ctx).ExecContext(ctx, "INSERT INTO uploads ("+uploadColumns+") VALUES ($1, $2, $3, $4, $5)",
+		upload.ID, upload.EventID, upload.URL, upload.Consumed, time.Now().UTC())
+	if err != nil {
+		return models.ID{}, sqlErr(err)
+	}
+	return upload.ID, nil
+}
+
+func (s *Store) ConsumeUpload(ctx context.Context, id, eventID models.ID) (_ models.Upload, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.ConsumeUpload")
+	defer func() { tracing.End(span, err) }()
+	return s.setUploadConsumed(ctx, id, eventID, true)
+}
+
+func (s *Store) ReleaseUpload(ctx context.Context, id, eventID models.ID) (err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.ReleaseUpload")
+	defer func() { tracing.End(span, err) }()
+	_, err = s.setUploadConsumed(ctx, id, eventID, false)
+	return err
+}
+
+func (s *Store) setUploadConsumed(ctx context.Context, id, eventID models.ID, consumed bool) (models.Upload, error) {
+	row := s.conn(ctx).QueryRowContext(ctx, `UPDATE uploads SET consumed = $1
+		WHERE id = $2 AND event_id = $3 AND consumed <> $1 RETURNING `+uploadColumns, consumed, id, eventID)
+	return scanUpload(row)
+}
+
+func (s *Store) ExpiredUploads(ctx context.Context, eventIDs []models.ID, createdBefore time.Time) (_ []models.Upload, err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.ExpiredUploads")
+	defer func() { tracing.End(span, err) }()
+	args := []any{createdBefore.UTC()}
+	events := "FALSE"
+	if len(eventIDs) > 0 {
+		list := make([]string, len(eventIDs))
+		for i, eventID := range eventIDs {
+			args = append(args, eventID)
+			list[i] = fmt.Sprintf("$%d", len(args))
+		}
+		events = "event_id IN (" + strings.Join(list, ", ") + ")"
+	}
+	rows, err := s.conn(ctx).QueryContext(ctx, "SELECT "+uploadColumns+" FROM uploads WHERE "+events+
+		" OR (NOT consumed AND created_at < $1) ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	uploads := []models.Upload{}
+	for rows.Next() {
+		upload, err := scanUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
+func (s *Store) DeleteUpload(ctx context.Context, id models.ID) (err error) {
+	ctx, span := tracer.Start(ctx, "sqlstore.DeleteUpload")
+	defer func() { tracing.End(span, err) }()
+	_, err = s.conn(ctx).ExecContext(ctx, "DELETE FROM uploads WHERE id = $1", id)
+	return err
+}
@@ -0,0 +1,46 @@
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestMiddleware(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+
+	router := mux.NewRouter()
+	router.Use(Route)
+	router.HandleFunc("/items/{id}", func(w http.ResponseWriter, r *http.Request) {}).Methods("GET")
+	// Stands in for the request log, which runs outside the router
+	var logged trace.SpanContext
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		router.ServeHTTP(w, r)
+		logged = trace.SpanContextFromContext(r.Context())
+	}))
+
+	for i, tt := range []struct {
+		path, name string
+	}{
+		{"/items/1", "GET /items/{id}"},
+		{"/missing", "GET"},
+	} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.path, nil))
+		spans := recorder.Ended()
+		if len(spans) != i+1 || spans[i].Name() != tt.name {
+			t.Fatalf("%s: %d spans, want one more named %q", tt.path, len(spans), tt.name)
+		}
+		if !logged.IsValid() || logged.SpanID() != spans[i].SpanContext().SpanID() {
+			t.Errorf("%s: the wrapped handler does not see the request span", tt.path)
+		}
+	}
+}